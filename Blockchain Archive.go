@@ -0,0 +1,87 @@
+/*
+File Username:  Blockchain Archive.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+*/
+
+package core
+
+import (
+    "bytes"
+    "errors"
+    "io"
+
+    "github.com/newinfoOffical/core/blockchain"
+    "github.com/newinfoOffical/core/warehouse"
+)
+
+// ExportBlockchain writes the user's blockchain as archive. If includeFiles is set, all files referenced by the blockchain that are stored in the warehouse are included.
+// Status is blockchain.StatusX.
+func (backend *Backend) ExportBlockchain(writer io.Writer, includeFiles bool) (status int, err error) {
+    if status, err = backend.UserBlockchain.Export(writer); status != blockchain.StatusOK || err != nil || !includeFiles {
+        return status, err
+    }
+
+    files, status := backend.UserBlockchain.ListFiles()
+    if status != blockchain.StatusOK {
+        return status, errors.New("error listing files")
+    }
+
+    exported := make(map[string]struct{})
+
+    for _, file := range files {
+        if _, ok := exported[string(file.Hash)]; ok {
+            continue
+        }
+        exported[string(file.Hash)] = struct{}{}
+
+        // Files not in the warehouse (such as virtual folders) are skipped.
+        _, fileSize, statusW, _ := backend.UserWarehouse.FileExists(file.Hash)
+        if statusW != warehouse.StatusOK {
+            continue
+        }
+
+        reader, writerPipe := io.Pipe()
+        go func(hash []byte) {
+            _, _, err := backend.UserWarehouse.ReadFile(hash, 0, 0, writerPipe)
+            writerPipe.CloseWithError(err)
+        }(file.Hash)
+
+        err = blockchain.WriteArchiveFile(writer, file.Hash, fileSize, reader)
+        reader.Close()
+
+        if err != nil {
+            return blockchain.StatusOK, err
+        }
+    }
+
+    return blockchain.StatusOK, nil
+}
+
+// ImportBlockchain replaces the user's blockchain with the one from the archive. Any files included in the archive are stored in the warehouse.
+// Status is blockchain.StatusX.
+func (backend *Backend) ImportBlockchain(reader io.Reader) (newHeight, newVersion uint64, filesImported int, status int, err error) {
+    if newHeight, newVersion, status, err = backend.UserBlockchain.Import(reader); status != blockchain.StatusOK || err != nil {
+        return newHeight, newVersion, 0, status, err
+    }
+
+    for {
+        hash, size, err := blockchain.ReadArchiveFile(reader)
+        if err == io.EOF {
+            break
+        } else if err != nil {
+            return newHeight, newVersion, filesImported, blockchain.StatusInvalidArchive, err
+        }
+
+        hashCreated, statusW, err := backend.UserWarehouse.CreateFile(io.LimitReader(reader, int64(size)), size, nil)
+        if statusW != warehouse.StatusOK {
+            return newHeight, newVersion, filesImported, blockchain.StatusInvalidArchive, err
+        } else if !bytes.Equal(hash, hashCreated) {
+            return newHeight, newVersion, filesImported, blockchain.StatusInvalidArchive, errors.New("archive file hash mismatch")
+        }
+
+        filesImported++
+    }
+
+    return newHeight, newVersion, filesImported, blockchain.StatusOK, nil
+}
@@ -0,0 +1,139 @@
+/*
+File Username:  Blockchain Cache Eviction.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+When the global blockchain cache reaches the record limit, the least valuable blockchains are evicted to make room for new ones.
+The value of a blockchain is determined by when it was last accessed by the user or when its owner was last seen online, whichever is later.
+*/
+
+package core
+
+import (
+    "sort"
+    "sync"
+    "time"
+
+    "github.com/newinfoOffical/core/blockchain"
+    "github.com/newinfoOffical/core/btcec"
+    "github.com/newinfoOffical/core/protocol"
+)
+
+// evictionTargetRatio is the share of the record limit the cache is reduced to when evicting. This prevents evicting on every new block.
+const evictionTargetRatio = 0.9
+
+// accessUpdateInterval limits how often the last access date of a blockchain is written.
+const accessUpdateInterval = 10 * time.Minute
+
+// blockchainEviction keeps track of access to cached blockchains and serializes evictions
+type blockchainEviction struct {
+    lastAccess      map[string]time.Time // Last time the access date was written per blockchain
+    lastAccessMutex sync.Mutex           // Mutex for lastAccess
+    evictMutex      sync.Mutex           // Only one eviction may run at a time
+    exhaustedAt     uint64               // Count of records in the store when the last eviction ran out of evictable blockchains. 0 if not. Protected by evictMutex.
+}
+
+// RecordAccess records that the user accessed the blockchain. The header is updated asynchronously and at most every accessUpdateInterval.
+func (cache *BlockchainCache) RecordAccess(publicKey *btcec.PublicKey) {
+    key := string(publicKey.SerializeCompressed())
+
+    cache.eviction.lastAccessMutex.Lock()
+    if last, ok := cache.eviction.lastAccess[key]; ok && time.Since(last) < accessUpdateInterval {
+        cache.eviction.lastAccessMutex.Unlock()
+        return
+    }
+    cache.eviction.lastAccess[key] = time.Now()
+    cache.eviction.lastAccessMutex.Unlock()
+
+    go func() {
+        cache.peerLock.Lock(key)
+        defer cache.peerLock.Unlock(key)
+
+        if header, found, err := cache.Store.ReadBlockchainHeader(publicKey); err == nil && found {
+            header.DateLastAccess = time.Now()
+            cache.Store.WriteBlockchainHeader(header)
+        }
+    }()
+}
+
+// blockchainLastUsed returns the date the blockchain was last accessed or its owner was last seen, whichever is later.
+func blockchainLastUsed(header *blockchain.MultiBlockchainHeader) time.Time {
+    if header.DateLastAccess.After(header.DateLastSeen) {
+        return header.DateLastAccess
+    }
+    return header.DateLastSeen
+}
+
+// Evict deletes the least valuable blockchains if the record limit is reached, until the record count is below the target.
+// Only headers and blocks count as records. Other data such as key migrations, ratings and subscriptions cannot be evicted.
+// Evicted blockchains are deleted via the store which fires the GlobalBlockchainCacheDelete filter, and are unindexed.
+// Blockchains of followed users are never evicted. The caller must not hold any blockchain lock. If another eviction is already running, it returns immediately.
+func (cache *BlockchainCache) Evict() (countEvicted int) {
+    // The count of all keys in the store is an upper bound of the count of records and cheap to get.
+    countTotal := cache.Store.Database.Count()
+    if cache.LimitTotalRecords == 0 || countTotal < cache.LimitTotalRecords {
+        return 0
+    }
+
+    if !cache.eviction.evictMutex.TryLock() {
+        return 0
+    }
+    defer cache.eviction.evictMutex.Unlock()
+
+    target := uint64(float64(cache.LimitTotalRecords) * evictionTargetRatio)
+
+    // If nothing was left to evict last time, wait until enough new data was added. Otherwise every new block would start a pointless eviction.
+    if cache.eviction.exhaustedAt > 0 && countTotal < cache.eviction.exhaustedAt+cache.LimitTotalRecords-target {
+        return 0
+    }
+    cache.eviction.exhaustedAt = 0
+
+    var headers []*blockchain.MultiBlockchainHeader
+    var countRecords uint64
+    cache.Store.IterateBlockchains(func(header *blockchain.MultiBlockchainHeader) {
+        headers = append(headers, header)
+        countRecords += 1 + uint64(len(header.ListBlocks))
+    })
+
+    if countRecords < cache.LimitTotalRecords {
+        cache.eviction.exhaustedAt = countTotal
+        return 0
+    }
+
+    sort.Slice(headers, func(i, j int) bool { return blockchainLastUsed(headers[i]).Before(blockchainLastUsed(headers[j])) })
+
+    for _, header := range headers {
+        if countRecords < target {
+            break
+        }
+
+        // blockchains of followed users are pinned
+        if cache.Store.IsSubscribed(protocol.PublicKey2NodeID(header.PublicKey)) {
+            continue
+        }
+
+        key := string(header.PublicKey.SerializeCompressed())
+        cache.peerLock.Lock(key)
+
+        // read the header again as it may have changed in the meantime
+        if headerCurrent, found, err := cache.Store.ReadBlockchainHeader(header.PublicKey); err == nil && found {
+            cache.Store.DeleteBlockchain(headerCurrent)
+            cache.backend.SearchIndex.UnindexBlockchain(header.PublicKey)
+            countEvicted++
+
+            if records := 1 + uint64(len(headerCurrent.ListBlocks)); records < countRecords {
+                countRecords -= records
+            } else {
+                countRecords = 0
+            }
+        }
+
+        cache.peerLock.Unlock(key)
+    }
+
+    if countRecords >= target {
+        cache.eviction.exhaustedAt = cache.Store.Database.Count()
+    }
+
+    return countEvicted
+}
@@ -7,7 +7,11 @@ Author:     Peter Kleissner
 package core
 
 import (
+    "encoding/hex"
+    "time"
+
     "github.com/newinfoOffical/core/blockchain"
+    "github.com/newinfoOffical/core/btcec"
     "github.com/newinfoOffical/core/protocol"
     "github.com/enfipy/locker"
 )
@@ -17,11 +21,14 @@ type BlockchainCache struct {
     BlockchainDirectory string // The directory for storing blockchains in a key-value store.
     MaxBlockSize        uint64 // Max block size to accept.
     MaxBlockCount       uint64 // Max block count to cache per peer.
-    LimitTotalRecords   uint64 // Max count of blocks and header in total to keep across all blockchains. 0 = unlimited. Max Records * Max Block Size = Size Limit.
-    ReadOnly            bool   // Whether the cache is read only.
+    LimitTotalRecords   uint64 // Max count of blocks and header in total to keep across all blockchains. 0 = unlimited. Max Records * Max Block Size = Size Limit. If reached, blockchains are evicted.
+    ReadOnly            bool   // Whether the cache is read only. No blockchains are added or updated.
 
     Store    *blockchain.MultiStore
     peerLock *locker.Locker
+    sync     *blockchainSync
+    eviction *blockchainEviction
+    feed     *feed
 
     backend *Backend
 }
@@ -47,14 +54,15 @@ func (backend *Backend) initBlockchainCache() {
     }
 
     backend.GlobalBlockchainCache.peerLock = locker.Initialize()
-
-    // Set the blockchain cache to read-only if the record limit is reached.
-    if backend.Config.LimitTotalRecords > 0 && backend.GlobalBlockchainCache.Store.Database.Count() >= backend.Config.LimitTotalRecords {
-        backend.GlobalBlockchainCache.ReadOnly = true
-    }
+    backend.GlobalBlockchainCache.eviction = &blockchainEviction{lastAccess: make(map[string]time.Time)}
+    backend.GlobalBlockchainCache.feed = &feed{}
+    backend.GlobalBlockchainCache.initSync()
 
     backend.GlobalBlockchainCache.Store.FilterStatisticUpdate = backend.Filters.GlobalBlockchainCacheStatistic
     backend.GlobalBlockchainCache.Store.FilterBlockchainDelete = backend.Filters.GlobalBlockchainCacheDelete
+
+    // Make room if the record limit is reached, for example if the limit was lowered.
+    backend.GlobalBlockchainCache.Evict()
 }
 
 // SeenBlockchainVersion shall be called with information about another peer's blockchain.
@@ -63,33 +71,68 @@ func (cache *BlockchainCache) SeenBlockchainVersion(peer *PeerInfo) {
     cache.peerLock.Lock(string(peer.PublicKey.SerializeCompressed()))
     defer cache.peerLock.Unlock(string(peer.PublicKey.SerializeCompressed()))
 
-    // intermediate function to download and process blocks
-    downloadAndProcessBlocks := func(peer *PeerInfo, header *blockchain.MultiBlockchainHeader, offset, limit uint64) {
-        if limit > cache.MaxBlockCount {
-            limit = cache.MaxBlockCount
+    // Blockchains that were migrated to a new key are no longer accepted. The old key is considered compromised.
+    if _, migrated := cache.Store.ReadKeyMigration(peer.PublicKey); migrated {
+        return
+    }
+
+    // intermediate function to download and process all missing blocks. It returns whether all blocks up to the max block count are stored afterwards.
+    downloadAndProcessBlocks := func(peer *PeerInfo, header *blockchain.MultiBlockchainHeader) (complete bool) {
+        var maxBlocks uint64
+        if uint64(len(header.ListBlocks)) < cache.MaxBlockCount {
+            maxBlocks = cache.MaxBlockCount - uint64(len(header.ListBlocks))
         }
 
-        peer.BlockDownload(peer.PublicKey, cache.MaxBlockCount, cache.MaxBlockSize, []protocol.BlockRange{{Offset: offset, Limit: limit}}, func(data []byte, targetBlock protocol.BlockRange, blockSize uint64, availability uint8) {
+        ranges := missingBlockRanges(header, maxBlocks)
+        if len(ranges) == 0 {
+            return true
+        }
+
+        peer.BlockDownload(peer.PublicKey, maxBlocks, cache.MaxBlockSize, ranges, func(data []byte, targetBlock protocol.BlockRange, blockSize uint64, availability uint8) {
             if availability != protocol.GetBlockStatusAvailable {
                 return
             }
 
             if decoded, _ := cache.Store.IngestBlock(header, targetBlock.Offset, data, true); decoded != nil {
+                // private files shared with the local user
+                cache.backend.decodePrivateFilesShared(decoded)
+
                 // index it for search
-                cache.backend.SearchIndex.IndexNewBlockDecoded(peer.PublicKey, peer.BlockchainVersion, targetBlock.Offset, decoded.RecordsDecoded)
+                cache.backend.SearchIndex.IndexNewBlockDecoded(peer.PublicKey, header.Version, targetBlock.Offset, decoded.RecordsDecoded)
+
+                cache.followKeyMigration(peer.PublicKey, decoded.RecordsDecoded)
             }
         })
+
+        if uint64(len(header.ListBlocks)) < cache.MaxBlockCount {
+            maxBlocks = cache.MaxBlockCount - uint64(len(header.ListBlocks))
+        } else {
+            maxBlocks = 0
+        }
+
+        return len(missingBlockRanges(header, maxBlocks)) == 0
     }
 
+    key := string(peer.PublicKey.SerializeCompressed())
+
     // get the old header
     header, status, err := cache.Store.AssessBlockchainHeader(peer.PublicKey, peer.BlockchainVersion, peer.BlockchainHeight)
     if err != nil {
         return
     }
 
+    // For followed users the content before the update is compared with the new one. A blockchain seen for the first time is the baseline.
+    var contentOld *blockchain.BlockchainContent
+    if (status == blockchain.MultiStatusNewVersion || status == blockchain.MultiStatusNewBlocks) && cache.Store.IsSubscribed(protocol.PublicKey2NodeID(peer.PublicKey)) {
+        contentOld = cache.Store.ReadBlockchainContent(header)
+    }
+
     switch status {
     case blockchain.MultiStatusEqual:
-        return
+        // Blocks may be missing if a previous download was interrupted. Blocks that repeatedly fail to download are retried with backoff.
+        if cache.sync.retryAllowed(key) {
+            cache.sync.retryResult(key, downloadAndProcessBlocks(peer, header))
+        }
 
     case blockchain.MultiStatusInvalidRemote:
         cache.Store.DeleteBlockchain(header)
@@ -101,10 +144,10 @@ func (cache *BlockchainCache) SeenBlockchainVersion(peer *PeerInfo) {
             return
         }
 
-        downloadAndProcessBlocks(peer, header, 0, peer.BlockchainHeight)
+        cache.sync.retryResult(key, downloadAndProcessBlocks(peer, header))
 
     case blockchain.MultiStatusNewVersion:
-        // delete existing data first, then create it new
+        // All blocks of a new version are different, since the version is part of each block. Delete existing data first, then create it new.
         cache.Store.DeleteBlockchain(header)
 
         cache.backend.SearchIndex.UnindexBlockchain(peer.PublicKey)
@@ -113,20 +156,115 @@ func (cache *BlockchainCache) SeenBlockchainVersion(peer *PeerInfo) {
             return
         }
 
-        downloadAndProcessBlocks(peer, header, 0, peer.BlockchainHeight)
+        cache.sync.retryResult(key, downloadAndProcessBlocks(peer, header))
 
     case blockchain.MultiStatusNewBlocks:
-        offset := header.Height
-        limit := peer.BlockchainHeight - header.Height
         header.Height = peer.BlockchainHeight
-        downloadAndProcessBlocks(peer, header, offset, limit)
+        cache.sync.retryResult(key, downloadAndProcessBlocks(peer, header))
+
+    }
+
+    if contentOld != nil {
+        diff := blockchain.DiffBlockchainContent(contentOld, cache.Store.ReadBlockchainContent(header))
+
+        // Files in blocks that are not stored, because the download failed or the max block count is reached, would otherwise appear as removed.
+        if uint64(len(header.ListBlocks)) < header.Height {
+            diff.FilesRemoved = nil
+        }
+
+        if !diff.IsEmpty() {
+            cache.publishDiff(peer.PublicKey, diff)
+        }
+    }
+
+    // The owner is online, which makes the blockchain more valuable to keep.
+    if status != blockchain.MultiStatusInvalidRemote {
+        header.DateLastSeen = time.Now()
+        cache.Store.WriteBlockchainHeader(header)
+    }
+}
 
+// followKeyMigration processes key migration records of a newly ingested block. The blockchains of the old keys are deleted from the cache and unindexed.
+// Only migrations leading to the blockchain owner are accepted, otherwise anyone holding a compromised key could redirect it. See ApplyKeyMigrations for conflicts.
+func (cache *BlockchainCache) followKeyMigration(publicKey *btcec.PublicKey, recordsDecoded []interface{}) {
+    var migrations []blockchain.BlockRecordKeyMigration
+    for _, decodedR := range recordsDecoded {
+        if migration, ok := decodedR.(blockchain.BlockRecordKeyMigration); ok {
+            migrations = append(migrations, migration)
+        }
+    }
+
+    if len(migrations) == 0 {
+        return
+    }
+
+    for _, oldPublicKey := range cache.Store.ApplyKeyMigrations(publicKey, migrations) {
+        if header, found, _ := cache.Store.ReadBlockchainHeader(oldPublicKey); found {
+            cache.Store.DeleteBlockchain(header)
+        }
+
+        cache.backend.SearchIndex.UnindexBlockchain(oldPublicKey)
+    }
+}
+
+// autoVerifyCache verifies the cache once the bootstrap is done, so that corrupt blockchains of connected owners can be fetched again immediately.
+func (backend *Backend) autoVerifyCache() {
+    backend.waitPeers(verifyCachePeersMin, verifyCacheBootstrapTimeout)
+
+    backend.GlobalBlockchainCache.VerifyCache()
+}
+
+const (
+    verifyCachePeersMin         = 2                // Count of connected peers to consider the bootstrap done.
+    verifyCacheBootstrapTimeout = 10 * time.Minute // Max time to wait for the bootstrap before verifying the cache anyway.
+)
+
+// VerifyCache verifies all blockchains in the cache. Corrupt blockchains are deleted and unindexed.
+// If the owner is connected, the blockchain is immediately fetched again. Otherwise it is fetched the next time the owner is seen.
+func (cache *BlockchainCache) VerifyCache() (countCorrupt int) {
+    // collect the headers first, since the store must not be modified while iterating
+    var headers []*blockchain.MultiBlockchainHeader
+    cache.Store.IterateBlockchains(func(header *blockchain.MultiBlockchainHeader) {
+        headers = append(headers, header)
+    })
+
+    for _, header := range headers {
+        corruptions := cache.Store.VerifyBlockchain(header)
+        if len(corruptions) == 0 {
+            continue
+        }
+
+        countCorrupt++
+
+        for _, corruption := range corruptions {
+            cache.backend.LogError("VerifyCache", "blockchain %s %s\n", hex.EncodeToString(header.PublicKey.SerializeCompressed()), corruption.String())
+        }
+
+        cache.peerLock.Lock(string(header.PublicKey.SerializeCompressed()))
+        cache.Store.DeleteBlockchain(header)
+        cache.backend.SearchIndex.UnindexBlockchain(header.PublicKey)
+        cache.peerLock.Unlock(string(header.PublicKey.SerializeCompressed()))
+
+        if peer := cache.backend.NodelistLookup(protocol.PublicKey2NodeID(header.PublicKey)); peer != nil && !cache.ReadOnly {
+            cache.SeenBlockchainVersion(peer)
+        }
     }
 
-    if cache.LimitTotalRecords > 0 {
-        // Bug: This code is currently never reached if ReadOnly is true.
-        cache.ReadOnly = cache.Store.Database.Count() >= cache.LimitTotalRecords
+    return countCorrupt
+}
+
+// ContentRatingTags returns the aggregated ratings and reports of the file seen across cached blockchains as virtual tags.
+func (backend *Backend) ContentRatingTags(hash []byte) (tags []blockchain.BlockRecordFileTag) {
+    if backend.GlobalBlockchainCache == nil {
+        return nil
     }
+
+    rating, found := backend.GlobalBlockchainCache.Store.ReadContentRating(hash)
+    if !found {
+        return nil
+    }
+
+    return rating.Tags()
 }
 
 // remoteBlockchainUpdate shall be called to indicate a potential update of the remotes blockchain.
@@ -137,6 +275,5 @@ func (peer *PeerInfo) remoteBlockchainUpdate() {
         return
     }
 
-    // TODO: This entire function should be instead a non-blocking message via a buffer channel.
-    go peer.Backend.GlobalBlockchainCache.SeenBlockchainVersion(peer)
+    peer.Backend.GlobalBlockchainCache.QueueSync(peer, peer.Backend.GlobalBlockchainCache.Store.IsSubscribed(peer.NodeID))
 }
@@ -0,0 +1,194 @@
+/*
+File Username:  Blockchain Cache Sync.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+The sync workers update the global blockchain cache asynchronously. Peers whose blockchain may have changed are queued.
+Blockchains the user is actively browsing are queued with priority. Each blockchain is queued at most once at any time; a blockchain already waiting in the regular queue is promoted to the priority queue.
+Only missing block ranges are downloaded. If blocks of an otherwise unchanged blockchain repeatedly fail to download, further attempts are delayed with exponential backoff.
+*/
+
+package core
+
+import (
+    "sort"
+    "sync"
+    "time"
+
+    "github.com/newinfoOffical/core/blockchain"
+    "github.com/newinfoOffical/core/protocol"
+)
+
+const (
+    syncWorkers           = 4    // Count of sync workers.
+    syncQueueSize         = 1024 // Max count of queued blockchains. If the queue is full, further requests are dropped until the peer's blockchain is seen again.
+    syncPriorityQueueSize = 64   // Max count of blockchains queued with priority.
+)
+
+const (
+    syncRetryDelayMin = time.Minute    // Delay before retrying to download missing blocks after the first failed attempt.
+    syncRetryDelayMax = 24 * time.Hour // Max delay between attempts.
+)
+
+// blockchainSync is the queue of blockchains to sync
+type blockchainSync struct {
+    queue         chan syncRequest           // Regular queue
+    queuePriority chan syncRequest           // Priority queue for blockchains the user is browsing
+    pending       map[string]bool            // List of queued blockchains by public key. The value indicates whether it is queued with priority.
+    retry         map[string]syncRetryStatus // Backoff for blockchains with blocks that failed to download, by public key
+    sync.Mutex                               // Mutex for pending and retry
+}
+
+type syncRequest struct {
+    peer     *PeerInfo
+    priority bool
+}
+
+type syncRetryStatus struct {
+    failures  int       // Count of consecutive failed attempts
+    nextRetry time.Time // No attempt is made before this time
+}
+
+// initSync starts the sync workers
+func (cache *BlockchainCache) initSync() {
+    cache.sync = &blockchainSync{
+        queue:         make(chan syncRequest, syncQueueSize),
+        queuePriority: make(chan syncRequest, syncPriorityQueueSize),
+        pending:       make(map[string]bool),
+        retry:         make(map[string]syncRetryStatus),
+    }
+
+    for n := 0; n < syncWorkers; n++ {
+        go cache.syncWorker()
+    }
+}
+
+// QueueSync queues the blockchain of the peer to be synced. If priority is set, it is processed before all others.
+// A blockchain already in the regular queue is promoted if priority is set. It does not block. If the queue is full, the request is dropped.
+func (cache *BlockchainCache) QueueSync(peer *PeerInfo, priority bool) {
+    key := string(peer.PublicKey.SerializeCompressed())
+
+    cache.sync.Lock()
+    defer cache.sync.Unlock()
+
+    if queuedPriority, ok := cache.sync.pending[key]; ok && (queuedPriority || !priority) {
+        return
+    }
+
+    queue := cache.sync.queue
+    if priority {
+        queue = cache.sync.queuePriority
+    }
+
+    // A promoted blockchain stays in the regular queue as well. The worker skips it there, since it is pending with priority.
+    select {
+    case queue <- syncRequest{peer: peer, priority: priority}:
+        cache.sync.pending[key] = priority
+    default:
+    }
+}
+
+// syncWorker processes the queues. Blockchains in the priority queue are always processed first.
+func (cache *BlockchainCache) syncWorker() {
+    for {
+        var request syncRequest
+
+        select {
+        case request = <-cache.sync.queuePriority:
+        default:
+            select {
+            case request = <-cache.sync.queuePriority:
+            case request = <-cache.sync.queue:
+            }
+        }
+
+        // Remove from pending before processing, so that any changes seen during processing are queued again.
+        // Requests that were promoted to the priority queue or already processed are skipped.
+        key := string(request.peer.PublicKey.SerializeCompressed())
+
+        cache.sync.Lock()
+        queuedPriority, ok := cache.sync.pending[key]
+        if ok && queuedPriority == request.priority {
+            delete(cache.sync.pending, key)
+        }
+        cache.sync.Unlock()
+
+        if !ok || queuedPriority != request.priority {
+            continue
+        }
+
+        cache.SeenBlockchainVersion(request.peer)
+
+        // make room for new blockchains if needed
+        cache.Evict()
+    }
+}
+
+// retryAllowed checks if missing blocks of the blockchain shall be downloaded now, or if the backoff after failed attempts is still active.
+func (sync *blockchainSync) retryAllowed(key string) bool {
+    sync.Lock()
+    defer sync.Unlock()
+
+    status, ok := sync.retry[key]
+    return !ok || time.Now().After(status.nextRetry)
+}
+
+// retryResult records the result of downloading missing blocks. After each failure the delay until the next attempt doubles.
+func (sync *blockchainSync) retryResult(key string, complete bool) {
+    sync.Lock()
+    defer sync.Unlock()
+
+    if complete {
+        delete(sync.retry, key)
+        return
+    }
+
+    status := sync.retry[key]
+    delay := syncRetryDelayMin << status.failures
+    if delay > syncRetryDelayMax || delay <= 0 {
+        delay = syncRetryDelayMax
+    } else {
+        status.failures++
+    }
+
+    status.nextRetry = time.Now().Add(delay)
+    sync.retry[key] = status
+}
+
+// missingBlockRanges returns the ranges of blocks not stored for the blockchain, up to the given count of blocks.
+func missingBlockRanges(header *blockchain.MultiBlockchainHeader, maxBlocks uint64) (ranges []protocol.BlockRange) {
+    stored := append([]uint64{}, header.ListBlocks...)
+    sort.Slice(stored, func(i, j int) bool { return stored[i] < stored[j] })
+
+    var count uint64
+    index := 0
+
+    for blockN := uint64(0); blockN < header.Height && count < maxBlocks; blockN++ {
+        for index < len(stored) && stored[index] < blockN {
+            index++
+        }
+        if index < len(stored) && stored[index] == blockN {
+            continue
+        }
+
+        // extend the last range if it is adjacent, otherwise start a new one
+        if len(ranges) > 0 && ranges[len(ranges)-1].Offset+ranges[len(ranges)-1].Limit == blockN {
+            ranges[len(ranges)-1].Limit++
+        } else {
+            ranges = append(ranges, protocol.BlockRange{Offset: blockN, Limit: 1})
+        }
+
+        count++
+    }
+
+    return ranges
+}
+
+// PrioritizeBlockchainSync shall be called when the user is browsing the blockchain of the peer. Its sync is prioritized.
+func (backend *Backend) PrioritizeBlockchainSync(peer *PeerInfo) {
+    if peer == nil || backend.GlobalBlockchainCache == nil || backend.GlobalBlockchainCache.ReadOnly || peer.BlockchainVersion == 0 && peer.BlockchainHeight == 0 {
+        return
+    }
+
+    backend.GlobalBlockchainCache.QueueSync(peer, true)
+}
@@ -0,0 +1,209 @@
+/*
+File Username:  Blockchain Subscription.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Users may subscribe to (follow) other users by node ID. Subscribed blockchains are pinned in the global blockchain cache and synced with priority.
+Changes to subscribed blockchains are published as feed events. The most recent events are kept in memory and streamed to registered monitors.
+*/
+
+package core
+
+import (
+    "errors"
+    "sync"
+    "time"
+
+    "github.com/newinfoOffical/core/blockchain"
+    "github.com/newinfoOffical/core/btcec"
+    "github.com/newinfoOffical/core/protocol"
+)
+
+const (
+    feedMaxEvents            = 1000             // Max count of feed events kept in memory.
+    subscriptionPollInterval = 5 * time.Minute  // Interval to poll subscribed blockchains.
+    subscriptionFindTimeout  = 10 * time.Second // Timeout to find a subscribed node via DHT.
+)
+
+// FeedX defines the type of a feed event
+const (
+    FeedFileShared     = 0 // A file was shared or its data changed.
+    FeedFileRemoved    = 1 // A file is no longer shared.
+    FeedProfileChanged = 2 // Profile fields were added or changed.
+)
+
+// FeedEvent is a change in the blockchain of a followed user
+type FeedEvent struct {
+    Type      int                             // See FeedX.
+    NodeID    []byte                          // Node ID of the followed user
+    PublicKey *btcec.PublicKey                // Public key of the followed user
+    Date      time.Time                       // Date the change was seen
+    File      blockchain.BlockRecordFile      // File, if the type is FeedFileShared or FeedFileRemoved
+    Profile   []blockchain.BlockRecordProfile // Changed profile fields, if the type is FeedProfileChanged
+}
+
+// feed keeps the most recent feed events and the monitors receiving new ones
+type feed struct {
+    events   []*FeedEvent        // Events, oldest first
+    monitors []chan<- *FeedEvent // Channels receiving new events
+    sync.RWMutex
+}
+
+var errCacheNotAvailable = errors.New("blockchain cache not available")
+
+// Subscribe follows the user. Its blockchain is pinned in the cache and synced with priority.
+func (backend *Backend) Subscribe(nodeID []byte) (err error) {
+    if backend.GlobalBlockchainCache == nil {
+        return errCacheNotAvailable
+    } else if err = backend.GlobalBlockchainCache.Store.WriteSubscription(nodeID); err != nil {
+        return err
+    }
+
+    go backend.pollSubscription(nodeID)
+
+    return nil
+}
+
+// Unsubscribe stops following the user. The blockchain remains in the cache but is no longer pinned.
+func (backend *Backend) Unsubscribe(nodeID []byte) (err error) {
+    if backend.GlobalBlockchainCache == nil {
+        return errCacheNotAvailable
+    }
+
+    backend.GlobalBlockchainCache.Store.DeleteSubscription(nodeID)
+    return nil
+}
+
+// ListSubscriptions returns all followed users.
+func (backend *Backend) ListSubscriptions() (subscriptions []blockchain.Subscription) {
+    if backend.GlobalBlockchainCache == nil {
+        return nil
+    }
+
+    return backend.GlobalBlockchainCache.Store.ListSubscriptions()
+}
+
+// IsSubscribed checks if the user is followed.
+func (backend *Backend) IsSubscribed(nodeID []byte) bool {
+    return backend.GlobalBlockchainCache != nil && backend.GlobalBlockchainCache.Store.IsSubscribed(nodeID)
+}
+
+// pollSubscription finds the followed node and queues its blockchain for sync with priority.
+func (backend *Backend) pollSubscription(nodeID []byte) {
+    peer := backend.NodelistLookup(nodeID)
+    if peer == nil {
+        // The DHT lookup is limited by a timeout, since the node is likely offline.
+        found := make(chan *PeerInfo, 1)
+        go func() {
+            _, peer, _ := backend.FindNode(nodeID, subscriptionFindTimeout)
+            found <- peer
+        }()
+
+        select {
+        case peer = <-found:
+        case <-time.After(subscriptionFindTimeout):
+        }
+    }
+
+    backend.PrioritizeBlockchainSync(peer)
+}
+
+// autoPollSubscriptions polls all subscribed blockchains periodically.
+func (backend *Backend) autoPollSubscriptions() {
+    if backend.GlobalBlockchainCache == nil {
+        return
+    }
+
+    for {
+        for _, subscription := range backend.GlobalBlockchainCache.Store.ListSubscriptions() {
+            backend.pollSubscription(subscription.NodeID)
+        }
+
+        time.Sleep(subscriptionPollInterval)
+    }
+}
+
+// publishDiff publishes the changes of a followed blockchain as feed events.
+func (cache *BlockchainCache) publishDiff(publicKey *btcec.PublicKey, diff blockchain.BlockchainDiff) {
+    nodeID := protocol.PublicKey2NodeID(publicKey)
+    timeN := time.Now()
+
+    var events []*FeedEvent
+
+    for _, file := range diff.FilesAdded {
+        events = append(events, &FeedEvent{Type: FeedFileShared, NodeID: nodeID, PublicKey: publicKey, Date: timeN, File: file})
+    }
+    for _, file := range diff.FilesRemoved {
+        events = append(events, &FeedEvent{Type: FeedFileRemoved, NodeID: nodeID, PublicKey: publicKey, Date: timeN, File: file})
+    }
+    if len(diff.ProfileChanged) > 0 {
+        events = append(events, &FeedEvent{Type: FeedProfileChanged, NodeID: nodeID, PublicKey: publicKey, Date: timeN, Profile: diff.ProfileChanged})
+    }
+
+    cache.feed.Lock()
+    defer cache.feed.Unlock()
+
+    cache.feed.events = append(cache.feed.events, events...)
+    if len(cache.feed.events) > feedMaxEvents {
+        cache.feed.events = append([]*FeedEvent{}, cache.feed.events[len(cache.feed.events)-feedMaxEvents:]...)
+    }
+
+    // send to all monitors non-blocking
+    for _, event := range events {
+        for _, monitor := range cache.feed.monitors {
+            select {
+            case monitor <- event:
+            default:
+            }
+        }
+    }
+}
+
+// FeedEvents returns the most recent feed events after the given date, newest first. Limit is the max count of events to return, 0 for all.
+func (backend *Backend) FeedEvents(since time.Time, limit int) (events []*FeedEvent) {
+    if backend.GlobalBlockchainCache == nil {
+        return nil
+    }
+
+    backend.GlobalBlockchainCache.feed.RLock()
+    defer backend.GlobalBlockchainCache.feed.RUnlock()
+
+    for n := len(backend.GlobalBlockchainCache.feed.events) - 1; n >= 0 && (limit == 0 || len(events) < limit); n-- {
+        event := backend.GlobalBlockchainCache.feed.events[n]
+        if !event.Date.After(since) {
+            break
+        }
+        events = append(events, event)
+    }
+
+    return events
+}
+
+// RegisterFeedMonitor registers a channel to receive all new feed events. Events are dropped if the channel is full.
+func (backend *Backend) RegisterFeedMonitor(channel chan<- *FeedEvent) {
+    if backend.GlobalBlockchainCache == nil {
+        return
+    }
+
+    backend.GlobalBlockchainCache.feed.Lock()
+    defer backend.GlobalBlockchainCache.feed.Unlock()
+
+    backend.GlobalBlockchainCache.feed.monitors = append(backend.GlobalBlockchainCache.feed.monitors, channel)
+}
+
+// UnregisterFeedMonitor unregisters a channel
+func (backend *Backend) UnregisterFeedMonitor(channel chan<- *FeedEvent) {
+    if backend.GlobalBlockchainCache == nil {
+        return
+    }
+
+    backend.GlobalBlockchainCache.feed.Lock()
+    defer backend.GlobalBlockchainCache.feed.Unlock()
+
+    for n, channel2 := range backend.GlobalBlockchainCache.feed.monitors {
+        if channel == channel2 {
+            backend.GlobalBlockchainCache.feed.monitors = append(backend.GlobalBlockchainCache.feed.monitors[:n:n], backend.GlobalBlockchainCache.feed.monitors[n+1:]...)
+            break
+        }
+    }
+}
@@ -7,10 +7,12 @@ Author:     Peter Kleissner
 package core
 
 import (
+    "bytes"
     "os"
 
     "github.com/newinfoOffical/core/blockchain"
     "github.com/newinfoOffical/core/btcec"
+    "github.com/newinfoOffical/core/search"
     "github.com/google/uuid"
 )
 
@@ -18,7 +20,7 @@ import (
 // If it is corrupted, it will log the error and exit the process.
 func (backend *Backend) initUserBlockchain() {
     var err error
-    backend.UserBlockchain, err = blockchain.Init(backend.PeerPrivateKey, backend.Config.BlockchainMain)
+    backend.UserBlockchain, err = blockchain.Init(backend.peerPrivateKey(), backend.Config.BlockchainMain)
 
     if err != nil {
         backend.LogError("initUserBlockchain", "error: %s\n", err.Error())
@@ -26,13 +28,45 @@ func (backend *Backend) initUserBlockchain() {
     }
 }
 
+// verifyUserBlockchain verifies the user's blockchain and repairs it if it is corrupt. It must be called after the update callbacks are set, so that the search index is updated.
+// Since records in corrupt blocks are lost, the repair is reported via the UserBlockchainRepair filter and UserBlockchainRepairStatus.
+// If the blockchain cannot be repaired, it will log the error and exit the process.
+func (backend *Backend) verifyUserBlockchain() {
+    corruptions := backend.UserBlockchain.Verify()
+    if len(corruptions) == 0 {
+        return
+    }
+
+    for _, corruption := range corruptions {
+        backend.LogError("verifyUserBlockchain", "%s\n", corruption.String())
+    }
+
+    newHeight, newVersion, status := backend.UserBlockchain.Repair()
+    if status != blockchain.StatusOK || len(backend.UserBlockchain.Verify()) > 0 {
+        backend.LogError("verifyUserBlockchain", "blockchain is unrecoverable, repair status %d\n", status)
+        os.Exit(ExitBlockchainCorrupt)
+    }
+
+    backend.LogError("verifyUserBlockchain", "blockchain repaired, new height %d version %d\n", newHeight, newVersion)
+
+    backend.userBlockchainRepair = corruptions
+    backend.Filters.UserBlockchainRepair(corruptions, newHeight, newVersion)
+}
+
+// UserBlockchainRepairStatus returns the corruptions of the user's blockchain that were repaired at startup. Records in these blocks were lost. It is empty if the blockchain was valid.
+func (backend *Backend) UserBlockchainRepairStatus() (corruptions []blockchain.Corruption) {
+    return backend.userBlockchainRepair
+}
+
 // Index the user's blockchain each time there is an update.
 func (backend *Backend) userBlockchainUpdateSearchIndex() {
     backend.UserBlockchain.BlockchainUpdate = func(blockchainU *blockchain.Blockchain, oldHeight, oldVersion, newHeight, newVersion uint64) {
+        // The full-text index reads the content of files from the warehouse, which may take a while.
+        go backend.userBlockchainUpdateContentIndex(oldHeight, oldVersion, newHeight, newVersion)
 
         if newVersion != oldVersion || newHeight < oldHeight {
             // invalidate search index data for the user's blockchain
-            backend.SearchIndex.UnindexBlockchain(backend.PeerPublicKey)
+            backend.SearchIndex.UnindexBlockchain(backend.peerPublicKey())
 
             // reindex everything
             for blockN := uint64(0); blockN < newHeight; blockN++ {
@@ -41,7 +75,7 @@ func (backend *Backend) userBlockchainUpdateSearchIndex() {
                     continue
                 }
 
-                backend.SearchIndex.IndexNewBlock(backend.PeerPublicKey, newVersion, blockN, raw)
+                backend.SearchIndex.IndexNewBlock(backend.peerPublicKey(), newVersion, blockN, raw)
             }
 
             return
@@ -55,16 +89,74 @@ func (backend *Backend) userBlockchainUpdateSearchIndex() {
                     continue
                 }
 
-                backend.SearchIndex.IndexNewBlock(backend.PeerPublicKey, newVersion, blockN, raw)
+                backend.SearchIndex.IndexNewBlock(backend.peerPublicKey(), newVersion, blockN, raw)
             }
         }
     }
 }
 
+// SearchIndexSources returns the blockchains the search index is built from, for rebuilding or checking the index.
+func (backend *Backend) SearchIndexSources() (sources search.IndexSources) {
+    sources.User = backend.UserBlockchain
+    sources.DecodeHook = backend.decodePrivateFilesShared
+
+    if backend.GlobalBlockchainCache != nil {
+        sources.Multi = backend.GlobalBlockchainCache.Store
+    }
+
+    return sources
+}
+
+// searchIndexRebuild rebuilds the search index in the background.
+func (backend *Backend) searchIndexRebuild() {
+    backend.LogError("searchIndexRebuild", "rebuilding search index\n")
+
+    if err := backend.SearchIndex.Rebuild(backend.SearchIndexSources(), nil); err != nil {
+        backend.LogError("searchIndexRebuild", "error: %v\n", err)
+    }
+}
+
+// autoCompactMinHeight is the minimum height of the user's blockchain before it is automatically compacted.
+const autoCompactMinHeight = 8
+
+// userBlockchainAutoCompact compacts the user's blockchain when new blocks are appended and the fragmentation exceeds the configured threshold.
+// It must be called after any other update callback is set, since that one is chained.
+func (backend *Backend) userBlockchainAutoCompact() {
+    if backend.Config.CompactFragmentation <= 0 {
+        return
+    }
+
+    updateOther := backend.UserBlockchain.BlockchainUpdate
+
+    backend.UserBlockchain.BlockchainUpdate = func(blockchainU *blockchain.Blockchain, oldHeight, oldVersion, newHeight, newVersion uint64) {
+        if updateOther != nil {
+            updateOther(blockchainU, oldHeight, oldVersion, newHeight, newVersion)
+        }
+
+        // The callback is called while the blockchain is locked, therefore compaction runs in a separate Go routine.
+        if newVersion == oldVersion && newHeight > oldHeight && newHeight >= autoCompactMinHeight {
+            go backend.compactUserBlockchain(float64(backend.Config.CompactFragmentation) / 100)
+        }
+    }
+}
+
+// compactUserBlockchain compacts the user's blockchain if the fragmentation exceeds the threshold (between 0 and 1).
+// The search index is updated via the blockchain update callback, since compaction creates a new version.
+func (backend *Backend) compactUserBlockchain(threshold float64) {
+    fragmentation, status := backend.UserBlockchain.Fragmentation()
+    if status != blockchain.StatusOK || fragmentation < threshold {
+        return
+    }
+
+    if _, _, status = backend.UserBlockchain.Compact(); status != blockchain.StatusOK {
+        backend.LogError("compactUserBlockchain", "error compacting blockchain status %d\n", status)
+    }
+}
+
 // ReadBlock reads a block and decodes the records. This may be a block of the user's blockchain, or any other that is cached in the global blockchain cache.
 func (backend *Backend) ReadBlock(PublicKey *btcec.PublicKey, Version, BlockNumber uint64) (decoded *blockchain.BlockDecoded, raw []byte, found bool, err error) {
     // requesting a block from the user's blockchain?
-    if PublicKey.IsEqual(backend.PeerPublicKey) {
+    if PublicKey.IsEqual(backend.peerPublicKey()) {
         _, _, version := backend.UserBlockchain.Header()
         if Version != version {
             return nil, nil, false, nil
@@ -80,6 +172,8 @@ func (backend *Backend) ReadBlock(PublicKey *btcec.PublicKey, Version, BlockNumb
         if raw, found = backend.GlobalBlockchainCache.Store.ReadBlock(PublicKey, Version, BlockNumber); !found {
             return nil, nil, false, nil
         }
+
+        backend.GlobalBlockchainCache.RecordAccess(PublicKey)
     } else {
         return nil, nil, false, nil
     }
@@ -90,6 +184,9 @@ func (backend *Backend) ReadBlock(PublicKey *btcec.PublicKey, Version, BlockNumb
         return nil, raw, false, err
     }
 
+    // private files are only readable by the owner and the recipients
+    backend.decodePrivateFilesShared(blockDecoded)
+
     return blockDecoded, raw, true, nil
 }
 
@@ -108,3 +205,37 @@ func (backend *Backend) ReadFile(PublicKey *btcec.PublicKey, Version, BlockNumbe
 
     return file, raw, false, nil
 }
+
+// ReadFileByHash finds a file by its hash on the given blockchain (the user or any other cached). Only blocks stored in the cache are searched.
+// The file record is signed by the owner, so that its merkle root hash can be used to verify data downloaded from any peer.
+func (backend *Backend) ReadFileByHash(PublicKey *btcec.PublicKey, hash []byte) (file blockchain.BlockRecordFile, found bool) {
+    if PublicKey.IsEqual(backend.peerPublicKey()) {
+        files, status := backend.UserBlockchain.FileExists(hash)
+        if status != blockchain.StatusOK || len(files) == 0 {
+            return file, false
+        }
+        return files[0], true
+    } else if backend.GlobalBlockchainCache == nil {
+        return file, false
+    }
+
+    header, found, err := backend.GlobalBlockchainCache.Store.ReadBlockchainHeader(PublicKey)
+    if err != nil || !found {
+        return file, false
+    }
+
+    for _, blockN := range header.ListBlocks {
+        blockDecoded, _, found, _ := backend.ReadBlock(PublicKey, header.Version, blockN)
+        if !found {
+            continue
+        }
+
+        for _, decodedR := range blockDecoded.RecordsDecoded {
+            if file, ok := decodedR.(blockchain.BlockRecordFile); ok && bytes.Equal(file.Hash, hash) {
+                return file, true
+            }
+        }
+    }
+
+    return file, false
+}
@@ -56,7 +56,7 @@ loopSeedList:
             continue
         }
 
-        if peer.publicKey.IsEqual(backend.PeerPublicKey) { // skip if self
+        if peer.publicKey.IsEqual(backend.peerPublicKey()) { // skip if self
             continue
         }
 
@@ -166,6 +166,24 @@ func (backend *Backend) bootstrap() {
     backend.LogError("bootstrap", "unable to connect to at least 2 root peers, aborting\n")
 }
 
+// waitPeers waits until at least the count of peers is connected, or until the timeout expires.
+func (backend *Backend) waitPeers(count int, timeout time.Duration) {
+    monitor := make(chan *PeerInfo, 1)
+    backend.registerPeerMonitor(monitor)
+    defer backend.unregisterPeerMonitor(monitor)
+
+    timer := time.NewTimer(timeout)
+    defer timer.Stop()
+
+    for backend.PeerlistCount() < count {
+        select {
+        case <-monitor:
+        case <-timer.C:
+            return
+        }
+    }
+}
+
 func (nets *Networks) autoMulticastBroadcast() {
     sendMulticastBroadcast := func() {
         nets.RLock()
@@ -287,7 +305,7 @@ func (backend *Backend) isReturnedPeerBadQuality(record *protocol.PeerRecord) bo
     }
 
     // Must not be self. There is no point that a remote peer would return self
-    if record.PublicKey.IsEqual(backend.PeerPublicKey) {
+    if record.PublicKey.IsEqual(backend.peerPublicKey()) {
         //fmt.Printf("IsReturnedPeerBadQuality received self peer\n")
         return true
     }
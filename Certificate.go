@@ -0,0 +1,92 @@
+/*
+File Username:  Certificate.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Certificates in blockchains are only considered valid if issued by a trusted issuer listed in the config.
+*/
+
+package core
+
+import (
+	"github.com/newinfoOffical/core/blockchain"
+	"github.com/newinfoOffical/core/btcec"
+)
+
+// initCertificateIssuers loads the list of trusted certificate issuers from the config.
+func (backend *Backend) initCertificateIssuers() {
+	backend.certificateIssuers = make(map[[btcec.PubKeyBytesLenCompressed]byte]struct{})
+
+	for _, issuer := range backend.Config.CertificateIssuers {
+		publicKey, err := PublicKeyFromPeerID(issuer)
+		if err != nil {
+			backend.LogError("initCertificateIssuers", "invalid certificate issuer '%s': %s\n", issuer, err.Error())
+			continue
+		}
+
+		backend.certificateIssuers[publicKey2Compressed(publicKey)] = struct{}{}
+	}
+}
+
+// IsCertificateTrusted checks if the certificate is issued by a trusted issuer and not expired.
+// The signature is already verified when the certificate record is decoded.
+func (backend *Backend) IsCertificateTrusted(certificate *blockchain.BlockRecordCertificate) bool {
+	if certificate.IsExpired() {
+		return false
+	}
+
+	_, ok := backend.certificateIssuers[publicKey2Compressed(certificate.Issuer)]
+	return ok
+}
+
+// ReadCertificates returns all certificates of the blockchain. This may be the user's blockchain, or any other that is cached in the global blockchain cache.
+func (backend *Backend) ReadCertificates(PublicKey *btcec.PublicKey, Version uint64) (certificates []blockchain.BlockRecordCertificate) {
+	if PublicKey.IsEqual(backend.peerPublicKey()) {
+		certificates, _ = backend.UserBlockchain.CertificateList()
+		return certificates
+	} else if backend.GlobalBlockchainCache == nil {
+		return nil
+	}
+
+	header, found, err := backend.GlobalBlockchainCache.Store.ReadBlockchainHeader(PublicKey)
+	if err != nil || !found || header.Version != Version {
+		return nil
+	}
+
+	for _, blockN := range header.ListBlocks {
+		blockDecoded, _, found, _ := backend.ReadBlock(PublicKey, Version, blockN)
+		if !found {
+			continue
+		}
+
+		for _, decodedR := range blockDecoded.RecordsDecoded {
+			if certificate, ok := decodedR.(blockchain.BlockRecordCertificate); ok {
+				certificates = append(certificates, certificate)
+			}
+		}
+	}
+
+	return certificates
+}
+
+// IsProfileFieldVerified checks if the profile field is certified by a trusted issuer.
+func (backend *Backend) IsProfileFieldVerified(certificates []blockchain.BlockRecordCertificate, field *blockchain.BlockRecordProfile) bool {
+	for n := range certificates {
+		if certificates[n].CertifiesProfileField(field) && backend.IsCertificateTrusted(&certificates[n]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsFileVerified checks if the file is certified by a trusted issuer.
+func (backend *Backend) IsFileVerified(certificates []blockchain.BlockRecordCertificate, file *blockchain.BlockRecordFile) bool {
+	for n := range certificates {
+		if certificates[n].CertifiesFile(file) && backend.IsCertificateTrusted(&certificates[n]) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -156,7 +156,7 @@ func (peer *PeerInfo) cmdResponse(msg *protocol.MessageResponse, connection *Con
     if _, ok := msg.SequenceInfo.Data.(*bootstrapFindSelf); ok {
         for _, hash2Peer := range msg.Hash2Peers {
             // Make sure no garbage is returned. The key must be self and only Closest is expected.
-            if !bytes.Equal(hash2Peer.ID.Hash, peer.Backend.nodeID) || len(hash2Peer.Closest) == 0 {
+            if !bytes.Equal(hash2Peer.ID.Hash, peer.Backend.SelfNodeID()) || len(hash2Peer.Closest) == 0 {
                 peer.Backend.LogError("cmdResponse", "incoming response to bootstrap FIND_SELF contains invalid data from %s\n", connection.Address.String())
                 return
             }
@@ -272,6 +272,9 @@ func (peer *PeerInfo) cmdTransfer(msg *protocol.MessageTransfer, connection *Con
         if v, ok := msg.SequenceInfo.Data.(*VirtualPacketConn); ok {
             v.Terminate(404)
             return
+        } else if request, ok := msg.SequenceInfo.Data.(*fileProofRequest); ok {
+            request.respond(nil)
+            return
         }
 
     case protocol.TransferControlTerminate:
@@ -280,6 +283,15 @@ func (peer *PeerInfo) cmdTransfer(msg *protocol.MessageTransfer, connection *Con
             return
         }
 
+    case protocol.TransferControlRequestProof:
+        go peer.sendFileProofs(msg.Hash, msg.Fragment, msg.FragmentCount, msg.Sequence)
+
+    case protocol.TransferControlProof:
+        if request, ok := msg.SequenceInfo.Data.(*fileProofRequest); ok && bytes.Equal(request.hash, msg.Hash) {
+            request.respond(msg.Proofs)
+            return
+        }
+
     }
 }
 
@@ -288,7 +300,7 @@ func (peer *PeerInfo) cmdGetBlock(msg *protocol.MessageGetBlock, connection *Con
     switch msg.Control {
     case protocol.GetBlockControlRequestStart:
         // Currently only support the local blockchain.
-        if !msg.BlockchainPublicKey.IsEqual(peer.Backend.PeerPublicKey) {
+        if !msg.BlockchainPublicKey.IsEqual(peer.Backend.peerPublicKey()) {
             peer.sendGetBlock(nil, protocol.GetBlockControlNotAvailable, msg.BlockchainPublicKey, 0, 0, nil, msg.Sequence, uuid.UUID{}, false)
             return
         } else if _, height, _ := peer.Backend.UserBlockchain.Header(); height == 0 {
@@ -23,13 +23,16 @@ const Version = "Alpha 9/01.11.2022"
 // Config defines the minimum required config for a Peernet client.
 type Config struct {
 	// Locations of important files and folders
-	LogFile          string `yaml:"LogFile"`          // Log file. It contains informational and error messages.
-	BlockchainMain   string `yaml:"BlockchainMain"`   // Blockchain main stores the end-users blockchain data. It contains meta data of shared files, profile data, and social interactions.
-	BlockchainGlobal string `yaml:"BlockchainGlobal"` // Blockchain global caches blockchain data from global users. Empty to disable.
-	WarehouseMain    string `yaml:"WarehouseMain"`    // Warehouse main stores the actual data of files shared by the end-user.
-	SearchIndex      string `yaml:"SearchIndex"`      // Local search index of blockchain records. Empty to disable.
-	GeoIPDatabase    string `yaml:"GeoIPDatabase"`    // GeoLite2 City database to provide GeoIP information.
-	DataFolder       string `yaml:"DataFolder"`       // Data folder.
+	LogFile            string `yaml:"LogFile"`            // Log file. It contains informational and error messages.
+	BlockchainMain     string `yaml:"BlockchainMain"`     // Blockchain main stores the end-users blockchain data. It contains meta data of shared files, profile data, and social interactions.
+	BlockchainGlobal   string `yaml:"BlockchainGlobal"`   // Blockchain global caches blockchain data from global users. Empty to disable.
+	WarehouseMain      string `yaml:"WarehouseMain"`      // Warehouse main stores the actual data of files shared by the end-user.
+	SearchIndex        string `yaml:"SearchIndex"`        // Local search index of blockchain records. Empty to disable.
+	SearchIndexContent string `yaml:"SearchIndexContent"` // Full-text index of the content of shared text files. Empty to disable.
+	MessageStore       string `yaml:"MessageStore"`       // Message store for direct messages. Empty to disable.
+	SavedSearchStore   string `yaml:"SavedSearchStore"`   // Saved searches and their notifications. Empty to disable.
+	GeoIPDatabase      string `yaml:"GeoIPDatabase"`      // GeoLite2 City database to provide GeoIP information.
+	DataFolder         string `yaml:"DataFolder"`         // Data folder.
 
 	// Target for the log messages: 0 = Log file,  1 = Stdout, 2 = Log file + Stdout, 3 = None
 	LogTarget int `yaml:"LogTarget"`
@@ -42,6 +45,22 @@ type Config struct {
 	// User specific settings
 	PrivateKey string `yaml:"PrivateKey"` // The Private Key, hex encoded so it can be copied manually
 
+	// User blockchain settings
+	CompactFragmentation int `yaml:"CompactFragmentation"` // Fragmentation in percent of blocks that triggers automatic compaction. 0 = disabled. Each compaction makes peers download the entire blockchain again.
+
+	// Warehouse settings
+	WarehouseChunking bool `yaml:"WarehouseChunking"` // Store new files in content-defined chunks, which deduplicates identical parts of different files.
+
+	// Warehouse integrity check settings
+	WarehouseScrubRate     uint64 `yaml:"WarehouseScrubRate"`     // Max bytes per second read by the background integrity check of the warehouse. 0 = disabled.
+	WarehouseScrubInterval int    `yaml:"WarehouseScrubInterval"` // Hours between passes of the integrity check. Default 168 (weekly).
+
+	// Search index settings
+	SearchLanguage string `yaml:"SearchLanguage"` // Language for stemming and stop words in the search index, for example "en" or "de". Empty to disable. Changing it rebuilds the index.
+
+	// Media metadata settings
+	MediaMetadataGPS bool `yaml:"MediaMetadataGPS"` // Keep the GPS location extracted from pictures when sharing files. Disabled by default for privacy.
+
 	// Initial peer seed list
 	SeedList           []PeerSeed `yaml:"SeedList"`
 	AutoUpdateSeedList bool       `yaml:"AutoUpdateSeedList"`
@@ -58,7 +77,10 @@ type Config struct {
 	// Global blockchain cache limits
 	CacheMaxBlockSize  uint64 `yaml:"CacheMaxBlockSize"`  // Max block size to accept in bytes.
 	CacheMaxBlockCount uint64 `yaml:"CacheMaxBlockCount"` // Max block count to cache per peer.
-	LimitTotalRecords  uint64 `yaml:"LimitTotalRecords"`  // Record count limit. 0 = unlimited. Max Records * Max Block Size = Size Limit.
+	LimitTotalRecords  uint64 `yaml:"LimitTotalRecords"`  // Record count limit. 0 = unlimited. Max Records * Max Block Size = Size Limit. Least recently used blockchains are evicted when reached.
+
+	// Certificates
+	CertificateIssuers []string `yaml:"CertificateIssuers"` // Public keys of trusted certificate issuers. Hex encoded.
 }
 
 // PeerSeed is a singl peer entry from the config's seed list
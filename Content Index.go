@@ -0,0 +1,357 @@
+/*
+File Username:  Content Index.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+The full-text index contains the words of the content of files shared by the user. It is separate from the search index of file metadata.
+Text is extracted by a content extractor registered for the file format. Extractors for text (including Markdown), HTML, and CSV files are registered by default.
+Only the first contentIndexSizeMax bytes of a file and its contentIndexTermsMax most frequent words are indexed. Private files are not indexed.
+
+The most frequent words of each file are published in the DHT, so that other peers can find files by their content. The DHT key is the blake3 hash of "content " followed by the hash of the word.
+At most contentPublishWordsMax words are published in total, the words contained in the most files first. The stores are spread evenly across the publish interval.
+Encoding of the published value:
+Offset  Size   Info
+0       32     Node ID of the publisher
+32      ?      Hashes of the files that contain the word, 32 bytes each
+
+*/
+
+package core
+
+import (
+    "bytes"
+    "encoding/csv"
+    "errors"
+    "io"
+    "path"
+    "regexp"
+    "sort"
+    "strings"
+    "time"
+
+    "github.com/google/uuid"
+    "github.com/newinfoOffical/core/blockchain"
+    "github.com/newinfoOffical/core/protocol"
+    "github.com/newinfoOffical/core/search"
+    "github.com/newinfoOffical/core/warehouse"
+    "golang.org/x/net/html"
+)
+
+const (
+    contentIndexSizeMax      = 4 * 1024 * 1024 // Max count of bytes of a file to extract text from.
+    contentIndexTermsMax     = 2000            // Max count of distinct words indexed per file.
+    contentPublishTermsMax   = 50              // Max count of words published per file.
+    contentPublishFilesMax   = 32              // Max count of file hashes published per word.
+    contentPublishWordsMax   = 2000            // Max count of words published in total.
+    contentPublishInterval   = time.Hour       // Interval to publish the words in the DHT.
+    contentPublishExpiration = 2 * time.Hour   // Published words expire after this duration, unless published again.
+    contentPublishClosest    = 5               // Count of closest peers to inform about published words.
+)
+
+// ContentExtractor extracts the text from the content of a file. Data is limited to contentIndexSizeMax bytes and may be truncated.
+type ContentExtractor func(file *blockchain.BlockRecordFile, data []byte) (text string, err error)
+
+func (backend *Backend) initContentIndex() {
+    backend.contentExtractors = map[uint16]ContentExtractor{
+        FormatText: extractContentText,
+        FormatHTML: extractContentHTML,
+        FormatCSV:  extractContentCSV,
+    }
+
+    if backend.Config.SearchIndexContent == "" {
+        return
+    }
+
+    var err error
+    if backend.ContentIndex, err = search.InitSearchIndexStore(backend.Config.SearchIndexContent, backend.Config.SearchLanguage); err != nil {
+        backend.LogError("initContentIndex", "full-text index '%s' init: %s", backend.Config.SearchIndexContent, err.Error())
+        backend.ContentIndex = nil
+        return
+    }
+
+    // Files may have been shared while the index was not available.
+    go backend.contentIndexUpdate(0, false)
+}
+
+// RegisterContentExtractor registers an extractor for the file format. It replaces any existing extractor for the format. It must be called before files are shared.
+func (backend *Backend) RegisterContentExtractor(format uint16, extractor ContentExtractor) {
+    backend.contentIndexMutex.Lock()
+    defer backend.contentIndexMutex.Unlock()
+
+    backend.contentExtractors[format] = extractor
+}
+
+// contentIndexUpdate indexes the content of all files in the user's blockchain starting at the block. Files that are already indexed are skipped.
+// If reset is set, the index is deleted first; it is always deleted if it was created by an older version.
+func (backend *Backend) contentIndexUpdate(fromBlock uint64, reset bool) {
+    if backend.ContentIndex == nil {
+        return
+    }
+
+    backend.contentIndexMutex.Lock()
+    defer backend.contentIndexMutex.Unlock()
+
+    if reset || backend.ContentIndex.RebuildRequired {
+        backend.ContentIndex.Reset()
+        fromBlock = 0
+    }
+
+    publicKey, height, version := backend.UserBlockchain.Header()
+
+    for blockN := fromBlock; blockN < height; blockN++ {
+        raw, status, err := backend.UserBlockchain.GetBlockRaw(blockN)
+        if err != nil || status != blockchain.StatusOK {
+            continue
+        }
+
+        decoded, status, err := blockchain.DecodeBlockRaw(raw)
+        if err != nil || status != blockchain.StatusOK {
+            continue
+        }
+
+        for _, decodedR := range decoded.RecordsDecoded {
+            file, ok := decodedR.(blockchain.BlockRecordFile)
+            if !ok || len(file.Recipients) > 0 || backend.ContentIndex.IsContentIndexed(file.ID) {
+                continue
+            }
+
+            extractor, ok := backend.contentExtractors[file.Format]
+            if !ok {
+                continue
+            }
+
+            text, err := backend.extractContent(&file, extractor)
+            if err != nil {
+                continue
+            }
+
+            backend.ContentIndex.IndexContent(publicKey, version, blockN, file.ID, file.Hash, text, contentIndexTermsMax, contentPublishTermsMax)
+        }
+    }
+}
+
+// extractContent reads the file from the warehouse and extracts the text.
+func (backend *Backend) extractContent(file *blockchain.BlockRecordFile, extractor ContentExtractor) (text string, err error) {
+    var buffer bytes.Buffer
+    if status, _, err := backend.UserWarehouse.ReadFile(file.Hash, 0, contentIndexSizeMax, &buffer); status != warehouse.StatusOK {
+        if err == nil {
+            err = errors.New("file not available")
+        }
+        return "", err
+    }
+
+    return extractor(file, buffer.Bytes())
+}
+
+// userBlockchainUpdateContentIndex updates the full-text index after the user's blockchain changed. A new version requires to index everything again.
+func (backend *Backend) userBlockchainUpdateContentIndex(oldHeight, oldVersion, newHeight, newVersion uint64) {
+    if newVersion != oldVersion || newHeight < oldHeight {
+        backend.contentIndexUpdate(0, true)
+    } else if newHeight > oldHeight {
+        backend.contentIndexUpdate(oldHeight, false)
+    }
+}
+
+// ---- publishing ----
+
+// contentKeywordKey returns the DHT key for the word hash.
+func contentKeywordKey(wordHash []byte) (key []byte) {
+    return protocol.HashData(append([]byte("content "), wordHash...))
+}
+
+// contentKeyword is a word to publish in the DHT
+type contentKeyword struct {
+    wordHash   []byte   // Hash of the word
+    fileHashes [][]byte // Hashes of the files that contain the word, up to contentPublishFilesMax
+    countFiles int      // Count of files that contain the word
+}
+
+// selectContentKeywords returns up to wordsMax words recorded for publishing, the words contained in the most files first.
+func (backend *Backend) selectContentKeywords(wordsMax int) (words []*contentKeyword) {
+    list := make(map[string]*contentKeyword)
+
+    backend.ContentIndex.ContentKeywords(func(fileID uuid.UUID, fileHash []byte, wordHashes [][]byte) {
+        for _, wordHash := range wordHashes {
+            word, ok := list[string(wordHash)]
+            if !ok {
+                word = &contentKeyword{wordHash: append([]byte{}, wordHash...)}
+                list[string(wordHash)] = word
+                words = append(words, word)
+            }
+
+            word.countFiles++
+            if len(word.fileHashes) < contentPublishFilesMax {
+                word.fileHashes = append(word.fileHashes, append([]byte{}, fileHash...))
+            }
+        }
+    })
+
+    sort.Slice(words, func(i, j int) bool {
+        if words[i].countFiles != words[j].countFiles {
+            return words[i].countFiles > words[j].countFiles
+        }
+        return bytes.Compare(words[i].wordHash, words[j].wordHash) < 0
+    })
+
+    if len(words) > wordsMax {
+        words = words[:wordsMax]
+    }
+
+    return words
+}
+
+// publishContentKeywords stores the published words of all indexed files in the DHT. The stores are spread evenly across the duration.
+func (backend *Backend) publishContentKeywords(duration time.Duration) {
+    words := backend.selectContentKeywords(contentPublishWordsMax)
+    if len(words) == 0 {
+        return
+    }
+
+    delay := duration / time.Duration(len(words))
+
+    for _, word := range words {
+        data := append([]byte{}, backend.SelfNodeID()...)
+        for _, fileHash := range word.fileHashes {
+            data = append(data, fileHash...)
+        }
+
+        key := contentKeywordKey(word.wordHash)
+        if err := backend.dhtStore.StoreExpire(key, data, time.Now().Add(contentPublishExpiration)); err == nil {
+            backend.nodesDHT.Store(key, uint64(len(data)), contentPublishClosest)
+        }
+
+        time.Sleep(delay)
+    }
+}
+
+// autoPublishContentKeywords publishes the words of the full-text index periodically.
+func (backend *Backend) autoPublishContentKeywords() {
+    if backend.ContentIndex == nil {
+        return
+    }
+
+    // wait for the initial bootstrap
+    time.Sleep(time.Minute)
+
+    for {
+        start := time.Now()
+        backend.publishContentKeywords(contentPublishInterval)
+        time.Sleep(time.Until(start.Add(contentPublishInterval)))
+    }
+}
+
+// FindContentKeyword looks up the word in the DHT. It returns the node ID of a peer that published the word and the hashes of its files that contain the word.
+// Only one published record is returned, even if multiple peers published the word.
+func (backend *Backend) FindContentKeyword(word string) (nodeID []byte, fileHashes [][]byte, found bool) {
+    wordHash := search.HashWord(word)
+    if wordHash == nil {
+        return nil, nil, false
+    }
+
+    data, _, found := backend.GetData(contentKeywordKey(wordHash))
+    if !found || len(data) < protocol.HashSize || len(data)%protocol.HashSize != 0 {
+        return nil, nil, false
+    }
+
+    for offset := protocol.HashSize; offset < len(data); offset += protocol.HashSize {
+        fileHashes = append(fileHashes, data[offset:offset+protocol.HashSize])
+    }
+
+    return data[:protocol.HashSize], fileHashes, true
+}
+
+// ---- extractors ----
+
+var (
+    markdownLink  = regexp.MustCompile(`!?\[([^\]]*)\]\([^)]*\)`) // Links and images, the text is kept.
+    markdownCode  = regexp.MustCompile("(?m)^(```|~~~).*$")       // Fences of code blocks
+    markdownRules = regexp.MustCompile(`(?m)^\s*([-*_]\s*){3,}$`)  // Horizontal rules
+)
+
+// contentFileName returns the name of the file. It is empty if the file has no name.
+func contentFileName(file *blockchain.BlockRecordFile) string {
+    if tag := file.GetTag(blockchain.TagName); tag != nil {
+        return tag.Text()
+    }
+    return ""
+}
+
+// extractContentText returns the text of a text file. Markdown syntax is removed from files with the extension .md or .markdown.
+func extractContentText(file *blockchain.BlockRecordFile, data []byte) (text string, err error) {
+    text = string(data)
+
+    switch strings.ToLower(path.Ext(contentFileName(file))) {
+    case ".md", ".markdown":
+        text = markdownLink.ReplaceAllString(text, "$1")
+        text = markdownCode.ReplaceAllString(text, "")
+        text = markdownRules.ReplaceAllString(text, "")
+    }
+
+    return text, nil
+}
+
+// extractContentHTML returns the text of an HTML document. Scripts and styles are skipped.
+func extractContentHTML(file *blockchain.BlockRecordFile, data []byte) (text string, err error) {
+    var builder strings.Builder
+    tokenizer := html.NewTokenizer(bytes.NewReader(data))
+    skip := 0
+
+    for {
+        switch tokenizer.Next() {
+        case html.ErrorToken:
+            if err := tokenizer.Err(); err != io.EOF {
+                return "", err
+            }
+            return builder.String(), nil
+
+        case html.StartTagToken:
+            if name, _ := tokenizer.TagName(); string(name) == "script" || string(name) == "style" {
+                skip++
+            }
+
+        case html.EndTagToken:
+            if name, _ := tokenizer.TagName(); (string(name) == "script" || string(name) == "style") && skip > 0 {
+                skip--
+            }
+
+        case html.TextToken:
+            if skip == 0 {
+                builder.Write(tokenizer.Text())
+                builder.WriteString(" ")
+            }
+        }
+    }
+}
+
+// extractContentCSV returns the fields of a CSV file. Files with the extension .tsv are tab separated. The last line may be truncated.
+func extractContentCSV(file *blockchain.BlockRecordFile, data []byte) (text string, err error) {
+    reader := csv.NewReader(bytes.NewReader(data))
+    reader.LazyQuotes = true
+    reader.FieldsPerRecord = -1
+    reader.ReuseRecord = true
+    if strings.ToLower(path.Ext(contentFileName(file))) == ".tsv" {
+        reader.Comma = '\t'
+    }
+
+    var builder strings.Builder
+
+    for {
+        record, err := reader.Read()
+        if err == io.EOF {
+            break
+        } else if err != nil {
+            // Not valid CSV, or truncated. The text is used as it is.
+            if builder.Len() == 0 {
+                return string(data), nil
+            }
+            break
+        }
+
+        for _, field := range record {
+            builder.WriteString(field)
+            builder.WriteString("\n")
+        }
+    }
+
+    return builder.String(), nil
+}
@@ -0,0 +1,107 @@
+/*
+File Username:  Direct Message Inbox.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+The inbox stores messages for offline users in the DHT. Each user has a fixed number of inbox slots per day.
+The key of a slot is the node ID of the receiver with the last 8 bytes replaced by the day number and the slot number. This places the messages near the receiver's node ID in the DHT.
+Senders use the first free slot of the current day. Values in the DHT cannot be deleted by the receiver, therefore the slots rotate daily instead and expire with the stored messages.
+Since the stored envelopes are end-to-end encrypted, the inbox does not reveal the content to anyone but the receiver. The sender's public key is part of the envelope header and is visible to anyone who reads the slot.
+The slot keys of a day can be calculated by anyone who knows the node ID of the receiver. A single sender can therefore take all slots of a day and block the inbox for other senders until the next day.
+*/
+
+package core
+
+import (
+    "bytes"
+    "encoding/binary"
+    "time"
+
+    "github.com/newinfoOffical/core/protocol"
+)
+
+const (
+    inboxSlots        = 32                 // Count of inbox slots per user and day.
+    inboxExpiration   = 7 * 24 * time.Hour // Messages in the inbox expire after this duration.
+    inboxPollInterval = 5 * time.Minute    // Interval to poll the own inbox.
+    inboxStoreClosest = 5                  // Count of closest peers to inform about stored inbox messages.
+)
+
+// inboxKey returns the DHT key of the inbox slot of the user for the day
+func inboxKey(nodeID []byte, day uint32, slot uint32) (key []byte) {
+    key = make([]byte, protocol.HashSize)
+    copy(key, nodeID[:protocol.HashSize-8])
+    binary.LittleEndian.PutUint32(key[protocol.HashSize-8:], day)
+    binary.LittleEndian.PutUint32(key[protocol.HashSize-4:], slot)
+    return key
+}
+
+// inboxDay returns the day number used for the inbox slots
+func inboxDay(date time.Time) uint32 {
+    return uint32(date.Unix() / int64(24*time.Hour/time.Second))
+}
+
+// storeInboxMessage stores the envelope of the message in the first free inbox slot of the receiver for the current day.
+// It is attempted at most once per day, and only until the inbox expiration since the message was sent. The day and slot are stored with the message.
+func (backend *Backend) storeInboxMessage(message *DirectMessage) {
+    day := inboxDay(time.Now())
+    if message.inboxDay == day || time.Since(message.DateSent) > inboxExpiration {
+        return
+    }
+
+    slot := uint32(0)
+    for ; slot < inboxSlots; slot++ {
+        key := inboxKey(message.NodeID, day, slot)
+
+        if data, found := backend.GetDataLocal(key); found {
+            if bytes.Equal(data, message.envelope) {
+                break
+            }
+            continue
+        } else if _, _, found := backend.GetDataDHT(key); found {
+            continue
+        }
+
+        if err := backend.dhtStore.StoreExpire(key, message.envelope, time.Now().Add(inboxExpiration)); err != nil {
+            return
+        }
+        backend.nodesDHT.Store(key, uint64(len(message.envelope)), inboxStoreClosest)
+        break
+    }
+
+    // The message may have been acknowledged in the meantime.
+    current, found := backend.messages.readMessage(message.ID)
+    if !found || !current.isPending() {
+        return
+    }
+
+    current.inboxDay, current.inboxSlot = day, slot
+    if slot < inboxSlots {
+        current.Status = MessageStatusInbox
+    }
+    backend.messages.writeMessage(current)
+}
+
+// pollInbox reads all messages from the own inbox of the days that have not expired yet. Slots are filled in order, so polling of a day stops at the first empty slot.
+// Slots with invalid or foreign envelopes are skipped. Local copies of the own inbox are deleted once read, since they are no longer needed.
+func (backend *Backend) pollInbox() {
+    today := inboxDay(time.Now())
+    days := uint32(inboxExpiration / (24 * time.Hour))
+
+    for day := today - days; day <= today; day++ {
+        for slot := uint32(0); slot < inboxSlots; slot++ {
+            key := inboxKey(backend.SelfNodeID(), day, slot)
+
+            data, _, found := backend.GetData(key)
+            if !found {
+                break
+            }
+
+            if _, err := backend.receiveMessage(data, nil); err != nil {
+                continue
+            }
+
+            backend.dhtStore.Delete(key)
+        }
+    }
+}
@@ -0,0 +1,438 @@
+/*
+File Username:  Direct Message.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Direct messages are end-to-end encrypted text messages between users. See protocol.EncryptMessageEnvelope for the encryption.
+Messages are delivered directly if the receiver is connected. The receiver acknowledges each message using the sequence number of the message.
+Until acknowledged, messages are retried periodically and stored in the DHT inbox of the receiver, which is polled by the receiver.
+A message is stored in the inbox at most once per day, and only until the inbox expiration since it was sent.
+
+Encoding of a message in the message store (key = message ID):
+Offset  Size   Info
+0       1      Direction: 0 = Received, 1 = Sent
+1       1      Status, see MessageStatusX
+2       33     Public key compressed of the other user
+35      8      Date sent
+43      8      Date of the status (delivered or received)
+51      4      Inbox day of the last attempt to store the message in the DHT inbox. 0 = never.
+55      1      Inbox slot the message was stored in. inboxSlots = all slots of the day were taken.
+56      2      Size of the text
+58      ?      Text
+?       ?      Message envelope. Only for pending sent messages.
+
+*/
+
+package core
+
+import (
+    "encoding/binary"
+    "errors"
+    "sort"
+    "sync"
+    "time"
+
+    "github.com/google/uuid"
+    "github.com/newinfoOffical/core/btcec"
+    "github.com/newinfoOffical/core/protocol"
+    "github.com/newinfoOffical/core/store"
+)
+
+// MessageStatusX defines the status of a direct message
+const (
+    MessageStatusPending   = 0 // Sent, but not yet acknowledged by the receiver.
+    MessageStatusDelivered = 1 // Sent and acknowledged by the receiver.
+    MessageStatusReceived  = 2 // Received from the other user.
+    MessageStatusInbox     = 3 // Sent and stored in the DHT inbox of the receiver, but not yet acknowledged.
+)
+
+// MessageSendX is the status of sending a direct message
+const (
+    MessageSendOK               = 0 // Success. The message is delivered asynchronously.
+    MessageSendNotAvailable     = 1 // The message store is not available.
+    MessageSendReceiverNotFound = 2 // The public key of the receiver is unknown and it could not be found.
+    MessageSendTextTooLong      = 3 // The text exceeds protocol.MessageTextMax.
+)
+
+const (
+    messageRetryInterval = time.Minute      // Interval to retry delivery of pending messages.
+    messageFindTimeout   = 10 * time.Second // Timeout to find the receiver via DHT.
+)
+
+const messageRecordHeaderSize = 58
+
+// DirectMessage is a message sent to or received from another user
+type DirectMessage struct {
+    ID         uuid.UUID        // Message ID
+    PublicKey  *btcec.PublicKey // Public key of the other user
+    NodeID     []byte           // Node ID of the other user
+    Sent       bool             // Whether the message was sent by the user. Otherwise it was received.
+    Status     int              // See MessageStatusX.
+    DateSent   time.Time        // Date the message was sent
+    DateStatus time.Time        // Date the message was delivered or received
+    Text       string           // Text
+
+    envelope  []byte // Encrypted envelope for pending messages
+    inboxDay  uint32 // Inbox day of the last attempt to store the message in the DHT inbox. 0 = never.
+    inboxSlot uint32 // Inbox slot the message was stored in. inboxSlots if all slots of the day were taken.
+}
+
+// directMessages stores all direct messages and the monitors receiving new ones
+type directMessages struct {
+    database store.Store             // Message store
+    monitors []chan<- *DirectMessage // Channels receiving new and updated messages
+    sync.RWMutex
+}
+
+func (backend *Backend) initDirectMessages() {
+    if backend.Config.MessageStore == "" {
+        return
+    }
+
+    database, err := store.NewPogrebStore(backend.Config.MessageStore)
+    if err != nil {
+        backend.LogError("initDirectMessages", "initializing database '%s': %s", backend.Config.MessageStore, err.Error())
+        return
+    }
+
+    backend.messages = &directMessages{database: database}
+}
+
+func decodeDirectMessage(id uuid.UUID, raw []byte) (message *DirectMessage, err error) {
+    if len(raw) < messageRecordHeaderSize {
+        return nil, errors.New("message record too small")
+    }
+
+    message = &DirectMessage{ID: id, Sent: raw[0] == 1, Status: int(raw[1])}
+    if message.PublicKey, err = btcec.ParsePubKey(raw[2:35], btcec.S256()); err != nil {
+        return nil, err
+    }
+    message.NodeID = protocol.PublicKey2NodeID(message.PublicKey)
+    message.DateSent = time.Unix(int64(binary.LittleEndian.Uint64(raw[35:43])), 0)
+    message.DateStatus = time.Unix(int64(binary.LittleEndian.Uint64(raw[43:51])), 0)
+
+    message.inboxDay = binary.LittleEndian.Uint32(raw[51:55])
+    message.inboxSlot = uint32(raw[55])
+
+    textSize := int(binary.LittleEndian.Uint16(raw[56:58]))
+    if len(raw) < messageRecordHeaderSize+textSize {
+        return nil, errors.New("message record text size invalid")
+    }
+    message.Text = string(raw[messageRecordHeaderSize : messageRecordHeaderSize+textSize])
+    message.envelope = raw[messageRecordHeaderSize+textSize:]
+
+    return message, nil
+}
+
+func encodeDirectMessage(message *DirectMessage) (raw []byte) {
+    raw = make([]byte, messageRecordHeaderSize+len(message.Text)+len(message.envelope))
+
+    if message.Sent {
+        raw[0] = 1
+    }
+    raw[1] = byte(message.Status)
+    copy(raw[2:35], message.PublicKey.SerializeCompressed())
+    binary.LittleEndian.PutUint64(raw[35:43], uint64(message.DateSent.UTC().Unix()))
+    binary.LittleEndian.PutUint64(raw[43:51], uint64(message.DateStatus.UTC().Unix()))
+    binary.LittleEndian.PutUint32(raw[51:55], message.inboxDay)
+    raw[55] = byte(message.inboxSlot)
+    binary.LittleEndian.PutUint16(raw[56:58], uint16(len(message.Text)))
+    copy(raw[messageRecordHeaderSize:], message.Text)
+    copy(raw[messageRecordHeaderSize+len(message.Text):], message.envelope)
+
+    return raw
+}
+
+// isPending checks if the sent message is not yet acknowledged by the receiver
+func (message *DirectMessage) isPending() bool {
+    return message.Status == MessageStatusPending || message.Status == MessageStatusInbox
+}
+
+// readMessage reads a message from the store
+func (messages *directMessages) readMessage(id uuid.UUID) (message *DirectMessage, found bool) {
+    raw, found := messages.database.Get(id[:])
+    if !found {
+        return nil, false
+    }
+
+    message, err := decodeDirectMessage(id, raw)
+    return message, err == nil
+}
+
+// writeMessage writes the message to the store and informs all monitors
+func (messages *directMessages) writeMessage(message *DirectMessage) {
+    messages.database.Set(message.ID[:], encodeDirectMessage(message))
+
+    messages.RLock()
+    defer messages.RUnlock()
+
+    // send to all monitors non-blocking
+    for _, monitor := range messages.monitors {
+        select {
+        case monitor <- message:
+        default:
+        }
+    }
+}
+
+// iterateMessages calls the callback for each stored message
+func (messages *directMessages) iterateMessages(callback func(message *DirectMessage)) {
+    messages.database.Iterate(func(key, value []byte) {
+        if len(key) != 16 {
+            return
+        }
+
+        var id uuid.UUID
+        copy(id[:], key)
+
+        if message, err := decodeDirectMessage(id, value); err == nil {
+            callback(message)
+        }
+    })
+}
+
+// resolveMessageReceiver returns the public key of the user. It checks previous messages, connected peers, and the DHT.
+func (backend *Backend) resolveMessageReceiver(nodeID []byte) (publicKey *btcec.PublicKey) {
+    if peer := backend.NodelistLookup(nodeID); peer != nil {
+        return peer.PublicKey
+    }
+
+    backend.messages.iterateMessages(func(message *DirectMessage) {
+        if publicKey == nil && string(message.NodeID) == string(nodeID) {
+            publicKey = message.PublicKey
+        }
+    })
+    if publicKey != nil {
+        return publicKey
+    }
+
+    found := make(chan *PeerInfo, 1)
+    go func() {
+        _, peer, _ := backend.FindNode(nodeID, messageFindTimeout)
+        found <- peer
+    }()
+
+    select {
+    case peer := <-found:
+        if peer != nil {
+            return peer.PublicKey
+        }
+    case <-time.After(messageFindTimeout):
+    }
+
+    return nil
+}
+
+// SendMessage sends an end-to-end encrypted message to the user. If the user is not connected, the message is stored in its DHT inbox and delivery is retried.
+// Status is MessageSendX.
+func (backend *Backend) SendMessage(nodeID []byte, text string) (message *DirectMessage, status int) {
+    if backend.messages == nil {
+        return nil, MessageSendNotAvailable
+    } else if len(text) > protocol.MessageTextMax {
+        return nil, MessageSendTextTooLong
+    }
+
+    publicKey := backend.resolveMessageReceiver(nodeID)
+    if publicKey == nil {
+        return nil, MessageSendReceiverNotFound
+    }
+
+    timeN := time.Now()
+    message = &DirectMessage{ID: uuid.New(), PublicKey: publicKey, NodeID: protocol.PublicKey2NodeID(publicKey), Sent: true, Status: MessageStatusPending, DateSent: timeN, DateStatus: timeN, Text: text}
+
+    var err error
+    if message.envelope, err = protocol.EncryptMessageEnvelope(backend.peerPrivateKey(), publicKey, message.ID, timeN, []byte(text)); err != nil {
+        backend.LogError("SendMessage", "encrypting message: %s", err.Error())
+        return nil, MessageSendNotAvailable
+    }
+
+    backend.messages.writeMessage(message)
+
+    backend.deliverMessage(message)
+
+    return message, MessageSendOK
+}
+
+// deliverMessage sends the message directly if the receiver is connected, otherwise it stores it in the DHT inbox of the receiver.
+func (backend *Backend) deliverMessage(message *DirectMessage) {
+    peer := backend.NodelistLookup(message.NodeID)
+    if peer == nil {
+        backend.storeInboxMessage(message)
+        return
+    }
+
+    packet, err := protocol.EncodeMessageDirect(protocol.MessageControlSend, message.ID, message.envelope)
+    if err != nil {
+        return
+    }
+
+    raw := &protocol.PacketRaw{Command: protocol.CommandMessage, Payload: packet, Sequence: backend.networks.Sequences.NewSequence(peer.PublicKey, &peer.messageSequence, message.ID).SequenceNumber}
+    peer.send(raw)
+}
+
+// cmdMessage handles an incoming direct message
+func (peer *PeerInfo) cmdMessage(msg *protocol.MessageDirect, connection *Connection) {
+    messages := peer.Backend.messages
+    if messages == nil {
+        return
+    }
+
+    switch msg.Control {
+    case protocol.MessageControlSend:
+        // The envelope must be from the sender of the packet.
+        if _, err := peer.Backend.receiveMessage(msg.Envelope, peer.PublicKey); err != nil {
+            return
+        }
+
+        // Acknowledge even if the message was received before, since the previous acknowledgement may have been lost.
+        packet, err := protocol.EncodeMessageDirect(protocol.MessageControlAcknowledge, msg.ID, nil)
+        if err != nil {
+            return
+        }
+        peer.send(&protocol.PacketRaw{Command: protocol.CommandMessage, Payload: packet, Sequence: msg.Sequence})
+
+    case protocol.MessageControlAcknowledge:
+        // The sequence is already validated. It carries the ID of the message that was sent.
+        if id, ok := msg.SequenceInfo.Data.(uuid.UUID); !ok || id != msg.ID {
+            return
+        }
+
+        if message, found := messages.readMessage(msg.ID); found && message.Sent && message.isPending() && message.PublicKey.IsEqual(peer.PublicKey) {
+            message.Status = MessageStatusDelivered
+            message.DateStatus = time.Now()
+            message.envelope = nil
+            messages.writeMessage(message)
+        }
+    }
+}
+
+// receiveMessage decrypts and stores a received message envelope. Messages that were already received are ignored.
+// If the sender is specified, the envelope must be from it.
+func (backend *Backend) receiveMessage(envelope []byte, sender *btcec.PublicKey) (message *DirectMessage, err error) {
+    senderPublicKey, id, date, text, err := protocol.DecryptMessageEnvelope(backend.peerPrivateKey(), envelope)
+    if err != nil {
+        return nil, err
+    } else if sender != nil && !senderPublicKey.IsEqual(sender) {
+        return nil, errors.New("message envelope from different sender")
+    }
+
+    if message, found := backend.messages.readMessage(id); found {
+        return message, nil
+    }
+
+    message = &DirectMessage{ID: id, PublicKey: senderPublicKey, NodeID: protocol.PublicKey2NodeID(senderPublicKey), Status: MessageStatusReceived, DateSent: date, DateStatus: time.Now(), Text: string(text)}
+    backend.messages.writeMessage(message)
+
+    return message, nil
+}
+
+// autoDeliverMessages retries delivery of pending messages and polls the DHT inbox periodically.
+func (backend *Backend) autoDeliverMessages() {
+    if backend.messages == nil {
+        return
+    }
+
+    for n := 0; ; n++ {
+        var pending []*DirectMessage
+        backend.messages.iterateMessages(func(message *DirectMessage) {
+            if message.Sent && message.isPending() {
+                pending = append(pending, message)
+            }
+        })
+
+        for _, message := range pending {
+            backend.deliverMessage(message)
+        }
+
+        if n%int(inboxPollInterval/messageRetryInterval) == 0 {
+            backend.pollInbox()
+        }
+
+        time.Sleep(messageRetryInterval)
+    }
+}
+
+// MessageConversation is a summary of all messages exchanged with another user
+type MessageConversation struct {
+    PublicKey     *btcec.PublicKey // Public key of the other user
+    NodeID        []byte           // Node ID of the other user
+    CountMessages int              // Count of messages
+    CountPending  int              // Count of sent messages not yet delivered
+    DateLast      time.Time        // Date of the last message
+}
+
+// ListConversations returns all conversations, the most recent first.
+func (backend *Backend) ListConversations() (conversations []*MessageConversation) {
+    if backend.messages == nil {
+        return nil
+    }
+
+    list := make(map[string]*MessageConversation)
+
+    backend.messages.iterateMessages(func(message *DirectMessage) {
+        conversation, ok := list[string(message.NodeID)]
+        if !ok {
+            conversation = &MessageConversation{PublicKey: message.PublicKey, NodeID: message.NodeID}
+            list[string(message.NodeID)] = conversation
+            conversations = append(conversations, conversation)
+        }
+
+        conversation.CountMessages++
+        if message.isPending() {
+            conversation.CountPending++
+        }
+        if message.DateSent.After(conversation.DateLast) {
+            conversation.DateLast = message.DateSent
+        }
+    })
+
+    sort.Slice(conversations, func(i, j int) bool { return conversations[i].DateLast.After(conversations[j].DateLast) })
+
+    return conversations
+}
+
+// ListMessages returns all messages exchanged with the user, oldest first.
+func (backend *Backend) ListMessages(nodeID []byte) (messages []*DirectMessage) {
+    if backend.messages == nil {
+        return nil
+    }
+
+    backend.messages.iterateMessages(func(message *DirectMessage) {
+        if string(message.NodeID) == string(nodeID) {
+            messages = append(messages, message)
+        }
+    })
+
+    sort.SliceStable(messages, func(i, j int) bool { return messages[i].DateSent.Before(messages[j].DateSent) })
+
+    return messages
+}
+
+// RegisterMessageMonitor registers a channel to receive all new and updated messages. Messages are dropped if the channel is full.
+func (backend *Backend) RegisterMessageMonitor(channel chan<- *DirectMessage) {
+    if backend.messages == nil {
+        return
+    }
+
+    backend.messages.Lock()
+    defer backend.messages.Unlock()
+
+    backend.messages.monitors = append(backend.messages.monitors, channel)
+}
+
+// UnregisterMessageMonitor unregisters a channel
+func (backend *Backend) UnregisterMessageMonitor(channel chan<- *DirectMessage) {
+    if backend.messages == nil {
+        return
+    }
+
+    backend.messages.Lock()
+    defer backend.messages.Unlock()
+
+    for n, channel2 := range backend.messages.monitors {
+        if channel == channel2 {
+            backend.messages.monitors = append(backend.messages.monitors[:n:n], backend.messages.monitors[n+1:]...)
+            break
+        }
+    }
+}
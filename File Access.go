@@ -0,0 +1,101 @@
+/*
+File Username:  File Access.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Private files are shared only with a list of recipients (the access control list). The file record on the blockchain is encrypted to them.
+The actual file data in the warehouse is only served to peers that are on the list.
+*/
+
+package core
+
+import (
+    "sync"
+
+    "github.com/newinfoOffical/core/blockchain"
+    "github.com/newinfoOffical/core/btcec"
+)
+
+// fileAccessList contains the recipients of all files shared by the user, so that access checks do not have to read the blockchain.
+type fileAccessList struct {
+    files map[string]*fileAccessEntry // Key is the file hash
+    sync.RWMutex
+}
+
+type fileAccessEntry struct {
+    public     bool               // Whether any file record with the hash is shared publicly
+    recipients []*btcec.PublicKey // Recipients of all private file records with the hash
+}
+
+// initFileAccess builds the access control list from the user's blockchain. It is rebuilt on each update of the blockchain, since files may be deleted or replaced.
+// It must be called before userBlockchainAutoCompact, since that one chains the update callback.
+func (backend *Backend) initFileAccess() {
+    backend.fileAccess = &fileAccessList{}
+    backend.fileAccess.rebuild(backend.UserBlockchain)
+
+    updateOther := backend.UserBlockchain.BlockchainUpdate
+
+    backend.UserBlockchain.BlockchainUpdate = func(blockchainU *blockchain.Blockchain, oldHeight, oldVersion, newHeight, newVersion uint64) {
+        backend.fileAccess.rebuild(blockchainU)
+
+        if updateOther != nil {
+            updateOther(blockchainU, oldHeight, oldVersion, newHeight, newVersion)
+        }
+    }
+}
+
+// rebuild reads all files from the blockchain and replaces the list.
+func (list *fileAccessList) rebuild(blockchainU *blockchain.Blockchain) {
+    filesList, _ := blockchainU.ListFiles()
+
+    files := make(map[string]*fileAccessEntry)
+    for _, file := range filesList {
+        entry, ok := files[string(file.Hash)]
+        if !ok {
+            entry = &fileAccessEntry{}
+            files[string(file.Hash)] = entry
+        }
+
+        if len(file.Recipients) == 0 {
+            entry.public = true
+        }
+        entry.recipients = append(entry.recipients, file.Recipients...)
+    }
+
+    list.Lock()
+    list.files = files
+    list.Unlock()
+}
+
+// IsFileAccessAllowed checks if the peer may download the file from the local warehouse.
+// Access is allowed if the file is shared publicly, or not referenced by any file record. Private files are only allowed to be accessed by their recipients.
+func (backend *Backend) IsFileAccessAllowed(hash []byte, publicKey *btcec.PublicKey) (allowed bool) {
+    backend.fileAccess.RLock()
+    defer backend.fileAccess.RUnlock()
+
+    entry, ok := backend.fileAccess.files[string(hash)]
+    if !ok || entry.public {
+        return true
+    }
+
+    for _, recipient := range entry.recipients {
+        if recipient.IsEqual(publicKey) {
+            return true
+        }
+    }
+
+    return false
+}
+
+// decodePrivateFilesShared decodes private files from the block that are shared with the local user and appends them to the decoded records.
+// Records that cannot be decrypted are ignored.
+func (backend *Backend) decodePrivateFilesShared(decoded *blockchain.BlockDecoded) {
+    files, err := blockchain.DecodeBlockRecordPrivateFiles(decoded.RecordsRaw, decoded.OwnerPublicKey, decoded.NodeID, backend.peerPrivateKey())
+    if err != nil {
+        return
+    }
+
+    for _, file := range files {
+        decoded.RecordsDecoded = append(decoded.RecordsDecoded, file)
+    }
+}
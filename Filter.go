@@ -71,6 +71,12 @@ type Filters struct {
 
     // Called after a blockchain is deleted from the blockchain cache. The header reflects the status before deletion. Must be set on init.
     GlobalBlockchainCacheDelete func(multi *blockchain.MultiStore, header *blockchain.MultiBlockchainHeader)
+
+    // WarehouseScrub is called for each file checked by the integrity check of the user's warehouse, and for each file repaired from the network. Result is ScrubX.
+    WarehouseScrub func(hash []byte, result int)
+
+    // UserBlockchainRepair is called when the user's blockchain was found corrupt at startup and repaired. Records in the corrupt blocks are lost. Must be set on init.
+    UserBlockchainRepair func(corruptions []blockchain.Corruption, newHeight, newVersion uint64)
 }
 
 func (backend *Backend) initFilters() {
@@ -119,6 +125,12 @@ func (backend *Backend) initFilters() {
     if backend.Filters.MessageOutPong == nil {
         backend.Filters.MessageOutPong = func(peer *PeerInfo, packet *protocol.PacketRaw) {}
     }
+    if backend.Filters.WarehouseScrub == nil {
+        backend.Filters.WarehouseScrub = func(hash []byte, result int) {}
+    }
+    if backend.Filters.UserBlockchainRepair == nil {
+        backend.Filters.UserBlockchainRepair = func(corruptions []blockchain.Corruption, newHeight, newVersion uint64) {}
+    }
 }
 
 // MultiWriter code that allows to subscribe/unsubscribe.
@@ -18,7 +18,7 @@ const alpha = 5       // Count of nodes to be contacted in parallel for finding
 const bucketSize = 20 // Count of nodes per bucket
 
 func (backend *Backend) initKademlia() {
-    backend.nodesDHT = dht.NewDHT(&dht.Node{ID: backend.nodeID}, 256, bucketSize, alpha)
+    backend.nodesDHT = dht.NewDHT(&dht.Node{ID: backend.SelfNodeID()}, 256, bucketSize, alpha)
 
     // ShouldEvict determines whether node 1 shall be evicted in favor of node 2
     backend.nodesDHT.ShouldEvict = func(node1, node2 *dht.Node) bool {
@@ -100,7 +100,7 @@ func (backend *Backend) bootstrapKademlia() {
 
 func (peer *PeerInfo) sendAnnouncementFindNode(request *dht.InformationRequest) {
     // If the key is self, send it as FIND_SELF
-    if bytes.Equal(request.Key, peer.Backend.nodeID) {
+    if bytes.Equal(request.Key, peer.Backend.SelfNodeID()) {
         peer.sendAnnouncement(false, true, nil, nil, nil, request)
     } else {
         peer.sendAnnouncement(false, false, []protocol.KeyHash{{Hash: request.Key}}, nil, nil, request)
@@ -132,7 +132,7 @@ func Data2Hash(data []byte) (hash []byte) {
 // GetData returns the requested data. It checks first the local store and then tries via DHT.
 func (backend *Backend) GetData(hash []byte) (data []byte, senderNodeID []byte, found bool) {
     if data, found = backend.GetDataLocal(hash); found {
-        return data, backend.nodeID, found
+        return data, backend.SelfNodeID(), found
     }
 
     return backend.GetDataDHT(hash)
@@ -0,0 +1,151 @@
+/*
+File Username:  Media Metadata.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Metadata such as artist, title, duration and dimensions is extracted from pictures, audio and video files when they are shared, and stored as tags of the file.
+Tags that are set by the user take precedence over extracted ones. The GPS location of pictures is removed unless enabled in the config via MediaMetadataGPS.
+*/
+
+package core
+
+import (
+    "bytes"
+    "errors"
+    "io"
+    "strconv"
+
+    "github.com/newinfoOffical/core/blockchain"
+    "github.com/newinfoOffical/core/media"
+    "github.com/newinfoOffical/core/warehouse"
+)
+
+// MediaExtractor extracts metadata tags from the content of a file.
+type MediaExtractor func(reader io.ReaderAt, size int64) (tags []blockchain.BlockRecordFileTag, err error)
+
+func (backend *Backend) initMediaExtractors() {
+    backend.mediaExtractors = map[uint16]MediaExtractor{
+        FormatPicture: extractMediaMetadata,
+        FormatAudio:   extractMediaMetadata,
+        FormatVideo:   extractMediaMetadata,
+    }
+}
+
+// RegisterMediaExtractor registers an extractor for the file format. It replaces any existing extractor for the format.
+func (backend *Backend) RegisterMediaExtractor(format uint16, extractor MediaExtractor) {
+    backend.mediaExtractorsMutex.Lock()
+    defer backend.mediaExtractorsMutex.Unlock()
+
+    backend.mediaExtractors[format] = extractor
+}
+
+// MediaTags extracts the metadata tags from the content of a file stored in the user's warehouse. No tags are returned if there is no extractor for the file format.
+// The GPS location is removed unless enabled in the config.
+func (backend *Backend) MediaTags(hash []byte, format uint16) (tags []blockchain.BlockRecordFileTag, err error) {
+    backend.mediaExtractorsMutex.RLock()
+    extractor, ok := backend.mediaExtractors[format]
+    backend.mediaExtractorsMutex.RUnlock()
+    if !ok {
+        return nil, nil
+    }
+
+    _, fileSize, status, err := backend.UserWarehouse.FileExists(hash)
+    if status != warehouse.StatusOK {
+        return nil, err
+    }
+
+    extracted, err := extractor(&warehouseReader{warehouse: backend.UserWarehouse, hash: hash}, int64(fileSize))
+    if err != nil {
+        return nil, err
+    }
+
+    for _, tag := range extracted {
+        if tag.IsVirtual() || (tag.Type == blockchain.TagGPS && !backend.Config.MediaMetadataGPS) {
+            continue
+        }
+        tags = append(tags, tag)
+    }
+
+    return tags, nil
+}
+
+// ExtractMediaTags adds the metadata tags extracted from the content of the file. Tags that are already set are kept, so that the user can override extracted values.
+func (backend *Backend) ExtractMediaTags(file *blockchain.BlockRecordFile) (err error) {
+    tags, err := backend.MediaTags(file.Hash, file.Format)
+    if err != nil {
+        return err
+    }
+
+    for _, tag := range tags {
+        if file.GetTag(tag.Type) == nil {
+            file.Tags = append(file.Tags, tag)
+        }
+    }
+
+    return nil
+}
+
+// extractMediaMetadata is the default extractor for pictures, audio and video files.
+func extractMediaMetadata(reader io.ReaderAt, size int64) (tags []blockchain.BlockRecordFileTag, err error) {
+    metadata, err := media.Read(reader, size)
+    if err != nil {
+        return nil, err
+    }
+
+    addText := func(Type uint16, text string) {
+        if text != "" {
+            tags = append(tags, blockchain.TagFromText(Type, text))
+        }
+    }
+    addNumber := func(Type uint16, number uint64) {
+        if number != 0 {
+            tags = append(tags, blockchain.TagFromNumber(Type, number))
+        }
+    }
+
+    addText(blockchain.TagTitle, metadata.Title)
+    addText(blockchain.TagArtist, metadata.Artist)
+    addText(blockchain.TagAlbum, metadata.Album)
+    addNumber(blockchain.TagDuration, metadata.Duration)
+    addNumber(blockchain.TagWidth, metadata.Width)
+    addNumber(blockchain.TagHeight, metadata.Height)
+    addText(blockchain.TagCamera, metadata.Camera)
+
+    if !metadata.DateCreated.IsZero() {
+        tags = append(tags, blockchain.TagFromDate(blockchain.TagDateCreated, metadata.DateCreated))
+    }
+    if metadata.HasGPS {
+        addText(blockchain.TagGPS, strconv.FormatFloat(metadata.Latitude, 'f', 6, 64)+","+strconv.FormatFloat(metadata.Longitude, 'f', 6, 64))
+    }
+
+    return tags, nil
+}
+
+// warehouseReader reads a file stored in the warehouse at any offset.
+type warehouseReader struct {
+    warehouse *warehouse.Warehouse
+    hash      []byte
+}
+
+// ReadAt implements the io.ReaderAt interface.
+func (reader *warehouseReader) ReadAt(data []byte, offset int64) (n int, err error) {
+    if len(data) == 0 {
+        return 0, nil
+    }
+
+    buffer := bytes.NewBuffer(make([]byte, 0, len(data)))
+    status, bytesRead, err := reader.warehouse.ReadFile(reader.hash, offset, int64(len(data)), buffer)
+    if status != warehouse.StatusOK {
+        if err == nil {
+            err = errors.New("file not available")
+        }
+        return 0, err
+    }
+
+    n = copy(data, buffer.Bytes())
+    if bytesRead < int64(len(data)) {
+        return n, io.EOF
+    }
+
+    return n, nil
+}
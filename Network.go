@@ -125,7 +125,7 @@ func (network *Network) Listen() {
         if isLite, err := network.networkGroup.LiteRouter.IsPacketLite(buffer[:length]); isLite && err != nil {
             continue
         } else if isLite {
-            network.networkGroup.litePacketsIncoming <- networkWire{network: network, sender: sender, raw: buffer[:length], receiverPublicKey: network.backend.PeerPublicKey, unicast: true}
+            network.networkGroup.litePacketsIncoming <- networkWire{network: network, sender: sender, raw: buffer[:length], receiverPublicKey: network.backend.peerPublicKey(), unicast: true}
             continue
         }
 
@@ -135,7 +135,7 @@ func (network *Network) Listen() {
         }
 
         // send the packet to a channel which is processed by multiple workers.
-        network.networkGroup.rawPacketsIncoming <- networkWire{network: network, sender: sender, raw: buffer[:length], receiverPublicKey: network.backend.PeerPublicKey, unicast: true}
+        network.networkGroup.rawPacketsIncoming <- networkWire{network: network, sender: sender, raw: buffer[:length], receiverPublicKey: network.backend.peerPublicKey(), unicast: true}
     }
 }
 
@@ -149,7 +149,7 @@ func (nets *Networks) packetWorker() {
         }
 
         // immediately discard message if sender = self
-        if senderPublicKey.IsEqual(nets.backend.PeerPublicKey) {
+        if senderPublicKey.IsEqual(nets.backend.peerPublicKey()) {
             continue
         }
 
@@ -280,12 +280,29 @@ func (nets *Networks) packetWorker() {
             nets.backend.Filters.MessageIn(peer, raw, nil)
             peer.cmdChat(raw, connection)
 
+        case protocol.CommandMessage:
+            if msg, _ := protocol.DecodeMessageDirect(raw); msg != nil {
+                // Acknowledgements must match the sequence of the sent message.
+                if msg.Control == protocol.MessageControlAcknowledge {
+                    sequenceInfo, valid, rtt := nets.Sequences.ValidateSequence(raw.SenderPublicKey, raw.Sequence, true, false)
+                    if !valid {
+                        continue
+                    } else if rtt > 0 {
+                        connection.RoundTripTime = rtt
+                    }
+                    raw.SequenceInfo = sequenceInfo
+                }
+
+                nets.backend.Filters.MessageIn(peer, raw, msg)
+                peer.cmdMessage(msg, connection)
+            }
+
         case protocol.CommandTraverse:
             if traverse, _ := protocol.DecodeTraverse(raw); traverse != nil {
                 nets.backend.Filters.MessageIn(peer, raw, traverse)
-                if traverse.TargetPeer.IsEqual(nets.backend.PeerPublicKey) && traverse.AuthorizedRelayPeer.IsEqual(peer.PublicKey) {
+                if traverse.TargetPeer.IsEqual(nets.backend.peerPublicKey()) && traverse.AuthorizedRelayPeer.IsEqual(peer.PublicKey) {
                     peer.cmdTraverseReceive(traverse)
-                } else if traverse.AuthorizedRelayPeer.IsEqual(nets.backend.PeerPublicKey) {
+                } else if traverse.AuthorizedRelayPeer.IsEqual(nets.backend.peerPublicKey()) {
                     peer.cmdTraverseForward(traverse)
                 }
             }
@@ -295,7 +312,7 @@ func (nets *Networks) packetWorker() {
                 // Validate sequence number which prevents unsolicited responses.
                 isLast := msg.IsLast()
                 sequenceInfo, valid, rtt := nets.Sequences.ValidateSequenceBi(raw.SenderPublicKey, raw.Sequence, isLast)
-                if msg.Control != protocol.TransferControlRequestStart && !valid {
+                if !msg.IsRequest() && !valid {
                     //LogError("packetWorker", "message with invalid sequence %d command %d from %s\n", raw.Sequence, raw.Command, raw.connection.Address.String()) // Only log for debug purposes.
                     continue
                 } else if rtt > 0 {
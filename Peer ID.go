@@ -15,6 +15,7 @@ import (
     "sync"
     "time"
 
+    "github.com/newinfoOffical/core/blockchain"
     "github.com/newinfoOffical/core/btcec"
     "github.com/newinfoOffical/core/dht"
     "github.com/newinfoOffical/core/protocol"
@@ -68,14 +69,40 @@ func Secp256k1NewPrivateKey() (privateKey *btcec.PrivateKey, publicKey *btcec.Pu
 
 // ExportPrivateKey returns the peers public and private key
 func (backend *Backend) ExportPrivateKey() (privateKey *btcec.PrivateKey, publicKey *btcec.PublicKey) {
+    backend.peerKeyMutex.RLock()
+    defer backend.peerKeyMutex.RUnlock()
+
     return backend.PeerPrivateKey, backend.PeerPublicKey
 }
 
+// peerPrivateKey returns the peers private key
+func (backend *Backend) peerPrivateKey() *btcec.PrivateKey {
+    privateKey, _ := backend.ExportPrivateKey()
+    return privateKey
+}
+
+// peerPublicKey returns the peers public key
+func (backend *Backend) peerPublicKey() *btcec.PublicKey {
+    _, publicKey := backend.ExportPrivateKey()
+    return publicKey
+}
+
 // SelfNodeID returns the node ID used for DHT
 func (backend *Backend) SelfNodeID() []byte {
+    backend.peerKeyMutex.RLock()
+    defer backend.peerKeyMutex.RUnlock()
+
     return backend.nodeID
 }
 
+// setPeerKey sets the key pair and the node ID derived from it.
+func (backend *Backend) setPeerKey(privateKey *btcec.PrivateKey, publicKey *btcec.PublicKey) {
+    backend.peerKeyMutex.Lock()
+    backend.PeerPrivateKey, backend.PeerPublicKey = privateKey, publicKey
+    backend.nodeID = protocol.PublicKey2NodeID(publicKey)
+    backend.peerKeyMutex.Unlock()
+}
+
 // SelfUserAgent returns the User Agent
 func (backend *Backend) SelfUserAgent() string {
     return backend.userAgent
@@ -250,8 +277,8 @@ func (peerSource *PeerInfo) records2Nodes(records []protocol.PeerRecord) (nodes
 // selfPeerRecord returns self as peer record
 func (backend *Backend) selfPeerRecord() (result protocol.PeerRecord) {
     return protocol.PeerRecord{
-        PublicKey: backend.PeerPublicKey,
-        NodeID:    backend.nodeID,
+        PublicKey: backend.peerPublicKey(),
+        NodeID:    backend.SelfNodeID(),
         //IP:          network.address.IP,
         //Port:        uint16(network.address.Port),
         LastContact: 0,
@@ -297,6 +324,37 @@ func (backend *Backend) DeleteAccount() {
     backend.SaveConfig()
 }
 
+// RotateKey replaces the private key of the account, for example if the old one is suspected compromised. Status is blockchain.StatusX.
+// The user's blockchain is migrated to the new key. The old key signs the handover and all records are re-published under the new identity.
+// The new private key is stored in the config. Outgoing packets and the DHT use the new key and node ID immediately.
+func (backend *Backend) RotateKey() (publicKey *btcec.PublicKey, newHeight, newVersion uint64, status int, err error) {
+    privateKeyNew, publicKeyNew, err := Secp256k1NewPrivateKey()
+    if err != nil {
+        return nil, 0, 0, blockchain.StatusOK, err
+    }
+
+    privateKeyOld, publicKeyOld := backend.ExportPrivateKey()
+
+    // The search index update of the user's blockchain uses the backend's public key. It must be set before the migration.
+    backend.setPeerKey(privateKeyNew, publicKeyNew)
+
+    if newHeight, newVersion, status = backend.UserBlockchain.MigrateKey(privateKeyNew); status != blockchain.StatusOK {
+        backend.setPeerKey(privateKeyOld, publicKeyOld)
+        return nil, newHeight, newVersion, status, errors.New("error migrating blockchain")
+    }
+
+    // Other peers locate this peer in the DHT via the new node ID.
+    backend.nodesDHT.SetSelfID(backend.SelfNodeID())
+
+    backend.SearchIndex.UnindexBlockchain(publicKeyOld)
+
+    // save the new private key into the config
+    backend.Config.PrivateKey = hex.EncodeToString(privateKeyNew.Serialize())
+    backend.SaveConfig()
+
+    return publicKeyNew, newHeight, newVersion, blockchain.StatusOK, nil
+}
+
 // PublicKeyFromPeerID decodes the peer ID (hex encoded) into a public key.
 func PublicKeyFromPeerID(peerID string) (publicKey *btcec.PublicKey, err error) {
     hash, err := hex.DecodeString(peerID)
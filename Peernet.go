@@ -53,6 +53,7 @@ func Init(UserAgent string, ConfigFilename string, Filters *Filters, ConfigOut i
 
     backend.initFilters()
     backend.initPeerID()
+    backend.initCertificateIssuers()
     backend.initUserBlockchain()
     backend.initUserWarehouse()
     backend.initKademlia()
@@ -63,18 +64,41 @@ func Init(UserAgent string, ConfigFilename string, Filters *Filters, ConfigOut i
     backend.initStore()
     backend.initNetwork()
     backend.initBlockchainCache()
+    backend.initDirectMessages()
+    backend.initSavedSearches()
 
-    if backend.SearchIndex, err = search.InitSearchIndexStore(backend.Config.SearchIndex); err != nil {
+    if backend.SearchIndex, err = search.InitSearchIndexStore(backend.Config.SearchIndex, backend.Config.SearchLanguage); err != nil {
         backend.LogError("Init", "search index '%s' init: %s", backend.Config.SearchIndex, err.Error())
     } else {
         backend.userBlockchainUpdateSearchIndex()
+        backend.SearchIndex.FilterNewFile = backend.savedSearchNewFile
+
+        // The index was built with different rules for normalizing text, or by an older version.
+        if backend.SearchIndex.RebuildRequired {
+            go backend.searchIndexRebuild()
+        }
     }
 
+    backend.initContentIndex()
+    backend.initMediaExtractors()
+    backend.initThumbnails()
+    backend.initFileAccess()
+
+    backend.userBlockchainAutoCompact()
+    backend.verifyUserBlockchain()
+
     return backend, ExitSuccess, nil
 }
 
 // Connect starts bootstrapping and local peer discovery.
 func (backend *Backend) Connect() {
+    if backend.GlobalBlockchainCache != nil && backend.GlobalBlockchainCache.Store != nil {
+        go backend.autoVerifyCache()
+        go backend.autoPollSubscriptions()
+    }
+
+    go backend.autoDeliverMessages()
+    go backend.autoPublishContentKeywords()
     go backend.bootstrapKademlia()
     go backend.bootstrap()
     go backend.networks.autoMulticastBroadcast()
@@ -82,6 +106,7 @@ func (backend *Backend) Connect() {
     go backend.networks.networkChangeMonitor()
     go backend.networks.startUPnP()
     go backend.autoBucketRefresh()
+    go backend.autoScrubWarehouse()
 }
 
 // The Backend represents an instance of a Peernet client to be used by a frontend.
@@ -94,6 +119,7 @@ type Backend struct {
     userAgent             string                   // User Agent
     GlobalBlockchainCache *BlockchainCache         // Caches blockchains of other peers.
     SearchIndex           *search.SearchIndexStore // Search index of blockchain records.
+    ContentIndex          *search.SearchIndexStore // Full-text index of the content of files shared by the user.
     networks              *Networks                // All connected networks.
     dhtStore              store.Store              // dhtStore contains all key-value data served via DHT
     UserBlockchain        *blockchain.Blockchain   // UserBlockchain is the user's blockchain and exports functions to directly read and write it
@@ -107,6 +133,9 @@ type Backend struct {
     // The node ID is the blake3 hash of the public key compressed form.
     nodeID []byte
 
+    // peerKeyMutex guards the key pair and the node ID, since they change when the key is rotated. Use ExportPrivateKey and SelfNodeID to read them.
+    peerKeyMutex sync.RWMutex
+
     // PeerList keeps track of all peers
     PeerList      map[[btcec.PubKeyBytesLenCompressed]byte]*PeerInfo
     peerlistMutex sync.RWMutex
@@ -117,6 +146,36 @@ type Backend struct {
     // peerMonitor is a list of channels receiving information about new peers
     peerMonitor []chan<- *PeerInfo
 
+    // certificateIssuers is the list of trusted certificate issuers
+    certificateIssuers map[[btcec.PubKeyBytesLenCompressed]byte]struct{}
+
     // Stdout bundles any output for the end-user. Writers may subscribe/unsubscribe.
     Stdout *multiWriter
+
+    // messages stores direct messages
+    messages *directMessages
+
+    // savedSearches stores saved searches and their notifications
+    savedSearches *savedSearches
+
+    // contentExtractors extract text for the full-text index per file format. See FormatX.
+    contentExtractors map[uint16]ContentExtractor
+    contentIndexMutex sync.Mutex
+
+    // mediaExtractors extract metadata tags from shared files per file format. See FormatX.
+    mediaExtractors      map[uint16]MediaExtractor
+    mediaExtractorsMutex sync.RWMutex
+
+    // thumbnailGenerators create thumbnails of shared files per file format. See FormatX.
+    thumbnailGenerators      map[uint16]ThumbnailGenerator
+    thumbnailGeneratorsMutex sync.RWMutex
+
+    // warehouseScrub keeps the status of the integrity check of the user's warehouse
+    warehouseScrub warehouseScrubber
+
+    // fileAccess contains the recipients of private files shared by the user.
+    fileAccess *fileAccessList
+
+    // userBlockchainRepair lists the corruptions of the user's blockchain that were repaired at startup. Records in these blocks were lost.
+    userBlockchainRepair []blockchain.Corruption
 }
@@ -0,0 +1,643 @@
+/*
+File Username:  Saved Search.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Saved searches are evaluated against every file that is newly added to the local search index. This includes files of the user's blockchain and of the global blockchain cache.
+A matching file creates a notification in the inbox, and is sent to all monitors. Files of the user's own blockchain are ignored.
+The same file of the same owner only creates one notification per saved search, even if it is indexed again.
+
+Encoding of a saved search in the store (key = 's' + search ID):
+Offset  Size   Info
+0       8      Date created
+8       1      Flags: Bit 0 = Date filter, Bit 1 = Exclude reported files
+9       2      File type. 0xFFFF = not used.
+11      2      File format. 0xFFFF = not used.
+13      8      Date from
+21      8      Date to
+29      8      Min file size. 0xFFFFFFFFFFFFFFFF = not used.
+37      8      Max file size. 0xFFFFFFFFFFFFFFFF = not used.
+45      8      Min rating score multiplied by 100. 0 = not used.
+53      32     Node ID of the owner. All zero = not used.
+85      2      Size of the name
+87      2      Size of the query
+89      ?      Name
+?       ?      Query
+
+Encoding of a notification in the store (key = 'n' + notification ID):
+Offset  Size   Info
+0       16     Saved search ID
+16      8      Date of the notification
+24      1      Read: 0 = Unread, 1 = Read
+25      33     Public key compressed of the owner of the file
+58      8      Blockchain version
+66      8      Block number
+74      16     File ID
+90      32     File hash
+122     8      File size
+130     1      File type
+131     2      File format
+133     2      Size of the file name
+135     ?      File name
+
+The notification ID is derived from the saved search ID, the owner, and the file ID.
+Since the store is not ordered, the notification IDs of each saved search are kept in memory ordered by date, so that the oldest one can be deleted when the inbox is full.
+
+*/
+
+package core
+
+import (
+    "bytes"
+    "encoding/binary"
+    "errors"
+    "math"
+    "sort"
+    "sync"
+    "time"
+
+    "github.com/google/uuid"
+    "github.com/newinfoOffical/core/blockchain"
+    "github.com/newinfoOffical/core/btcec"
+    "github.com/newinfoOffical/core/protocol"
+    "github.com/newinfoOffical/core/search"
+    "github.com/newinfoOffical/core/store"
+)
+
+// SavedSearchX is the status of creating a saved search
+const (
+    SavedSearchOK           = 0 // Success.
+    SavedSearchNotAvailable = 1 // The saved search store or the search index is not available.
+    SavedSearchInvalidQuery = 2 // The query is invalid. See search.ParseQuery.
+    SavedSearchLimitReached = 3 // The maximum count of saved searches is reached.
+)
+
+const (
+    savedSearchMax      = 100  // Max count of saved searches.
+    savedSearchInboxMax = 1000 // Max count of notifications per saved search. The oldest ones are deleted.
+)
+
+const (
+    savedSearchKeySearch       = 's' // Key prefix of saved searches
+    savedSearchKeyNotification = 'n' // Key prefix of notifications
+)
+
+const (
+    savedSearchRecordHeaderSize       = 89
+    savedSearchNotificationHeaderSize = 135
+)
+
+// SavedSearchFilter restricts the files a saved search matches. It has the same semantics as the filters of a regular search.
+type SavedSearchFilter struct {
+    IsDates    bool      // Whether the from/to dates are valid, both are required.
+    DateFrom   time.Time // Optional date from
+    DateTo     time.Time // Optional date to
+    FileType   int       // File type. See TypeX. -1 = not used.
+    FileFormat int       // File format. See FormatX. -1 = not used.
+    SizeMin    int       // Min file size in bytes. -1 = not used.
+    SizeMax    int       // Max file size in bytes. -1 = not used.
+    NodeID     []byte    // Node ID of the owner. Nil = not used.
+    RatingMin  int       // Min average rating score multiplied by 100. 0 = not used.
+    NoReported bool      // Exclude files that were reported.
+}
+
+// SavedSearch is a search that is evaluated against all newly indexed files
+type SavedSearch struct {
+    ID          uuid.UUID         // Saved search ID
+    Name        string            // Name provided by the user
+    Query       string            // Structured query. See search.ParseQuery.
+    Filter      SavedSearchFilter // Filters
+    DateCreated time.Time         // Date the saved search was created
+
+    query *search.Query // Parsed query
+}
+
+// SavedSearchNotification informs about a new file matching a saved search
+type SavedSearchNotification struct {
+    ID                uuid.UUID        // Notification ID
+    SearchID          uuid.UUID        // Saved search ID
+    Date              time.Time        // Date the file was found
+    Read              bool             // Whether the notification was marked as read
+    PublicKey         *btcec.PublicKey // Public key of the owner of the file
+    NodeID            []byte           // Node ID of the owner of the file
+    BlockchainVersion uint64           // Blockchain version
+    BlockNumber       uint64           // Block number that contains the file
+    FileID            uuid.UUID        // File ID
+    Hash              []byte           // Hash of the file
+    Size              uint64           // Size of the file
+    Type              uint8            // File type. See TypeX.
+    Format            uint16           // File format. See FormatX.
+    Name              string           // File name
+}
+
+// savedSearches stores all saved searches, their notifications, and the monitors receiving new notifications
+type savedSearches struct {
+    database store.Store                       // Saved search store
+    searches map[uuid.UUID]*SavedSearch        // All saved searches
+    inbox    map[uuid.UUID][]savedSearchEntry  // Notifications per saved search, oldest first
+    monitors []chan<- *SavedSearchNotification // Channels receiving new notifications
+    sync.RWMutex
+}
+
+// savedSearchEntry identifies a notification in the inbox of a saved search
+type savedSearchEntry struct {
+    id   uuid.UUID // Notification ID
+    date time.Time // Date of the notification
+}
+
+func (backend *Backend) initSavedSearches() {
+    if backend.Config.SavedSearchStore == "" {
+        return
+    }
+
+    database, err := store.NewPogrebStore(backend.Config.SavedSearchStore)
+    if err != nil {
+        backend.LogError("initSavedSearches", "initializing database '%s': %s", backend.Config.SavedSearchStore, err.Error())
+        return
+    }
+
+    saved := &savedSearches{database: database, searches: make(map[uuid.UUID]*SavedSearch), inbox: make(map[uuid.UUID][]savedSearchEntry)}
+
+    database.Iterate(func(key, value []byte) {
+        if len(key) != 17 {
+            return
+        }
+
+        var id uuid.UUID
+        copy(id[:], key[1:])
+
+        switch key[0] {
+        case savedSearchKeySearch:
+            savedS, err := decodeSavedSearch(id, value)
+            if err != nil {
+                return
+            }
+            if savedS.query, err = search.ParseQuery(savedS.Query); err == nil {
+                saved.searches[id] = savedS
+            }
+
+        case savedSearchKeyNotification:
+            if notification, err := decodeSavedSearchNotification(id, value); err == nil {
+                saved.inbox[notification.SearchID] = append(saved.inbox[notification.SearchID], savedSearchEntry{id: id, date: notification.Date})
+            }
+        }
+    })
+
+    for _, entries := range saved.inbox {
+        sort.SliceStable(entries, func(i, j int) bool { return entries[i].date.Before(entries[j].date) })
+    }
+
+    backend.savedSearches = saved
+}
+
+func savedSearchKey(prefix byte, id uuid.UUID) (key []byte) {
+    return append([]byte{prefix}, id[:]...)
+}
+
+func decodeSavedSearch(id uuid.UUID, raw []byte) (savedS *SavedSearch, err error) {
+    if len(raw) < savedSearchRecordHeaderSize {
+        return nil, errors.New("saved search record too small")
+    }
+
+    savedS = &SavedSearch{ID: id}
+    savedS.DateCreated = time.Unix(int64(binary.LittleEndian.Uint64(raw[0:8])), 0)
+    savedS.Filter.IsDates = raw[8]&1 != 0
+    savedS.Filter.NoReported = raw[8]&2 != 0
+    savedS.Filter.FileType = decodeOptionalUint16(binary.LittleEndian.Uint16(raw[9:11]))
+    savedS.Filter.FileFormat = decodeOptionalUint16(binary.LittleEndian.Uint16(raw[11:13]))
+    savedS.Filter.DateFrom = time.Unix(int64(binary.LittleEndian.Uint64(raw[13:21])), 0)
+    savedS.Filter.DateTo = time.Unix(int64(binary.LittleEndian.Uint64(raw[21:29])), 0)
+    savedS.Filter.SizeMin = decodeOptionalUint64(binary.LittleEndian.Uint64(raw[29:37]))
+    savedS.Filter.SizeMax = decodeOptionalUint64(binary.LittleEndian.Uint64(raw[37:45]))
+    savedS.Filter.RatingMin = int(binary.LittleEndian.Uint64(raw[45:53]))
+    if !bytes.Equal(raw[53:85], make([]byte, 32)) {
+        savedS.Filter.NodeID = append([]byte{}, raw[53:85]...)
+    }
+
+    nameSize := int(binary.LittleEndian.Uint16(raw[85:87]))
+    querySize := int(binary.LittleEndian.Uint16(raw[87:89]))
+    if len(raw) < savedSearchRecordHeaderSize+nameSize+querySize {
+        return nil, errors.New("saved search record text size invalid")
+    }
+    savedS.Name = string(raw[savedSearchRecordHeaderSize : savedSearchRecordHeaderSize+nameSize])
+    savedS.Query = string(raw[savedSearchRecordHeaderSize+nameSize : savedSearchRecordHeaderSize+nameSize+querySize])
+
+    return savedS, nil
+}
+
+func encodeSavedSearch(savedS *SavedSearch) (raw []byte) {
+    raw = make([]byte, savedSearchRecordHeaderSize+len(savedS.Name)+len(savedS.Query))
+
+    binary.LittleEndian.PutUint64(raw[0:8], uint64(savedS.DateCreated.UTC().Unix()))
+    if savedS.Filter.IsDates {
+        raw[8] |= 1
+    }
+    if savedS.Filter.NoReported {
+        raw[8] |= 2
+    }
+    binary.LittleEndian.PutUint16(raw[9:11], encodeOptionalUint16(savedS.Filter.FileType))
+    binary.LittleEndian.PutUint16(raw[11:13], encodeOptionalUint16(savedS.Filter.FileFormat))
+    binary.LittleEndian.PutUint64(raw[13:21], uint64(savedS.Filter.DateFrom.UTC().Unix()))
+    binary.LittleEndian.PutUint64(raw[21:29], uint64(savedS.Filter.DateTo.UTC().Unix()))
+    binary.LittleEndian.PutUint64(raw[29:37], encodeOptionalUint64(savedS.Filter.SizeMin))
+    binary.LittleEndian.PutUint64(raw[37:45], encodeOptionalUint64(savedS.Filter.SizeMax))
+    binary.LittleEndian.PutUint64(raw[45:53], uint64(savedS.Filter.RatingMin))
+    copy(raw[53:85], savedS.Filter.NodeID)
+    binary.LittleEndian.PutUint16(raw[85:87], uint16(len(savedS.Name)))
+    binary.LittleEndian.PutUint16(raw[87:89], uint16(len(savedS.Query)))
+    copy(raw[savedSearchRecordHeaderSize:], savedS.Name)
+    copy(raw[savedSearchRecordHeaderSize+len(savedS.Name):], savedS.Query)
+
+    return raw
+}
+
+func decodeSavedSearchNotification(id uuid.UUID, raw []byte) (notification *SavedSearchNotification, err error) {
+    if len(raw) < savedSearchNotificationHeaderSize {
+        return nil, errors.New("notification record too small")
+    }
+
+    notification = &SavedSearchNotification{ID: id, Read: raw[24] == 1}
+    copy(notification.SearchID[:], raw[0:16])
+    notification.Date = time.Unix(int64(binary.LittleEndian.Uint64(raw[16:24])), 0)
+    if notification.PublicKey, err = btcec.ParsePubKey(raw[25:58], btcec.S256()); err != nil {
+        return nil, err
+    }
+    notification.NodeID = protocol.PublicKey2NodeID(notification.PublicKey)
+    notification.BlockchainVersion = binary.LittleEndian.Uint64(raw[58:66])
+    notification.BlockNumber = binary.LittleEndian.Uint64(raw[66:74])
+    copy(notification.FileID[:], raw[74:90])
+    notification.Hash = append([]byte{}, raw[90:122]...)
+    notification.Size = binary.LittleEndian.Uint64(raw[122:130])
+    notification.Type = raw[130]
+    notification.Format = binary.LittleEndian.Uint16(raw[131:133])
+
+    nameSize := int(binary.LittleEndian.Uint16(raw[133:135]))
+    if len(raw) < savedSearchNotificationHeaderSize+nameSize {
+        return nil, errors.New("notification record name size invalid")
+    }
+    notification.Name = string(raw[savedSearchNotificationHeaderSize : savedSearchNotificationHeaderSize+nameSize])
+
+    return notification, nil
+}
+
+func encodeSavedSearchNotification(notification *SavedSearchNotification) (raw []byte) {
+    raw = make([]byte, savedSearchNotificationHeaderSize+len(notification.Name))
+
+    copy(raw[0:16], notification.SearchID[:])
+    binary.LittleEndian.PutUint64(raw[16:24], uint64(notification.Date.UTC().Unix()))
+    if notification.Read {
+        raw[24] = 1
+    }
+    copy(raw[25:58], notification.PublicKey.SerializeCompressed())
+    binary.LittleEndian.PutUint64(raw[58:66], notification.BlockchainVersion)
+    binary.LittleEndian.PutUint64(raw[66:74], notification.BlockNumber)
+    copy(raw[74:90], notification.FileID[:])
+    copy(raw[90:122], notification.Hash)
+    binary.LittleEndian.PutUint64(raw[122:130], notification.Size)
+    raw[130] = notification.Type
+    binary.LittleEndian.PutUint16(raw[131:133], notification.Format)
+    binary.LittleEndian.PutUint16(raw[133:135], uint16(len(notification.Name)))
+    copy(raw[savedSearchNotificationHeaderSize:], notification.Name)
+
+    return raw
+}
+
+// Filter values of -1 are stored as max value.
+
+func encodeOptionalUint16(value int) uint16 {
+    if value < 0 || value > math.MaxUint16 {
+        return math.MaxUint16
+    }
+    return uint16(value)
+}
+
+func decodeOptionalUint16(value uint16) int {
+    if value == math.MaxUint16 {
+        return -1
+    }
+    return int(value)
+}
+
+func encodeOptionalUint64(value int) uint64 {
+    if value < 0 {
+        return math.MaxUint64
+    }
+    return uint64(value)
+}
+
+func decodeOptionalUint64(value uint64) int {
+    if value == math.MaxUint64 || value > math.MaxInt64 {
+        return -1
+    }
+    return int(value)
+}
+
+// savedSearchNotificationID returns the notification ID for the file. It is the same each time the file is indexed.
+func savedSearchNotificationID(searchID uuid.UUID, publicKey *btcec.PublicKey, fileID uuid.UUID) (id uuid.UUID) {
+    data := append(append(append([]byte{}, searchID[:]...), publicKey.SerializeCompressed()...), fileID[:]...)
+    copy(id[:], protocol.HashData(data))
+    return id
+}
+
+// CreateSavedSearch creates a new saved search. The query uses the syntax of search.ParseQuery. Status is SavedSearchX.
+func (backend *Backend) CreateSavedSearch(name, query string, filter SavedSearchFilter) (savedS *SavedSearch, status int) {
+    if backend.savedSearches == nil || backend.SearchIndex == nil {
+        return nil, SavedSearchNotAvailable
+    } else if len(name) > math.MaxUint16 || len(filter.NodeID) > 32 {
+        return nil, SavedSearchInvalidQuery
+    }
+
+    parsed, err := search.ParseQuery(query)
+    if err != nil {
+        return nil, SavedSearchInvalidQuery
+    }
+
+    backend.savedSearches.Lock()
+    defer backend.savedSearches.Unlock()
+
+    if len(backend.savedSearches.searches) >= savedSearchMax {
+        return nil, SavedSearchLimitReached
+    }
+
+    savedS = &SavedSearch{ID: uuid.New(), Name: name, Query: query, Filter: filter, DateCreated: time.Now(), query: parsed}
+
+    backend.savedSearches.database.Set(savedSearchKey(savedSearchKeySearch, savedS.ID), encodeSavedSearch(savedS))
+    backend.savedSearches.searches[savedS.ID] = savedS
+
+    return savedS, SavedSearchOK
+}
+
+// ListSavedSearches returns all saved searches, oldest first.
+func (backend *Backend) ListSavedSearches() (list []*SavedSearch) {
+    if backend.savedSearches == nil {
+        return nil
+    }
+
+    backend.savedSearches.RLock()
+    for _, savedS := range backend.savedSearches.searches {
+        list = append(list, savedS)
+    }
+    backend.savedSearches.RUnlock()
+
+    sort.Slice(list, func(i, j int) bool { return list[i].DateCreated.Before(list[j].DateCreated) })
+
+    return list
+}
+
+// DeleteSavedSearch deletes the saved search and all of its notifications.
+func (backend *Backend) DeleteSavedSearch(id uuid.UUID) (found bool) {
+    if backend.savedSearches == nil {
+        return false
+    }
+
+    backend.savedSearches.Lock()
+    defer backend.savedSearches.Unlock()
+
+    if _, found = backend.savedSearches.searches[id]; !found {
+        return false
+    }
+
+    backend.savedSearches.database.Delete(savedSearchKey(savedSearchKeySearch, id))
+    delete(backend.savedSearches.searches, id)
+
+    for _, entry := range backend.savedSearches.inbox[id] {
+        backend.savedSearches.database.Delete(savedSearchKey(savedSearchKeyNotification, entry.id))
+    }
+    delete(backend.savedSearches.inbox, id)
+
+    return true
+}
+
+// listNotifications returns the notifications of the saved search, or all if the ID is uuid.Nil.
+func (saved *savedSearches) listNotifications(searchID uuid.UUID, unreadOnly bool) (list []*SavedSearchNotification) {
+    saved.database.Iterate(func(key, value []byte) {
+        if len(key) != 17 || key[0] != savedSearchKeyNotification {
+            return
+        }
+
+        var id uuid.UUID
+        copy(id[:], key[1:])
+
+        notification, err := decodeSavedSearchNotification(id, value)
+        if err != nil || searchID != uuid.Nil && notification.SearchID != searchID || unreadOnly && notification.Read {
+            return
+        }
+
+        list = append(list, notification)
+    })
+
+    return list
+}
+
+// ListSavedSearchNotifications returns the notifications of the saved search, newest first. If the search ID is uuid.Nil, all notifications are returned.
+func (backend *Backend) ListSavedSearchNotifications(searchID uuid.UUID, unreadOnly bool) (list []*SavedSearchNotification) {
+    if backend.savedSearches == nil {
+        return nil
+    }
+
+    backend.savedSearches.RLock()
+    list = backend.savedSearches.listNotifications(searchID, unreadOnly)
+    backend.savedSearches.RUnlock()
+
+    sort.SliceStable(list, func(i, j int) bool { return list[i].Date.After(list[j].Date) })
+
+    return list
+}
+
+// MarkSavedSearchNotificationRead marks the notification as read.
+func (backend *Backend) MarkSavedSearchNotificationRead(id uuid.UUID) (found bool) {
+    if backend.savedSearches == nil {
+        return false
+    }
+
+    backend.savedSearches.Lock()
+    defer backend.savedSearches.Unlock()
+
+    key := savedSearchKey(savedSearchKeyNotification, id)
+    raw, found := backend.savedSearches.database.Get(key)
+    if !found {
+        return false
+    }
+
+    notification, err := decodeSavedSearchNotification(id, raw)
+    if err != nil {
+        return false
+    }
+
+    notification.Read = true
+    backend.savedSearches.database.Set(key, encodeSavedSearchNotification(notification))
+
+    return true
+}
+
+// DeleteSavedSearchNotification deletes the notification from the inbox.
+func (backend *Backend) DeleteSavedSearchNotification(id uuid.UUID) (found bool) {
+    if backend.savedSearches == nil {
+        return false
+    }
+
+    backend.savedSearches.Lock()
+    defer backend.savedSearches.Unlock()
+
+    key := savedSearchKey(savedSearchKeyNotification, id)
+    raw, found := backend.savedSearches.database.Get(key)
+    if !found {
+        return false
+    }
+
+    if notification, err := decodeSavedSearchNotification(id, raw); err == nil {
+        entries := backend.savedSearches.inbox[notification.SearchID]
+        for n := range entries {
+            if entries[n].id == id {
+                backend.savedSearches.inbox[notification.SearchID] = append(entries[:n:n], entries[n+1:]...)
+                break
+            }
+        }
+    }
+    backend.savedSearches.database.Delete(key)
+
+    return true
+}
+
+// savedSearchNewFile is called by the search index for each newly indexed file. It creates a notification for each matching saved search.
+func (backend *Backend) savedSearchNewFile(publicKey *btcec.PublicKey, blockchainVersion, blockNumber uint64, file *blockchain.BlockRecordFile) {
+    saved := backend.savedSearches
+    if saved == nil || publicKey.IsEqual(backend.peerPublicKey()) {
+        return
+    }
+
+    saved.Lock()
+    defer saved.Unlock()
+
+    var ratingTags []blockchain.BlockRecordFileTag
+    ratingRead := false
+
+    for _, savedS := range saved.searches {
+        if !backend.SearchIndex.MatchFile(savedS.query, file) {
+            continue
+        }
+
+        // ratings are only read if needed
+        if (savedS.Filter.RatingMin > 0 || savedS.Filter.NoReported) && !ratingRead {
+            ratingTags = backend.ContentRatingTags(file.Hash)
+            ratingRead = true
+        }
+
+        if !savedS.Filter.isFileFiltered(file, ratingTags) {
+            continue
+        }
+
+        id := savedSearchNotificationID(savedS.ID, publicKey, file.ID)
+        key := savedSearchKey(savedSearchKeyNotification, id)
+        if _, found := saved.database.Get(key); found {
+            continue
+        }
+
+        notification := &SavedSearchNotification{ID: id, SearchID: savedS.ID, Date: time.Now(), PublicKey: publicKey, NodeID: protocol.PublicKey2NodeID(publicKey), BlockchainVersion: blockchainVersion, BlockNumber: blockNumber, FileID: file.ID, Hash: file.Hash, Size: file.Size, Type: file.Type, Format: file.Format}
+        if tag := file.GetTag(blockchain.TagName); tag != nil && len(tag.Data) <= math.MaxUint16 {
+            notification.Name = tag.Text()
+        }
+
+        saved.database.Set(key, encodeSavedSearchNotification(notification))
+
+        saved.inbox[savedS.ID] = append(saved.inbox[savedS.ID], savedSearchEntry{id: id, date: notification.Date})
+        if len(saved.inbox[savedS.ID]) > savedSearchInboxMax {
+            saved.deleteOldestNotification(savedS.ID)
+        }
+
+        // send to all monitors non-blocking
+        for _, monitor := range saved.monitors {
+            select {
+            case monitor <- notification:
+            default:
+            }
+        }
+    }
+}
+
+// deleteOldestNotification deletes the oldest notification of the saved search. The caller must lock.
+func (saved *savedSearches) deleteOldestNotification(searchID uuid.UUID) {
+    entries := saved.inbox[searchID]
+    if len(entries) == 0 {
+        return
+    }
+
+    saved.database.Delete(savedSearchKey(savedSearchKeyNotification, entries[0].id))
+    saved.inbox[searchID] = entries[1:]
+}
+
+// isFileFiltered checks if the file passes the filter. Rating tags are the virtual tags returned by ContentRatingTags.
+func (filter *SavedSearchFilter) isFileFiltered(file *blockchain.BlockRecordFile, ratingTags []blockchain.BlockRecordFileTag) bool {
+    if filter.FileType >= 0 && file.Type != uint8(filter.FileType) {
+        return false
+    }
+
+    if filter.FileFormat >= 0 && file.Format != uint16(filter.FileFormat) {
+        return false
+    }
+
+    // Files without a shared date are filtered out, same as in regular search.
+    if filter.IsDates {
+        date, err := file.GetTag(blockchain.TagDateShared).Date()
+        if err != nil || date.IsZero() || date.Before(filter.DateFrom) || date.After(filter.DateTo) {
+            return false
+        }
+    }
+
+    if filter.SizeMin >= 0 && file.Size < uint64(filter.SizeMin) || filter.SizeMax >= 0 && file.Size > uint64(filter.SizeMax) {
+        return false
+    }
+
+    if filter.NodeID != nil && !bytes.Equal(filter.NodeID, file.NodeID) {
+        return false
+    }
+
+    var ratingScore, reportCount uint64
+    for n := range ratingTags {
+        switch ratingTags[n].Type {
+        case blockchain.TagRatingScore:
+            ratingScore = ratingTags[n].Number()
+        case blockchain.TagReportCount:
+            reportCount = ratingTags[n].Number()
+        }
+    }
+
+    if filter.RatingMin > 0 && ratingScore < uint64(filter.RatingMin) {
+        return false
+    }
+
+    if filter.NoReported && reportCount > 0 {
+        return false
+    }
+
+    return true
+}
+
+// RegisterSavedSearchMonitor registers a channel to receive all new notifications of saved searches. Notifications are dropped if the channel is full.
+func (backend *Backend) RegisterSavedSearchMonitor(channel chan<- *SavedSearchNotification) {
+    if backend.savedSearches == nil {
+        return
+    }
+
+    backend.savedSearches.Lock()
+    defer backend.savedSearches.Unlock()
+
+    backend.savedSearches.monitors = append(backend.savedSearches.monitors, channel)
+}
+
+// UnregisterSavedSearchMonitor unregisters a channel
+func (backend *Backend) UnregisterSavedSearchMonitor(channel chan<- *SavedSearchNotification) {
+    if backend.savedSearches == nil {
+        return
+    }
+
+    backend.savedSearches.Lock()
+    defer backend.savedSearches.Unlock()
+
+    for n, channel2 := range backend.savedSearches.monitors {
+        if channel == channel2 {
+            backend.savedSearches.monitors = append(backend.savedSearches.monitors[:n:n], backend.savedSearches.monitors[n+1:]...)
+            break
+        }
+    }
+}
@@ -0,0 +1,605 @@
+package core
+
+import (
+    "bytes"
+    "image"
+    "image/color"
+    "image/jpeg"
+    "image/png"
+    "os"
+    "path/filepath"
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+    "unicode/utf8"
+
+    "github.com/google/uuid"
+    "github.com/newinfoOffical/core/blockchain"
+    "github.com/newinfoOffical/core/btcec"
+    "github.com/newinfoOffical/core/merkle"
+    "github.com/newinfoOffical/core/protocol"
+    "github.com/newinfoOffical/core/search"
+    "github.com/newinfoOffical/core/warehouse"
+)
+
+// initTestBackend returns a backend with a random key and the stores in a temporary directory. Only the parts needed by the tests are initialized.
+func initTestBackend(t *testing.T) (backend *Backend) {
+    directory := t.TempDir()
+
+    backend = &Backend{Config: &Config{SavedSearchStore: filepath.Join(directory, "saved searches"), LogTarget: 3}}
+    backend.initFilters()
+
+    privateKey, _ := btcec.NewPrivateKey(btcec.S256())
+    backend.setPeerKey(privateKey, privateKey.PubKey())
+
+    var err error
+    if backend.SearchIndex, err = search.InitSearchIndexStore(filepath.Join(directory, "search index"), search.LanguageNone); err != nil {
+        t.Fatalf("Error opening search index: %s\n", err.Error())
+    } else if backend.UserWarehouse, err = warehouse.Init(filepath.Join(directory, "warehouse")); err != nil {
+        t.Fatalf("Error opening warehouse: %s\n", err.Error())
+    } else if backend.UserBlockchain, err = blockchain.Init(privateKey, filepath.Join(directory, "blockchain")); err != nil {
+        t.Fatalf("Error opening blockchain: %s\n", err.Error())
+    }
+
+    backend.initSavedSearches()
+    if backend.savedSearches == nil {
+        t.Fatalf("Error opening saved search store\n")
+    }
+
+    return backend
+}
+
+func TestSavedSearchFilter(t *testing.T) {
+    dateShared := time.Date(2022, 6, 15, 0, 0, 0, 0, time.UTC)
+    nodeID := protocol.HashData([]byte("owner"))
+
+    file := &blockchain.BlockRecordFile{Type: 1, Format: 2, Size: 1000, NodeID: nodeID}
+    file.Tags = append(file.Tags, blockchain.TagFromDate(blockchain.TagDateShared, dateShared))
+
+    none := SavedSearchFilter{FileType: -1, FileFormat: -1, SizeMin: -1, SizeMax: -1}
+
+    tests := []struct {
+        name       string
+        change     func(filter *SavedSearchFilter)
+        ratingTags []blockchain.BlockRecordFileTag
+        match      bool
+    }{
+        {"no filter", func(filter *SavedSearchFilter) {}, nil, true},
+        {"type", func(filter *SavedSearchFilter) { filter.FileType = 1 }, nil, true},
+        {"other type", func(filter *SavedSearchFilter) { filter.FileType = 3 }, nil, false},
+        {"other format", func(filter *SavedSearchFilter) { filter.FileFormat = 3 }, nil, false},
+        {"size range", func(filter *SavedSearchFilter) { filter.SizeMin, filter.SizeMax = 1000, 1000 }, nil, true},
+        {"too small", func(filter *SavedSearchFilter) { filter.SizeMin = 1001 }, nil, false},
+        {"too big", func(filter *SavedSearchFilter) { filter.SizeMax = 999 }, nil, false},
+        {"date range", func(filter *SavedSearchFilter) {
+            filter.IsDates, filter.DateFrom, filter.DateTo = true, dateShared.AddDate(0, 0, -1), dateShared.AddDate(0, 0, 1)
+        }, nil, true},
+        {"date before", func(filter *SavedSearchFilter) {
+            filter.IsDates, filter.DateFrom, filter.DateTo = true, dateShared.AddDate(0, 0, 1), dateShared.AddDate(0, 0, 2)
+        }, nil, false},
+        {"owner", func(filter *SavedSearchFilter) { filter.NodeID = nodeID }, nil, true},
+        {"other owner", func(filter *SavedSearchFilter) { filter.NodeID = protocol.HashData([]byte("other")) }, nil, false},
+        {"rating", func(filter *SavedSearchFilter) { filter.RatingMin = 300 }, []blockchain.BlockRecordFileTag{blockchain.TagFromNumber(blockchain.TagRatingScore, 400)}, true},
+        {"rating too low", func(filter *SavedSearchFilter) { filter.RatingMin = 300 }, []blockchain.BlockRecordFileTag{blockchain.TagFromNumber(blockchain.TagRatingScore, 200)}, false},
+        {"not rated", func(filter *SavedSearchFilter) { filter.RatingMin = 300 }, nil, false},
+        {"reported", func(filter *SavedSearchFilter) { filter.NoReported = true }, []blockchain.BlockRecordFileTag{blockchain.TagFromNumber(blockchain.TagReportCount, 1)}, false},
+    }
+
+    for _, test := range tests {
+        filter := none
+        test.change(&filter)
+
+        if match := filter.isFileFiltered(file, test.ratingTags); match != test.match {
+            t.Errorf("Filter %s: match is %v, expected %v\n", test.name, match, test.match)
+        }
+
+        // the filter must survive encoding
+        savedS := &SavedSearch{Name: test.name, Query: "beach", Filter: filter, DateCreated: dateShared}
+        decoded, err := decodeSavedSearch(uuid.New(), encodeSavedSearch(savedS))
+        if err != nil {
+            t.Fatalf("Error decoding saved search: %s\n", err.Error())
+        } else if match := decoded.Filter.isFileFiltered(file, test.ratingTags); match != test.match || decoded.Name != test.name || decoded.Query != "beach" {
+            t.Errorf("Filter %s changed by encoding\n", test.name)
+        }
+    }
+}
+
+func TestSavedSearchInbox(t *testing.T) {
+    backend := initTestBackend(t)
+
+    savedS, status := backend.CreateSavedSearch("Beach", "beach", SavedSearchFilter{FileType: -1, FileFormat: -1, SizeMin: -1, SizeMax: -1})
+    if status != SavedSearchOK {
+        t.Fatalf("Error creating saved search: status %d\n", status)
+    }
+
+    privateKeyOwner, _ := btcec.NewPrivateKey(btcec.S256())
+
+    newFile := func(name string) *blockchain.BlockRecordFile {
+        file := &blockchain.BlockRecordFile{ID: uuid.New(), Hash: protocol.HashData([]byte(name))}
+        file.Tags = append(file.Tags, blockchain.TagFromText(blockchain.TagName, name))
+        return file
+    }
+
+    // files of the user and files not matching the query are ignored
+    backend.savedSearchNewFile(backend.peerPublicKey(), 0, 0, newFile("Beach 1.jpg"))
+    backend.savedSearchNewFile(privateKeyOwner.PubKey(), 0, 0, newFile("Mountain.jpg"))
+
+    if list := backend.ListSavedSearchNotifications(savedS.ID, false); len(list) != 0 {
+        t.Fatalf("Unexpected notifications %d\n", len(list))
+    }
+
+    // fill the inbox beyond the max, the oldest notification must be deleted
+    var first *blockchain.BlockRecordFile
+    for n := 0; n <= savedSearchInboxMax; n++ {
+        file := newFile("Beach.jpg")
+        if first == nil {
+            first = file
+        }
+        backend.savedSearchNewFile(privateKeyOwner.PubKey(), 0, 0, file)
+
+        // the same file creates only one notification
+        backend.savedSearchNewFile(privateKeyOwner.PubKey(), 0, 0, file)
+    }
+
+    list := backend.ListSavedSearchNotifications(savedS.ID, false)
+    if len(list) != savedSearchInboxMax {
+        t.Fatalf("Inbox contains %d notifications, expected %d\n", len(list), savedSearchInboxMax)
+    }
+    for _, notification := range list {
+        if notification.FileID == first.ID {
+            t.Fatalf("Oldest notification was not deleted\n")
+        }
+    }
+
+    if !backend.DeleteSavedSearchNotification(list[0].ID) {
+        t.Fatalf("Notification not found\n")
+    } else if entries := backend.savedSearches.inbox[savedS.ID]; len(entries) != savedSearchInboxMax-1 {
+        t.Fatalf("Inbox contains %d notifications after deleting one\n", len(entries))
+    }
+
+    if !backend.DeleteSavedSearch(savedS.ID) {
+        t.Fatalf("Saved search not found\n")
+    } else if list = backend.ListSavedSearchNotifications(uuid.Nil, false); len(list) != 0 {
+        t.Fatalf("Notifications of the deleted saved search remain\n")
+    }
+}
+
+func TestFragmentVerifier(t *testing.T) {
+    // a small file has a single fragment, its hash is the merkle root hash
+    small := []byte("small file")
+    verifier := (&PeerInfo{}).NewFragmentVerifier(protocol.HashData(small), protocol.HashData(small), uint64(len(small)), merkle.MinimumFragmentSize)
+
+    if valid, err := verifier.Verify(0, small); !valid || err != nil {
+        t.Fatalf("Small file not valid\n")
+    } else if valid, _ = verifier.Verify(0, []byte("other file")); valid {
+        t.Fatalf("Other data is valid\n")
+    } else if valid, _ = verifier.Verify(0, small[:4]); valid {
+        t.Fatalf("Partial data is valid\n")
+    }
+
+    // a large file with proofs from the merkle tree as returned by the remote peer
+    fragmentSize := uint64(merkle.MinimumFragmentSize)
+    data := make([]byte, 5*fragmentSize+100)
+    for n := range data {
+        data[n] = byte(n / 1000)
+    }
+    copy(data[3*fragmentSize:4*fragmentSize], data[2*fragmentSize:3*fragmentSize])
+
+    tree, err := merkle.NewMerkleTree(uint64(len(data)), fragmentSize, bytes.NewReader(data))
+    if err != nil {
+        t.Fatalf("Error creating merkle tree: %s\n", err.Error())
+    }
+
+    verifier = (&PeerInfo{}).NewFragmentVerifier(protocol.HashData(data), tree.RootHash, uint64(len(data)), fragmentSize)
+    for n := uint64(0); n < tree.FragmentCount; n++ {
+        verifier.proofs[n] = *tree.CreateProof(n)
+    }
+
+    fragment := func(n uint64) []byte {
+        return data[n*fragmentSize : n*fragmentSize+verifier.FragmentLength(n)]
+    }
+
+    // fragment 2 and 3 are identical, but the proof of fragment 2 must not be accepted for fragment 3
+    verifier.proofs[3] = verifier.proofs[2]
+
+    for n := uint64(0); n < tree.FragmentCount; n++ {
+        valid, err := verifier.Verify(n, fragment(n))
+        if err != nil {
+            t.Fatalf("Fragment %d: error %s\n", n, err.Error())
+        } else if valid != (n != 3) {
+            t.Fatalf("Fragment %d: valid %v\n", n, valid)
+        }
+    }
+
+    // corrupt data
+    verifier.proofs[0] = *tree.CreateProof(0)
+    corrupt := append([]byte{}, fragment(0)...)
+    corrupt[100] ^= 1
+    if valid, _ := verifier.Verify(0, corrupt); valid {
+        t.Fatalf("Corrupt fragment is valid\n")
+    }
+}
+
+func TestScrubWarehouse(t *testing.T) {
+    backend := initTestBackend(t)
+
+    results := make(map[string]int)
+    backend.Filters.WarehouseScrub = func(hash []byte, result int) { results[string(hash)] = result }
+
+    // share a small and a large file
+    var files []blockchain.BlockRecordFile
+    var paths []string
+    for _, size := range []int{1000, merkle.MinimumFragmentSize + 1000} {
+        data := make([]byte, size)
+        for n := range data {
+            data[n] = byte(n * size)
+        }
+
+        hash, status, err := backend.UserWarehouse.CreateFile(bytes.NewReader(data), uint64(size), nil)
+        if status != warehouse.StatusOK {
+            t.Fatalf("Error creating file: %v\n", err)
+        }
+        path, _, _, _ := backend.UserWarehouse.FileExists(hash)
+
+        file := blockchain.BlockRecordFile{ID: uuid.New(), Hash: hash, Size: uint64(size), MerkleRootHash: hash, FragmentSize: merkle.MinimumFragmentSize}
+        if size > merkle.MinimumFragmentSize {
+            tree, _, _ := backend.UserWarehouse.ReadMerkleTree(hash, true)
+            file.MerkleRootHash, file.FragmentSize = tree.RootHash, tree.FragmentSize
+        }
+        file.Tags = append(file.Tags, blockchain.TagFromText(blockchain.TagName, "File.bin"))
+
+        files = append(files, file)
+        paths = append(paths, path)
+    }
+
+    if _, _, status := backend.UserBlockchain.AddFiles(files); status != blockchain.StatusOK {
+        t.Fatalf("Error adding files: status %d\n", status)
+    }
+
+    // corrupt the data of the small file and delete the merkle companion file of the large one
+    original, _ := os.ReadFile(paths[0])
+    corrupt := append([]byte{}, original...)
+    corrupt[10] ^= 1
+    os.WriteFile(paths[0], corrupt, 0666)
+    os.Remove(paths[1] + ".merkle")
+
+    if !backend.scrubBegin() {
+        t.Fatalf("Scrub not started\n")
+    } else if backend.scrubBegin() {
+        t.Fatalf("Scrub started twice\n")
+    }
+    backend.scrubWarehouse()
+
+    status := backend.WarehouseScrubStatus()
+    if status.Running || status.FilesTotal != 2 || status.FilesChecked != 2 || status.FilesCorrupt != 1 || status.MerkleRepaired != 1 || status.FilesError != 0 || status.Unavailable != 1 {
+        t.Fatalf("Unexpected status after first pass: %+v\n", status)
+    } else if results[string(files[0].Hash)] != ScrubCorrupt || results[string(files[1].Hash)] != ScrubMerkleRepaired {
+        t.Fatalf("Unexpected results after first pass: %v\n", results)
+    }
+
+    // the corrupt file is quarantined and its record marked as unavailable
+    if _, _, statusW, _ := backend.UserWarehouse.FileExists(files[0].Hash); statusW != warehouse.StatusFileNotFound {
+        t.Fatalf("Corrupt file was not quarantined\n")
+    } else if recorded, _ := backend.UserBlockchain.FileExists(files[0].Hash); len(recorded) != 1 || recorded[0].GetTag(blockchain.TagUnavailable) == nil {
+        t.Fatalf("Corrupt file not marked as unavailable\n")
+    } else if recorded, _ = backend.UserBlockchain.FileExists(files[1].Hash); len(recorded) != 1 || recorded[0].GetTag(blockchain.TagUnavailable) != nil {
+        t.Fatalf("Intact file marked as unavailable\n")
+    }
+
+    // sharing the file again repairs it in the next pass
+    backend.UserWarehouse.CreateFile(bytes.NewReader(original), uint64(len(original)), nil)
+
+    backend.scrubBegin()
+    backend.scrubWarehouse()
+
+    status = backend.WarehouseScrubStatus()
+    if status.FilesCorrupt != 0 || status.MerkleRepaired != 0 || status.FilesRepaired != 1 || status.Unavailable != 0 {
+        t.Fatalf("Unexpected status after second pass: %+v\n", status)
+    } else if results[string(files[0].Hash)] != ScrubRepaired {
+        t.Fatalf("Unexpected result after second pass: %d\n", results[string(files[0].Hash)])
+    } else if recorded, _ := backend.UserBlockchain.FileExists(files[0].Hash); len(recorded) != 1 || recorded[0].GetTag(blockchain.TagUnavailable) != nil {
+        t.Fatalf("Repaired file still marked as unavailable\n")
+    }
+}
+
+func TestDirectMessageInbox(t *testing.T) {
+    backend := initTestBackend(t)
+    backend.Config.MessageStore = filepath.Join(t.TempDir(), "messages")
+    backend.initDirectMessages()
+    if backend.messages == nil {
+        t.Fatalf("Error opening message store\n")
+    }
+
+    privateKey, _ := btcec.NewPrivateKey(btcec.S256())
+    today := inboxDay(time.Now())
+    message := &DirectMessage{ID: uuid.New(), PublicKey: privateKey.PubKey(), NodeID: protocol.PublicKey2NodeID(privateKey.PubKey()), Sent: true, Status: MessageStatusInbox, DateSent: time.Now(), DateStatus: time.Now(), Text: "text", envelope: []byte{1, 2, 3}, inboxDay: today, inboxSlot: 5}
+    backend.messages.writeMessage(message)
+
+    // the inbox day and slot are stored with the message
+    stored, found := backend.messages.readMessage(message.ID)
+    if !found || stored.inboxDay != today || stored.inboxSlot != 5 || stored.Text != "text" || !bytes.Equal(stored.envelope, message.envelope) || !stored.isPending() {
+        t.Fatalf("Message record not decoded correctly\n")
+    }
+
+    // The message is not stored again on the same day, or once the inbox expired. The DHT is not initialized, so any access would fail.
+    backend.storeInboxMessage(stored)
+
+    stored.inboxDay = today - 1
+    stored.DateSent = time.Now().Add(-inboxExpiration - time.Hour)
+    backend.storeInboxMessage(stored)
+
+    if stored, _ = backend.messages.readMessage(message.ID); stored.inboxDay != today || stored.inboxSlot != 5 || stored.Status != MessageStatusInbox {
+        t.Fatalf("Message stored in the inbox again\n")
+    } else if conversations := backend.ListConversations(); len(conversations) != 1 || conversations[0].CountPending != 1 {
+        t.Fatalf("Message in the inbox not counted as pending\n")
+    }
+}
+
+func TestContentExtractors(t *testing.T) {
+    fileNamed := func(name string) *blockchain.BlockRecordFile {
+        return &blockchain.BlockRecordFile{Tags: []blockchain.BlockRecordFileTag{blockchain.TagFromText(blockchain.TagName, name)}}
+    }
+
+    tests := []struct {
+        name      string
+        extractor ContentExtractor
+        file      *blockchain.BlockRecordFile
+        data      string
+        contains  []string
+        excludes  []string
+    }{
+        {"text", extractContentText, fileNamed("notes.txt"), "Plain [text](link) ```", []string{"Plain [text](link) ```"}, nil},
+        {"markdown", extractContentText, fileNamed("readme.md"), "# Title\nSee [the docs](http://example.com/page) and ![logo](logo.png).\n```go\ncode\n```\n---\n", []string{"Title", "the docs", "logo", "code"}, []string{"example.com", "logo.png", "```", "---"}},
+        {"html", extractContentHTML, fileNamed("page.html"), "<html><head><style>body { color: red }</style><script>var secret = 1</script></head><body><p>Hello</p><b>World</b></body></html>", []string{"Hello", "World"}, []string{"color", "secret", "<p>"}},
+        {"csv", extractContentCSV, fileNamed("data.csv"), "name,city\nAlice,\"New York\"\nBob,Paris", []string{"name\ncity\nAlice\nNew York\nBob\nParis\n"}, []string{"\""}},
+        {"tsv", extractContentCSV, fileNamed("data.tsv"), "name\tcity\nAlice\tNew, York\n", []string{"Alice\nNew, York\n"}, []string{"\t"}},
+        {"csv truncated", extractContentCSV, fileNamed("data.csv"), "a,b\nc,\"d", []string{"a\nb\nc\nd"}, nil},
+    }
+
+    for _, test := range tests {
+        text, err := test.extractor(test.file, []byte(test.data))
+        if err != nil {
+            t.Errorf("%s: error %s\n", test.name, err.Error())
+            continue
+        }
+
+        for _, contains := range test.contains {
+            if !strings.Contains(text, contains) {
+                t.Errorf("%s: text %q does not contain %q\n", test.name, text, contains)
+            }
+        }
+        for _, excludes := range test.excludes {
+            if strings.Contains(text, excludes) {
+                t.Errorf("%s: text %q contains %q\n", test.name, text, excludes)
+            }
+        }
+    }
+}
+
+func TestSelectContentKeywords(t *testing.T) {
+    backend := initTestBackend(t)
+
+    var err error
+    if backend.ContentIndex, err = search.InitSearchIndexStore(filepath.Join(t.TempDir(), "content index"), search.LanguageNone); err != nil {
+        t.Fatalf("Error opening full-text index: %s\n", err.Error())
+    }
+
+    // apple is in 3 files, banana in 2, cherry and durian in 1
+    texts := []string{"apple banana cherry", "apple banana", "apple durian"}
+    for _, text := range texts {
+        backend.ContentIndex.IndexContent(backend.peerPublicKey(), 0, 0, uuid.New(), protocol.HashData([]byte(text)), text, contentIndexTermsMax, contentPublishTermsMax)
+    }
+
+    words := backend.selectContentKeywords(2)
+    if len(words) != 2 {
+        t.Fatalf("Selected %d words instead of 2\n", len(words))
+    } else if !bytes.Equal(words[0].wordHash, search.HashWord("apple")) || words[0].countFiles != 3 || len(words[0].fileHashes) != 3 {
+        t.Fatalf("Most frequent word not selected first\n")
+    } else if !bytes.Equal(words[1].wordHash, search.HashWord("banana")) || words[1].countFiles != 2 {
+        t.Fatalf("Second most frequent word not selected second\n")
+    }
+
+    if words = backend.selectContentKeywords(contentPublishWordsMax); len(words) != 4 {
+        t.Fatalf("Selected %d words instead of all 4\n", len(words))
+    }
+}
+
+func TestMissingBlockRanges(t *testing.T) {
+    tests := []struct {
+        name      string
+        height    uint64
+        stored    []uint64
+        maxBlocks uint64
+        ranges    []protocol.BlockRange
+    }{
+        {"empty blockchain", 0, nil, 100, nil},
+        {"nothing stored", 5, nil, 100, []protocol.BlockRange{{Offset: 0, Limit: 5}}},
+        {"all stored", 3, []uint64{2, 0, 1}, 100, nil},
+        {"gaps", 10, []uint64{0, 3, 4, 8}, 100, []protocol.BlockRange{{Offset: 1, Limit: 2}, {Offset: 5, Limit: 3}, {Offset: 9, Limit: 1}}},
+        {"unsorted list", 6, []uint64{5, 1, 3}, 100, []protocol.BlockRange{{Offset: 0, Limit: 1}, {Offset: 2, Limit: 1}, {Offset: 4, Limit: 1}}},
+        {"stored blocks beyond height", 3, []uint64{1, 7}, 100, []protocol.BlockRange{{Offset: 0, Limit: 1}, {Offset: 2, Limit: 1}}},
+        {"max blocks", 100, []uint64{2}, 5, []protocol.BlockRange{{Offset: 0, Limit: 2}, {Offset: 3, Limit: 3}}},
+        {"max blocks within range", 100, nil, 10, []protocol.BlockRange{{Offset: 0, Limit: 10}}},
+    }
+
+    for _, test := range tests {
+        header := &blockchain.MultiBlockchainHeader{Height: test.height, ListBlocks: test.stored}
+        if ranges := missingBlockRanges(header, test.maxBlocks); !reflect.DeepEqual(ranges, test.ranges) {
+            t.Errorf("%s: ranges %v, expected %v\n", test.name, ranges, test.ranges)
+        }
+    }
+}
+
+func TestQueueSync(t *testing.T) {
+    // The workers are not started, so the queues can be inspected.
+    cache := &BlockchainCache{sync: &blockchainSync{
+        queue:         make(chan syncRequest, syncQueueSize),
+        queuePriority: make(chan syncRequest, syncPriorityQueueSize),
+        pending:       make(map[string]bool),
+        retry:         make(map[string]syncRetryStatus),
+    }}
+
+    privateKey, _ := btcec.NewPrivateKey(btcec.S256())
+    peer := &PeerInfo{PublicKey: privateKey.PubKey()}
+    key := string(peer.PublicKey.SerializeCompressed())
+
+    // queued only once
+    cache.QueueSync(peer, false)
+    cache.QueueSync(peer, false)
+    if len(cache.sync.queue) != 1 || len(cache.sync.queuePriority) != 0 || cache.sync.pending[key] {
+        t.Fatalf("Regular queue length %d, priority queue length %d\n", len(cache.sync.queue), len(cache.sync.queuePriority))
+    }
+
+    // promoted to the priority queue, and not queued again
+    cache.QueueSync(peer, true)
+    cache.QueueSync(peer, true)
+    cache.QueueSync(peer, false)
+    if len(cache.sync.queue) != 1 || len(cache.sync.queuePriority) != 1 || !cache.sync.pending[key] {
+        t.Fatalf("After promotion regular queue length %d, priority queue length %d\n", len(cache.sync.queue), len(cache.sync.queuePriority))
+    }
+
+    // the request in the regular queue is skipped by the worker, since it is pending with priority
+    if request := <-cache.sync.queue; request.priority || !cache.sync.pending[key] {
+        t.Fatalf("Request in the regular queue is not skipped\n")
+    } else if request = <-cache.sync.queuePriority; !request.priority || request.peer != peer {
+        t.Fatalf("Request in the priority queue invalid\n")
+    }
+
+    // backoff after failed attempts
+    if !cache.sync.retryAllowed(key) {
+        t.Fatalf("Retry not allowed before any failure\n")
+    }
+    cache.sync.retryResult(key, false)
+    if cache.sync.retryAllowed(key) || cache.sync.retry[key].failures != 1 {
+        t.Fatalf("Retry allowed during backoff\n")
+    }
+    cache.sync.retryResult(key, false)
+    if delay := time.Until(cache.sync.retry[key].nextRetry); delay <= syncRetryDelayMin || delay > 2*syncRetryDelayMin {
+        t.Fatalf("Backoff delay %s after second failure\n", delay)
+    }
+    cache.sync.retryResult(key, true)
+    if !cache.sync.retryAllowed(key) {
+        t.Fatalf("Retry not allowed after success\n")
+    }
+}
+
+func TestThumbnailScale(t *testing.T) {
+    tests := []struct {
+        name                      string
+        width, height             int
+        targetWidth, targetHeight int
+    }{
+        {"small", 100, 50, 100, 50},
+        {"wide", 1024, 512, thumbnailSizeMax, thumbnailSizeMax / 2},
+        {"tall", 300, 600, thumbnailSizeMax / 2, thumbnailSizeMax},
+        {"square", 512, 512, thumbnailSizeMax, thumbnailSizeMax},
+        {"line", 10000, 1, thumbnailSizeMax, 1},
+        {"empty", 0, 0, 1, 1},
+    }
+
+    for _, test := range tests {
+        picture := image.NewNRGBA(image.Rect(0, 0, test.width, test.height))
+        if scaled := thumbnailScale(picture); scaled.Bounds().Dx() != test.targetWidth || scaled.Bounds().Dy() != test.targetHeight {
+            t.Errorf("%s: scaled to %dx%d instead of %dx%d\n", test.name, scaled.Bounds().Dx(), scaled.Bounds().Dy(), test.targetWidth, test.targetHeight)
+        }
+    }
+
+    // colors are averaged, transparent areas become white
+    picture := image.NewNRGBA(image.Rect(0, 0, 512, 512))
+    for y := 0; y < 512; y++ {
+        for x := 0; x < 256; x++ {
+            picture.SetNRGBA(x, y, color.NRGBA{R: 0xFF, A: 0xFF})
+        }
+    }
+
+    scaled := thumbnailScale(picture)
+    if c := scaled.RGBAAt(10, 10); c != (color.RGBA{R: 0xFF, A: 0xFF}) {
+        t.Errorf("Red area scaled to %v\n", c)
+    } else if c = scaled.RGBAAt(200, 10); c != (color.RGBA{R: 0xFF, G: 0xFF, B: 0xFF, A: 0xFF}) {
+        t.Errorf("Transparent area scaled to %v\n", c)
+    }
+}
+
+func TestThumbnailPicture(t *testing.T) {
+    var buffer bytes.Buffer
+    png.Encode(&buffer, image.NewNRGBA(image.Rect(0, 0, 600, 300)))
+
+    data, err := thumbnailPicture(nil, bytes.NewReader(buffer.Bytes()), int64(buffer.Len()))
+    if err != nil {
+        t.Fatalf("Error creating thumbnail: %s\n", err.Error())
+    }
+
+    config, format, err := image.DecodeConfig(bytes.NewReader(data))
+    if err != nil || format != "jpeg" || config.Width != thumbnailSizeMax || config.Height != thumbnailSizeMax/2 {
+        t.Fatalf("Thumbnail is %s %dx%d error %v\n", format, config.Width, config.Height, err)
+    }
+
+    // invalid pictures return an error, too large ones are skipped
+    if data, err = thumbnailPicture(nil, bytes.NewReader([]byte("not a picture")), 13); err == nil || data != nil {
+        t.Fatalf("Invalid picture did not return an error\n")
+    } else if data, err = thumbnailPicture(nil, bytes.NewReader(buffer.Bytes()), thumbnailSourceMax+1); err != nil || data != nil {
+        t.Fatalf("Too large picture not skipped\n")
+    }
+
+    // JPEG pictures are encoded again, which removes any metadata
+    buffer.Reset()
+    jpeg.Encode(&buffer, image.NewRGBA(image.Rect(0, 0, 16, 16)), nil)
+    if data, err = thumbnailPicture(nil, bytes.NewReader(buffer.Bytes()), int64(buffer.Len())); err != nil || len(data) == 0 {
+        t.Fatalf("Error creating thumbnail of JPEG: %v\n", err)
+    }
+}
+
+func TestThumbnailText(t *testing.T) {
+    backend := initTestBackend(t)
+    backend.initContentIndex()
+    backend.initThumbnails()
+
+    file := &blockchain.BlockRecordFile{Format: FormatText}
+
+    // whitespace is collapsed
+    if data, err := backend.thumbnailText(file, bytes.NewReader([]byte("  Hello\n\n  world\t! ")), 23); err != nil || string(data) != "Hello world !" {
+        t.Fatalf("Text snippet %q error %v\n", data, err)
+    }
+
+    // The snippet is cut at a character boundary. The 2-byte characters start at odd offsets, so the byte at the limit is not the start of a character.
+    text := "a" + strings.Repeat("\u00e9", thumbnailTextMax)
+    data, err := backend.thumbnailText(file, bytes.NewReader([]byte(text)), int64(len(text)))
+    if err != nil || len(data) != thumbnailTextMax-1 || !utf8.Valid(data) || !strings.HasPrefix(text, string(data)) {
+        t.Fatalf("Text snippet of %d bytes, valid %v, error %v\n", len(data), utf8.Valid(data), err)
+    }
+
+    // formats without content extractor have no snippet
+    if data, err = backend.thumbnailText(&blockchain.BlockRecordFile{Format: FormatPDF}, bytes.NewReader([]byte(text)), int64(len(text))); err != nil || data != nil {
+        t.Fatalf("Snippet created for format without extractor\n")
+    }
+
+    // The thumbnail is only returned as created if it was not stored in the warehouse before.
+    content := []byte("Text file\n\ncontent\n")
+    fileHash, _, _ := backend.UserWarehouse.CreateFile(bytes.NewReader(content), uint64(len(content)), nil)
+
+    fileA := &blockchain.BlockRecordFile{Hash: fileHash, Format: FormatText}
+    created, err := backend.CreateThumbnail(fileA)
+    if err != nil || created == nil || fileA.GetTag(blockchain.TagThumbnail) == nil || !bytes.Equal(fileA.GetTag(blockchain.TagThumbnail).Data, created) {
+        t.Fatalf("Thumbnail not created: %v\n", err)
+    }
+
+    fileB := &blockchain.BlockRecordFile{Hash: fileHash, Format: FormatText}
+    if created, err = backend.CreateThumbnail(fileB); err != nil || created != nil || fileB.GetTag(blockchain.TagThumbnail) == nil {
+        t.Fatalf("Existing thumbnail returned as created\n")
+    }
+
+    // a text file identical to its snippet is its own thumbnail
+    content = []byte("Same text")
+    fileHash, _, _ = backend.UserWarehouse.CreateFile(bytes.NewReader(content), uint64(len(content)), nil)
+    fileD := &blockchain.BlockRecordFile{Hash: fileHash, Format: FormatText}
+    if created, err = backend.CreateThumbnail(fileD); err != nil || created != nil || !bytes.Equal(fileD.GetTag(blockchain.TagThumbnail).Data, fileHash) {
+        t.Fatalf("File identical to its thumbnail returned as created\n")
+    }
+
+    // private files do not get a thumbnail
+    fileC := &blockchain.BlockRecordFile{Hash: fileHash, Format: FormatText, Recipients: []*btcec.PublicKey{backend.peerPublicKey()}}
+    if created, _ = backend.CreateThumbnail(fileC); created != nil || fileC.GetTag(blockchain.TagThumbnail) != nil {
+        t.Fatalf("Thumbnail created for a private file\n")
+    }
+}
@@ -0,0 +1,265 @@
+/*
+File Username:  Thumbnail.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Thumbnails are small previews of shared files. They are stored as separate files in the warehouse and referenced by the TagThumbnail tag of the file,
+so that remote peers can download the preview instead of the entire file.
+Pictures are scaled down to thumbnailSizeMax pixels and encoded as JPEG. This also removes any EXIF data including the GPS location.
+Audio and video files use the embedded cover art. Text, HTML and CSV files get a text snippet of up to thumbnailTextMax bytes (UTF-8).
+
+Private files do not get a thumbnail, since thumbnails are not referenced by any file record and are therefore served to any peer.
+*/
+
+package core
+
+import (
+    "bufio"
+    "bytes"
+    "image"
+    "image/color"
+    "image/jpeg"
+    "io"
+    "strings"
+    "unicode/utf8"
+
+    _ "image/gif" // register the GIF decoder
+    _ "image/png" // register the PNG decoder
+
+    "github.com/newinfoOffical/core/blockchain"
+    "github.com/newinfoOffical/core/media"
+    "github.com/newinfoOffical/core/protocol"
+    "github.com/newinfoOffical/core/warehouse"
+)
+
+const (
+    thumbnailSizeMax       = 256               // Max width and height of thumbnails in pixels.
+    thumbnailQuality       = 80                // JPEG quality of thumbnails.
+    thumbnailSourceMax     = 64 * 1024 * 1024  // Max size of a picture to create a thumbnail from.
+    thumbnailPixelsMax     = 100 * 1000 * 1000 // Max count of pixels of a picture to decode. Protects against decompression bombs.
+    thumbnailTextMax       = 1024              // Max size of text previews in bytes.
+    thumbnailTextSourceMax = 64 * 1024         // Max count of bytes of a file to extract the text preview from.
+)
+
+// ThumbnailGenerator creates a thumbnail from the content of a file. It returns no data if the file has no preview.
+type ThumbnailGenerator func(file *blockchain.BlockRecordFile, reader io.ReaderAt, size int64) (data []byte, err error)
+
+func (backend *Backend) initThumbnails() {
+    backend.thumbnailGenerators = map[uint16]ThumbnailGenerator{
+        FormatPicture: thumbnailPicture,
+        FormatAudio:   thumbnailCoverArt,
+        FormatVideo:   thumbnailCoverArt,
+        FormatText:    backend.thumbnailText,
+        FormatHTML:    backend.thumbnailText,
+        FormatCSV:     backend.thumbnailText,
+    }
+}
+
+// RegisterThumbnailGenerator registers a thumbnail generator for the file format. It replaces any existing generator for the format.
+func (backend *Backend) RegisterThumbnailGenerator(format uint16, generator ThumbnailGenerator) {
+    backend.thumbnailGeneratorsMutex.Lock()
+    defer backend.thumbnailGeneratorsMutex.Unlock()
+
+    backend.thumbnailGenerators[format] = generator
+}
+
+// CreateThumbnail creates the thumbnail of a file stored in the user's warehouse and adds the TagThumbnail tag to the file.
+// Private files and files that already have a thumbnail are skipped.
+// The returned hash is only set if the thumbnail was not yet stored in the warehouse, so that the caller knows which thumbnail to delete if the file is not shared.
+func (backend *Backend) CreateThumbnail(file *blockchain.BlockRecordFile) (created []byte, err error) {
+    if len(file.Recipients) > 0 || file.GetTag(blockchain.TagThumbnail) != nil {
+        return nil, nil
+    }
+
+    backend.thumbnailGeneratorsMutex.RLock()
+    generator, ok := backend.thumbnailGenerators[file.Format]
+    backend.thumbnailGeneratorsMutex.RUnlock()
+    if !ok {
+        return nil, nil
+    }
+
+    _, fileSize, status, err := backend.UserWarehouse.FileExists(file.Hash)
+    if status != warehouse.StatusOK {
+        return nil, err
+    }
+
+    data, err := generator(file, &warehouseReader{warehouse: backend.UserWarehouse, hash: file.Hash}, int64(fileSize))
+    if err != nil || len(data) == 0 {
+        return nil, err
+    }
+
+    // The thumbnail may already exist, for example as a shared file itself.
+    _, _, existsStatus, _ := backend.UserWarehouse.FileExists(protocol.HashData(data))
+
+    hash, status, err := backend.UserWarehouse.CreateFile(bytes.NewReader(data), uint64(len(data)), nil)
+    if status != warehouse.StatusOK {
+        return nil, err
+    }
+
+    file.Tags = append(file.Tags, blockchain.BlockRecordFileTag{Type: blockchain.TagThumbnail, Data: hash})
+
+    if existsStatus == warehouse.StatusOK {
+        return nil, nil
+    }
+
+    return hash, nil
+}
+
+// DeleteFileUnreferenced deletes the file from the user's warehouse if no file on the user's blockchain references it, either as file data or as thumbnail.
+func (backend *Backend) DeleteFileUnreferenced(hash []byte) {
+    files, status := backend.UserBlockchain.ListFiles()
+    if status != blockchain.StatusOK {
+        return
+    }
+
+    for _, file := range files {
+        if bytes.Equal(file.Hash, hash) {
+            return
+        } else if tag := file.GetTag(blockchain.TagThumbnail); tag != nil && bytes.Equal(tag.Data, hash) {
+            return
+        }
+    }
+
+    backend.UserWarehouse.DeleteFile(hash)
+}
+
+// thumbnailPicture creates the thumbnail of a picture. Formats other than JPEG, PNG and GIF are not supported.
+func thumbnailPicture(file *blockchain.BlockRecordFile, reader io.ReaderAt, size int64) (data []byte, err error) {
+    if size > thumbnailSourceMax {
+        return nil, nil
+    }
+
+    config, _, err := image.DecodeConfig(bufio.NewReader(io.NewSectionReader(reader, 0, size)))
+    if err != nil {
+        return nil, err
+    } else if int64(config.Width)*int64(config.Height) > thumbnailPixelsMax {
+        return nil, nil
+    }
+
+    picture, _, err := image.Decode(bufio.NewReaderSize(io.NewSectionReader(reader, 0, size), 64*1024))
+    if err != nil {
+        return nil, err
+    }
+
+    return thumbnailEncode(picture)
+}
+
+// thumbnailCoverArt creates the thumbnail from the cover art embedded in audio and video files.
+func thumbnailCoverArt(file *blockchain.BlockRecordFile, reader io.ReaderAt, size int64) (data []byte, err error) {
+    metadata, err := media.Read(reader, size)
+    if err != nil || len(metadata.Picture) == 0 {
+        return nil, err
+    }
+
+    config, _, err := image.DecodeConfig(bytes.NewReader(metadata.Picture))
+    if err != nil {
+        return nil, err
+    } else if int64(config.Width)*int64(config.Height) > thumbnailPixelsMax {
+        return nil, nil
+    }
+
+    picture, _, err := image.Decode(bytes.NewReader(metadata.Picture))
+    if err != nil {
+        return nil, err
+    }
+
+    return thumbnailEncode(picture)
+}
+
+// thumbnailText creates a text snippet using the content extractor of the file format. Whitespace is collapsed.
+func (backend *Backend) thumbnailText(file *blockchain.BlockRecordFile, reader io.ReaderAt, size int64) (data []byte, err error) {
+    // Content extractors must be registered before files are shared, therefore the map is read without holding the content index lock which may be held during indexing.
+    extractor, ok := backend.contentExtractors[file.Format]
+    if !ok {
+        return nil, nil
+    }
+
+    if size > thumbnailTextSourceMax {
+        size = thumbnailTextSourceMax
+    }
+    source := make([]byte, size)
+    n, err := reader.ReadAt(source, 0)
+    if err != nil && err != io.EOF {
+        return nil, err
+    }
+
+    text, err := extractor(file, source[:n])
+    if err != nil {
+        return nil, err
+    }
+
+    text = strings.ToValidUTF8(strings.Join(strings.Fields(text), " "), "")
+    if len(text) > thumbnailTextMax {
+        cut := thumbnailTextMax
+        for cut > 0 && !utf8.RuneStart(text[cut]) {
+            cut--
+        }
+        text = text[:cut]
+    }
+
+    return []byte(text), nil
+}
+
+// thumbnailEncode scales the picture down to fit into thumbnailSizeMax pixels and encodes it as JPEG.
+func thumbnailEncode(picture image.Image) (data []byte, err error) {
+    var buffer bytes.Buffer
+    if err := jpeg.Encode(&buffer, thumbnailScale(picture), &jpeg.Options{Quality: thumbnailQuality}); err != nil {
+        return nil, err
+    }
+
+    return buffer.Bytes(), nil
+}
+
+// thumbnailScale scales the picture down to fit into thumbnailSizeMax pixels, keeping the aspect ratio. Smaller pictures keep their size.
+// Each pixel is the average of up to 4x4 pixels of the source. Transparent areas are rendered on white background.
+func thumbnailScale(picture image.Image) (scaled *image.RGBA) {
+    bounds := picture.Bounds()
+    width, height := bounds.Dx(), bounds.Dy()
+    targetWidth, targetHeight := width, height
+
+    if width >= height && width > thumbnailSizeMax {
+        targetWidth, targetHeight = thumbnailSizeMax, height*thumbnailSizeMax/width
+    } else if height > width && height > thumbnailSizeMax {
+        targetWidth, targetHeight = width*thumbnailSizeMax/height, thumbnailSizeMax
+    }
+    if targetWidth < 1 {
+        targetWidth = 1
+    }
+    if targetHeight < 1 {
+        targetHeight = 1
+    }
+
+    scaled = image.NewRGBA(image.Rect(0, 0, targetWidth, targetHeight))
+    if width == 0 || height == 0 {
+        return scaled
+    }
+
+    for y := 0; y < targetHeight; y++ {
+        y0, y1 := bounds.Min.Y+y*height/targetHeight, bounds.Min.Y+(y+1)*height/targetHeight
+        stepY := (y1 - y0 + 3) / 4
+
+        for x := 0; x < targetWidth; x++ {
+            x0, x1 := bounds.Min.X+x*width/targetWidth, bounds.Min.X+(x+1)*width/targetWidth
+            stepX := (x1 - x0 + 3) / 4
+
+            var r, g, b, count uint64
+            for sourceY := y0; sourceY < y1; sourceY += stepY {
+                for sourceX := x0; sourceX < x1; sourceX += stepX {
+                    // The color values are premultiplied with alpha. Adding the transparent part results in a white background.
+                    cr, cg, cb, ca := picture.At(sourceX, sourceY).RGBA()
+                    white := uint64(0xFFFF - ca)
+                    r += uint64(cr) + white
+                    g += uint64(cg) + white
+                    b += uint64(cb) + white
+                    count++
+                }
+            }
+
+            if count > 0 {
+                scaled.SetRGBA(x, y, color.RGBA{R: uint8(r / count >> 8), G: uint8(g / count >> 8), B: uint8(b / count >> 8), A: 0xFF})
+            }
+        }
+    }
+
+    return scaled
+}
@@ -145,6 +145,9 @@ func (peer *PeerInfo) BlockDownload(BlockchainPublicKey *btcec.PublicKey, LimitB
         limit += target.Limit
     }
 
+    // list of blocks already returned as available, to prevent passing duplicates to the callback
+    received := make(map[uint64]struct{})
+
     for n := uint64(0); n < limit; {
         data, targetBlock, blockSize, availability, err := protocol.BlockTransferReadBlock(conn, MaxBlockSize)
         if err != nil {
@@ -153,11 +156,16 @@ func (peer *PeerInfo) BlockDownload(BlockchainPublicKey *btcec.PublicKey, LimitB
             return errors.New("invalid returned block range")
         }
 
-        // TODO: Check if the block was already returned in case the block is available. This can be done via simple map.
+        n += targetBlock.Limit
 
-        callback(data, targetBlock, blockSize, availability)
+        if availability == protocol.GetBlockStatusAvailable {
+            if _, ok := received[targetBlock.Offset]; ok {
+                continue
+            }
+            received[targetBlock.Offset] = struct{}{}
+        }
 
-        n += targetBlock.Limit
+        callback(data, targetBlock, blockSize, availability)
     }
 
     return nil
@@ -0,0 +1,166 @@
+/*
+File Username:  Transfer Proof.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Fragment proofs allow to verify each fragment of a file against the merkle root hash of the file record, regardless of which peer serves the data.
+The downloader does not need the entire merkle tree. Proofs are requested via the Transfer message (TransferControlRequestProof) and returned in a single response.
+FragmentVerifier requests the proofs in batches while the file is downloaded and verifies each fragment once it is received completely.
+*/
+
+package core
+
+import (
+    "errors"
+    "time"
+
+    "github.com/google/uuid"
+    "github.com/newinfoOffical/core/merkle"
+    "github.com/newinfoOffical/core/protocol"
+    "github.com/newinfoOffical/core/warehouse"
+)
+
+// fileProofsMax is the max count of proofs returned for a single request.
+const fileProofsMax = 255
+
+// fileProofTimeout is the timeout for requesting proofs while downloading a file.
+const fileProofTimeout = 10 * time.Second
+
+// fileProofRequest is an outgoing request for proofs. It is stored as data of the sequence.
+type fileProofRequest struct {
+    hash     []byte
+    response chan []merkle.FragmentProof // Proofs returned by the remote peer. Empty if not available.
+}
+
+// respond passes the proofs to the requester. Subsequent responses are ignored.
+func (request *fileProofRequest) respond(proofs []merkle.FragmentProof) {
+    select {
+    case request.response <- proofs:
+    default:
+    }
+}
+
+// FileTransferRequestProof requests the proofs of fragments of a file from the remote peer. The remote peer may return fewer proofs than requested due to the packet size limit.
+// The proofs are not verified. The caller must verify them via Verify using the merkle root hash, size and fragment size of the file record.
+func (peer *PeerInfo) FileTransferRequestProof(hash []byte, fragment, count uint64, timeout time.Duration) (proofs []merkle.FragmentProof, err error) {
+    request := &fileProofRequest{hash: hash, response: make(chan []merkle.FragmentProof, 1)}
+
+    sequence := peer.Backend.networks.Sequences.NewSequenceBi(peer.PublicKey, &peer.messageSequence, request, timeout, nil)
+    if sequence == nil {
+        return nil, errors.New("cannot acquire sequence")
+    }
+
+    raw := &protocol.PacketRaw{Command: protocol.CommandTransfer, Payload: protocol.EncodeTransferProofRequest(hash, fragment, count), Sequence: sequence.SequenceNumber}
+    if err = peer.send(raw); err != nil {
+        peer.Backend.networks.Sequences.InvalidateSequence(peer.PublicKey, sequence.SequenceNumber, true)
+        return nil, err
+    }
+
+    select {
+    case response := <-request.response:
+        // Only return proofs of the requested fragments.
+        for _, proof := range response {
+            if proof.Fragment >= fragment && proof.Fragment-fragment < count {
+                proofs = append(proofs, proof)
+            }
+        }
+
+        if len(proofs) == 0 {
+            return nil, errors.New("proof not available")
+        }
+        return proofs, nil
+
+    case <-time.After(timeout):
+        peer.Backend.networks.Sequences.InvalidateSequence(peer.PublicKey, sequence.SequenceNumber, true)
+        return nil, errors.New("timeout")
+    }
+}
+
+// sendFileProofs responds to a request for proofs of fragments of a file stored in the local warehouse.
+// Private files are only served to peers on the access control list of the file.
+func (peer *PeerInfo) sendFileProofs(hash []byte, fragment, count uint64, sequenceNumber uint32) {
+    _, fileSize, status, _ := peer.Backend.UserWarehouse.FileExists(hash)
+    if status != warehouse.StatusOK || !peer.Backend.IsFileAccessAllowed(hash, peer.PublicKey) {
+        peer.sendTransfer(nil, protocol.TransferControlNotAvailable, protocol.TransferProtocolUDT, hash, 0, 0, sequenceNumber, uuid.UUID{}, false)
+        return
+    }
+
+    // Files up to the minimum fragment size do not use a merkle tree. The file hash is the merkle root hash.
+    var tree *merkle.MerkleTree
+    if fileSize <= merkle.MinimumFragmentSize {
+        tree = &merkle.MerkleTree{FileSize: fileSize, FragmentSize: merkle.MinimumFragmentSize, RootHash: hash}
+        if fileSize > 0 {
+            tree.FragmentCount = 1
+        }
+    } else if tree, status, _ = peer.Backend.UserWarehouse.ReadMerkleTree(hash, false); status != warehouse.StatusOK {
+        peer.sendTransfer(nil, protocol.TransferControlNotAvailable, protocol.TransferProtocolUDT, hash, 0, 0, sequenceNumber, uuid.UUID{}, false)
+        return
+    }
+
+    var proofs []merkle.FragmentProof
+    for n := fragment; n < tree.FragmentCount && n-fragment < count && len(proofs) < fileProofsMax; n++ {
+        if proof := tree.CreateProof(n); proof != nil {
+            proofs = append(proofs, *proof)
+        }
+    }
+
+    packetRaw, encoded, err := protocol.EncodeTransferProof(hash, proofs)
+    if err != nil || encoded == 0 {
+        peer.sendTransfer(nil, protocol.TransferControlNotAvailable, protocol.TransferProtocolUDT, hash, 0, 0, sequenceNumber, uuid.UUID{}, false)
+        return
+    }
+
+    peer.send(&protocol.PacketRaw{Command: protocol.CommandTransfer, Payload: packetRaw, Sequence: sequenceNumber})
+}
+
+// FragmentVerifier verifies the fragments of a file downloaded from a remote peer against the merkle root hash of the file record.
+// The file size and fragment size must be taken from the file record as well. Proofs are requested from the peer as needed.
+type FragmentVerifier struct {
+    peer         *PeerInfo
+    hash         []byte
+    rootHash     []byte
+    fileSize     uint64
+    fragmentSize uint64
+    proofs       map[uint64]merkle.FragmentProof // Proofs received but not yet used
+}
+
+// NewFragmentVerifier creates a verifier for the file. Hash is the hash of the file, the other parameters are from the file record.
+func (peer *PeerInfo) NewFragmentVerifier(hash, rootHash []byte, fileSize, fragmentSize uint64) (verifier *FragmentVerifier) {
+    return &FragmentVerifier{peer: peer, hash: hash, rootHash: rootHash, fileSize: fileSize, fragmentSize: fragmentSize, proofs: make(map[uint64]merkle.FragmentProof)}
+}
+
+// FragmentLength returns the size of the fragment. It returns 0 if the fragment index is invalid.
+func (verifier *FragmentVerifier) FragmentLength(fragment uint64) (length uint64) {
+    return merkle.FragmentLength(verifier.fileSize, verifier.fragmentSize, fragment)
+}
+
+// Verify checks the data of the fragment. An error is returned if the proof cannot be requested from the peer.
+// Files up to the fragment size only have a single fragment, its hash is the merkle root hash and no proof is needed.
+func (verifier *FragmentVerifier) Verify(fragment uint64, data []byte) (valid bool, err error) {
+    if uint64(len(data)) != verifier.FragmentLength(fragment) {
+        return false, nil
+    } else if verifier.fileSize <= verifier.fragmentSize {
+        proof := merkle.FragmentProof{Fragment: fragment, FragmentHash: verifier.rootHash}
+        return proof.VerifyData(data), nil
+    }
+
+    proof, ok := verifier.proofs[fragment]
+    if !ok {
+        proofs, err := verifier.peer.FileTransferRequestProof(verifier.hash, fragment, fileProofsMax, fileProofTimeout)
+        if err != nil {
+            return false, err
+        }
+
+        for _, proof := range proofs {
+            verifier.proofs[proof.Fragment] = proof
+        }
+
+        if proof, ok = verifier.proofs[fragment]; !ok {
+            return false, errors.New("proof not available")
+        }
+    }
+
+    delete(verifier.proofs, fragment)
+
+    return proof.Fragment == fragment && proof.Verify(verifier.rootHash, verifier.fileSize, verifier.fragmentSize) && proof.VerifyData(data), nil
+}
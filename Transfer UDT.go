@@ -30,7 +30,13 @@ const transferLite = true
 
 // startFileTransferUDT starts a file transfer from the local warehouse to the remote peer.
 // It creates a virtual UDT client to transfer data to a remote peer. Counterintuitively, this will be the "file server" peer.
+// Private files are only served to peers on the access control list of the file.
 func (peer *PeerInfo) startFileTransferUDT(hash []byte, fileSize uint64, offset, limit uint64, sequenceNumber uint32, transferID uuid.UUID, transferProtocol uint8) (err error) {
+    if !peer.Backend.IsFileAccessAllowed(hash, peer.PublicKey) {
+        peer.sendTransfer(nil, protocol.TransferControlNotAvailable, transferProtocol, hash, 0, 0, sequenceNumber, uuid.UUID{}, false)
+        return errors.New("access denied")
+    }
+
     if limit > 0 && offset+limit > fileSize {
         return errors.New("invalid limit")
     } else if offset > fileSize {
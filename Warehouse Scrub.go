@@ -0,0 +1,358 @@
+/*
+File Username:  Warehouse Scrub.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+The scrubber checks the integrity of all files in the user's warehouse in the background, since bit rot or manual edits would otherwise silently serve corrupt data to other peers.
+The read rate is limited by WarehouseScrubRate in the config, and a full pass is started every WarehouseScrubInterval hours.
+
+Corrupt files are quarantined and the file records referencing them are marked with the TagUnavailable tag, so that other peers do not consider the user as a source.
+After each pass the scrubber tries to repair unavailable files by downloading them from connected peers. The downloaded data is verified via its hash.
+Corrupt thumbnails are recreated from the file data.
+*/
+
+package core
+
+import (
+    "bytes"
+    "io"
+    "sync"
+    "time"
+
+    "github.com/newinfoOffical/core/blockchain"
+    "github.com/newinfoOffical/core/protocol"
+    "github.com/newinfoOffical/core/warehouse"
+)
+
+// Results of checking a single file, reported via the WarehouseScrub filter.
+const (
+    ScrubOK             = 0 // File is intact.
+    ScrubMerkleRepaired = 1 // File is intact, but the merkle companion file was invalid and recreated.
+    ScrubCorrupt        = 2 // File is corrupt and was quarantined. Its file records are marked as unavailable.
+    ScrubRepaired       = 3 // File was repaired from the network. Its file records are available again.
+    ScrubError          = 4 // File could not be checked.
+)
+
+const (
+    scrubIntervalDefault = 7 * 24 * time.Hour // Interval between passes if not set in the config.
+    scrubStartDelay      = 5 * time.Minute    // Delay of the first pass after start.
+    scrubRepairPeersMax  = 10                 // Max count of peers to ask for a copy of an unavailable file per pass.
+    scrubRepairTimeout   = 10 * time.Second   // Timeout for a peer to start the transfer of an unavailable file.
+)
+
+// WarehouseScrubStatus informs about the current or last pass of the warehouse integrity check.
+type WarehouseScrubStatus struct {
+    Running        bool      // Whether a pass is currently running.
+    Started        time.Time // Start of the current or last pass.
+    Finished       time.Time // End of the last pass. Zero if no pass finished yet.
+    FilesTotal     uint64    // Count of files in the warehouse at the start of the pass.
+    FilesChecked   uint64    // Count of files checked so far.
+    BytesChecked   uint64    // Count of bytes checked so far.
+    FilesCorrupt   uint64    // Count of corrupt files that were quarantined.
+    MerkleRepaired uint64    // Count of recreated merkle companion files.
+    FilesRepaired  uint64    // Count of files repaired from the network.
+    FilesError     uint64    // Count of files that could not be checked.
+    Unavailable    uint64    // Count of files that are still unavailable after the last pass and await repair.
+}
+
+type warehouseScrubber struct {
+    status WarehouseScrubStatus
+    sync.Mutex
+}
+
+// autoScrubWarehouse runs the integrity check of the user's warehouse in regular intervals. It is disabled if no read rate is set.
+func (backend *Backend) autoScrubWarehouse() {
+    if backend.Config.WarehouseScrubRate == 0 || backend.UserWarehouse == nil {
+        return
+    }
+
+    interval := time.Duration(backend.Config.WarehouseScrubInterval) * time.Hour
+    if interval <= 0 {
+        interval = scrubIntervalDefault
+    }
+
+    time.Sleep(scrubStartDelay)
+
+    for {
+        if backend.scrubBegin() {
+            backend.scrubWarehouse()
+        }
+        time.Sleep(interval)
+    }
+}
+
+// ScrubWarehouse starts a pass of the integrity check of the user's warehouse in the background. It returns false if a pass is already running.
+func (backend *Backend) ScrubWarehouse() (started bool) {
+    if backend.UserWarehouse == nil || !backend.scrubBegin() {
+        return false
+    }
+
+    go backend.scrubWarehouse()
+
+    return true
+}
+
+// WarehouseScrubStatus returns the status of the current or last pass of the integrity check.
+func (backend *Backend) WarehouseScrubStatus() (status WarehouseScrubStatus) {
+    backend.warehouseScrub.Lock()
+    defer backend.warehouseScrub.Unlock()
+
+    return backend.warehouseScrub.status
+}
+
+// scrubBegin resets the statistics for a new pass. It returns false if a pass is already running.
+func (backend *Backend) scrubBegin() (started bool) {
+    backend.warehouseScrub.Lock()
+    defer backend.warehouseScrub.Unlock()
+
+    if backend.warehouseScrub.status.Running {
+        return false
+    }
+
+    backend.warehouseScrub.status = WarehouseScrubStatus{Running: true, Started: time.Now().UTC(), Unavailable: backend.warehouseScrub.status.Unavailable}
+
+    return true
+}
+
+// scrubWarehouse checks all files in the warehouse and tries to repair unavailable files afterwards. scrubBegin must be called first.
+func (backend *Backend) scrubWarehouse() {
+    type scrubFile struct {
+        hash []byte
+        size int64
+    }
+
+    // Files are moved into the quarantine folder during the check, therefore the list is created first.
+    var files []scrubFile
+    backend.UserWarehouse.IterateFiles(func(hash []byte, size int64) (Continue bool) {
+        files = append(files, scrubFile{hash: hash, size: size})
+        return true
+    })
+
+    backend.scrubUpdate(func(status *WarehouseScrubStatus) { status.FilesTotal = uint64(len(files)) })
+
+    for _, file := range files {
+        status, merkleRepaired, err := backend.UserWarehouse.VerifyFile(file.hash, backend.Config.WarehouseScrubRate)
+
+        result := ScrubOK
+        switch {
+        case status == warehouse.StatusFileNotFound: // deleted in the meantime
+            continue
+
+        case status == warehouse.StatusFileCorrupt:
+            result = ScrubCorrupt
+            backend.LogError("scrubWarehouse", "file %x is corrupt\n", file.hash)
+
+            if statusQ, err := backend.UserWarehouse.QuarantineFile(file.hash); statusQ != warehouse.StatusOK {
+                backend.LogError("scrubWarehouse", "quarantine file %x status %d error: %v\n", file.hash, statusQ, err)
+                result = ScrubError
+            } else {
+                backend.scrubMarkUnavailable(file.hash)
+            }
+
+        case status != warehouse.StatusOK:
+            backend.LogError("scrubWarehouse", "verify file %x status %d error: %v\n", file.hash, status, err)
+            result = ScrubError
+
+        case merkleRepaired:
+            result = ScrubMerkleRepaired
+        }
+
+        backend.scrubUpdate(func(status *WarehouseScrubStatus) {
+            status.FilesChecked++
+            status.BytesChecked += uint64(file.size)
+
+            switch result {
+            case ScrubCorrupt:
+                status.FilesCorrupt++
+            case ScrubMerkleRepaired:
+                status.MerkleRepaired++
+            case ScrubError:
+                status.FilesError++
+            }
+        })
+
+        backend.Filters.WarehouseScrub(file.hash, result)
+    }
+
+    unavailable := backend.scrubRepair()
+
+    backend.scrubUpdate(func(status *WarehouseScrubStatus) {
+        status.Running = false
+        status.Finished = time.Now().UTC()
+        status.Unavailable = unavailable
+    })
+}
+
+// scrubUpdate updates the statistics of the current pass.
+func (backend *Backend) scrubUpdate(update func(status *WarehouseScrubStatus)) {
+    backend.warehouseScrub.Lock()
+    defer backend.warehouseScrub.Unlock()
+
+    update(&backend.warehouseScrub.status)
+}
+
+// scrubMarkUnavailable marks the file records referencing the corrupt file as unavailable. Thumbnails are recreated from the file data instead.
+func (backend *Backend) scrubMarkUnavailable(hash []byte) {
+    files, status := backend.UserBlockchain.ListFiles()
+    if status != blockchain.StatusOK {
+        return
+    }
+
+    var filesUpdate []blockchain.BlockRecordFile
+
+    for _, file := range files {
+        changed := false
+
+        if bytes.Equal(file.Hash, hash) && file.GetTag(blockchain.TagUnavailable) == nil {
+            file.Tags = append(file.Tags, blockchain.TagFromDate(blockchain.TagUnavailable, time.Now()))
+            changed = true
+        }
+
+        if tag := file.GetTag(blockchain.TagThumbnail); tag != nil && bytes.Equal(tag.Data, hash) {
+            var tags []blockchain.BlockRecordFileTag
+            for _, tag := range file.Tags {
+                if tag.Type != blockchain.TagThumbnail {
+                    tags = append(tags, tag)
+                }
+            }
+            file.Tags = tags
+
+            backend.CreateThumbnail(&file)
+            changed = true
+        }
+
+        if changed {
+            filesUpdate = append(filesUpdate, file)
+        }
+    }
+
+    if len(filesUpdate) == 0 {
+        return
+    }
+
+    if _, _, status := backend.UserBlockchain.ReplaceFiles(filesUpdate); status != blockchain.StatusOK {
+        backend.LogError("scrubMarkUnavailable", "replacing files status %d\n", status)
+    }
+}
+
+// scrubMarkAvailable removes the TagUnavailable tag from the file records referencing the repaired file.
+func (backend *Backend) scrubMarkAvailable(hash []byte) {
+    files, status := backend.UserBlockchain.FileExists(hash)
+    if status != blockchain.StatusOK {
+        return
+    }
+
+    var filesUpdate []blockchain.BlockRecordFile
+
+    for _, file := range files {
+        if file.GetTag(blockchain.TagUnavailable) == nil {
+            continue
+        }
+
+        var tags []blockchain.BlockRecordFileTag
+        for _, tag := range file.Tags {
+            if tag.Type != blockchain.TagUnavailable {
+                tags = append(tags, tag)
+            }
+        }
+        file.Tags = tags
+
+        // The thumbnail may have been removed if it was corrupt as well.
+        backend.CreateThumbnail(&file)
+
+        filesUpdate = append(filesUpdate, file)
+    }
+
+    if len(filesUpdate) == 0 {
+        return
+    }
+
+    if _, _, status := backend.UserBlockchain.ReplaceFiles(filesUpdate); status != blockchain.StatusOK {
+        backend.LogError("scrubMarkAvailable", "replacing files status %d\n", status)
+    }
+}
+
+// scrubRepair tries to repair all files that are marked as unavailable. It returns the count of files that remain unavailable.
+func (backend *Backend) scrubRepair() (unavailable uint64) {
+    files, status := backend.UserBlockchain.ListFiles()
+    if status != blockchain.StatusOK {
+        return 0
+    }
+
+    checked := make(map[string]struct{})
+
+    for _, file := range files {
+        if file.GetTag(blockchain.TagUnavailable) == nil {
+            continue
+        } else if _, ok := checked[string(file.Hash)]; ok {
+            continue
+        }
+        checked[string(file.Hash)] = struct{}{}
+
+        if !backend.scrubRepairFile(file.Hash, file.Size) {
+            unavailable++
+            continue
+        }
+
+        backend.scrubMarkAvailable(file.Hash)
+        backend.scrubUpdate(func(status *WarehouseScrubStatus) { status.FilesRepaired++ })
+        backend.Filters.WarehouseScrub(file.Hash, ScrubRepaired)
+    }
+
+    return unavailable
+}
+
+// scrubRepairFile makes sure the file is available in the warehouse. If not, it tries to download it from connected peers.
+func (backend *Backend) scrubRepairFile(hash []byte, size uint64) (repaired bool) {
+    // The file may have been stored again in the meantime, for example by sharing it again.
+    status, _, _ := backend.UserWarehouse.VerifyFile(hash, backend.Config.WarehouseScrubRate)
+    if status == warehouse.StatusOK {
+        return true
+    } else if status == warehouse.StatusFileCorrupt {
+        backend.UserWarehouse.QuarantineFile(hash)
+    }
+
+    count := 0
+    for _, peer := range backend.PeerlistGet() {
+        if count >= scrubRepairPeersMax {
+            break
+        } else if !peer.IsConnectionActive() {
+            continue
+        }
+        count++
+
+        if backend.scrubDownload(peer, hash, size) {
+            return true
+        }
+    }
+
+    return false
+}
+
+// scrubDownload downloads the file from the peer into the warehouse. The data is only kept if it matches the hash.
+func (backend *Backend) scrubDownload(peer *PeerInfo, hash []byte, size uint64) (success bool) {
+    udtConn, _, err := peer.FileTransferRequestUDT(hash, 0, 0)
+    if err != nil {
+        return false
+    }
+    defer udtConn.Close()
+
+    // Peers that do not respond would block until the transfer sequence times out.
+    timer := time.AfterFunc(scrubRepairTimeout, func() { udtConn.Close() })
+    fileSize, transferSize, err := protocol.FileTransferReadHeader(udtConn)
+    timer.Stop()
+
+    if err != nil || fileSize != size || transferSize != size {
+        return false
+    }
+
+    hashCreated, status, _ := backend.UserWarehouse.CreateFile(io.LimitReader(udtConn, int64(size)), size, nil)
+    if status != warehouse.StatusOK {
+        return false
+    } else if !bytes.Equal(hashCreated, hash) {
+        backend.DeleteFileUnreferenced(hashCreated)
+        return false
+    }
+
+    return true
+}
@@ -16,5 +16,12 @@ func (backend *Backend) initUserWarehouse() {
 
     if err != nil {
         backend.LogError("initUserWarehouse", "error: %s\n", err.Error())
+        return
+    }
+
+    if backend.Config.WarehouseChunking {
+        if err = backend.UserWarehouse.EnableChunking(); err != nil {
+            backend.LogError("initUserWarehouse", "chunk store error: %s\n", err.Error())
+        }
     }
 }
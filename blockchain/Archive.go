@@ -0,0 +1,204 @@
+/*
+File Username:  Archive.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Archives are a portable backup of a blockchain. They allow to migrate a blockchain between machines.
+
+Encoding of the archive:
+Offset  Size   Info
+0       8      Magic "PNBCARCH"
+8       2      Archive format version, currently 0
+10      33     Public key compressed of the blockchain owner
+43      8      Height of the blockchain
+51      8      Version of the blockchain
+59      ?      Blocks. Each block is prefixed by its size (4 bytes) and encoded as returned by GetBlockRaw.
+?       ?      Optional files until the end of the archive. See WriteArchiveFile.
+
+Encoding of a file in the archive:
+Offset  Size   Info
+0       32     Hash blake3 of the file content
+32      8      Size of the file
+40      ?      File content
+
+*/
+
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/newinfoOffical/core/btcec"
+	"github.com/newinfoOffical/core/protocol"
+)
+
+const archiveMagic = "PNBCARCH"
+const archiveFormatVersion = 0
+const archiveHeaderSize = 59
+
+// Limits for importing archives. The archive is untrusted input and the blocks are kept in memory until all are verified.
+const (
+	archiveMaxBlockSize = 4 * 1024 * 1024 // Max size of a single block. Blocks are created up to TargetBlockSize, but a single large record may exceed it.
+	archiveMaxHeight    = 1<<32 - 1       // Max height. The height is exchanged as uint32 in the protocol.
+)
+
+// Export writes the entire blockchain as archive. Status is StatusX.
+// Files may be appended to the writer afterwards via WriteArchiveFile.
+func (blockchain *Blockchain) Export(writer io.Writer) (status int, err error) {
+	blockchain.Lock()
+	defer blockchain.Unlock()
+
+	var header [archiveHeaderSize]byte
+	copy(header[0:8], archiveMagic)
+	binary.LittleEndian.PutUint16(header[8:10], archiveFormatVersion)
+	copy(header[10:10+33], blockchain.publicKey.SerializeCompressed())
+	binary.LittleEndian.PutUint64(header[43:43+8], blockchain.height)
+	binary.LittleEndian.PutUint64(header[51:51+8], blockchain.version)
+
+	if _, err = writer.Write(header[:]); err != nil {
+		return StatusOK, err
+	}
+
+	for blockN := uint64(0); blockN < blockchain.height; blockN++ {
+		blockRaw, found := blockchain.database.Get(blockNumberToKey(blockN))
+		if !found || len(blockRaw) == 0 {
+			return StatusBlockNotFound, errors.New("block not found")
+		}
+
+		var size [4]byte
+		binary.LittleEndian.PutUint32(size[:], uint32(len(blockRaw)))
+
+		if _, err = writer.Write(size[:]); err != nil {
+			return StatusOK, err
+		} else if _, err = writer.Write(blockRaw); err != nil {
+			return StatusOK, err
+		}
+	}
+
+	return StatusOK, nil
+}
+
+// Import replaces the blockchain with the one from the archive. Status is StatusX.
+// The archive must be of the same blockchain owner, therefore importing only works with the same private key. The signature, number, version and hash link of each block is verified.
+// If the archive version is not newer than the current one, all blocks are re-encoded under a new version so that peers pick up the change.
+// Any files following the blocks are not read; use ReadArchiveFile for them.
+func (blockchain *Blockchain) Import(reader io.Reader) (newHeight, newVersion uint64, status int, err error) {
+	var header [archiveHeaderSize]byte
+	if _, err = io.ReadFull(reader, header[:]); err != nil {
+		return 0, 0, StatusInvalidArchive, err
+	} else if string(header[0:8]) != archiveMagic || binary.LittleEndian.Uint16(header[8:10]) != archiveFormatVersion {
+		return 0, 0, StatusInvalidArchive, errors.New("invalid archive header")
+	}
+
+	publicKey, err := btcec.ParsePubKey(header[10:10+33], btcec.S256())
+	if err != nil {
+		return 0, 0, StatusInvalidArchive, err
+	}
+
+	height := binary.LittleEndian.Uint64(header[43 : 43+8])
+	version := binary.LittleEndian.Uint64(header[51 : 51+8])
+
+	if height > archiveMaxHeight {
+		return 0, 0, StatusInvalidArchive, errors.New("archive height exceeds limit")
+	}
+
+	blockchain.Lock()
+	defer blockchain.Unlock()
+
+	if !publicKey.IsEqual(blockchain.publicKey) {
+		return 0, 0, StatusInvalidArchive, errors.New("archive of another blockchain")
+	}
+
+	// read and verify all blocks first, so that any failure leaves the blockchain untouched
+	var blocks []Block
+	var lastBlockHash []byte
+
+	for blockN := uint64(0); blockN < height; blockN++ {
+		var size [4]byte
+		if _, err = io.ReadFull(reader, size[:]); err != nil {
+			return 0, 0, StatusInvalidArchive, err
+		}
+
+		blockSize := binary.LittleEndian.Uint32(size[:])
+		if blockSize > archiveMaxBlockSize {
+			return 0, 0, StatusInvalidArchive, errors.New("archive block exceeds max size")
+		}
+
+		blockRaw := make([]byte, blockSize)
+		if _, err = io.ReadFull(reader, blockRaw); err != nil {
+			return 0, 0, StatusInvalidArchive, err
+		}
+
+		block, err := decodeBlock(blockRaw)
+		if err != nil {
+			return 0, 0, StatusCorruptBlock, err
+		} else if !block.OwnerPublicKey.IsEqual(publicKey) {
+			return 0, 0, StatusCorruptBlock, errors.New("block signed by another key")
+		} else if block.Number != blockN || block.BlockchainVersion != version {
+			return 0, 0, StatusCorruptBlock, errors.New("block number or version mismatch")
+		} else if blockN > 0 && !bytes.Equal(block.LastBlockHash, lastBlockHash) {
+			return 0, 0, StatusCorruptBlock, errors.New("block hash link mismatch")
+		}
+
+		lastBlockHash = protocol.HashData(blockRaw)
+		blocks = append(blocks, *block)
+	}
+
+	if version <= blockchain.version {
+		version = blockchain.version + 1
+
+		for n := range blocks {
+			blocks[n].BlockchainVersion = version
+		}
+	}
+
+	if status = blockchain.replaceBlocks(blocks, blockchain.privateKey); status != StatusOK {
+		return 0, 0, status, errors.New("error encoding blocks")
+	}
+
+	blockchain.headerWrite(height, version)
+
+	return blockchain.height, blockchain.version, StatusOK, nil
+}
+
+// WriteArchiveFile writes a file into the archive. It must be called after Export.
+func WriteArchiveFile(writer io.Writer, hash []byte, size uint64, data io.Reader) (err error) {
+	if len(hash) != protocol.HashSize {
+		return errors.New("invalid file hash")
+	}
+
+	var header [protocol.HashSize + 8]byte
+	copy(header[0:protocol.HashSize], hash)
+	binary.LittleEndian.PutUint64(header[protocol.HashSize:protocol.HashSize+8], size)
+
+	if _, err = writer.Write(header[:]); err != nil {
+		return err
+	}
+
+	written, err := io.CopyN(writer, data, int64(size))
+	if err == nil && uint64(written) != size {
+		err = errors.New("file size mismatch")
+	}
+
+	return err
+}
+
+// ReadArchiveFile reads the header of the next file in the archive. It must be called after Import. The caller must read exactly size bytes of the file content from the reader.
+// At the end of the archive io.EOF is returned.
+func ReadArchiveFile(reader io.Reader) (hash []byte, size uint64, err error) {
+	var header [protocol.HashSize + 8]byte
+	if _, err = io.ReadFull(reader, header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = errors.New("archive file header truncated")
+		}
+		return nil, 0, err
+	}
+
+	hash = make([]byte, protocol.HashSize)
+	copy(hash, header[0:protocol.HashSize])
+
+	return hash, binary.LittleEndian.Uint64(header[protocol.HashSize : protocol.HashSize+8]), nil
+}
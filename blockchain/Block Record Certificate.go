@@ -0,0 +1,152 @@
+/*
+File Username:  Block Record Certificate.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Certificates are issued by a trusted 3rd party and certify information in the blockchain, such as profile fields or files.
+The issuer signs the certificate for a specific blockchain owner, so it cannot be copied into other blockchains.
+
+Certificate records:
+Offset  Size    Info
+0       33      Issuer public key compressed
+33      33      Subject public key compressed. This must be the blockchain owner.
+66      1       Certificate type, see CertificateTypeX
+67      2       Profile field type (only for CertificateTypeProfile)
+69      32      Hash (blake3) of the profile field data, or the file hash
+101     8       Expiration date. 0 = does not expire.
+109     65      Signature by the issuer of the hash of the above 109 bytes
+
+*/
+
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/newinfoOffical/core/btcec"
+	"github.com/newinfoOffical/core/protocol"
+)
+
+// CertificateTypeX defines what is certified
+const (
+	CertificateTypeProfile = 0 // Certifies a profile field.
+	CertificateTypeFile    = 1 // Certifies a file.
+)
+
+// BlockRecordCertificate is a certificate issued by a 3rd party.
+type BlockRecordCertificate struct {
+	Issuer     *btcec.PublicKey // Issuer of the certificate.
+	Subject    *btcec.PublicKey // Subject of the certificate. This is the blockchain owner.
+	Type       uint8            // See CertificateTypeX.
+	Field      uint16           // Profile field type. See ProfileX. Only for CertificateTypeProfile.
+	Hash       []byte           // Hash of the profile field data, or the file hash.
+	Expiration time.Time        // Expiration date. Zero if it does not expire.
+	Signature  []byte           // Signature by the issuer.
+}
+
+const blockRecordCertificateSize = 174
+
+// decodeBlockRecordCertificates decodes only certificate records. Other records are ignored.
+// Each certificate must be signed by the issuer and issued for the blockchain owner, otherwise an error is returned.
+func decodeBlockRecordCertificates(recordsRaw []BlockRecordRaw, ownerPublicKey *btcec.PublicKey) (certificates []BlockRecordCertificate, err error) {
+	for _, record := range recordsRaw {
+		if record.Type != RecordTypeCertificate {
+			continue
+		}
+
+		certificate, err := DecodeCertificate(record.Data)
+		if err != nil {
+			return nil, err
+		} else if ownerPublicKey != nil && !certificate.Subject.IsEqual(ownerPublicKey) {
+			return nil, errors.New("certificate record subject mismatch")
+		}
+
+		certificates = append(certificates, *certificate)
+	}
+
+	return certificates, nil
+}
+
+// DecodeCertificate decodes a certificate as provided by the issuer and verifies the signature.
+func DecodeCertificate(data []byte) (certificate *BlockRecordCertificate, err error) {
+	if len(data) != blockRecordCertificateSize {
+		return nil, errors.New("certificate record invalid size")
+	}
+
+	certificate = &BlockRecordCertificate{}
+
+	if certificate.Issuer, err = btcec.ParsePubKey(data[0:33], btcec.S256()); err != nil {
+		return nil, err
+	} else if certificate.Subject, err = btcec.ParsePubKey(data[33:66], btcec.S256()); err != nil {
+		return nil, err
+	}
+
+	certificate.Type = data[66]
+	certificate.Field = binary.LittleEndian.Uint16(data[67 : 67+2])
+	certificate.Hash = make([]byte, protocol.HashSize)
+	copy(certificate.Hash, data[69:69+protocol.HashSize])
+
+	if expiration := binary.LittleEndian.Uint64(data[101 : 101+8]); expiration != 0 {
+		certificate.Expiration = time.Unix(int64(expiration), 0).UTC()
+	}
+
+	certificate.Signature = make([]byte, 65)
+	copy(certificate.Signature, data[109:109+65])
+
+	signer, _, err := btcec.RecoverCompact(btcec.S256(), certificate.Signature, protocol.HashData(data[0:109]))
+	if err != nil {
+		return nil, err
+	} else if !signer.IsEqual(certificate.Issuer) {
+		return nil, errors.New("certificate record invalid signature")
+	}
+
+	return certificate, nil
+}
+
+// IssueCertificate creates a new certificate signed by the issuer. It is used by 3rd parties to certify information of the subject.
+// The returned data is loaded into the subject's blockchain via CertificateAdd.
+func IssueCertificate(issuerPrivateKey *btcec.PrivateKey, subject *btcec.PublicKey, Type uint8, field uint16, hash []byte, expiration time.Time) (data []byte, err error) {
+	if len(hash) != protocol.HashSize {
+		return nil, errors.New("certificate invalid hash")
+	}
+
+	data = make([]byte, blockRecordCertificateSize)
+	copy(data[0:33], issuerPrivateKey.PubKey().SerializeCompressed())
+	copy(data[33:66], subject.SerializeCompressed())
+	data[66] = Type
+	binary.LittleEndian.PutUint16(data[67:67+2], field)
+	copy(data[69:69+protocol.HashSize], hash)
+
+	if !expiration.IsZero() {
+		binary.LittleEndian.PutUint64(data[101:101+8], uint64(expiration.UTC().Unix()))
+	}
+
+	signature, err := btcec.SignCompact(btcec.S256(), issuerPrivateKey, protocol.HashData(data[0:109]), true)
+	if err != nil {
+		return nil, err
+	} else if len(signature) != 65 {
+		return nil, errors.New("signature length invalid")
+	}
+
+	copy(data[109:109+65], signature)
+
+	return data, nil
+}
+
+// IsExpired checks if the certificate is expired.
+func (certificate *BlockRecordCertificate) IsExpired() bool {
+	return !certificate.Expiration.IsZero() && time.Now().After(certificate.Expiration)
+}
+
+// CertifiesProfileField checks if the certificate certifies the profile field. It does not check the issuer or expiration.
+func (certificate *BlockRecordCertificate) CertifiesProfileField(field *BlockRecordProfile) bool {
+	return certificate.Type == CertificateTypeProfile && certificate.Field == field.Type && bytes.Equal(certificate.Hash, protocol.HashData(field.Data))
+}
+
+// CertifiesFile checks if the certificate certifies the file. It does not check the issuer or expiration.
+func (certificate *BlockRecordCertificate) CertifiesFile(file *BlockRecordFile) bool {
+	return certificate.Type == CertificateTypeFile && bytes.Equal(certificate.Hash, file.Hash)
+}
@@ -0,0 +1,129 @@
+/*
+File Username:  Block Record Content Rating.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Content rating (positive) and content report (negative) records reference a file shared by any user:
+Offset  Size    Info
+0       32      Node ID of the file owner
+32      16      File ID
+48      32      Hash blake3 of the file content
+80      1       Rating: Score. Report: Reason, see ReportReasonX.
+81      ?       Comment, UTF-8 text. Optional.
+
+*/
+
+package blockchain
+
+import (
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/newinfoOffical/core/protocol"
+)
+
+// RatingScoreMin and RatingScoreMax define the valid range of rating scores.
+const (
+	RatingScoreMin = 1
+	RatingScoreMax = 5
+)
+
+// ReportReasonX defines why content is reported
+const (
+	ReportReasonOther     = 0 // Other reason, see comment.
+	ReportReasonSpam      = 1 // Spam or misleading metadata.
+	ReportReasonMalware   = 2 // Malware.
+	ReportReasonIllegal   = 3 // Illegal content.
+	ReportReasonCopyright = 4 // Copyright infringement.
+)
+
+// BlockRecordContentRating is a rating of a file.
+type BlockRecordContentRating struct {
+	NodeID  []byte    // Node ID of the file owner
+	FileID  uuid.UUID // File ID
+	Hash    []byte    // Hash of the file
+	Score   uint8     // Score between RatingScoreMin and RatingScoreMax.
+	Comment string    // Optional comment
+}
+
+// BlockRecordContentReport is a report of a file.
+type BlockRecordContentReport struct {
+	NodeID  []byte    // Node ID of the file owner
+	FileID  uuid.UUID // File ID
+	Hash    []byte    // Hash of the file
+	Reason  uint8     // See ReportReasonX.
+	Comment string    // Optional comment
+}
+
+const blockRecordContentMinSize = 81
+
+// decodeBlockRecordContent decodes the target file and value of a content rating or report record.
+func decodeBlockRecordContent(data []byte) (nodeID []byte, fileID uuid.UUID, hash []byte, value uint8, comment string, err error) {
+	if len(data) < blockRecordContentMinSize {
+		return nil, fileID, nil, 0, "", errors.New("content record invalid size")
+	}
+
+	nodeID = make([]byte, protocol.HashSize)
+	copy(nodeID, data[0:0+protocol.HashSize])
+	copy(fileID[:], data[32:32+16])
+	hash = make([]byte, protocol.HashSize)
+	copy(hash, data[48:48+protocol.HashSize])
+
+	return nodeID, fileID, hash, data[80], string(data[blockRecordContentMinSize:]), nil
+}
+
+// encodeBlockRecordContent encodes a content rating or report record.
+func encodeBlockRecordContent(recordType uint8, nodeID []byte, fileID uuid.UUID, hash []byte, value uint8, comment string) (record BlockRecordRaw, err error) {
+	if len(nodeID) != protocol.HashSize {
+		return record, errors.New("content record invalid node ID")
+	} else if len(hash) != protocol.HashSize {
+		return record, errors.New("content record invalid file hash")
+	}
+
+	data := make([]byte, blockRecordContentMinSize)
+	copy(data[0:32], nodeID)
+	copy(data[32:32+16], fileID[:])
+	copy(data[48:48+32], hash)
+	data[80] = value
+	data = append(data, []byte(comment)...)
+
+	return BlockRecordRaw{Type: recordType, Data: data}, nil
+}
+
+// decodeBlockRecordContentRatings decodes only content rating records. Other records are ignored.
+func decodeBlockRecordContentRatings(recordsRaw []BlockRecordRaw) (ratings []BlockRecordContentRating, err error) {
+	for _, record := range recordsRaw {
+		if record.Type != RecordTypeContentRating {
+			continue
+		}
+
+		var rating BlockRecordContentRating
+		if rating.NodeID, rating.FileID, rating.Hash, rating.Score, rating.Comment, err = decodeBlockRecordContent(record.Data); err != nil {
+			return nil, err
+		} else if rating.Score < RatingScoreMin || rating.Score > RatingScoreMax {
+			return nil, errors.New("content rating invalid score")
+		}
+
+		ratings = append(ratings, rating)
+	}
+
+	return ratings, nil
+}
+
+// decodeBlockRecordContentReports decodes only content report records. Other records are ignored.
+func decodeBlockRecordContentReports(recordsRaw []BlockRecordRaw) (reports []BlockRecordContentReport, err error) {
+	for _, record := range recordsRaw {
+		if record.Type != RecordTypeContentReport {
+			continue
+		}
+
+		var report BlockRecordContentReport
+		if report.NodeID, report.FileID, report.Hash, report.Reason, report.Comment, err = decodeBlockRecordContent(record.Data); err != nil {
+			return nil, err
+		}
+
+		reports = append(reports, report)
+	}
+
+	return reports, nil
+}
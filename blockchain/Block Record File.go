@@ -32,8 +32,10 @@ import (
 	"encoding/binary"
 	"errors"
 	"math"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/newinfoOffical/core/btcec"
 	"github.com/newinfoOffical/core/protocol"
 )
 
@@ -50,6 +52,7 @@ type BlockRecordFile struct {
 	Tags           []BlockRecordFileTag // Tags provide additional metadata
 	Username       string               // Username of the User who uploaded the file
 	ProfilePicture []byte               // UserProfile phone
+	Recipients     []*btcec.PublicKey   // Recipients who may access a private file. Empty for public files.
 }
 
 // BlockRecordFileTag provides metadata about the file.
@@ -164,55 +167,79 @@ func encodeBlockRecordFiles(files []BlockRecordFile) (recordsRaw []BlockRecordRa
 
 	// then encode all files as records
 	for n := range files {
-		data := make([]byte, blockRecordFileMinSize)
-
-		if len(files[n].Hash) != protocol.HashSize {
-			return nil, errors.New("encodeBlockRecords invalid file hash")
-		} else if len(files[n].MerkleRootHash) != protocol.HashSize {
-			return nil, errors.New("encodeBlockRecords invalid merkle root hash")
+		data, err := encodeBlockRecordFileData(&files[n], func(tagData []byte) (reference []byte, ok bool) {
+			if refNumber, ok := duplicateTagDataMap[string(tagData)]; ok {
+				return intToBytes(-(len(recordsRaw) - refNumber)), true
+			}
+			return nil, false
+		})
+		if err != nil {
+			return nil, err
 		}
 
-		copy(data[0:32], files[n].Hash[0:32])
-		copy(data[32:32+16], files[n].ID[:])
-		copy(data[48:48+32], files[n].MerkleRootHash[0:32])
-		binary.LittleEndian.PutUint64(data[80:80+8], files[n].FragmentSize)
+		recordsRaw = append(recordsRaw, BlockRecordRaw{Type: RecordTypeFile, Date: files[n].dateShared(), Data: data})
+	}
 
-		data[88] = files[n].Type
-		binary.LittleEndian.PutUint16(data[89:89+2], files[n].Format)
-		binary.LittleEndian.PutUint64(data[91:91+8], files[n].Size)
+	return recordsRaw, nil
+}
 
-		var tagCount uint16
+// encodeBlockRecordFileData encodes a single file record. The optional reference function returns the reference data for tag data that is stored in a RecordTypeTagData record.
+func encodeBlockRecordFileData(file *BlockRecordFile, reference func(tagData []byte) (reference []byte, ok bool)) (data []byte, err error) {
+	data = make([]byte, blockRecordFileMinSize)
 
-		for _, tag := range files[n].Tags {
-			// Some tags are virtual and never stored on the blockchain. If attempted to write, ignore.
-			if tag.IsVirtual() {
-				continue
-			}
-			tagCount++
+	if len(file.Hash) != protocol.HashSize {
+		return nil, errors.New("encodeBlockRecords invalid file hash")
+	} else if len(file.MerkleRootHash) != protocol.HashSize {
+		return nil, errors.New("encodeBlockRecords invalid merkle root hash")
+	}
 
-			if len(tag.Data) > 4 {
-				if refNumber, ok := duplicateTagDataMap[string(tag.Data)]; ok {
-					// In case the data is duplicated, use reference to the RecordTypeTagData instead
-					tag.Type |= 0x8000
-					tag.Data = intToBytes(-(len(recordsRaw) - refNumber))
-				}
-			}
+	copy(data[0:32], file.Hash[0:32])
+	copy(data[32:32+16], file.ID[:])
+	copy(data[48:48+32], file.MerkleRootHash[0:32])
+	binary.LittleEndian.PutUint64(data[80:80+8], file.FragmentSize)
+
+	data[88] = file.Type
+	binary.LittleEndian.PutUint16(data[89:89+2], file.Format)
+	binary.LittleEndian.PutUint64(data[91:91+8], file.Size)
 
-			var tempTag [6]byte
+	var tagCount uint16
 
-			binary.LittleEndian.PutUint16(tempTag[0:2], tag.Type)
-			binary.LittleEndian.PutUint32(tempTag[2:2+4], uint32(len(tag.Data)))
+	for _, tag := range file.Tags {
+		// Some tags are virtual and never stored on the blockchain. If attempted to write, ignore.
+		if tag.IsVirtual() {
+			continue
+		}
+		tagCount++
 
-			data = append(data, tempTag[:]...)
-			data = append(data, tag.Data...)
+		if len(tag.Data) > 4 && reference != nil {
+			if refData, ok := reference(tag.Data); ok {
+				// In case the data is duplicated, use reference to the RecordTypeTagData instead
+				tag.Type |= 0x8000
+				tag.Data = refData
+			}
 		}
 
-		binary.LittleEndian.PutUint16(data[99:99+2], tagCount)
+		var tempTag [6]byte
 
-		recordsRaw = append(recordsRaw, BlockRecordRaw{Type: RecordTypeFile, Data: data})
+		binary.LittleEndian.PutUint16(tempTag[0:2], tag.Type)
+		binary.LittleEndian.PutUint32(tempTag[2:2+4], uint32(len(tag.Data)))
+
+		data = append(data, tempTag[:]...)
+		data = append(data, tag.Data...)
 	}
 
-	return recordsRaw, nil
+	binary.LittleEndian.PutUint16(data[99:99+2], tagCount)
+
+	return data, nil
+}
+
+// dateShared returns the date shared from the tag. Keep the original date when re-encoding a decoded file. If not available, the date is set when the block is encoded.
+func (file *BlockRecordFile) dateShared() (date time.Time) {
+	if tag := file.GetTag(TagDateShared); tag != nil {
+		date, _ = tag.Date()
+	}
+
+	return date
 }
 
 // intToBytes encodes int to little endian byte array as it fits to 16, 32 or 64 bit.
@@ -244,5 +271,9 @@ func (file *BlockRecordFile) SizeInBlock() (size uint64) {
 		size += 6 + uint64(len(tag.Data))
 	}
 
+	if len(file.Recipients) > 0 {
+		size += blockRecordPrivateFileSizeOverhead + uint64(len(file.Recipients))*blockRecordPrivateFileRecipientSize
+	}
+
 	return size
 }
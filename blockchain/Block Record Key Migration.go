@@ -0,0 +1,82 @@
+/*
+File Username:  Block Record Key Migration.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Key migration records hand over a blockchain from an old key to a new one. They are used when the old private key is suspected compromised.
+The record is signed by the old key and stored as first record in block 0 of the new blockchain. Records of earlier migrations follow it, so that a chain of migrations (A -> B -> C) can be followed.
+
+Offset  Size    Info
+0       33      Old public key compressed
+33      33      New public key compressed
+66      65      Signature by the old key of the hash of the above 66 bytes
+
+*/
+
+package blockchain
+
+import (
+	"errors"
+
+	"github.com/newinfoOffical/core/btcec"
+	"github.com/newinfoOffical/core/protocol"
+)
+
+// BlockRecordKeyMigration is a signed handover of a blockchain from an old key to a new key.
+type BlockRecordKeyMigration struct {
+	OldPublicKey *btcec.PublicKey // Old public key which signed the handover.
+	NewPublicKey *btcec.PublicKey // New public key which takes over the blockchain.
+}
+
+const blockRecordKeyMigrationSize = 33 + 33 + 65
+
+// decodeBlockRecordKeyMigration decodes only key migration records. Other records are ignored.
+// Each record must be signed by the old key, otherwise an error is returned.
+func decodeBlockRecordKeyMigration(recordsRaw []BlockRecordRaw) (migrations []BlockRecordKeyMigration, err error) {
+	for _, record := range recordsRaw {
+		if record.Type != RecordTypeKeyMigration {
+			continue
+		}
+
+		if len(record.Data) != blockRecordKeyMigrationSize {
+			return nil, errors.New("key migration record invalid size")
+		}
+
+		var migration BlockRecordKeyMigration
+
+		if migration.OldPublicKey, err = btcec.ParsePubKey(record.Data[0:33], btcec.S256()); err != nil {
+			return nil, err
+		} else if migration.NewPublicKey, err = btcec.ParsePubKey(record.Data[33:66], btcec.S256()); err != nil {
+			return nil, err
+		}
+
+		signer, _, err := btcec.RecoverCompact(btcec.S256(), record.Data[66:66+65], protocol.HashData(record.Data[0:66]))
+		if err != nil {
+			return nil, err
+		} else if !signer.IsEqual(migration.OldPublicKey) {
+			return nil, errors.New("key migration record invalid signature")
+		}
+
+		migrations = append(migrations, migration)
+	}
+
+	return migrations, nil
+}
+
+// encodeBlockRecordKeyMigration encodes a key migration record. The handover is signed by the old private key.
+func encodeBlockRecordKeyMigration(oldPrivateKey *btcec.PrivateKey, newPublicKey *btcec.PublicKey) (record BlockRecordRaw, err error) {
+	data := make([]byte, blockRecordKeyMigrationSize)
+	copy(data[0:33], oldPrivateKey.PubKey().SerializeCompressed())
+	copy(data[33:66], newPublicKey.SerializeCompressed())
+
+	signature, err := btcec.SignCompact(btcec.S256(), oldPrivateKey, protocol.HashData(data[0:66]), true)
+	if err != nil {
+		return record, err
+	} else if len(signature) != 65 {
+		return record, errors.New("signature length invalid")
+	}
+
+	copy(data[66:66+65], signature)
+
+	return BlockRecordRaw{Type: RecordTypeKeyMigration, Data: data}, nil
+}
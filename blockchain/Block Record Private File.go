@@ -0,0 +1,187 @@
+/*
+File Username:  Block Record Private File.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Private file records contain a file record that is only readable by the owner and the listed recipients.
+The file record is encrypted with a random per-file key. The file key is encrypted to each recipient using a key derived via ECDH between the owner and the recipient.
+Since ECDH is symmetric, the owner can decrypt any of the recipient entries with its own private key.
+The public keys of the recipients are not encrypted. Tags are always stored inline; they never reference tag data records.
+
+Offset  Size    Info
+0       2       Count of recipients
+2       ?       Recipients, each 105 bytes:
+                Offset  Size    Info
+                0       33      Public key compressed of the recipient
+                33      24      Nonce
+                57      48      File key encrypted via XChaCha20-Poly1305
+?       24      Nonce
+?       ?       File record (see Block Record File.go) encrypted via XChaCha20-Poly1305 with the file key
+
+*/
+
+package blockchain
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+
+	"github.com/newinfoOffical/core/btcec"
+	"github.com/newinfoOffical/core/protocol"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const (
+	blockRecordPrivateFileRecipientSize = 33 + chacha20poly1305.NonceSizeX + chacha20poly1305.KeySize + chacha20poly1305.Overhead
+	blockRecordPrivateFileSizeOverhead  = 2 + chacha20poly1305.NonceSizeX + chacha20poly1305.Overhead
+)
+
+// privateFileRecipientKey returns the key to encrypt the file key for a single recipient. Either the owner or the recipient private key may be used.
+func privateFileRecipientKey(privateKey *btcec.PrivateKey, publicKey *btcec.PublicKey) []byte {
+	return protocol.HashData(btcec.GenerateSharedSecret(privateKey, publicKey))
+}
+
+// encodeBlockRecordPrivateFiles encodes files as private file records. Each file must have at least one recipient.
+func encodeBlockRecordPrivateFiles(files []BlockRecordFile, ownerPrivateKey *btcec.PrivateKey) (recordsRaw []BlockRecordRaw, err error) {
+	for n := range files {
+		if len(files[n].Recipients) == 0 || len(files[n].Recipients) > 0xFFFF {
+			return nil, errors.New("encodeBlockRecordPrivateFiles invalid count of recipients")
+		}
+
+		fileData, err := encodeBlockRecordFileData(&files[n], nil)
+		if err != nil {
+			return nil, err
+		}
+
+		// random per-file key
+		fileKey := make([]byte, chacha20poly1305.KeySize)
+		if _, err := rand.Read(fileKey); err != nil {
+			return nil, err
+		}
+
+		data := make([]byte, 2)
+		binary.LittleEndian.PutUint16(data[0:2], uint16(len(files[n].Recipients)))
+
+		for _, recipient := range files[n].Recipients {
+			aead, err := chacha20poly1305.NewX(privateFileRecipientKey(ownerPrivateKey, recipient))
+			if err != nil {
+				return nil, err
+			}
+
+			nonce := make([]byte, chacha20poly1305.NonceSizeX)
+			if _, err := rand.Read(nonce); err != nil {
+				return nil, err
+			}
+
+			data = append(data, recipient.SerializeCompressed()...)
+			data = append(data, nonce...)
+			data = aead.Seal(data, nonce, fileKey, nil)
+		}
+
+		aead, err := chacha20poly1305.NewX(fileKey)
+		if err != nil {
+			return nil, err
+		}
+
+		nonce := make([]byte, chacha20poly1305.NonceSizeX)
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, err
+		}
+
+		data = append(data, nonce...)
+		data = aead.Seal(data, nonce, fileData, nil)
+
+		recordsRaw = append(recordsRaw, BlockRecordRaw{Type: RecordTypePrivateFile, Date: files[n].dateShared(), Data: data})
+	}
+
+	return recordsRaw, nil
+}
+
+// DecodeBlockRecordPrivateFiles decodes only private file records that can be decrypted with the private key. Other records are ignored.
+// The private key must be either the one of the blockchain owner, or the one of a recipient. Invalid records are skipped, so that they do not hide other files in the block.
+func DecodeBlockRecordPrivateFiles(recordsRaw []BlockRecordRaw, ownerPublicKey *btcec.PublicKey, nodeID []byte, privateKey *btcec.PrivateKey) (files []BlockRecordFile, err error) {
+	isOwner := privateKey.PubKey().IsEqual(ownerPublicKey)
+	publicKeyC := privateKey.PubKey().SerializeCompressed()
+
+	for _, record := range recordsRaw {
+		if record.Type != RecordTypePrivateFile {
+			continue
+		}
+
+		if file, ok := decodeBlockRecordPrivateFile(record, ownerPublicKey, nodeID, privateKey, isOwner, publicKeyC); ok {
+			files = append(files, file)
+		}
+	}
+
+	return files, nil
+}
+
+// decodeBlockRecordPrivateFile decodes a single private file record. It returns false if the record is invalid or the private key is not a recipient.
+func decodeBlockRecordPrivateFile(record BlockRecordRaw, ownerPublicKey *btcec.PublicKey, nodeID []byte, privateKey *btcec.PrivateKey, isOwner bool, publicKeyC []byte) (file BlockRecordFile, ok bool) {
+	if len(record.Data) < blockRecordPrivateFileSizeOverhead {
+		return file, false
+	}
+
+	countRecipients := int(binary.LittleEndian.Uint16(record.Data[0:2]))
+	index := 2 + countRecipients*blockRecordPrivateFileRecipientSize
+	if countRecipients == 0 || index+chacha20poly1305.NonceSizeX+chacha20poly1305.Overhead > len(record.Data) {
+		return file, false
+	}
+
+	var recipients []*btcec.PublicKey
+	var fileKey []byte
+
+	for n := 0; n < countRecipients; n++ {
+		entry := record.Data[2+n*blockRecordPrivateFileRecipientSize : 2+(n+1)*blockRecordPrivateFileRecipientSize]
+
+		recipient, err := btcec.ParsePubKey(entry[0:33], btcec.S256())
+		if err != nil {
+			return file, false
+		}
+		recipients = append(recipients, recipient)
+
+		if fileKey != nil || !isOwner && string(entry[0:33]) != string(publicKeyC) {
+			continue
+		}
+
+		// The owner derives the key with the recipient's public key, the recipient with the owner's public key.
+		peerPublicKey := ownerPublicKey
+		if isOwner {
+			peerPublicKey = recipient
+		}
+
+		aead, err := chacha20poly1305.NewX(privateFileRecipientKey(privateKey, peerPublicKey))
+		if err != nil {
+			return file, false
+		}
+
+		if fileKey, err = aead.Open(nil, entry[33:33+chacha20poly1305.NonceSizeX], entry[33+chacha20poly1305.NonceSizeX:], nil); err != nil {
+			return file, false
+		}
+	}
+
+	// not a recipient of this file
+	if fileKey == nil {
+		return file, false
+	}
+
+	aead, err := chacha20poly1305.NewX(fileKey)
+	if err != nil {
+		return file, false
+	}
+
+	fileData, err := aead.Open(nil, record.Data[index:index+chacha20poly1305.NonceSizeX], record.Data[index+chacha20poly1305.NonceSizeX:], nil)
+	if err != nil {
+		return file, false
+	}
+
+	filesD, err := decodeBlockRecordFiles([]BlockRecordRaw{{Type: RecordTypeFile, Date: record.Date, Data: fileData}}, nodeID)
+	if err != nil || len(filesD) != 1 {
+		return file, false
+	}
+
+	filesD[0].Recipients = recipients
+
+	return filesD[0], true
+}
@@ -23,6 +23,8 @@ const (
 	RecordTypeCertificate   = 4 // Certificate to certify provided information in the blockchain issued by a trusted 3rd party.
 	RecordTypeContentRating = 5 // Content rating (positive).
 	RecordTypeContentReport = 6 // Content report (negative).
+	RecordTypeKeyMigration  = 7 // Key migration. The old key hands over the blockchain to a new key.
+	RecordTypePrivateFile   = 8 // Private file. The file record is encrypted to a list of recipients.
 )
 
 // BlockDecoded contains the decoded records from a block
@@ -51,5 +53,41 @@ func decodeBlockRecords(block *Block) (decoded *BlockDecoded, err error) {
 		decoded.RecordsDecoded = append(decoded.RecordsDecoded, profileFields)
 	}
 
+	certificates, err := decodeBlockRecordCertificates(block.RecordsRaw, block.OwnerPublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, certificate := range certificates {
+		decoded.RecordsDecoded = append(decoded.RecordsDecoded, certificate)
+	}
+
+	ratings, err := decodeBlockRecordContentRatings(block.RecordsRaw)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rating := range ratings {
+		decoded.RecordsDecoded = append(decoded.RecordsDecoded, rating)
+	}
+
+	reports, err := decodeBlockRecordContentReports(block.RecordsRaw)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, report := range reports {
+		decoded.RecordsDecoded = append(decoded.RecordsDecoded, report)
+	}
+
+	migrations, err := decodeBlockRecordKeyMigration(block.RecordsRaw)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, migration := range migrations {
+		decoded.RecordsDecoded = append(decoded.RecordsDecoded, migration)
+	}
+
 	return decoded, nil
 }
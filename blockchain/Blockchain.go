@@ -151,6 +151,7 @@ const (
     StatusCorruptBlockRecord = 3 // Error block record encoding
     StatusDataNotFound       = 4 // Requested data not available in the blockchain
     StatusNotInWarehouse     = 5 // File to be added to blockchain does not exist in the Warehouse
+    StatusInvalidArchive     = 6 // Invalid archive or archive of another blockchain
 )
 
 // blockNumberToKey returns the database key for the given block number
@@ -216,7 +217,7 @@ func (blockchain *Blockchain) IterateDeleteRecord(callbackFile func(file *BlockR
 
         // Decode all file records at once. This is needed due to potential referenced tags.
         // If a file is deleted or referenced tag data changed, it would corrupt the blockchain if the other records were not updated.
-        filesD, err := decodeBlockRecordFiles(block.RecordsRaw, block.NodeID)
+        filesD, err := blockchain.decodeFiles(block)
         if err != nil {
             return 0, 0, StatusCorruptBlock
         }
@@ -251,8 +252,8 @@ func (blockchain *Blockchain) IterateDeleteRecord(callbackFile func(file *BlockR
         var newRecordsRaw []BlockRecordRaw
 
         for n := range block.RecordsRaw {
-            // File and Tag records were already handled in above loop.
-            if block.RecordsRaw[n].Type == RecordTypeFile || block.RecordsRaw[n].Type == RecordTypeTagData {
+            // File, private file, and Tag records were already handled in above loop.
+            if block.RecordsRaw[n].Type == RecordTypeFile || block.RecordsRaw[n].Type == RecordTypePrivateFile || block.RecordsRaw[n].Type == RecordTypeTagData {
                 continue
             }
 
@@ -282,7 +283,7 @@ func (blockchain *Blockchain) IterateDeleteRecord(callbackFile func(file *BlockR
         // Note: Deleting records may leave referenced records orphaned, such as RecordTypeTagData for deleted file records.
         if refactorBlock {
             // re-encode the block
-            filesRecords, err := encodeBlockRecordFiles(newFileRecords)
+            filesRecords, err := blockchain.encodeFiles(newFileRecords)
             if err != nil {
                 return 0, 0, StatusCorruptBlock
             }
@@ -413,6 +414,106 @@ func (blockchain *Blockchain) DeleteBlockchain() (status int, err error) {
     return StatusOK, nil
 }
 
+// MigrateKey hands over the blockchain to a new private key. Status is StatusX.
+// All blocks are re-encoded and signed by the new key. Block 0 starts with a key migration record signed by the old key.
+// Records keep their original date and are not regrouped, since file records may reference tag data records in the same block.
+func (blockchain *Blockchain) MigrateKey(newPrivateKey *btcec.PrivateKey) (newHeight, newVersion uint64, status int) {
+    blockchain.Lock()
+    defer blockchain.Unlock()
+
+    migrationRecord, err := encodeBlockRecordKeyMigration(blockchain.privateKey, newPrivateKey.PubKey())
+    if err != nil {
+        return 0, 0, StatusCorruptBlockRecord
+    }
+
+    newPublicKey := newPrivateKey.PubKey()
+    refactorVersion := blockchain.version + 1
+    blockchainNew := []Block{{OwnerPublicKey: newPublicKey, RecordsRaw: []BlockRecordRaw{migrationRecord}, BlockchainVersion: refactorVersion}}
+
+    for blockN := uint64(0); blockN < blockchain.height; blockN++ {
+        blockRaw, found := blockchain.database.Get(blockNumberToKey(blockN))
+        if !found || len(blockRaw) == 0 {
+            return 0, 0, StatusBlockNotFound
+        }
+
+        block, err := decodeBlock(blockRaw)
+        if err != nil {
+            return 0, 0, StatusCorruptBlock
+        }
+
+        // Key migration records of earlier migrations are kept, so that peers can follow the chain of migrations.
+        // Certificates are dropped, since they are issued for the old key.
+        // Private file records are encrypted via the owner's key, therefore they are decrypted and encrypted again with the new key. Records that cannot be decrypted are dropped.
+        var recordsRaw []BlockRecordRaw
+        for _, record := range block.RecordsRaw {
+            switch record.Type {
+            case RecordTypeCertificate:
+            case RecordTypePrivateFile:
+                files, _ := DecodeBlockRecordPrivateFiles([]BlockRecordRaw{record}, blockchain.publicKey, block.NodeID, blockchain.privateKey)
+                if len(files) != 1 {
+                    continue
+                }
+
+                recordsPrivate, err := encodeBlockRecordPrivateFiles(files, newPrivateKey)
+                if err != nil || len(recordsPrivate) != 1 {
+                    return 0, 0, StatusCorruptBlockRecord
+                }
+                recordsPrivate[0].Date = record.Date
+
+                recordsRaw = append(recordsRaw, recordsPrivate[0])
+            default:
+                recordsRaw = append(recordsRaw, record)
+            }
+        }
+
+        if blockN == 0 {
+            blockchainNew[0].RecordsRaw = append(blockchainNew[0].RecordsRaw, recordsRaw...)
+        } else if len(recordsRaw) > 0 {
+            blockchainNew = append(blockchainNew, Block{OwnerPublicKey: newPublicKey, RecordsRaw: recordsRaw, BlockchainVersion: refactorVersion, Number: uint64(len(blockchainNew))})
+        }
+    }
+
+    if status = blockchain.replaceBlocks(blockchainNew, newPrivateKey); status != StatusOK {
+        return 0, 0, status
+    }
+
+    blockchain.privateKey = newPrivateKey
+    blockchain.publicKey = newPublicKey
+
+    blockchain.headerWrite(uint64(len(blockchainNew)), refactorVersion)
+
+    return blockchain.height, blockchain.version, StatusOK
+}
+
+// replaceBlocks encodes the new blocks and replaces all existing ones. Blocks beyond the new height are deleted. Status is StatusX.
+// All blocks are encoded first, so that any failure leaves the blockchain untouched. The caller must update the header.
+func (blockchain *Blockchain) replaceBlocks(blocks []Block, privateKey *btcec.PrivateKey) (status int) {
+    var blocksRaw [][]byte
+    var lastBlockHash []byte
+
+    for _, block := range blocks {
+        block.LastBlockHash = lastBlockHash
+
+        raw, err := encodeBlock(&block, privateKey)
+        if err != nil {
+            return StatusCorruptBlock
+        }
+
+        blocksRaw = append(blocksRaw, raw)
+        lastBlockHash = protocol.HashData(raw)
+    }
+
+    for n, raw := range blocksRaw {
+        blockchain.database.Set(blockNumberToKey(uint64(n)), raw)
+    }
+
+    for n := uint64(len(blocksRaw)); n < blockchain.height; n++ {
+        blockchain.database.Delete(blockNumberToKey(n))
+    }
+
+    return StatusOK
+}
+
 // GetBlockRaw returns the encoded block from the blockchain. Status is StatusX.
 func (blockchain *Blockchain) GetBlockRaw(number uint64) (data []byte, status int, err error) {
     if number >= blockchain.height {
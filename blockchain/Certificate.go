@@ -0,0 +1,40 @@
+/*
+File Username:  Certificate.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+*/
+
+package blockchain
+
+// CertificateList lists all certificates. Status is StatusX.
+func (blockchain *Blockchain) CertificateList() (certificates []BlockRecordCertificate, status int) {
+	status = blockchain.Iterate(func(block *Block) (statusI int) {
+		certificatesMore, err := decodeBlockRecordCertificates(block.RecordsRaw, block.OwnerPublicKey)
+		if err != nil {
+			return StatusCorruptBlockRecord
+		}
+		certificates = append(certificates, certificatesMore...)
+
+		return StatusOK
+	})
+
+	return certificates, status
+}
+
+// CertificateAdd adds certificates issued by 3rd parties to the blockchain. Status is StatusX.
+// Certificates that are not validly signed or not issued for the blockchain owner are rejected with StatusCorruptBlockRecord.
+func (blockchain *Blockchain) CertificateAdd(certificatesRaw [][]byte) (newHeight, newVersion uint64, status int) {
+	publicKey, _, _ := blockchain.Header()
+
+	var recordsRaw []BlockRecordRaw
+
+	for _, data := range certificatesRaw {
+		if certificate, err := DecodeCertificate(data); err != nil || !certificate.Subject.IsEqual(publicKey) {
+			return 0, 0, StatusCorruptBlockRecord
+		}
+
+		recordsRaw = append(recordsRaw, BlockRecordRaw{Type: RecordTypeCertificate, Data: data})
+	}
+
+	return blockchain.Append(recordsRaw)
+}
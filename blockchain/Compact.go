@@ -0,0 +1,146 @@
+/*
+File Username:  Compact.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Compaction repacks all records into as few blocks as possible. Each new block is filled up to TargetBlockSize.
+This is useful when many small blocks were appended over time, for example when files are shared one by one.
+*/
+
+package blockchain
+
+// Fragmentation returns the share of blocks that could be saved by compaction, between 0 and 1. Status is StatusX.
+// It is an estimate based on the total size of all blocks compared to TargetBlockSize.
+func (blockchain *Blockchain) Fragmentation() (fragmentation float64, status int) {
+	blockchain.Lock()
+	defer blockchain.Unlock()
+
+	if blockchain.height == 0 {
+		return 0, StatusOK
+	}
+
+	var sizeRecords uint64
+
+	for blockN := uint64(0); blockN < blockchain.height; blockN++ {
+		blockRaw, found := blockchain.database.Get(blockNumberToKey(blockN))
+		if !found || len(blockRaw) == 0 {
+			return 0, StatusBlockNotFound
+		} else if len(blockRaw) < blockHeaderSize {
+			return 0, StatusCorruptBlock
+		}
+
+		sizeRecords += uint64(len(blockRaw)) - blockHeaderSize
+	}
+
+	optimalHeight := (sizeRecords + TargetBlockSize - blockHeaderSize - 1) / (TargetBlockSize - blockHeaderSize)
+	if optimalHeight == 0 {
+		optimalHeight = 1
+	}
+	if optimalHeight >= blockchain.height {
+		return 0, StatusOK
+	}
+
+	return 1 - float64(optimalHeight)/float64(blockchain.height), StatusOK
+}
+
+// Compact repacks all records into blocks up to TargetBlockSize. Status is StatusX.
+// If fewer blocks are needed, the blockchain is re-encoded under a new version number. Otherwise it remains unchanged.
+// The order of records and their dates are preserved. Orphaned tag data records are dropped, since file records are re-encoded.
+func (blockchain *Blockchain) Compact() (newHeight, newVersion uint64, status int) {
+	blockchain.Lock()
+	defer blockchain.Unlock()
+
+	refactorVersion := blockchain.version + 1
+
+	var blockchainNew []Block
+	var currentRecords []BlockRecordRaw
+	var currentFiles []BlockRecordFile
+	currentSize := uint64(blockHeaderSize)
+
+	// finalizeBlock encodes the current files and creates a new block with all current records.
+	finalizeBlock := func() (status int) {
+		filesRecords, err := encodeBlockRecordFiles(currentFiles)
+		if err != nil {
+			return StatusCorruptBlockRecord
+		}
+
+		if recordsRaw := append(currentRecords, filesRecords...); len(recordsRaw) > 0 {
+			blockchainNew = append(blockchainNew, Block{OwnerPublicKey: blockchain.publicKey, RecordsRaw: recordsRaw, BlockchainVersion: refactorVersion, Number: uint64(len(blockchainNew))})
+		}
+
+		currentRecords = nil
+		currentFiles = nil
+		currentSize = blockHeaderSize
+
+		return StatusOK
+	}
+
+	// makeSpace starts a new block if the current one would exceed the target block size. A record larger than the target size gets its own block.
+	makeSpace := func(recordSize uint64) (status int) {
+		if (len(currentRecords) > 0 || len(currentFiles) > 0) && currentSize+recordSize > TargetBlockSize {
+			if status = finalizeBlock(); status != StatusOK {
+				return status
+			}
+		}
+
+		currentSize += recordSize
+
+		return StatusOK
+	}
+
+	for blockN := uint64(0); blockN < blockchain.height; blockN++ {
+		blockRaw, found := blockchain.database.Get(blockNumberToKey(blockN))
+		if !found || len(blockRaw) == 0 {
+			return 0, 0, StatusBlockNotFound
+		}
+
+		block, err := decodeBlock(blockRaw)
+		if err != nil {
+			return 0, 0, StatusCorruptBlock
+		}
+
+		// Other records first, as in IterateDeleteRecord. This keeps a key migration record at the start of block 0.
+		for _, record := range block.RecordsRaw {
+			if record.Type == RecordTypeFile || record.Type == RecordTypeTagData {
+				continue
+			}
+
+			if status = makeSpace(blockRecordHeaderSize + uint64(len(record.Data))); status != StatusOK {
+				return 0, 0, status
+			}
+
+			currentRecords = append(currentRecords, record)
+		}
+
+		// File records are decoded, since they may reference tag data records in the same block. The size is an upper limit as tag data may be deduplicated.
+		files, err := decodeBlockRecordFiles(block.RecordsRaw, block.NodeID)
+		if err != nil {
+			return 0, 0, StatusCorruptBlock
+		}
+
+		for _, file := range files {
+			if status = makeSpace(file.SizeInBlock()); status != StatusOK {
+				return 0, 0, status
+			}
+
+			currentFiles = append(currentFiles, file)
+		}
+	}
+
+	if status = finalizeBlock(); status != StatusOK {
+		return 0, 0, status
+	}
+
+	// only refactor if it actually saves blocks
+	if uint64(len(blockchainNew)) >= blockchain.height {
+		return blockchain.height, blockchain.version, StatusOK
+	}
+
+	if status = blockchain.replaceBlocks(blockchainNew, blockchain.privateKey); status != StatusOK {
+		return 0, 0, status
+	}
+
+	blockchain.headerWrite(uint64(len(blockchainNew)), refactorVersion)
+
+	return blockchain.height, blockchain.version, StatusOK
+}
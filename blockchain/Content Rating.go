@@ -0,0 +1,247 @@
+/*
+File Username:  Content Rating.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Ratings and reports seen in cached blockchains are aggregated per file hash in the multi store.
+Each blockchain (rater) counts at most once per file: Only its latest rating and latest report are counted. The user's own blockchain is not part of the cache and therefore not included.
+
+Aggregated content rating:
+Offset  Size   Info
+0       8      Count of ratings
+8       8      Sum of all rating scores
+16      8      Count of reports
+
+Counted rating and report of a single rater:
+Offset  Size   Info
+0       1      Flags: Bit 0 = rating counted, Bit 1 = report counted
+1       1      Score of the counted rating
+2       8      Position of the counted rating in the rater's blockchain
+10      8      Position of the counted report in the rater's blockchain
+
+The position is the block number in the upper 40 bits and the index of the record in the block in the lower 24 bits.
+
+*/
+
+package blockchain
+
+import (
+	"encoding/binary"
+
+	"github.com/newinfoOffical/core/btcec"
+)
+
+// ContentRatingAdd rates files. Status is StatusX.
+func (blockchain *Blockchain) ContentRatingAdd(ratings []BlockRecordContentRating) (newHeight, newVersion uint64, status int) {
+	var recordsRaw []BlockRecordRaw
+
+	for _, rating := range ratings {
+		if rating.Score < RatingScoreMin || rating.Score > RatingScoreMax {
+			return 0, 0, StatusCorruptBlockRecord
+		}
+
+		record, err := encodeBlockRecordContent(RecordTypeContentRating, rating.NodeID, rating.FileID, rating.Hash, rating.Score, rating.Comment)
+		if err != nil {
+			return 0, 0, StatusCorruptBlockRecord
+		}
+
+		recordsRaw = append(recordsRaw, record)
+	}
+
+	return blockchain.Append(recordsRaw)
+}
+
+// ContentReportAdd reports files. Status is StatusX.
+func (blockchain *Blockchain) ContentReportAdd(reports []BlockRecordContentReport) (newHeight, newVersion uint64, status int) {
+	var recordsRaw []BlockRecordRaw
+
+	for _, report := range reports {
+		record, err := encodeBlockRecordContent(RecordTypeContentReport, report.NodeID, report.FileID, report.Hash, report.Reason, report.Comment)
+		if err != nil {
+			return 0, 0, StatusCorruptBlockRecord
+		}
+
+		recordsRaw = append(recordsRaw, record)
+	}
+
+	return blockchain.Append(recordsRaw)
+}
+
+// ContentRating is the aggregated rating of a file across all cached blockchains.
+type ContentRating struct {
+	CountRatings uint64 // Count of ratings
+	SumScores    uint64 // Sum of all rating scores
+	CountReports uint64 // Count of reports
+}
+
+// AverageScore returns the average rating score multiplied by 100. It returns 0 if there are no ratings.
+func (rating *ContentRating) AverageScore() uint64 {
+	if rating.CountRatings == 0 {
+		return 0
+	}
+
+	return rating.SumScores * 100 / rating.CountRatings
+}
+
+// Tags returns the aggregated rating as virtual file tags.
+func (rating *ContentRating) Tags() (tags []BlockRecordFileTag) {
+	if rating.CountRatings > 0 {
+		tags = append(tags, TagFromNumber(TagRatingScore, rating.AverageScore()))
+		tags = append(tags, TagFromNumber(TagRatingCount, rating.CountRatings))
+	}
+	if rating.CountReports > 0 {
+		tags = append(tags, TagFromNumber(TagReportCount, rating.CountReports))
+	}
+
+	return tags
+}
+
+// keyContentRatingPrefix is the key prefix for aggregated content ratings. It ensures the key length differs from blockchain headers.
+const keyContentRatingPrefix = "content rating "
+
+// ReadContentRating returns the aggregated rating of the file.
+func (multi *MultiStore) ReadContentRating(hash []byte) (rating ContentRating, found bool) {
+	raw, found := multi.Database.Get(append([]byte(keyContentRatingPrefix), hash...))
+	if !found || len(raw) != 24 {
+		return rating, false
+	}
+
+	rating.CountRatings = binary.LittleEndian.Uint64(raw[0:8])
+	rating.SumScores = binary.LittleEndian.Uint64(raw[8:16])
+	rating.CountReports = binary.LittleEndian.Uint64(raw[16:24])
+
+	return rating, true
+}
+
+// writeContentRating writes the aggregated rating of the file. If all counts are zero, it is deleted.
+func (multi *MultiStore) writeContentRating(hash []byte, rating ContentRating) {
+	key := append([]byte(keyContentRatingPrefix), hash...)
+
+	if rating.CountRatings == 0 && rating.CountReports == 0 {
+		multi.Database.Delete(key)
+		return
+	}
+
+	var raw [24]byte
+	binary.LittleEndian.PutUint64(raw[0:8], rating.CountRatings)
+	binary.LittleEndian.PutUint64(raw[8:16], rating.SumScores)
+	binary.LittleEndian.PutUint64(raw[16:24], rating.CountReports)
+
+	multi.Database.Set(key, raw[:])
+}
+
+// keyContentRaterPrefix is the key prefix for the counted rating and report of a single rater.
+const keyContentRaterPrefix = "content rater "
+
+const (
+	raterFlagRating = 1 << 0
+	raterFlagReport = 1 << 1
+)
+
+// contentRater is the rating and report of a single rater that is counted in the aggregated rating.
+type contentRater struct {
+	flags          uint8
+	score          uint8
+	positionRating uint64
+	positionReport uint64
+}
+
+func keyContentRater(hash []byte, rater *btcec.PublicKey) []byte {
+	key := append([]byte(keyContentRaterPrefix), hash...)
+	return append(key, rater.SerializeCompressed()...)
+}
+
+func (multi *MultiStore) readContentRater(hash []byte, rater *btcec.PublicKey) (entry contentRater) {
+	raw, found := multi.Database.Get(keyContentRater(hash, rater))
+	if !found || len(raw) != 18 {
+		return entry
+	}
+
+	entry.flags = raw[0]
+	entry.score = raw[1]
+	entry.positionRating = binary.LittleEndian.Uint64(raw[2:10])
+	entry.positionReport = binary.LittleEndian.Uint64(raw[10:18])
+
+	return entry
+}
+
+func (multi *MultiStore) writeContentRater(hash []byte, rater *btcec.PublicKey, entry contentRater) {
+	if entry.flags == 0 {
+		multi.Database.Delete(keyContentRater(hash, rater))
+		return
+	}
+
+	var raw [18]byte
+	raw[0] = entry.flags
+	raw[1] = entry.score
+	binary.LittleEndian.PutUint64(raw[2:10], entry.positionRating)
+	binary.LittleEndian.PutUint64(raw[10:18], entry.positionReport)
+
+	multi.Database.Set(keyContentRater(hash, rater), raw[:])
+}
+
+// aggregateContentRatings adds (or removes, if remove is set) the ratings and reports of the decoded records of the rater's block to the aggregated ratings.
+// Only the latest rating and report of each rater per file are counted. Older ones are ignored, newer ones replace the counted one.
+func (multi *MultiStore) aggregateContentRatings(rater *btcec.PublicKey, blockNumber uint64, recordsDecoded []interface{}, remove bool) {
+	multi.contentRatingMutex.Lock()
+	defer multi.contentRatingMutex.Unlock()
+
+	for n, decodedR := range recordsDecoded {
+		position := blockNumber<<24 | uint64(n)
+
+		switch record := decodedR.(type) {
+		case BlockRecordContentRating:
+			entry := multi.readContentRater(record.Hash, rater)
+			rating, _ := multi.ReadContentRating(record.Hash)
+			counted := entry.flags&raterFlagRating != 0
+
+			if remove {
+				if !counted || entry.positionRating != position {
+					continue
+				}
+				rating.CountRatings--
+				rating.SumScores -= uint64(entry.score)
+				entry.flags &^= raterFlagRating
+			} else {
+				if counted && entry.positionRating >= position {
+					continue
+				} else if counted {
+					rating.SumScores -= uint64(entry.score)
+				} else {
+					rating.CountRatings++
+				}
+				rating.SumScores += uint64(record.Score)
+				entry.flags |= raterFlagRating
+				entry.score = record.Score
+				entry.positionRating = position
+			}
+
+			multi.writeContentRating(record.Hash, rating)
+			multi.writeContentRater(record.Hash, rater, entry)
+
+		case BlockRecordContentReport:
+			entry := multi.readContentRater(record.Hash, rater)
+			rating, _ := multi.ReadContentRating(record.Hash)
+			counted := entry.flags&raterFlagReport != 0
+
+			if remove {
+				if !counted || entry.positionReport != position {
+					continue
+				}
+				rating.CountReports--
+				entry.flags &^= raterFlagReport
+			} else {
+				if counted && entry.positionReport >= position {
+					continue
+				} else if !counted {
+					rating.CountReports++
+				}
+				entry.flags |= raterFlagReport
+				entry.positionReport = position
+			}
+
+			multi.writeContentRating(record.Hash, rating)
+			multi.writeContentRater(record.Hash, rater, entry)
+		}
+	}
+}
@@ -23,9 +23,31 @@ const (
 	TagDateCreated   = 4 // Date when the file was originally created. This may differ from the date in the block record, which indicates when the file was shared.
 	TagSharedByCount = 5 // Count of peers that share the file. Virtual.
 	TagSharedByGeoIP = 6 // GeoIP data of peers that are sharing the file. CSV encoded with header "latitude,longitude". Virtual.
+	TagRatingScore   = 7 // Average rating score of the file multiplied by 100. Virtual.
+	TagRatingCount   = 8 // Count of ratings of the file. Virtual.
+	TagReportCount   = 9 // Count of reports of the file. Virtual.
 )
 
-// Future tags to be defined for audio/video: Artist, Album, Title, Length, Bitrate, Codec
+// List of media tags. They are extracted from the content of pictures, audio and video files when the file is shared, unless set by the user.
+const (
+	TagTitle    = 10 // Title of the song or video.
+	TagArtist   = 11 // Artist or author.
+	TagAlbum    = 12 // Album name.
+	TagDuration = 13 // Duration of audio or video in seconds. Number.
+	TagWidth    = 14 // Width of the picture or video in pixels. Number.
+	TagHeight   = 15 // Height of the picture or video in pixels. Number.
+	TagCamera   = 16 // Make and model of the camera that took the picture.
+	TagGPS      = 17 // GPS location where the picture was taken. Text encoded "latitude,longitude" in decimal degrees. Only extracted if enabled in the config.
+)
+
+// TagThumbnail is the blake3 hash of a small preview of the file that is stored as a separate file in the warehouse. It is a JPEG picture for pictures and audio files with cover art, or a text snippet for text files.
+const TagThumbnail = 18
+
+// TagUnavailable indicates that the sharing peer does not serve the file data, because its local copy failed the integrity check. Date when it was detected.
+// The tag is removed once the file is repaired from the network.
+const TagUnavailable = 19
+
+// Future tags to be defined for audio/video: Bitrate, Codec
 // Windows list: https://docs.microsoft.com/en-us/windows/win32/wmdm/metadata-constants
 
 // ---- encoding ----
@@ -85,7 +107,7 @@ func TagFromNumber(Type uint16, Number uint64) BlockRecordFileTag {
 // IsTagVirtual checks if the tag is a virtual one.
 func IsTagVirtual(Type uint16) bool {
 	switch Type {
-	case TagDateShared, TagSharedByCount, TagSharedByGeoIP:
+	case TagDateShared, TagSharedByCount, TagSharedByGeoIP, TagRatingScore, TagRatingCount, TagReportCount:
 		return true
 	default:
 		return false
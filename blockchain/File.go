@@ -14,11 +14,50 @@ import (
 	"github.com/google/uuid"
 )
 
+// encodeFiles encodes public and private files into records. Files with recipients are encoded as private file records.
+func (blockchain *Blockchain) encodeFiles(files []BlockRecordFile) (recordsRaw []BlockRecordRaw, err error) {
+	var filesPublic, filesPrivate []BlockRecordFile
+
+	for n := range files {
+		if len(files[n].Recipients) > 0 {
+			filesPrivate = append(filesPrivate, files[n])
+		} else {
+			filesPublic = append(filesPublic, files[n])
+		}
+	}
+
+	if recordsRaw, err = encodeBlockRecordFiles(filesPublic); err != nil {
+		return nil, err
+	}
+
+	recordsPrivate, err := encodeBlockRecordPrivateFiles(filesPrivate, blockchain.privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(recordsRaw, recordsPrivate...), nil
+}
+
+// decodeFiles decodes public and private files from the block. Private files are decrypted with the owner's private key.
+func (blockchain *Blockchain) decodeFiles(block *Block) (files []BlockRecordFile, err error) {
+	if files, err = decodeBlockRecordFiles(block.RecordsRaw, block.NodeID); err != nil {
+		return nil, err
+	}
+
+	filesPrivate, err := DecodeBlockRecordPrivateFiles(block.RecordsRaw, blockchain.publicKey, block.NodeID, blockchain.privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(files, filesPrivate...), nil
+}
+
 // AddFiles adds files to the blockchain. Status is StatusX.
+// Files with recipients are private; they are encrypted and only readable by the recipients.
 // It makes sense to group all files in the same directory into one call, since only one directory record will be created per unique directory per block.
 func (blockchain *Blockchain) AddFiles(files []BlockRecordFile) (newHeight, newVersion uint64, status int) {
 	encodeFilesAppend := func(files []BlockRecordFile) (newHeight, newVersion uint64, status int) {
-		encoded, err := encodeBlockRecordFiles(files)
+		encoded, err := blockchain.encodeFiles(files)
 		if err != nil {
 			return 0, 0, StatusCorruptBlockRecord
 		}
@@ -53,7 +92,7 @@ func (blockchain *Blockchain) AddFiles(files []BlockRecordFile) (newHeight, newV
 // If there is a corruption in the blockchain it will stop reading but return the files parsed so far.
 func (blockchain *Blockchain) ListFiles() (files []BlockRecordFile, status int) {
 	status = blockchain.Iterate(func(block *Block) (statusI int) {
-		filesMore, err := decodeBlockRecordFiles(block.RecordsRaw, block.NodeID)
+		filesMore, err := blockchain.decodeFiles(block)
 		if err != nil {
 			return StatusCorruptBlockRecord
 		}
@@ -69,7 +108,7 @@ func (blockchain *Blockchain) ListFiles() (files []BlockRecordFile, status int)
 // If there is a corruption in the blockchain it will stop reading but return the files found so far.
 func (blockchain *Blockchain) FileExists(hash []byte) (files []BlockRecordFile, status int) {
 	status = blockchain.Iterate(func(block *Block) (statusI int) {
-		filesD, err := decodeBlockRecordFiles(block.RecordsRaw, block.NodeID)
+		filesD, err := blockchain.decodeFiles(block)
 		if err != nil {
 			return StatusCorruptBlockRecord
 		}
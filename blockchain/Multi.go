@@ -8,6 +8,10 @@ Multi-blockchain store implementation.
 Keys used in the key-value store:
 1. Key: Public key compressed, Value: Header
 2. Key: Public key compressed + version + block number, Value: Block
+3. Key: keyMigrationPrefix + old public key compressed, Value: New public key compressed, or empty if disputed
+4. Key: keyContentRatingPrefix + file hash, Value: Aggregated content rating
+5. Key: keySubscriptionPrefix + node ID, Value: Subscription
+6. Key: keyContentRaterPrefix + file hash + rater public key compressed, Value: Counted rating and report of the rater
 
 */
 
@@ -17,6 +21,7 @@ import (
     "encoding/binary"
     "errors"
     "fmt"
+    "sync"
     "time"
 
     "github.com/newinfoOffical/core/btcec"
@@ -41,6 +46,8 @@ type MultiStore struct {
     // callbacks
     FilterStatisticUpdate  func(multi *MultiStore, header *MultiBlockchainHeader, statsOld BlockchainStats)
     FilterBlockchainDelete func(multi *MultiStore, header *MultiBlockchainHeader)
+
+    contentRatingMutex sync.Mutex // Serializes updates of aggregated content ratings.
 }
 
 func InitMultiStore(path string) (multi *MultiStore, err error) {
@@ -65,13 +72,17 @@ Offset  Size   Info
 32      8      Date last block added
 40      8      Stats: Count of file records  (from available blocks)
 48      8      Stats: Size of all files combined (from available blocks)
-56      8 * n  List of block numbers that are stored
+56      8      Date last accessed by the user
+64      8      Date the owner was last seen online
+72      8 * n  List of block numbers that are stored
 
 Note: The statistics fields only count available stored blocks.
+Headers written by older versions do not have the last accessed and last seen fields. The list of block numbers starts at offset 56.
 
 */
 
-const multiBlockchainHeaderSize = 56
+const multiBlockchainHeaderSize = 72
+const multiBlockchainHeaderSizeV0 = 56
 
 // This is a header for a single blockchain stored in a multi store.
 type MultiBlockchainHeader struct {
@@ -80,6 +91,8 @@ type MultiBlockchainHeader struct {
     Version             uint64           // Version is always uint64.
     DateFirstBlockAdded time.Time        // Date the first block was added
     DateLastBlockAdded  time.Time        // Date the last block was added
+    DateLastAccess      time.Time        // Date the blockchain was last accessed by the user
+    DateLastSeen        time.Time        // Date the owner of the blockchain was last seen online
     ListBlocks          []uint64         // List of block numbers that are stored
     Stats               BlockchainStats  // Statistics about the blockchain (only about stored blocks)
 }
@@ -91,7 +104,7 @@ type BlockchainStats struct {
 }
 
 func decodeBlockchainHeader(publicKey *btcec.PublicKey, buffer []byte) (header *MultiBlockchainHeader, err error) {
-    if len(buffer) < multiBlockchainHeaderSize {
+    if len(buffer) < multiBlockchainHeaderSizeV0 {
         return nil, errors.New("header length too small")
     }
 
@@ -104,12 +117,16 @@ func decodeBlockchainHeader(publicKey *btcec.PublicKey, buffer []byte) (header *
     header.Stats.CountFileRecords = binary.LittleEndian.Uint64(buffer[40:48])
     header.Stats.SizeAllFiles = binary.LittleEndian.Uint64(buffer[48:56])
 
-    if uint64(len(buffer)) < multiBlockchainHeaderSize+8*countBlocks {
+    index := multiBlockchainHeaderSizeV0
+
+    if uint64(len(buffer)) == multiBlockchainHeaderSize+8*countBlocks {
+        header.DateLastAccess = time.Unix(int64(binary.LittleEndian.Uint64(buffer[56:64])), 0)
+        header.DateLastSeen = time.Unix(int64(binary.LittleEndian.Uint64(buffer[64:72])), 0)
+        index = multiBlockchainHeaderSize
+    } else if uint64(len(buffer)) < multiBlockchainHeaderSizeV0+8*countBlocks {
         return nil, errors.New("header length too small")
     }
 
-    index := multiBlockchainHeaderSize
-
     for n := uint64(0); n < countBlocks; n++ {
         blockN := binary.LittleEndian.Uint64(buffer[index : index+8])
         header.ListBlocks = append(header.ListBlocks, blockN)
@@ -141,6 +158,8 @@ func (multi *MultiStore) WriteBlockchainHeader(header *MultiBlockchainHeader) (e
     binary.LittleEndian.PutUint64(raw[32:40], uint64(header.DateLastBlockAdded.UTC().Unix()))
     binary.LittleEndian.PutUint64(raw[40:48], header.Stats.CountFileRecords)
     binary.LittleEndian.PutUint64(raw[48:56], header.Stats.SizeAllFiles)
+    binary.LittleEndian.PutUint64(raw[56:64], uint64(header.DateLastAccess.UTC().Unix()))
+    binary.LittleEndian.PutUint64(raw[64:72], uint64(header.DateLastSeen.UTC().Unix()))
 
     index := multiBlockchainHeaderSize
 
@@ -204,6 +223,13 @@ func (multi *MultiStore) AssessBlockchainHeader(publicKey *btcec.PublicKey, vers
 func (multi *MultiStore) DeleteBlockchain(header *MultiBlockchainHeader) {
     // first delete all blocks
     for _, blockN := range header.ListBlocks {
+        // remove the ratings and reports of the block from the aggregated content ratings
+        if raw, found := multi.ReadBlock(header.PublicKey, header.Version, blockN); found {
+            if decoded, status, _ := DecodeBlockRaw(raw); status == StatusOK {
+                multi.aggregateContentRatings(header.PublicKey, blockN, decoded.RecordsDecoded, true)
+            }
+        }
+
         multi.Database.Delete(lookupKeyForBlock(header.PublicKey, header.Version, blockN))
     }
 
@@ -223,6 +249,7 @@ func (multi *MultiStore) NewBlockchainHeader(publicKey *btcec.PublicKey, version
         Version:             version,
         DateFirstBlockAdded: timeN,
         DateLastBlockAdded:  timeN,
+        DateLastSeen:        timeN,
     }
 
     return header, multi.WriteBlockchainHeader(header)
@@ -288,6 +315,7 @@ func (multi *MultiStore) IngestBlock(header *MultiBlockchainHeader, blockNumber
     // update blockchain header stats if records were decoded
     if status == StatusOK {
         multi.UpdateBlockchainStatistics(header, decoded.RecordsDecoded)
+        multi.aggregateContentRatings(header.PublicKey, blockNumber, decoded.RecordsDecoded, false)
     }
 
     // update the blockchain header
@@ -295,3 +323,71 @@ func (multi *MultiStore) IngestBlock(header *MultiBlockchainHeader, blockNumber
 
     return decoded, nil
 }
+
+// keyMigrationPrefix is the key prefix for key migrations. It ensures the key length differs from the other keys.
+const keyMigrationPrefix = "key migration "
+
+// WriteKeyMigration stores that the blockchain of the old public key was migrated to a new public key. If the new public key is nil, the migration is stored as disputed.
+func (multi *MultiStore) WriteKeyMigration(oldPublicKey, newPublicKey *btcec.PublicKey) (err error) {
+    var value []byte
+    if newPublicKey != nil {
+        value = newPublicKey.SerializeCompressed()
+    }
+    return multi.Database.Set(append([]byte(keyMigrationPrefix), oldPublicKey.SerializeCompressed()...), value)
+}
+
+// ReadKeyMigration returns the new public key if the blockchain of the old public key was migrated. If the migration is disputed, the new public key is nil.
+func (multi *MultiStore) ReadKeyMigration(oldPublicKey *btcec.PublicKey) (newPublicKey *btcec.PublicKey, found bool) {
+    raw, found := multi.Database.Get(append([]byte(keyMigrationPrefix), oldPublicKey.SerializeCompressed()...))
+    if !found {
+        return nil, false
+    } else if len(raw) == 0 {
+        return nil, true
+    }
+
+    newPublicKey, err := btcec.ParsePubKey(raw, btcec.S256())
+    return newPublicKey, err == nil
+}
+
+// ApplyKeyMigrations stores the key migrations found in the blockchain of the owner. It returns the old public keys that are now considered migrated or disputed.
+// A migration is only accepted if it leads to the owner, either directly or via a chain of migrations (A -> B -> C).
+// Since an old key may be compromised, its signature alone is not trusted to change an existing migration. A different successor is only accepted if the
+// existing successor itself handed over to the owner. Otherwise the migration is stored as disputed and no successor is followed.
+func (multi *MultiStore) ApplyKeyMigrations(owner *btcec.PublicKey, migrations []BlockRecordKeyMigration) (oldKeys []*btcec.PublicKey) {
+    // keys that lead to the owner, including the owner
+    chain := map[string]struct{}{string(owner.SerializeCompressed()): {}}
+
+    for {
+        added := false
+        for _, migration := range migrations {
+            _, newInChain := chain[string(migration.NewPublicKey.SerializeCompressed())]
+            _, oldInChain := chain[string(migration.OldPublicKey.SerializeCompressed())]
+            if newInChain && !oldInChain {
+                chain[string(migration.OldPublicKey.SerializeCompressed())] = struct{}{}
+                oldKeys = append(oldKeys, migration.OldPublicKey)
+                added = true
+            }
+        }
+        if !added {
+            break
+        }
+    }
+
+    for _, oldKey := range oldKeys {
+        existing, found := multi.ReadKeyMigration(oldKey)
+        switch {
+        case !found:
+            multi.WriteKeyMigration(oldKey, owner)
+        case existing == nil || existing.IsEqual(owner):
+            // already disputed or known
+        default:
+            if _, handedOver := chain[string(existing.SerializeCompressed())]; handedOver {
+                multi.WriteKeyMigration(oldKey, owner)
+            } else {
+                multi.WriteKeyMigration(oldKey, nil)
+            }
+        }
+    }
+
+    return oldKeys
+}
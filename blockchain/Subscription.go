@@ -0,0 +1,150 @@
+/*
+File Username:  Subscription.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Subscriptions are the list of users (by node ID) that the local user follows. They are stored in the multi store of the global blockchain cache.
+Subscribed blockchains are never evicted from the cache. When a new block or version arrives, the content is compared to compute a diff.
+
+Encoding of a subscription (value):
+Offset  Size   Info
+0       8      Date subscribed
+
+*/
+
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/newinfoOffical/core/protocol"
+)
+
+const keySubscriptionPrefix = "subscription "
+
+// Subscription is a followed user
+type Subscription struct {
+	NodeID         []byte    // Node ID of the followed user
+	DateSubscribed time.Time // Date subscribed
+}
+
+// WriteSubscription subscribes to the blockchain of the node ID. If already subscribed, the date is not changed.
+func (multi *MultiStore) WriteSubscription(nodeID []byte) (err error) {
+	if len(nodeID) != protocol.HashSize {
+		return errors.New("invalid node ID")
+	} else if multi.IsSubscribed(nodeID) {
+		return nil
+	}
+
+	var value [8]byte
+	binary.LittleEndian.PutUint64(value[:], uint64(time.Now().UTC().Unix()))
+
+	return multi.Database.Set(append([]byte(keySubscriptionPrefix), nodeID...), value[:])
+}
+
+// DeleteSubscription unsubscribes from the blockchain of the node ID.
+func (multi *MultiStore) DeleteSubscription(nodeID []byte) {
+	multi.Database.Delete(append([]byte(keySubscriptionPrefix), nodeID...))
+}
+
+// IsSubscribed checks if the node ID is subscribed to.
+func (multi *MultiStore) IsSubscribed(nodeID []byte) bool {
+	_, found := multi.Database.Get(append([]byte(keySubscriptionPrefix), nodeID...))
+	return found
+}
+
+// ListSubscriptions returns all subscriptions.
+func (multi *MultiStore) ListSubscriptions() (subscriptions []Subscription) {
+	multi.Database.Iterate(func(key, value []byte) {
+		if len(key) != len(keySubscriptionPrefix)+protocol.HashSize || !bytes.HasPrefix(key, []byte(keySubscriptionPrefix)) || len(value) < 8 {
+			return
+		}
+
+		nodeID := make([]byte, protocol.HashSize)
+		copy(nodeID, key[len(keySubscriptionPrefix):])
+
+		subscriptions = append(subscriptions, Subscription{NodeID: nodeID, DateSubscribed: time.Unix(int64(binary.LittleEndian.Uint64(value[0:8])), 0)})
+	})
+
+	return subscriptions
+}
+
+// BlockchainContent is the content of the stored blocks of a blockchain, used to compute a diff.
+type BlockchainContent struct {
+	Files   map[uuid.UUID]BlockRecordFile // Files by ID
+	Profile map[uint16][]byte             // Profile fields by type. Later fields overwrite earlier ones.
+}
+
+// ReadBlockchainContent reads the files and profile fields of all stored blocks of the blockchain.
+func (multi *MultiStore) ReadBlockchainContent(header *MultiBlockchainHeader) (content *BlockchainContent) {
+	content = &BlockchainContent{Files: make(map[uuid.UUID]BlockRecordFile), Profile: make(map[uint16][]byte)}
+
+	blockNumbers := append([]uint64{}, header.ListBlocks...)
+	sort.Slice(blockNumbers, func(i, j int) bool { return blockNumbers[i] < blockNumbers[j] })
+
+	for _, blockN := range blockNumbers {
+		raw, found := multi.ReadBlock(header.PublicKey, header.Version, blockN)
+		if !found {
+			continue
+		}
+
+		decoded, status, _ := DecodeBlockRaw(raw)
+		if status != StatusOK {
+			continue
+		}
+
+		for _, decodedR := range decoded.RecordsDecoded {
+			switch record := decodedR.(type) {
+			case BlockRecordFile:
+				content.Files[record.ID] = record
+			case []BlockRecordProfile:
+				for _, field := range record {
+					content.Profile[field.Type] = field.Data
+				}
+			}
+		}
+	}
+
+	return content
+}
+
+// BlockchainDiff contains the changes between two versions of the content of a blockchain
+type BlockchainDiff struct {
+	FilesAdded     []BlockRecordFile    // New files and files whose data changed
+	FilesRemoved   []BlockRecordFile    // Files no longer shared
+	ProfileChanged []BlockRecordProfile // New or changed profile fields
+}
+
+// DiffBlockchainContent returns the changes from the old to the new content.
+// Deleted profile fields are not reported, since the blockchain only stores a partial set of blocks.
+func DiffBlockchainContent(old, new *BlockchainContent) (diff BlockchainDiff) {
+	for id, file := range new.Files {
+		if fileOld, ok := old.Files[id]; !ok || !bytes.Equal(fileOld.Hash, file.Hash) {
+			diff.FilesAdded = append(diff.FilesAdded, file)
+		}
+	}
+
+	for id, file := range old.Files {
+		if _, ok := new.Files[id]; !ok {
+			diff.FilesRemoved = append(diff.FilesRemoved, file)
+		}
+	}
+
+	for fieldType, data := range new.Profile {
+		if dataOld, ok := old.Profile[fieldType]; !ok || !bytes.Equal(dataOld, data) {
+			diff.ProfileChanged = append(diff.ProfileChanged, BlockRecordProfile{Type: fieldType, Data: data})
+		}
+	}
+
+	return diff
+}
+
+// IsEmpty checks if the diff contains no changes.
+func (diff *BlockchainDiff) IsEmpty() bool {
+	return len(diff.FilesAdded) == 0 && len(diff.FilesRemoved) == 0 && len(diff.ProfileChanged) == 0
+}
@@ -2,9 +2,12 @@ package blockchain
 
 import (
     "bytes"
+    "encoding/binary"
     "encoding/hex"
     "fmt"
+    "path/filepath"
     "testing"
+    "time"
 
     "github.com/newinfoOffical/core/btcec"
     "github.com/newinfoOffical/core/merkle"
@@ -94,6 +97,18 @@ func initTestPrivateKey() (blockchain *Blockchain, err error) {
     return Init(peerPrivateKey, "test.blockchain")
 }
 
+// initTestBlockchain creates a new blockchain with a random key in a temporary folder.
+func initTestBlockchain(t *testing.T) (blockchain *Blockchain) {
+    privateKey, _ := btcec.NewPrivateKey(btcec.S256())
+
+    blockchain, err := Init(privateKey, filepath.Join(t.TempDir(), "test.blockchain"))
+    if err != nil {
+        t.Fatalf("Error opening blockchain: %s\n", err.Error())
+    }
+
+    return blockchain
+}
+
 func TestBlockchainAdd(t *testing.T) {
     blockchain, err := initTestPrivateKey()
     if err != nil {
@@ -262,3 +277,503 @@ func printProfileField(field BlockRecordProfile) {
 
 const testTypeText = 1
 const testFormatText = 10
+
+func TestBlockKeyMigration(t *testing.T) {
+    privateKeyOld, _ := btcec.NewPrivateKey(btcec.S256())
+    privateKeyNew, _ := btcec.NewPrivateKey(btcec.S256())
+
+    record, err := encodeBlockRecordKeyMigration(privateKeyOld, privateKeyNew.PubKey())
+    if err != nil {
+        t.Fatalf("Error encoding key migration: %s\n", err.Error())
+    }
+
+    raw, err := encodeBlock(&Block{RecordsRaw: []BlockRecordRaw{record}}, privateKeyNew)
+    if err != nil {
+        t.Fatalf("Error encoding block: %s\n", err.Error())
+    }
+
+    decoded, _, err := DecodeBlockRaw(raw)
+    if err != nil {
+        t.Fatalf("Error decoding block: %s\n", err.Error())
+    }
+
+    if len(decoded.RecordsDecoded) != 1 {
+        t.Fatalf("Expected 1 decoded record, got %d\n", len(decoded.RecordsDecoded))
+    }
+
+    migration, ok := decoded.RecordsDecoded[0].(BlockRecordKeyMigration)
+    if !ok || !migration.OldPublicKey.IsEqual(privateKeyOld.PubKey()) || !migration.NewPublicKey.IsEqual(decoded.OwnerPublicKey) {
+        t.Fatalf("Key migration record mismatch\n")
+    }
+
+    // tampering with the new key must invalidate the signature of the old key
+    record.Data[40] ^= 0xFF
+    if _, err := decodeBlockRecordKeyMigration([]BlockRecordRaw{record}); err == nil {
+        t.Fatalf("Tampered key migration record was accepted\n")
+    }
+}
+
+func TestKeyMigrationChain(t *testing.T) {
+    privateKeyA, _ := btcec.NewPrivateKey(btcec.S256())
+    privateKeyB, _ := btcec.NewPrivateKey(btcec.S256())
+    privateKeyC, _ := btcec.NewPrivateKey(btcec.S256())
+    privateKeyX, _ := btcec.NewPrivateKey(btcec.S256())
+
+    blockchain, err := Init(privateKeyA, filepath.Join(t.TempDir(), "chain"))
+    if err != nil {
+        t.Fatalf("Error opening blockchain: %s\n", err.Error())
+    }
+
+    // migrate A -> B -> C, the migration A -> B must be kept
+    if _, _, status := blockchain.MigrateKey(privateKeyB); status != StatusOK {
+        t.Fatalf("Error migrating key: status %d\n", status)
+    } else if _, _, status := blockchain.MigrateKey(privateKeyC); status != StatusOK {
+        t.Fatalf("Error migrating key: status %d\n", status)
+    }
+
+    raw, _ := blockchain.database.Get(blockNumberToKey(0))
+    decoded, _, err := DecodeBlockRaw(raw)
+    if err != nil {
+        t.Fatalf("Error decoding block: %s\n", err.Error())
+    }
+
+    var migrations []BlockRecordKeyMigration
+    for _, record := range decoded.RecordsDecoded {
+        if migration, ok := record.(BlockRecordKeyMigration); ok {
+            migrations = append(migrations, migration)
+        }
+    }
+    if len(migrations) != 2 {
+        t.Fatalf("Expected 2 key migration records, got %d\n", len(migrations))
+    }
+
+    multi, err := InitMultiStore(filepath.Join(t.TempDir(), "multi"))
+    if err != nil {
+        t.Fatalf("Error opening multi store: %s\n", err.Error())
+    }
+
+    // a peer that has seen B before follows the chain to C
+    multi.ApplyKeyMigrations(privateKeyB.PubKey(), migrations[1:])
+    if newPublicKey, found := multi.ReadKeyMigration(privateKeyA.PubKey()); !found || !newPublicKey.IsEqual(privateKeyB.PubKey()) {
+        t.Fatalf("Migration A -> B not stored\n")
+    }
+
+    if oldKeys := multi.ApplyKeyMigrations(privateKeyC.PubKey(), migrations); len(oldKeys) != 2 {
+        t.Fatalf("Expected 2 migrated keys, got %d\n", len(oldKeys))
+    }
+    for _, oldKey := range []*btcec.PublicKey{privateKeyA.PubKey(), privateKeyB.PubKey()} {
+        if newPublicKey, found := multi.ReadKeyMigration(oldKey); !found || !newPublicKey.IsEqual(privateKeyC.PubKey()) {
+            t.Fatalf("Migration to C not stored\n")
+        }
+    }
+
+    // the compromised key A alone cannot redirect the migration
+    recordX, _ := encodeBlockRecordKeyMigration(privateKeyA, privateKeyX.PubKey())
+    migrationsX, _ := decodeBlockRecordKeyMigration([]BlockRecordRaw{recordX})
+    multi.ApplyKeyMigrations(privateKeyX.PubKey(), migrationsX)
+
+    if newPublicKey, found := multi.ReadKeyMigration(privateKeyA.PubKey()); !found || newPublicKey != nil {
+        t.Fatalf("Conflicting migration not stored as disputed\n")
+    } else if newPublicKey, found := multi.ReadKeyMigration(privateKeyB.PubKey()); !found || !newPublicKey.IsEqual(privateKeyC.PubKey()) {
+        t.Fatalf("Unrelated migration B -> C changed\n")
+    }
+}
+
+func TestBlockCertificate(t *testing.T) {
+    privateKeyIssuer, _ := btcec.NewPrivateKey(btcec.S256())
+    privateKeyOwner, _ := btcec.NewPrivateKey(btcec.S256())
+    privateKeyOther, _ := btcec.NewPrivateKey(btcec.S256())
+
+    field := ProfileFieldFromText(ProfileEmail, "test@test.com")
+
+    data, err := IssueCertificate(privateKeyIssuer, privateKeyOwner.PubKey(), CertificateTypeProfile, field.Type, protocol.HashData(field.Data), time.Time{})
+    if err != nil {
+        t.Fatalf("Error issuing certificate: %s\n", err.Error())
+    }
+
+    raw, err := encodeBlock(&Block{RecordsRaw: []BlockRecordRaw{{Type: RecordTypeCertificate, Data: data}}}, privateKeyOwner)
+    if err != nil {
+        t.Fatalf("Error encoding block: %s\n", err.Error())
+    }
+
+    decoded, _, err := DecodeBlockRaw(raw)
+    if err != nil {
+        t.Fatalf("Error decoding block: %s\n", err.Error())
+    }
+
+    certificate, ok := decoded.RecordsDecoded[0].(BlockRecordCertificate)
+    if !ok || !certificate.Issuer.IsEqual(privateKeyIssuer.PubKey()) || !certificate.CertifiesProfileField(&field) || certificate.IsExpired() {
+        t.Fatalf("Certificate record mismatch\n")
+    }
+
+    // the same certificate must be rejected in another user's blockchain
+    raw, _ = encodeBlock(&Block{RecordsRaw: []BlockRecordRaw{{Type: RecordTypeCertificate, Data: data}}}, privateKeyOther)
+    if _, _, err := DecodeBlockRaw(raw); err == nil {
+        t.Fatalf("Certificate for another subject was accepted\n")
+    }
+}
+
+func TestBlockPrivateFile(t *testing.T) {
+    privateKeyOwner, _ := btcec.NewPrivateKey(btcec.S256())
+    privateKeyRecipient, _ := btcec.NewPrivateKey(btcec.S256())
+    privateKeyOther, _ := btcec.NewPrivateKey(btcec.S256())
+
+    file, _ := createBlockRecordFile([]byte("Private data"), "Private.txt", "documents")
+    file.Tags = append(file.Tags, TagFromText(TagDescription, "documents"))
+    file.Recipients = []*btcec.PublicKey{privateKeyRecipient.PubKey()}
+
+    recordsRaw, err := encodeBlockRecordPrivateFiles([]BlockRecordFile{file}, privateKeyOwner)
+    if err != nil {
+        t.Fatalf("Error encoding private file: %s\n", err.Error())
+    }
+
+    // the file must not be readable without a key
+    if files, _ := decodeBlockRecordFiles(recordsRaw, nil); len(files) != 0 {
+        t.Fatalf("Private file decoded as public file\n")
+    }
+
+    for _, privateKey := range []*btcec.PrivateKey{privateKeyOwner, privateKeyRecipient} {
+        files, err := DecodeBlockRecordPrivateFiles(recordsRaw, privateKeyOwner.PubKey(), nil, privateKey)
+        if err != nil {
+            t.Fatalf("Error decoding private file: %s\n", err.Error())
+        } else if len(files) != 1 || files[0].ID != file.ID || !bytes.Equal(files[0].Hash, file.Hash) || files[0].GetTag(TagDescription) == nil {
+            t.Fatalf("Private file mismatch\n")
+        } else if len(files[0].Recipients) != 1 || !files[0].Recipients[0].IsEqual(privateKeyRecipient.PubKey()) {
+            t.Fatalf("Private file recipients mismatch\n")
+        }
+    }
+
+    if files, err := DecodeBlockRecordPrivateFiles(recordsRaw, privateKeyOwner.PubKey(), nil, privateKeyOther); err != nil || len(files) != 0 {
+        t.Fatalf("Private file decoded by non-recipient\n")
+    }
+}
+
+func TestMigrateKeyPrivateFile(t *testing.T) {
+    privateKeyOld, _ := btcec.NewPrivateKey(btcec.S256())
+    privateKeyNew, _ := btcec.NewPrivateKey(btcec.S256())
+    privateKeyRecipient, _ := btcec.NewPrivateKey(btcec.S256())
+
+    blockchain, err := Init(privateKeyOld, filepath.Join(t.TempDir(), "chain"))
+    if err != nil {
+        t.Fatalf("Error opening blockchain: %s\n", err.Error())
+    }
+
+    file, _ := createBlockRecordFile([]byte("Private data"), "Private.txt", "documents")
+    file.Recipients = []*btcec.PublicKey{privateKeyRecipient.PubKey()}
+
+    if _, _, status := blockchain.AddFiles([]BlockRecordFile{file}); status != StatusOK {
+        t.Fatalf("Error adding file: status %d\n", status)
+    } else if _, _, status := blockchain.MigrateKey(privateKeyNew); status != StatusOK {
+        t.Fatalf("Error migrating key: status %d\n", status)
+    }
+
+    // the owner and the recipient must be able to decrypt the file with the new key
+    files, status := blockchain.ListFiles()
+    if status != StatusOK || len(files) != 1 || files[0].ID != file.ID || len(files[0].Recipients) != 1 {
+        t.Fatalf("Private file not readable by the owner after migration\n")
+    }
+
+    var recordsRaw []BlockRecordRaw
+    for blockN := uint64(0); blockN < blockchain.height; blockN++ {
+        raw, _ := blockchain.database.Get(blockNumberToKey(blockN))
+        block, err := decodeBlock(raw)
+        if err != nil {
+            t.Fatalf("Error decoding block: %s\n", err.Error())
+        }
+        recordsRaw = append(recordsRaw, block.RecordsRaw...)
+    }
+
+    // an invalid private file record must not hide the valid one
+    recordsRaw = append([]BlockRecordRaw{{Type: RecordTypePrivateFile, Data: make([]byte, blockRecordPrivateFileSizeOverhead)}}, recordsRaw...)
+
+    if files, err := DecodeBlockRecordPrivateFiles(recordsRaw, privateKeyNew.PubKey(), nil, privateKeyRecipient); err != nil || len(files) != 1 || files[0].ID != file.ID {
+        t.Fatalf("Private file not readable by the recipient after migration\n")
+    }
+}
+
+func TestBlockContentRating(t *testing.T) {
+    privateKey, _ := btcec.NewPrivateKey(btcec.S256())
+
+    nodeID := protocol.HashData([]byte("node"))
+    hash := protocol.HashData([]byte("file"))
+    fileID := uuid.New()
+
+    rating, err := encodeBlockRecordContent(RecordTypeContentRating, nodeID, fileID, hash, 4, "good")
+    if err != nil {
+        t.Fatalf("Error encoding rating: %s\n", err.Error())
+    }
+    report, _ := encodeBlockRecordContent(RecordTypeContentReport, nodeID, fileID, hash, ReportReasonSpam, "")
+
+    raw, err := encodeBlock(&Block{RecordsRaw: []BlockRecordRaw{rating, report}}, privateKey)
+    if err != nil {
+        t.Fatalf("Error encoding block: %s\n", err.Error())
+    }
+
+    decoded, _, err := DecodeBlockRaw(raw)
+    if err != nil {
+        t.Fatalf("Error decoding block: %s\n", err.Error())
+    }
+
+    var aggregate ContentRating
+
+    for _, decodedR := range decoded.RecordsDecoded {
+        switch record := decodedR.(type) {
+        case BlockRecordContentRating:
+            if record.FileID != fileID || !bytes.Equal(record.Hash, hash) || !bytes.Equal(record.NodeID, nodeID) || record.Comment != "good" {
+                t.Fatalf("Rating record mismatch\n")
+            }
+            aggregate.CountRatings++
+            aggregate.SumScores += uint64(record.Score)
+
+        case BlockRecordContentReport:
+            if record.Reason != ReportReasonSpam || record.Comment != "" {
+                t.Fatalf("Report record mismatch\n")
+            }
+            aggregate.CountReports++
+        }
+    }
+
+    if tags := aggregate.Tags(); len(tags) != 3 || tags[0].Number() != 400 {
+        t.Fatalf("Aggregated rating tags mismatch\n")
+    }
+
+    // a score out of range must be rejected
+    rating.Data[80] = RatingScoreMax + 1
+    raw, _ = encodeBlock(&Block{RecordsRaw: []BlockRecordRaw{rating}}, privateKey)
+    if _, _, err := DecodeBlockRaw(raw); err == nil {
+        t.Fatalf("Rating with invalid score was accepted\n")
+    }
+}
+
+func TestContentRatingAggregate(t *testing.T) {
+    privateKeyRater, _ := btcec.NewPrivateKey(btcec.S256())
+    privateKeyOther, _ := btcec.NewPrivateKey(btcec.S256())
+
+    multi, err := InitMultiStore(filepath.Join(t.TempDir(), "multi"))
+    if err != nil {
+        t.Fatalf("Error opening multi store: %s\n", err.Error())
+    }
+
+    nodeID := protocol.HashData([]byte("node"))
+    hash := protocol.HashData([]byte("file"))
+    fileID := uuid.New()
+
+    encodeRecords := func(privateKey *btcec.PrivateKey, scores []uint8, reports int) []byte {
+        var recordsRaw []BlockRecordRaw
+        for _, score := range scores {
+            rating, _ := encodeBlockRecordContent(RecordTypeContentRating, nodeID, fileID, hash, score, "")
+            recordsRaw = append(recordsRaw, rating)
+        }
+        for n := 0; n < reports; n++ {
+            report, _ := encodeBlockRecordContent(RecordTypeContentReport, nodeID, fileID, hash, ReportReasonSpam, "")
+            recordsRaw = append(recordsRaw, report)
+        }
+        raw, _ := encodeBlock(&Block{RecordsRaw: recordsRaw}, privateKey)
+        return raw
+    }
+
+    headerRater, _ := multi.NewBlockchainHeader(privateKeyRater.PubKey(), 0, 3)
+    headerOther, _ := multi.NewBlockchainHeader(privateKeyOther.PubKey(), 0, 1)
+
+    tests := []struct {
+        header       *MultiBlockchainHeader
+        blockNumber  uint64
+        raw          []byte
+        countRatings uint64
+        sumScores    uint64
+        countReports uint64
+    }{
+        {headerRater, 1, encodeRecords(privateKeyRater, []uint8{1, 2, 3}, 3), 1, 3, 1}, // repeated records count once, the latest one wins
+        {headerRater, 2, encodeRecords(privateKeyRater, []uint8{5}, 1), 1, 5, 1},       // a newer block replaces the rating
+        {headerRater, 0, encodeRecords(privateKeyRater, []uint8{1}, 1), 1, 5, 1},       // an older block is ignored
+        {headerOther, 0, encodeRecords(privateKeyOther, []uint8{2}, 0), 2, 7, 1},       // another rater counts separately
+    }
+
+    for n, test := range tests {
+        if _, err := multi.IngestBlock(test.header, test.blockNumber, test.raw, true); err != nil {
+            t.Fatalf("Test %d: error ingesting block: %s\n", n, err.Error())
+        }
+
+        rating, _ := multi.ReadContentRating(hash)
+        if rating.CountRatings != test.countRatings || rating.SumScores != test.sumScores || rating.CountReports != test.countReports {
+            t.Fatalf("Test %d: aggregated rating mismatch: %+v\n", n, rating)
+        }
+    }
+
+    multi.DeleteBlockchain(headerRater)
+    if rating, _ := multi.ReadContentRating(hash); rating.CountRatings != 1 || rating.SumScores != 2 || rating.CountReports != 0 {
+        t.Fatalf("Aggregated rating mismatch after deleting blockchain: %+v\n", rating)
+    }
+
+    multi.DeleteBlockchain(headerOther)
+    if _, found := multi.ReadContentRating(hash); found {
+        t.Fatalf("Aggregated rating not deleted\n")
+    }
+}
+
+func TestBlockchainCompact(t *testing.T) {
+    blockchain := initTestBlockchain(t)
+
+    // add files one by one, creating a new block for each
+    for n := 0; n < 3; n++ {
+        file, _ := createBlockRecordFile([]byte(fmt.Sprintf("Test data %d", n)), fmt.Sprintf("Compact %d.txt", n), "documents\\compact")
+        file.Tags = append(file.Tags, TagFromDate(TagDateShared, time.Date(2020, 1, n+1, 0, 0, 0, 0, time.UTC)))
+
+        if _, _, status := blockchain.AddFiles([]BlockRecordFile{file}); status != StatusOK {
+            t.Fatalf("Error adding file: status %d\n", status)
+        }
+    }
+
+    filesBefore, _ := blockchain.ListFiles()
+    _, heightBefore, versionBefore := blockchain.Header()
+
+    if fragmentation, status := blockchain.Fragmentation(); status != StatusOK || fragmentation <= 0 {
+        t.Fatalf("Fragmentation not detected: status %d fragmentation %f\n", status, fragmentation)
+    }
+
+    newHeight, newVersion, status := blockchain.Compact()
+    if status != StatusOK {
+        t.Fatalf("Error compacting blockchain: status %d\n", status)
+    } else if newHeight >= heightBefore || newVersion != versionBefore+1 {
+        t.Fatalf("Blockchain not compacted: height %d -> %d version %d -> %d\n", heightBefore, newHeight, versionBefore, newVersion)
+    }
+
+    filesAfter, _ := blockchain.ListFiles()
+    if len(filesAfter) != len(filesBefore) {
+        t.Fatalf("File count mismatch after compaction: %d -> %d\n", len(filesBefore), len(filesAfter))
+    }
+
+    for n := range filesBefore {
+        dateBefore, _ := filesBefore[n].GetTag(TagDateShared).Date()
+        dateAfter, _ := filesAfter[n].GetTag(TagDateShared).Date()
+
+        if filesBefore[n].ID != filesAfter[n].ID || !dateBefore.Equal(dateAfter) {
+            t.Fatalf("File mismatch after compaction: %s\n", filesBefore[n].ID.String())
+        }
+    }
+}
+
+func TestBlockchainArchive(t *testing.T) {
+    blockchain := initTestBlockchain(t)
+
+    file1, _ := createBlockRecordFile([]byte("Test data"), "Archive.txt", "documents")
+    blockchain.AddFiles([]BlockRecordFile{file1})
+
+    _, heightBefore, versionBefore := blockchain.Header()
+    filesBefore, _ := blockchain.ListFiles()
+
+    var archive bytes.Buffer
+    if status, err := blockchain.Export(&archive); status != StatusOK || err != nil {
+        t.Fatalf("Error exporting blockchain: status %d error %v\n", status, err)
+    }
+
+    // a corrupted block must be rejected
+    corrupted := append([]byte{}, archive.Bytes()...)
+    corrupted[len(corrupted)-1] ^= 0xFF
+    if _, _, status, _ := blockchain.Import(bytes.NewReader(corrupted)); status == StatusOK {
+        t.Fatalf("Corrupted archive was imported\n")
+    }
+
+    // a block size beyond the limit must be rejected before allocating
+    oversized := append([]byte{}, archive.Bytes()...)
+    binary.LittleEndian.PutUint32(oversized[archiveHeaderSize:archiveHeaderSize+4], 0xFFFFFFFF)
+    if _, _, status, _ := blockchain.Import(bytes.NewReader(oversized)); status != StatusInvalidArchive {
+        t.Fatalf("Archive with oversized block was imported: status %d\n", status)
+    }
+
+    newHeight, newVersion, status, err := blockchain.Import(&archive)
+    if status != StatusOK || err != nil {
+        t.Fatalf("Error importing blockchain: status %d error %v\n", status, err)
+    } else if newHeight != heightBefore || newVersion != versionBefore+1 {
+        t.Fatalf("Imported blockchain mismatch: height %d version %d\n", newHeight, newVersion)
+    }
+
+    filesAfter, _ := blockchain.ListFiles()
+    if len(filesAfter) != len(filesBefore) {
+        t.Fatalf("File count mismatch after import: %d -> %d\n", len(filesBefore), len(filesAfter))
+    }
+}
+
+func TestBlockchainVerify(t *testing.T) {
+    blockchain := initTestBlockchain(t)
+
+    for n := 0; n < 3; n++ {
+        file, _ := createBlockRecordFile([]byte(fmt.Sprintf("Verify data %d", n)), fmt.Sprintf("Verify %d.txt", n), "documents")
+        blockchain.AddFiles([]BlockRecordFile{file})
+    }
+
+    if corruptions := blockchain.Verify(); len(corruptions) > 0 {
+        t.Fatalf("Valid blockchain reported as corrupt: %s\n", corruptions[0].String())
+    }
+
+    // remove a block in the middle
+    _, height, _ := blockchain.Header()
+    blockchain.database.Delete(blockNumberToKey(height - 2))
+
+    corruptions := blockchain.Verify()
+    if len(corruptions) != 1 || corruptions[0].Type != CorruptionMissingBlock || corruptions[0].BlockNumber != height-2 {
+        t.Fatalf("Missing block not detected\n")
+    }
+
+    newHeight, _, status := blockchain.Repair()
+    if status != StatusOK || newHeight != height-1 {
+        t.Fatalf("Error repairing blockchain: status %d height %d\n", status, newHeight)
+    } else if corruptions := blockchain.Verify(); len(corruptions) > 0 {
+        t.Fatalf("Repaired blockchain is corrupt: %s\n", corruptions[0].String())
+    }
+}
+
+func TestMultiBlockchainHeaderDecode(t *testing.T) {
+    privateKey, _ := btcec.NewPrivateKey(btcec.S256())
+
+    // header in the old format without the dates of last access and last seen
+    raw := make([]byte, multiBlockchainHeaderSizeV0+8*2)
+    raw[0] = 3                             // version
+    raw[8] = 5                             // height
+    raw[16] = 2                            // count of blocks
+    raw[multiBlockchainHeaderSizeV0] = 1   // block 1
+    raw[multiBlockchainHeaderSizeV0+8] = 4 // block 4
+
+    header, err := decodeBlockchainHeader(privateKey.PubKey(), raw)
+    if err != nil {
+        t.Fatalf("Error decoding old header: %s\n", err.Error())
+    } else if header.Version != 3 || header.Height != 5 || len(header.ListBlocks) != 2 || header.ListBlocks[0] != 1 || header.ListBlocks[1] != 4 {
+        t.Fatalf("Old header decoded incorrectly\n")
+    }
+
+    // header in the current format
+    raw = make([]byte, multiBlockchainHeaderSize+8)
+    raw[16] = 1
+    raw[56] = 10
+    raw[64] = 20
+    raw[multiBlockchainHeaderSize] = 7
+
+    header, err = decodeBlockchainHeader(privateKey.PubKey(), raw)
+    if err != nil {
+        t.Fatalf("Error decoding header: %s\n", err.Error())
+    } else if header.DateLastAccess.Unix() != 10 || header.DateLastSeen.Unix() != 20 || len(header.ListBlocks) != 1 || header.ListBlocks[0] != 7 {
+        t.Fatalf("Header decoded incorrectly\n")
+    }
+}
+
+func TestDiffBlockchainContent(t *testing.T) {
+    file1, _ := createBlockRecordFile([]byte("Diff data 1"), "Diff 1.txt", "")
+    file2, _ := createBlockRecordFile([]byte("Diff data 2"), "Diff 2.txt", "")
+    file3, _ := createBlockRecordFile([]byte("Diff data 3"), "Diff 3.txt", "")
+
+    old := &BlockchainContent{Files: map[uuid.UUID]BlockRecordFile{file1.ID: file1, file2.ID: file2}, Profile: map[uint16][]byte{ProfileName: []byte("Old")}}
+    new := &BlockchainContent{Files: map[uuid.UUID]BlockRecordFile{file1.ID: file1, file3.ID: file3}, Profile: map[uint16][]byte{ProfileName: []byte("New")}}
+
+    diff := DiffBlockchainContent(old, new)
+    if len(diff.FilesAdded) != 1 || diff.FilesAdded[0].ID != file3.ID {
+        t.Fatalf("Added file not detected\n")
+    } else if len(diff.FilesRemoved) != 1 || diff.FilesRemoved[0].ID != file2.ID {
+        t.Fatalf("Removed file not detected\n")
+    } else if len(diff.ProfileChanged) != 1 || string(diff.ProfileChanged[0].Data) != "New" {
+        t.Fatalf("Profile change not detected\n")
+    }
+
+    if diff = DiffBlockchainContent(new, new); !diff.IsEmpty() {
+        t.Fatalf("Diff of equal content is not empty\n")
+    }
+}
@@ -0,0 +1,180 @@
+/*
+File Username:  Verify.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Verification walks a blockchain and reports any corruption. Each block is checked for:
+* Availability of the block in the database
+* Valid encoding and signature by the blockchain owner
+* Block number and blockchain version matching the header
+* Hash link to the previous block (LastBlockHash)
+* Decodable records
+*/
+
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/newinfoOffical/core/btcec"
+	"github.com/newinfoOffical/core/protocol"
+)
+
+// CorruptionX defines the type of corruption found in a blockchain
+const (
+	CorruptionMissingBlock   = 0 // Block is missing.
+	CorruptionBadSignature   = 1 // Block encoding is invalid or it is not signed by the blockchain owner.
+	CorruptionHeaderMismatch = 2 // Block number or blockchain version does not match the header.
+	CorruptionBrokenLink     = 3 // LastBlockHash does not match the hash of the previous block.
+	CorruptionBadRecord      = 4 // Records cannot be decoded.
+	CorruptionOrphanBlock    = 5 // Block is stored beyond the height of the blockchain.
+)
+
+// Corruption describes a corruption of a single block.
+type Corruption struct {
+	BlockNumber uint64 // Block number
+	Type        int    // See CorruptionX.
+	Err         error  // Error details
+}
+
+func (corruption Corruption) String() string {
+	return fmt.Sprintf("block %d corruption type %d: %v", corruption.BlockNumber, corruption.Type, corruption.Err)
+}
+
+// verifyBlocks verifies the blocks with the given numbers. Hash links are only verified if the previous block is available and valid.
+func verifyBlocks(publicKey *btcec.PublicKey, version uint64, blockNumbers []uint64, readBlock func(number uint64) (raw []byte, found bool)) (corruptions []Corruption) {
+	var lastBlockNumber uint64
+	var lastBlockHash []byte // Hash of the last valid block. Nil if the last block is not valid.
+
+	for _, blockN := range blockNumbers {
+		if blockN != lastBlockNumber+1 {
+			lastBlockHash = nil
+		}
+		lastBlockNumber = blockN
+		previousHash := lastBlockHash
+		lastBlockHash = nil
+
+		raw, found := readBlock(blockN)
+		if !found || len(raw) == 0 {
+			corruptions = append(corruptions, Corruption{BlockNumber: blockN, Type: CorruptionMissingBlock, Err: errors.New("block not found")})
+			continue
+		}
+
+		block, err := decodeBlock(raw)
+		if err != nil {
+			corruptions = append(corruptions, Corruption{BlockNumber: blockN, Type: CorruptionBadSignature, Err: err})
+			continue
+		} else if !block.OwnerPublicKey.IsEqual(publicKey) {
+			corruptions = append(corruptions, Corruption{BlockNumber: blockN, Type: CorruptionBadSignature, Err: errors.New("block signed by another key")})
+			continue
+		}
+
+		if block.Number != blockN || block.BlockchainVersion != version {
+			corruptions = append(corruptions, Corruption{BlockNumber: blockN, Type: CorruptionHeaderMismatch, Err: fmt.Errorf("block number %d version %d", block.Number, block.BlockchainVersion)})
+			continue
+		}
+
+		if previousHash != nil && !bytes.Equal(block.LastBlockHash, previousHash) {
+			corruptions = append(corruptions, Corruption{BlockNumber: blockN, Type: CorruptionBrokenLink, Err: errors.New("last block hash mismatch")})
+		} else if _, err = decodeBlockRecords(block); err != nil {
+			corruptions = append(corruptions, Corruption{BlockNumber: blockN, Type: CorruptionBadRecord, Err: err})
+		}
+
+		lastBlockHash = protocol.HashData(raw)
+	}
+
+	return corruptions
+}
+
+// Verify walks the entire blockchain and returns all corruptions found.
+func (blockchain *Blockchain) Verify() (corruptions []Corruption) {
+	blockchain.Lock()
+	defer blockchain.Unlock()
+
+	return blockchain.verify()
+}
+
+func (blockchain *Blockchain) verify() (corruptions []Corruption) {
+	var blockNumbers []uint64
+	for blockN := uint64(0); blockN < blockchain.height; blockN++ {
+		blockNumbers = append(blockNumbers, blockN)
+	}
+
+	corruptions = verifyBlocks(blockchain.publicKey, blockchain.version, blockNumbers, func(number uint64) (raw []byte, found bool) {
+		return blockchain.database.Get(blockNumberToKey(number))
+	})
+
+	// Blocks beyond the height may be left over if the process was interrupted while writing the blockchain.
+	if _, found := blockchain.database.Get(blockNumberToKey(blockchain.height)); found {
+		corruptions = append(corruptions, Corruption{BlockNumber: blockchain.height, Type: CorruptionOrphanBlock, Err: errors.New("block beyond height")})
+	}
+
+	return corruptions
+}
+
+// Repair deletes orphaned blocks and rebuilds the blockchain if it is corrupt. Status is StatusX.
+// All blocks with a valid signature by the owner and decodable records are kept and re-encoded under a new version. Records in other blocks are lost.
+func (blockchain *Blockchain) Repair() (newHeight, newVersion uint64, status int) {
+	blockchain.Lock()
+	defer blockchain.Unlock()
+
+	for blockN := blockchain.height; ; blockN++ {
+		if _, found := blockchain.database.Get(blockNumberToKey(blockN)); !found {
+			break
+		}
+		blockchain.database.Delete(blockNumberToKey(blockN))
+	}
+
+	if len(blockchain.verify()) == 0 {
+		return blockchain.height, blockchain.version, StatusOK
+	}
+
+	refactorVersion := blockchain.version + 1
+	var blockchainNew []Block
+
+	for blockN := uint64(0); blockN < blockchain.height; blockN++ {
+		raw, found := blockchain.database.Get(blockNumberToKey(blockN))
+		if !found || len(raw) == 0 {
+			continue
+		}
+
+		block, err := decodeBlock(raw)
+		if err != nil || !block.OwnerPublicKey.IsEqual(blockchain.publicKey) {
+			continue
+		} else if _, err = decodeBlockRecords(block); err != nil {
+			continue
+		}
+
+		blockchainNew = append(blockchainNew, Block{OwnerPublicKey: blockchain.publicKey, RecordsRaw: block.RecordsRaw, BlockchainVersion: refactorVersion, Number: uint64(len(blockchainNew))})
+	}
+
+	if status = blockchain.replaceBlocks(blockchainNew, blockchain.privateKey); status != StatusOK {
+		return 0, 0, status
+	}
+
+	blockchain.headerWrite(uint64(len(blockchainNew)), refactorVersion)
+
+	return blockchain.height, blockchain.version, StatusOK
+}
+
+// VerifyBlockchain verifies all blocks of a blockchain stored in the multi store and returns all corruptions found.
+// Blocks not listed in the header are not considered missing, since caches may store only some blocks.
+func (multi *MultiStore) VerifyBlockchain(header *MultiBlockchainHeader) (corruptions []Corruption) {
+	blockNumbers := append([]uint64{}, header.ListBlocks...)
+	sort.Slice(blockNumbers, func(i, j int) bool { return blockNumbers[i] < blockNumbers[j] })
+
+	for n, blockN := range blockNumbers {
+		if blockN >= header.Height || n > 0 && blockNumbers[n-1] == blockN {
+			corruptions = append(corruptions, Corruption{BlockNumber: blockN, Type: CorruptionOrphanBlock, Err: errors.New("invalid block number in header")})
+		}
+	}
+
+	corruptions = append(corruptions, verifyBlocks(header.PublicKey, header.Version, blockNumbers, func(number uint64) (raw []byte, found bool) {
+		return multi.ReadBlock(header.PublicKey, header.Version, number)
+	})...)
+
+	return corruptions
+}
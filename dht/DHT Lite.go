@@ -69,7 +69,12 @@ func (dht *DHT) Nodes() []*Node {
 
 // GetSelfID returns the identifier of the local node
 func (dht *DHT) GetSelfID() []byte {
-	return dht.ht.Self.ID
+	return dht.ht.selfID()
+}
+
+// SetSelfID changes the identifier of the local node, for example after the key of the local node changed. Existing nodes are moved into the buckets according to the new identifier.
+func (dht *DHT) SetSelfID(ID []byte) {
+	dht.ht.setSelfID(ID)
 }
 
 // AddNode adds a node into the appropriate k bucket. These buckets are stored in big-endian order so we look at the bits from right to left in order to find the appropriate bucket.
@@ -92,13 +97,13 @@ func (dht *DHT) GetClosestContacts(count int, target []byte, filterFunc NodeFilt
 
 // MarkNodeAsSeen marks a node as seen, which pushes it to the top in the bucket list.
 func (dht *DHT) MarkNodeAsSeen(ID []byte) {
-	dht.ht.markNodeAsSeen(dht.ht.getBucketIndexFromDifferingBit(ID), ID)
+	dht.ht.markNodeAsSeen(ID)
 }
 
 // IsNodeCloser compares 2 nodes to self. If true, the first node is closer (= smaller distance) to self than the second.
 func (dht *DHT) IsNodeCloser(node1, node2 []byte) bool {
-	iDist := getDistance(node1, dht.ht.Self.ID)
-	jDist := getDistance(node2, dht.ht.Self.ID)
+	iDist := getDistance(node1, dht.ht.selfID())
+	jDist := getDistance(node2, dht.ht.selfID())
 
 	return iDist.Cmp(jDist) == -1
 }
@@ -198,7 +203,7 @@ func (dht *DHT) RefreshBuckets(target int) {
 
 			// Refreshing closest bucket? Use self ID instead of random one.
 			if bucket == 0 {
-				nodeR = dht.ht.Self.ID
+				nodeR = dht.ht.selfID()
 			}
 
 			dht.FindNode(nodeR)
@@ -12,13 +12,14 @@ import (
 	"math/rand"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // hashTable represents the hashtable state
 type hashTable struct {
-	// The ID of the local node
-	Self *Node
+	// The ID of the local node. It may change at runtime, see setSelfID.
+	self atomic.Value // []byte
 
 	// the size in bits of the keys used to identify nodes and store and
 	// retrieve data; in basic Kademlia this is 160, the length of a SHA1
@@ -42,16 +43,47 @@ func newHashTable(self *Node, bits, bucketSize int) *hashTable {
 		bBits: bits,
 		bSize: bucketSize,
 		mutex: &sync.RWMutex{},
-		Self:  self,
 	}
+	ht.self.Store(self.ID)
 
 	ht.RoutingTable = make([][]*Node, ht.bBits)
 	return ht
 }
 
-func (ht *hashTable) markNodeAsSeen(index int, ID []byte) {
+// selfID returns the ID of the local node.
+func (ht *hashTable) selfID() []byte {
+	return ht.self.Load().([]byte)
+}
+
+// setSelfID changes the ID of the local node. All nodes are moved into the buckets according to the new ID. Nodes that do not fit are dropped.
+func (ht *hashTable) setSelfID(ID []byte) {
 	ht.mutex.Lock()
 	defer ht.mutex.Unlock()
+
+	var nodes []*Node
+	for _, bucket := range ht.RoutingTable {
+		nodes = append(nodes, bucket...)
+	}
+
+	ht.self.Store(ID)
+	ht.RoutingTable = make([][]*Node, ht.bBits)
+
+	for _, node := range nodes {
+		if bytes.Equal(node.ID, ID) {
+			continue
+		}
+
+		index := ht.getBucketIndexFromDifferingBit(node.ID)
+		if len(ht.RoutingTable[index]) < ht.bSize {
+			ht.RoutingTable[index] = append(ht.RoutingTable[index], node)
+		}
+	}
+}
+
+func (ht *hashTable) markNodeAsSeen(ID []byte) {
+	ht.mutex.Lock()
+	defer ht.mutex.Unlock()
+	index := ht.getBucketIndexFromDifferingBit(ID)
 	bucket := ht.RoutingTable[index]
 	nodeIndex := -1
 	for i, v := range bucket {
@@ -74,10 +106,10 @@ func (ht *hashTable) markNodeAsSeen(index int, ID []byte) {
 	ht.RoutingTable[index] = bucket
 }
 
-func (ht *hashTable) doesNodeExistInBucket(bucket int, ID []byte) (node *Node) {
+func (ht *hashTable) doesNodeExist(ID []byte) (node *Node) {
 	ht.mutex.RLock()
 	defer ht.mutex.RUnlock()
-	for _, node = range ht.RoutingTable[bucket] {
+	for _, node = range ht.RoutingTable[ht.getBucketIndexFromDifferingBit(ID)] {
 		if bytes.Compare(node.ID, ID) == 0 {
 			return node
 		}
@@ -85,10 +117,6 @@ func (ht *hashTable) doesNodeExistInBucket(bucket int, ID []byte) (node *Node) {
 	return nil
 }
 
-func (ht *hashTable) doesNodeExist(ID []byte) (node *Node) {
-	return ht.doesNodeExistInBucket(ht.getBucketIndexFromDifferingBit(ID), ID)
-}
-
 // getClosestContacts returns the closest nodes to the target. filterFunc is optional and allows the caller to filter the nodes.
 func (ht *hashTable) getClosestContacts(num int, target []byte, filterFunc NodeFilterFunc, ignoredNodes ...[]byte) *shortList {
 	ht.mutex.RLock()
@@ -141,21 +169,24 @@ func (ht *hashTable) getClosestContacts(num int, target []byte, filterFunc NodeF
 }
 
 func (ht *hashTable) insertNode(node *Node, shouldEvict func(nodeOld *Node, nodeNew *Node) bool) {
+	ht.mutex.Lock()
+	defer ht.mutex.Unlock()
+
 	index := ht.getBucketIndexFromDifferingBit(node.ID)
+	bucket := ht.RoutingTable[index]
 
 	// If the node already exist, mark it as seen
-	if ht.doesNodeExistInBucket(index, node.ID) != nil {
-		ht.markNodeAsSeen(index, node.ID)
-		return
+	for i, v := range bucket {
+		if bytes.Equal(v.ID, node.ID) {
+			v.LastSeen = time.Now().UTC()
+			bucket = append(bucket[:i], bucket[i+1:]...)
+			ht.RoutingTable[index] = append(bucket, v)
+			return
+		}
 	}
 
 	node.LastSeen = time.Now().UTC()
 
-	ht.mutex.Lock()
-	defer ht.mutex.Unlock()
-
-	bucket := ht.RoutingTable[index]
-
 	if len(bucket) == ht.bSize {
 		if shouldEvict(bucket[0], node) {
 			bucket = append(bucket, node)
@@ -199,7 +230,7 @@ func (ht *hashTable) getRandomIDFromBucket(bucket int) []byte {
 	byteIndex := bucket / 8
 	var id []byte
 	for i := 0; i < byteIndex; i++ {
-		id = append(id, ht.Self.ID[i])
+		id = append(id, ht.selfID()[i])
 	}
 	differingBitStart := bucket % 8
 
@@ -210,7 +241,7 @@ func (ht *hashTable) getRandomIDFromBucket(bucket int) []byte {
 		// up to the differing bit. Then begin randomizing
 		var bit bool
 		if i < differingBitStart {
-			bit = hasBit(ht.Self.ID[byteIndex], uint(i))
+			bit = hasBit(ht.selfID()[byteIndex], uint(i))
 		} else {
 			bit = rand.Intn(2) == 1
 		}
@@ -252,7 +283,7 @@ func (ht *hashTable) getBucketIndexFromDifferingBit(id1 []byte) int {
 	// Look at each byte from left to right
 	for j := 0; j < len(id1); j++ {
 		// xor the byte
-		xor := id1[j] ^ ht.Self.ID[j]
+		xor := id1[j] ^ ht.selfID()[j]
 
 		// check each bit on the xored result from left to right in order
 		for i := 0; i < 8; i++ {
@@ -0,0 +1,541 @@
+/*
+File Username:  Audio.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+ID3v2 tags are stored at the beginning of MP3 files. The header is 10 bytes:
+Offset  Size   Info
+0       3      "ID3"
+3       1      Major version (2, 3 or 4)
+4       1      Revision
+5       1      Flags
+6       4      Size of the tag excluding the header, 7 bits per byte ("syncsafe")
+
+Frames follow the header. Version 2.2 uses 3 character IDs and 3 byte sizes. Version 2.3 and 2.4 use 4 character IDs, 4 byte sizes (syncsafe in 2.4) and 2 bytes flags.
+Used frames: TIT2/TT2 title, TPE1/TP1 artist, TALB/TAL album, TLEN/TLE duration in milliseconds, APIC/PIC cover art.
+
+ID3v1 tags are the last 128 bytes of the file starting with "TAG". Title, artist and album are 30 bytes each at offset 3, 33 and 63.
+
+Vorbis comments (used by FLAC, Ogg Vorbis and Ogg Opus) are a list of "KEY=value" strings. Used keys: TITLE, ARTIST, ALBUM, METADATA_BLOCK_PICTURE (base64 encoded FLAC picture).
+
+The FLAC PICTURE block (all numbers are big endian):
+Offset  Size   Info
+0       4      Picture type, 3 = front cover
+4       4      Length of the MIME type
+8       ?      MIME type
+?       4      Length of the description
+?       ?      Description, UTF-8
+?       16     Width, height, color depth, count of colors
+?       4      Length of the picture data
+?       ?      Picture data
+*/
+
+package media
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// readMP3 reads the ID3 tags and calculates the duration from the first MPEG frame.
+func readMP3(reader io.ReaderAt, size int64, metadata *Metadata) {
+	audioStart := readID3v2(reader, size, metadata)
+	audioEnd := size
+
+	if tag := readAt(reader, size-128, 128, size); len(tag) == 128 && bytes.HasPrefix(tag, []byte("TAG")) {
+		audioEnd -= 128
+
+		if metadata.Title == "" {
+			metadata.Title = id3v1Text(tag[3:33])
+		}
+		if metadata.Artist == "" {
+			metadata.Artist = id3v1Text(tag[33:63])
+		}
+		if metadata.Album == "" {
+			metadata.Album = id3v1Text(tag[63:93])
+		}
+	}
+
+	if metadata.Duration == 0 {
+		metadata.Duration = mpegDuration(reader, audioStart, audioEnd)
+	}
+}
+
+// readID3v2 reads the ID3v2 tag at the beginning of the file, if any. It returns the offset of the audio data.
+func readID3v2(reader io.ReaderAt, size int64, metadata *Metadata) (audioStart int64) {
+	header := readAt(reader, 0, 10, size)
+	if len(header) < 10 || !bytes.HasPrefix(header, []byte("ID3")) {
+		return 0
+	}
+
+	version := header[3]
+	flags := header[5]
+	tagSize := int64(syncsafe(header[6:10]))
+	audioStart = 10 + tagSize
+	if flags&0x10 != 0 { // footer present
+		audioStart += 10
+	}
+
+	if version < 2 || version > 4 || tagSize > headerSizeMax {
+		return audioStart
+	}
+
+	data := readAt(reader, 10, tagSize, size)
+	if flags&0x80 != 0 && version < 4 { // unsynchronization of the entire tag
+		data = bytes.ReplaceAll(data, []byte{0xFF, 0x00}, []byte{0xFF})
+	}
+
+	// skip the extended header
+	if flags&0x40 != 0 && len(data) >= 4 {
+		if version == 3 {
+			data = data[minInt64(4+int64(binary.BigEndian.Uint32(data[0:4])), int64(len(data))):]
+		} else if version == 4 {
+			data = data[minInt64(int64(syncsafe(data[0:4])), int64(len(data))):]
+		}
+	}
+
+	idSize, headerSize := 4, 10
+	if version == 2 {
+		idSize, headerSize = 3, 6
+	}
+
+	for len(data) >= headerSize && data[0] != 0 {
+		id := string(data[:idSize])
+
+		var frameSize int
+		switch version {
+		case 2:
+			frameSize = int(data[3])<<16 | int(data[4])<<8 | int(data[5])
+		case 3:
+			frameSize = int(binary.BigEndian.Uint32(data[4:8]))
+		case 4:
+			frameSize = int(syncsafe(data[4:8]))
+		}
+
+		if frameSize < 0 || headerSize+frameSize > len(data) {
+			break
+		}
+		frame := data[headerSize : headerSize+frameSize]
+		unsupported := (version == 3 && data[9]&0xC0 != 0) || (version == 4 && data[9]&0x0C != 0) // compressed or encrypted
+		data = data[headerSize+frameSize:]
+
+		if unsupported {
+			continue
+		}
+
+		switch id {
+		case "TIT2", "TT2":
+			metadata.Title = id3Text(frame)
+		case "TPE1", "TP1":
+			metadata.Artist = id3Text(frame)
+		case "TALB", "TAL":
+			metadata.Album = id3Text(frame)
+		case "TLEN", "TLE":
+			if milliseconds, err := strconv.ParseUint(id3Text(frame), 10, 64); err == nil {
+				metadata.Duration = milliseconds / 1000
+			}
+		case "APIC", "PIC":
+			if pictureType, picture, ok := id3Picture(frame, version); ok {
+				metadata.setPicture(uint32(pictureType), picture)
+			}
+		}
+	}
+
+	return audioStart
+}
+
+// syncsafe decodes a 4 byte syncsafe integer, which uses 7 bits per byte.
+func syncsafe(data []byte) uint32 {
+	return uint32(data[0]&0x7F)<<21 | uint32(data[1]&0x7F)<<14 | uint32(data[2]&0x7F)<<7 | uint32(data[3]&0x7F)
+}
+
+// id3Text decodes an ID3v2 text frame. The first byte is the encoding: 0 = ISO-8859-1, 1 = UTF-16 with BOM, 2 = UTF-16BE, 3 = UTF-8.
+// Only the first value is returned if the frame contains multiple ones.
+func id3Text(frame []byte) string {
+	if len(frame) < 1 {
+		return ""
+	}
+
+	var text string
+	switch frame[0] {
+	case 0:
+		text = latin1(frame[1:])
+	case 1, 2:
+		text = utf16Text(frame[1:], frame[0] == 2)
+	case 3:
+		text = string(frame[1:])
+	}
+
+	if index := strings.IndexByte(text, 0); index >= 0 {
+		text = text[:index]
+	}
+
+	return strings.TrimSpace(text)
+}
+
+// id3Picture decodes an APIC frame: Encoding (1 byte), MIME type (zero terminated), picture type (1 byte), description (zero terminated in the encoding), picture data.
+// Version 2.2 uses PIC frames which have a 3 character image format instead of the MIME type.
+func id3Picture(frame []byte, version byte) (pictureType byte, picture []byte, ok bool) {
+	if len(frame) < 1 {
+		return 0, nil, false
+	}
+	encoding := frame[0]
+	frame = frame[1:]
+
+	if version == 2 {
+		if len(frame) < 3 {
+			return 0, nil, false
+		}
+		frame = frame[3:]
+	} else if index := bytes.IndexByte(frame, 0); index >= 0 {
+		frame = frame[index+1:]
+	} else {
+		return 0, nil, false
+	}
+
+	if len(frame) < 1 {
+		return 0, nil, false
+	}
+	pictureType = frame[0]
+	frame = frame[1:]
+
+	// The description is terminated by 2 zero bytes in UTF-16.
+	if encoding == 1 || encoding == 2 {
+		for index := 0; ; index += 2 {
+			if index+1 >= len(frame) {
+				return 0, nil, false
+			} else if frame[index] == 0 && frame[index+1] == 0 {
+				frame = frame[index+2:]
+				break
+			}
+		}
+	} else if index := bytes.IndexByte(frame, 0); index >= 0 {
+		frame = frame[index+1:]
+	} else {
+		return 0, nil, false
+	}
+
+	return pictureType, frame, len(frame) > 0
+}
+
+// id3v1Text decodes a fixed size text field of an ID3v1 tag.
+func id3v1Text(data []byte) string {
+	if index := bytes.IndexByte(data, 0); index >= 0 {
+		data = data[:index]
+	}
+
+	return strings.TrimSpace(latin1(data))
+}
+
+// latin1 decodes ISO-8859-1 text.
+func latin1(data []byte) string {
+	runes := make([]rune, len(data))
+	for n, b := range data {
+		runes[n] = rune(b)
+	}
+
+	return string(runes)
+}
+
+// utf16Text decodes UTF-16 text. A byte order mark overrides the default byte order.
+func utf16Text(data []byte, bigEndian bool) string {
+	if len(data) >= 2 {
+		if data[0] == 0xFE && data[1] == 0xFF {
+			bigEndian, data = true, data[2:]
+		} else if data[0] == 0xFF && data[1] == 0xFE {
+			bigEndian, data = false, data[2:]
+		}
+	}
+
+	units := make([]uint16, 0, len(data)/2)
+	for n := 0; n+1 < len(data); n += 2 {
+		if bigEndian {
+			units = append(units, binary.BigEndian.Uint16(data[n:n+2]))
+		} else {
+			units = append(units, binary.LittleEndian.Uint16(data[n:n+2]))
+		}
+	}
+
+	return string(utf16.Decode(units))
+}
+
+// ---- MPEG audio ----
+
+// Bitrates in kbit/s of layer III, indexed by the bitrate index. MPEG-1 and MPEG-2/2.5 use different tables.
+var (
+	mpeg1Bitrates = [16]uint64{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0}
+	mpeg2Bitrates = [16]uint64{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0}
+	mpegRates     = [4]uint64{44100, 48000, 32000, 0} // Sample rates of MPEG-1. MPEG-2 uses half, MPEG-2.5 a quarter.
+)
+
+// isMPEGFrame checks if the data starts with a valid MPEG audio layer III frame header.
+func isMPEGFrame(header []byte) bool {
+	_, _, _, _, ok := mpegFrameHeader(header)
+	return ok
+}
+
+// mpegFrameHeader decodes an MPEG audio layer III frame header.
+func mpegFrameHeader(header []byte) (mpeg1, mono bool, bitrate, sampleRate uint64, ok bool) {
+	if len(header) < 4 || header[0] != 0xFF || header[1]&0xE0 != 0xE0 {
+		return false, false, 0, 0, false
+	}
+
+	version := (header[1] >> 3) & 0x03 // 0 = MPEG-2.5, 2 = MPEG-2, 3 = MPEG-1
+	layer := (header[1] >> 1) & 0x03   // 1 = layer III
+	bitrateIndex := header[2] >> 4
+	rateIndex := (header[2] >> 2) & 0x03
+	if version == 1 || layer != 1 || bitrateIndex == 0 || bitrateIndex == 15 || rateIndex == 3 {
+		return false, false, 0, 0, false
+	}
+
+	mpeg1 = version == 3
+	mono = header[3]>>6 == 3
+	sampleRate = mpegRates[rateIndex]
+
+	switch version {
+	case 3:
+		bitrate = mpeg1Bitrates[bitrateIndex]
+	case 2:
+		bitrate = mpeg2Bitrates[bitrateIndex]
+		sampleRate /= 2
+	case 0:
+		bitrate = mpeg2Bitrates[bitrateIndex]
+		sampleRate /= 4
+	}
+
+	return mpeg1, mono, bitrate, sampleRate, true
+}
+
+// mpegDuration calculates the duration in seconds of the MPEG audio data. VBR files are expected to have a Xing or Info header in the first frame, otherwise constant bitrate is assumed.
+func mpegDuration(reader io.ReaderAt, audioStart, audioEnd int64) (duration uint64) {
+	// The first frame is expected shortly after the start. Some files are padded with zeros.
+	data := readAt(reader, audioStart, 4096, audioEnd)
+
+	for offset := 0; offset+4 <= len(data); offset++ {
+		mpeg1, mono, bitrate, sampleRate, ok := mpegFrameHeader(data[offset:])
+		if !ok {
+			continue
+		}
+
+		// position of the Xing header after the side information
+		xing := offset + 4
+		switch {
+		case mpeg1 && !mono:
+			xing += 32
+		case mpeg1 && mono, !mpeg1 && !mono:
+			xing += 17
+		default:
+			xing += 9
+		}
+
+		if xing+12 <= len(data) && (string(data[xing:xing+4]) == "Xing" || string(data[xing:xing+4]) == "Info") && data[xing+7]&0x01 != 0 {
+			frames := uint64(binary.BigEndian.Uint32(data[xing+8 : xing+12]))
+			samplesPerFrame := uint64(1152)
+			if !mpeg1 {
+				samplesPerFrame = 576
+			}
+
+			return frames * samplesPerFrame / sampleRate
+		}
+
+		return uint64(audioEnd-audioStart-int64(offset)) * 8 / (bitrate * 1000)
+	}
+
+	return 0
+}
+
+// ---- FLAC ----
+
+// readFLAC reads the metadata blocks of a FLAC file. Each block starts with a 4 byte header: Last block flag (1 bit), type (7 bits), length (3 bytes).
+// The STREAMINFO block (type 0) contains the sample rate (20 bits at offset 10) and the total count of samples (36 bits at offset 13).
+func readFLAC(reader io.ReaderAt, size int64, metadata *Metadata) {
+	offset := int64(4)
+
+	for n := 0; n < 1000; n++ {
+		header := readAt(reader, offset, 4, size)
+		if len(header) < 4 {
+			return
+		}
+
+		length := int64(header[1])<<16 | int64(header[2])<<8 | int64(header[3])
+
+		switch header[0] & 0x7F {
+		case 0: // STREAMINFO
+			if data := readAt(reader, offset+4, 18, size); len(data) == 18 {
+				sampleRate := uint64(data[10])<<12 | uint64(data[11])<<4 | uint64(data[12])>>4
+				samples := uint64(data[13]&0x0F)<<32 | uint64(binary.BigEndian.Uint32(data[14:18]))
+				if sampleRate > 0 {
+					metadata.Duration = samples / sampleRate
+				}
+			}
+
+		case 4: // VORBIS_COMMENT
+			readVorbisComment(readAt(reader, offset+4, length, size), metadata)
+
+		case 6: // PICTURE
+			if length <= headerSizeMax {
+				readFLACPicture(readAt(reader, offset+4, length, size), metadata)
+			}
+		}
+
+		if header[0]&0x80 != 0 {
+			return
+		}
+		offset += 4 + length
+	}
+}
+
+// readVorbisComment reads a Vorbis comment structure: Vendor length (4 bytes), vendor, count of comments (4 bytes), each comment with a 4 byte length. All numbers are little endian.
+func readVorbisComment(data []byte, metadata *Metadata) {
+	if len(data) < 8 {
+		return
+	}
+
+	vendorLength := uint64(binary.LittleEndian.Uint32(data[0:4]))
+	if 4+vendorLength+4 > uint64(len(data)) {
+		return
+	}
+	data = data[4+vendorLength:]
+	count := binary.LittleEndian.Uint32(data[0:4])
+	data = data[4:]
+
+	for n := uint32(0); n < count && len(data) >= 4; n++ {
+		length := uint64(binary.LittleEndian.Uint32(data[0:4]))
+		if 4+length > uint64(len(data)) {
+			return
+		}
+		comment := string(data[4 : 4+length])
+		data = data[4+length:]
+
+		key, value, found := strings.Cut(comment, "=")
+		if !found {
+			continue
+		}
+		value = strings.TrimSpace(value)
+
+		switch strings.ToUpper(key) {
+		case "TITLE":
+			metadata.Title = value
+		case "ARTIST":
+			metadata.Artist = value
+		case "ALBUM":
+			metadata.Album = value
+		case "METADATA_BLOCK_PICTURE":
+			if data, err := base64.StdEncoding.DecodeString(value); err == nil {
+				readFLACPicture(data, metadata)
+			}
+		}
+	}
+}
+
+// readFLACPicture reads a FLAC PICTURE block.
+func readFLACPicture(data []byte, metadata *Metadata) {
+	if len(data) < 8 {
+		return
+	}
+	pictureType := binary.BigEndian.Uint32(data[0:4])
+	offset := uint64(4)
+
+	// skip the MIME type and the description
+	for n := 0; n < 2; n++ {
+		if offset+4 > uint64(len(data)) {
+			return
+		}
+		offset += 4 + uint64(binary.BigEndian.Uint32(data[offset:offset+4]))
+	}
+
+	offset += 16
+	if offset+4 > uint64(len(data)) {
+		return
+	}
+	length := uint64(binary.BigEndian.Uint32(data[offset : offset+4]))
+	offset += 4
+	if offset+length > uint64(len(data)) {
+		return
+	}
+
+	metadata.setPicture(pictureType, data[offset:offset+length])
+}
+
+// ---- Ogg ----
+
+// readOgg reads the identification and comment headers of the first logical stream of an Ogg file. Vorbis and Opus are supported.
+// Each page has a 27 byte header followed by the segment table. The granule position (offset 6, 8 bytes) of the last page is the total count of samples.
+func readOgg(reader io.ReaderAt, size int64, metadata *Metadata) {
+	var packets [][]byte
+	var packet []byte
+	offset := int64(0)
+
+	for len(packets) < 2 && offset < size {
+		header := readAt(reader, offset, 27, size)
+		if len(header) < 27 || !bytes.HasPrefix(header, []byte("OggS")) {
+			break
+		}
+
+		segments := readAt(reader, offset+27, int64(header[26]), size)
+		dataOffset := offset + 27 + int64(len(segments))
+
+		for _, segment := range segments {
+			packet = append(packet, readAt(reader, dataOffset, int64(segment), size)...)
+			dataOffset += int64(segment)
+
+			if segment < 255 {
+				packets = append(packets, packet)
+				packet = nil
+			}
+			if len(packet) > headerSizeMax {
+				return
+			}
+		}
+
+		offset = dataOffset
+	}
+
+	if len(packets) < 2 {
+		return
+	}
+
+	var sampleRate, preSkip uint64
+	switch {
+	case bytes.HasPrefix(packets[0], []byte("\x01vorbis")) && len(packets[0]) >= 16:
+		sampleRate = uint64(binary.LittleEndian.Uint32(packets[0][12:16]))
+		if bytes.HasPrefix(packets[1], []byte("\x03vorbis")) {
+			readVorbisComment(packets[1][7:], metadata)
+		}
+
+	case bytes.HasPrefix(packets[0], []byte("OpusHead")) && len(packets[0]) >= 12:
+		sampleRate = 48000 // Opus granule positions always use 48 kHz.
+		preSkip = uint64(binary.LittleEndian.Uint16(packets[0][10:12]))
+		if bytes.HasPrefix(packets[1], []byte("OpusTags")) {
+			readVorbisComment(packets[1][8:], metadata)
+		}
+
+	default:
+		return
+	}
+
+	// find the last page
+	tail := readAt(reader, size-65536, 65536, size)
+	if size < 65536 {
+		tail = readAt(reader, 0, size, size)
+	}
+
+	if index := bytes.LastIndex(tail, []byte("OggS")); index >= 0 && index+14 <= len(tail) && sampleRate > 0 {
+		if granule := binary.LittleEndian.Uint64(tail[index+6 : index+14]); granule > preSkip && granule != ^uint64(0) {
+			metadata.Duration = (granule - preSkip) / sampleRate
+		}
+	}
+}
+
+// minInt64 returns the smaller number.
+func minInt64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
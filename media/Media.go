@@ -0,0 +1,133 @@
+/*
+File Username:  Media.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Extracts metadata from pictures, audio and video files. The format is detected by the signature of the file, the file extension is not used.
+Only the headers of the file are read, the media data itself is not decoded. Corrupt or truncated headers result in incomplete metadata rather than an error.
+
+Supported formats:
+Pictures    JPEG (EXIF), TIFF (EXIF), PNG (including eXIf chunk), GIF, WebP
+Audio       MP3 (ID3v1, ID3v2.2-2.4), FLAC, Ogg Vorbis, Ogg Opus, WAV
+Video       MP4/M4A/MOV, Matroska/WebM, AVI
+
+Cover art is read from ID3v2 (APIC, PIC), FLAC (PICTURE block), Vorbis comments (METADATA_BLOCK_PICTURE) and MP4 (covr).
+*/
+
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+)
+
+// Metadata contains the information extracted from a media file. Fields that are not available are empty.
+type Metadata struct {
+	Title       string    // Title of the song or video
+	Artist      string    // Artist or author
+	Album       string    // Album name
+	Duration    uint64    // Duration in seconds
+	Width       uint64    // Width in pixels
+	Height      uint64    // Height in pixels
+	Camera      string    // Make and model of the camera
+	DateCreated time.Time // Date when the picture was taken
+	HasGPS      bool      // Whether the GPS location is available
+	Latitude    float64   // GPS latitude in decimal degrees, negative for south
+	Longitude   float64   // GPS longitude in decimal degrees, negative for west
+	Picture     []byte    // Embedded cover art, typically JPEG or PNG. The front cover is preferred.
+}
+
+// ErrUnsupported is returned if the format of the file is not supported.
+var ErrUnsupported = errors.New("unsupported media format")
+
+// headerSizeMax is the max size of a single header structure that is read into memory, for example the EXIF data or the MP4 movie box.
+const headerSizeMax = 16 * 1024 * 1024
+
+// Read extracts the metadata from the media file.
+func Read(reader io.ReaderAt, size int64) (metadata *Metadata, err error) {
+	header := readAt(reader, 0, 16, size)
+	if len(header) < 12 {
+		return nil, ErrUnsupported
+	}
+
+	metadata = &Metadata{}
+
+	switch {
+	case bytes.HasPrefix(header, []byte{0xFF, 0xD8, 0xFF}):
+		readJPEG(reader, size, metadata)
+
+	case bytes.HasPrefix(header, []byte("\x89PNG\r\n\x1a\n")):
+		readPNG(reader, size, metadata)
+
+	case bytes.HasPrefix(header, []byte("GIF87a")), bytes.HasPrefix(header, []byte("GIF89a")):
+		metadata.Width = uint64(binary.LittleEndian.Uint16(header[6:8]))
+		metadata.Height = uint64(binary.LittleEndian.Uint16(header[8:10]))
+
+	case bytes.HasPrefix(header, []byte("II*\x00")), bytes.HasPrefix(header, []byte("MM\x00*")):
+		readEXIF(readAt(reader, 0, 1024*1024, size), metadata)
+
+	case bytes.HasPrefix(header, []byte("RIFF")):
+		switch string(header[8:12]) {
+		case "WEBP":
+			readWebP(reader, size, metadata)
+		case "WAVE", "AVI ":
+			readRIFF(reader, size, metadata)
+		default:
+			return nil, ErrUnsupported
+		}
+
+	case bytes.HasPrefix(header, []byte("ID3")):
+		readMP3(reader, size, metadata)
+
+	case bytes.HasPrefix(header, []byte("fLaC")):
+		readFLAC(reader, size, metadata)
+
+	case bytes.HasPrefix(header, []byte("OggS")):
+		readOgg(reader, size, metadata)
+
+	case string(header[4:8]) == "ftyp":
+		readMP4(reader, size, metadata)
+
+	case bytes.HasPrefix(header, []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		readMatroska(reader, size, metadata)
+
+	case isMPEGFrame(header):
+		readMP3(reader, size, metadata)
+
+	default:
+		return nil, ErrUnsupported
+	}
+
+	return metadata, nil
+}
+
+// readAt reads up to length bytes at the offset. It returns less data if the file is shorter, or nil in case of an error.
+func readAt(reader io.ReaderAt, offset, length, size int64) (data []byte) {
+	if offset < 0 || length <= 0 || offset >= size {
+		return nil
+	}
+	if offset+length > size {
+		length = size - offset
+	}
+
+	data = make([]byte, length)
+	n, err := reader.ReadAt(data, offset)
+	if err != nil && err != io.EOF {
+		return nil
+	}
+
+	return data[:n]
+}
+
+// pictureFrontCover is the picture type of the front cover as used by ID3v2 and FLAC.
+const pictureFrontCover = 3
+
+// setPicture sets the cover art. The front cover replaces any other picture, other pictures are only used if there is none yet.
+func (metadata *Metadata) setPicture(pictureType uint32, data []byte) {
+	if len(data) > 0 && (metadata.Picture == nil || pictureType == pictureFrontCover) {
+		metadata.Picture = data
+	}
+}
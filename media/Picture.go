@@ -0,0 +1,307 @@
+/*
+File Username:  Picture.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+EXIF data is stored in TIFF format. It starts with the byte order ("II" little endian, "MM" big endian), the magic number 42 and the offset of the first IFD.
+Each IFD (image file directory) is a list of 12 byte entries:
+Offset  Size   Info
+0       2      Tag
+2       2      Type, see exifTypeSize
+4       4      Count of values
+8       4      Value if it fits into 4 bytes, otherwise offset of the value
+
+Used tags:
+IFD0        0x0100 ImageWidth, 0x0101 ImageLength, 0x010F Make, 0x0110 Model, 0x8769 Offset of the EXIF IFD, 0x8825 Offset of the GPS IFD
+EXIF IFD    0x9003 DateTimeOriginal, 0xA002 PixelXDimension, 0xA003 PixelYDimension
+GPS IFD     0x0001 GPSLatitudeRef, 0x0002 GPSLatitude, 0x0003 GPSLongitudeRef, 0x0004 GPSLongitude
+*/
+
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"time"
+)
+
+// readJPEG reads the dimensions from the SOF segment and the EXIF data from the APP1 segment.
+func readJPEG(reader io.ReaderAt, size int64, metadata *Metadata) {
+	offset := int64(2)
+
+	for n := 0; n < 1000; n++ {
+		header := readAt(reader, offset, 4, size)
+		if len(header) < 4 || header[0] != 0xFF {
+			return
+		}
+
+		marker := header[1]
+		switch {
+		case marker == 0xFF: // fill byte
+			offset++
+			continue
+		case marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7): // markers without length
+			offset += 2
+			continue
+		case marker == 0xDA || marker == 0xD9: // start of scan, end of image
+			return
+		}
+
+		length := int64(binary.BigEndian.Uint16(header[2:4]))
+		if length < 2 {
+			return
+		}
+
+		switch {
+		case marker == 0xE1: // APP1
+			if data := readAt(reader, offset+4, length-2, size); bytes.HasPrefix(data, []byte("Exif\x00\x00")) {
+				readEXIF(data[6:], metadata)
+			}
+
+		case marker >= 0xC0 && marker <= 0xCF && marker != 0xC4 && marker != 0xC8 && marker != 0xCC: // start of frame
+			if data := readAt(reader, offset+4, 5, size); len(data) == 5 {
+				metadata.Height = uint64(binary.BigEndian.Uint16(data[1:3]))
+				metadata.Width = uint64(binary.BigEndian.Uint16(data[3:5]))
+			}
+		}
+
+		offset += 2 + length
+	}
+}
+
+// readPNG reads the dimensions from the IHDR chunk and the EXIF data from the eXIf chunk.
+// Each chunk has the format: length (4 bytes), type (4 bytes), data, CRC (4 bytes).
+func readPNG(reader io.ReaderAt, size int64, metadata *Metadata) {
+	offset := int64(8)
+
+	for n := 0; n < 1000; n++ {
+		header := readAt(reader, offset, 8, size)
+		if len(header) < 8 {
+			return
+		}
+
+		length := int64(binary.BigEndian.Uint32(header[0:4]))
+
+		switch string(header[4:8]) {
+		case "IHDR":
+			if data := readAt(reader, offset+8, 8, size); len(data) == 8 {
+				metadata.Width = uint64(binary.BigEndian.Uint32(data[0:4]))
+				metadata.Height = uint64(binary.BigEndian.Uint32(data[4:8]))
+			}
+
+		case "eXIf":
+			if length <= headerSizeMax {
+				readEXIF(readAt(reader, offset+8, length, size), metadata)
+			}
+
+		case "IDAT", "IEND": // The metadata is expected before the image data.
+			return
+		}
+
+		offset += 12 + length
+	}
+}
+
+// readWebP reads the dimensions of a WebP picture. The first chunk is either VP8 (lossy), VP8L (lossless) or VP8X (extended).
+func readWebP(reader io.ReaderAt, size int64, metadata *Metadata) {
+	data := readAt(reader, 12, 18, size)
+	if len(data) < 18 {
+		return
+	}
+
+	switch string(data[0:4]) {
+	case "VP8 ":
+		if bytes.Equal(data[11:14], []byte{0x9D, 0x01, 0x2A}) {
+			metadata.Width = uint64(binary.LittleEndian.Uint16(data[14:16]) & 0x3FFF)
+			metadata.Height = uint64(binary.LittleEndian.Uint16(data[16:18]) & 0x3FFF)
+		}
+
+	case "VP8L":
+		if data[8] == 0x2F {
+			bits := binary.LittleEndian.Uint32(data[9:13])
+			metadata.Width = uint64(bits&0x3FFF) + 1
+			metadata.Height = uint64((bits>>14)&0x3FFF) + 1
+		}
+
+	case "VP8X":
+		metadata.Width = uint64(uint32(data[12])|uint32(data[13])<<8|uint32(data[14])<<16) + 1
+		metadata.Height = uint64(uint32(data[15])|uint32(data[16])<<8|uint32(data[17])<<16) + 1
+	}
+}
+
+// ---- EXIF ----
+
+// exifEntry is a single decoded IFD entry.
+type exifEntry struct {
+	Type  uint16
+	Count uint32
+	Data  []byte
+	order binary.ByteOrder
+}
+
+// exifTypeSize returns the size of a single value of the type. 0 if the type is unknown.
+func exifTypeSize(Type uint16) int {
+	switch Type {
+	case 1, 2, 6, 7: // BYTE, ASCII, SBYTE, UNDEFINED
+		return 1
+	case 3, 8: // SHORT, SSHORT
+		return 2
+	case 4, 9, 11: // LONG, SLONG, FLOAT
+		return 4
+	case 5, 10, 12: // RATIONAL, SRATIONAL, DOUBLE
+		return 8
+	default:
+		return 0
+	}
+}
+
+// readEXIF reads the metadata from EXIF data in TIFF format.
+func readEXIF(data []byte, metadata *Metadata) {
+	if len(data) < 8 {
+		return
+	}
+
+	var order binary.ByteOrder
+	switch string(data[0:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return
+	}
+
+	ifd0 := readIFD(data, order, order.Uint32(data[4:8]))
+
+	if width, ok := ifd0[0x0100].number(); ok {
+		metadata.Width = width
+	}
+	if height, ok := ifd0[0x0101].number(); ok {
+		metadata.Height = height
+	}
+
+	cameraMake := ifd0[0x010F].text()
+	model := ifd0[0x0110].text()
+	if strings.HasPrefix(strings.ToLower(model), strings.ToLower(cameraMake)) { // Many cameras repeat the make in the model.
+		cameraMake = ""
+	}
+	metadata.Camera = strings.TrimSpace(cameraMake + " " + model)
+
+	if offset, ok := ifd0[0x8769].number(); ok {
+		exif := readIFD(data, order, uint32(offset))
+
+		if date, err := time.Parse("2006:01:02 15:04:05", exif[0x9003].text()); err == nil {
+			metadata.DateCreated = date
+		}
+		if width, ok := exif[0xA002].number(); ok {
+			metadata.Width = width
+		}
+		if height, ok := exif[0xA003].number(); ok {
+			metadata.Height = height
+		}
+	}
+
+	if offset, ok := ifd0[0x8825].number(); ok {
+		gps := readIFD(data, order, uint32(offset))
+
+		latitude, ok1 := gps[0x0002].degrees()
+		longitude, ok2 := gps[0x0004].degrees()
+		if ok1 && ok2 {
+			if gps[0x0001].text() == "S" {
+				latitude = -latitude
+			}
+			if gps[0x0003].text() == "W" {
+				longitude = -longitude
+			}
+
+			metadata.HasGPS = true
+			metadata.Latitude = latitude
+			metadata.Longitude = longitude
+		}
+	}
+}
+
+// readIFD reads all entries of the IFD at the offset. Invalid entries are skipped.
+func readIFD(data []byte, order binary.ByteOrder, offset uint32) (entries map[uint16]*exifEntry) {
+	entries = make(map[uint16]*exifEntry)
+
+	if offset < 8 || uint64(offset)+2 > uint64(len(data)) {
+		return entries
+	}
+
+	count := int(order.Uint16(data[offset : offset+2]))
+	start := int(offset) + 2
+
+	for n := 0; n < count; n++ {
+		index := start + n*12
+		if index+12 > len(data) {
+			break
+		}
+
+		entry := &exifEntry{Type: order.Uint16(data[index+2 : index+4]), Count: order.Uint32(data[index+4 : index+8]), order: order}
+		length := uint64(exifTypeSize(entry.Type)) * uint64(entry.Count)
+		if length == 0 {
+			continue
+		}
+
+		if length <= 4 {
+			entry.Data = data[index+8 : index+8+int(length)]
+		} else if valueOffset := uint64(order.Uint32(data[index+8 : index+12])); valueOffset+length <= uint64(len(data)) {
+			entry.Data = data[valueOffset : valueOffset+length]
+		} else {
+			continue
+		}
+
+		entries[order.Uint16(data[index:index+2])] = entry
+	}
+
+	return entries
+}
+
+// text returns the entry as text. Trailing zeros and spaces are removed.
+func (entry *exifEntry) text() string {
+	if entry == nil || entry.Type != 2 {
+		return ""
+	}
+
+	return strings.TrimSpace(strings.TrimRight(string(entry.Data), "\x00"))
+}
+
+// number returns the first value of a SHORT or LONG entry.
+func (entry *exifEntry) number() (value uint64, ok bool) {
+	if entry == nil {
+		return 0, false
+	}
+
+	switch entry.Type {
+	case 3:
+		return uint64(entry.order.Uint16(entry.Data[0:2])), true
+	case 4:
+		return uint64(entry.order.Uint32(entry.Data[0:4])), true
+	}
+
+	return 0, false
+}
+
+// degrees returns the value of a GPS coordinate stored as 3 rationals (degrees, minutes, seconds) in decimal degrees.
+func (entry *exifEntry) degrees() (value float64, ok bool) {
+	if entry == nil || entry.Type != 5 || entry.Count != 3 {
+		return 0, false
+	}
+
+	divisor := 1.0
+	for n := 0; n < 3; n++ {
+		numerator := entry.order.Uint32(entry.Data[n*8 : n*8+4])
+		denominator := entry.order.Uint32(entry.Data[n*8+4 : n*8+8])
+		if denominator == 0 {
+			return 0, false
+		}
+
+		value += float64(numerator) / float64(denominator) / divisor
+		divisor *= 60
+	}
+
+	return value, value <= 180
+}
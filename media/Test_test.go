@@ -0,0 +1,458 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// ---- EXIF ----
+
+// tiffEntry is an IFD entry used to build TIFF data. If ifd is set, the value is the offset of that IFD (starting with 1).
+type tiffEntry struct {
+	tag   uint16
+	Type  uint16
+	count uint32
+	value []byte
+	ifd   int
+}
+
+func exifASCII(tag uint16, text string) tiffEntry {
+	return tiffEntry{tag: tag, Type: 2, count: uint32(len(text) + 1), value: append([]byte(text), 0)}
+}
+
+func exifShort(order binary.ByteOrder, tag uint16, value uint16) tiffEntry {
+	data := make([]byte, 2)
+	order.PutUint16(data, value)
+	return tiffEntry{tag: tag, Type: 3, count: 1, value: data}
+}
+
+func exifLong(order binary.ByteOrder, tag uint16, value uint32) tiffEntry {
+	data := make([]byte, 4)
+	order.PutUint32(data, value)
+	return tiffEntry{tag: tag, Type: 4, count: 1, value: data}
+}
+
+// exifRational creates a RATIONAL entry from numerator and denominator pairs.
+func exifRational(order binary.ByteOrder, tag uint16, values ...uint32) tiffEntry {
+	data := make([]byte, 4*len(values))
+	for n, value := range values {
+		order.PutUint32(data[n*4:], value)
+	}
+	return tiffEntry{tag: tag, Type: 5, count: uint32(len(values) / 2), value: data}
+}
+
+func exifPointer(tag uint16, ifd int) tiffEntry {
+	return tiffEntry{tag: tag, Type: 4, count: 1, ifd: ifd}
+}
+
+// buildTIFF creates TIFF data with the IFDs following the header. Values that do not fit into an entry are appended after the IFDs.
+func buildTIFF(order binary.ByteOrder, ifds ...[]tiffEntry) (data []byte) {
+	offsets := make([]uint32, len(ifds))
+	offset := uint32(8)
+	for n, ifd := range ifds {
+		offsets[n] = offset
+		offset += 2 + 12*uint32(len(ifd)) + 4
+	}
+
+	data = make([]byte, offset)
+	if order == binary.LittleEndian {
+		copy(data, "II*\x00")
+	} else {
+		copy(data, "MM\x00*")
+	}
+	order.PutUint32(data[4:8], 8)
+
+	for n, ifd := range ifds {
+		order.PutUint16(data[offsets[n]:], uint16(len(ifd)))
+
+		for m, entry := range ifd {
+			index := offsets[n] + 2 + 12*uint32(m)
+			order.PutUint16(data[index:], entry.tag)
+			order.PutUint16(data[index+2:], entry.Type)
+			order.PutUint32(data[index+4:], entry.count)
+
+			value := entry.value
+			if entry.ifd > 0 {
+				value = make([]byte, 4)
+				order.PutUint32(value, offsets[entry.ifd-1])
+			}
+
+			if len(value) <= 4 {
+				copy(data[index+8:], value)
+			} else {
+				order.PutUint32(data[index+8:], uint32(len(data)))
+				data = append(data, value...)
+			}
+		}
+	}
+
+	return data
+}
+
+// buildJPEG creates a JPEG file with an optional APP1 segment containing the EXIF data and a SOF0 segment.
+func buildJPEG(width, height uint16, exif []byte) (data []byte) {
+	data = []byte{0xFF, 0xD8}
+
+	if exif != nil {
+		segment := append([]byte("Exif\x00\x00"), exif...)
+		data = append(data, 0xFF, 0xE1, byte((len(segment)+2)>>8), byte(len(segment)+2))
+		data = append(data, segment...)
+	}
+
+	sof := make([]byte, 15)
+	sof[0] = 8
+	binary.BigEndian.PutUint16(sof[1:3], height)
+	binary.BigEndian.PutUint16(sof[3:5], width)
+	data = append(data, 0xFF, 0xC0, 0, 17)
+	data = append(data, sof...)
+
+	return append(data, 0xFF, 0xDA, 0, 2, 0xFF, 0xD9)
+}
+
+// buildPNG creates a PNG file with an IHDR and an optional eXIf chunk. The CRC is not checked and left empty.
+func buildPNG(width, height uint32, exif []byte) (data []byte) {
+	chunk := func(chunkType string, content []byte) []byte {
+		header := make([]byte, 4, 8)
+		binary.BigEndian.PutUint32(header, uint32(len(content)))
+		header = append(header, chunkType...)
+		return append(append(header, content...), 0, 0, 0, 0)
+	}
+
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:4], width)
+	binary.BigEndian.PutUint32(ihdr[4:8], height)
+	ihdr[8], ihdr[9] = 8, 2
+
+	data = append([]byte("\x89PNG\r\n\x1a\n"), chunk("IHDR", ihdr)...)
+	if exif != nil {
+		data = append(data, chunk("eXIf", exif)...)
+	}
+
+	return append(data, chunk("IEND", nil)...)
+}
+
+func TestReadPicture(t *testing.T) {
+	le, be := binary.LittleEndian, binary.BigEndian
+
+	exifCanon := buildTIFF(le,
+		[]tiffEntry{exifShort(le, 0x0100, 4000), exifShort(le, 0x0101, 3000), exifASCII(0x010F, "Canon"), exifASCII(0x0110, "Canon EOS 5D"), exifPointer(0x8769, 2), exifPointer(0x8825, 3)},
+		[]tiffEntry{exifASCII(0x9003, "2021:05:06 07:08:09")},
+		[]tiffEntry{exifASCII(0x0001, "N"), exifRational(le, 0x0002, 48, 1, 15, 1, 0, 1), exifASCII(0x0003, "W"), exifRational(le, 0x0004, 16, 1, 22, 1, 30, 1)},
+	)
+
+	exifApple := buildTIFF(be,
+		[]tiffEntry{exifShort(be, 0x0100, 640), exifShort(be, 0x0101, 480), exifASCII(0x010F, "Apple"), exifASCII(0x0110, "iPhone 12"), exifPointer(0x8769, 2)},
+		[]tiffEntry{exifLong(be, 0xA002, 1920), exifLong(be, 0xA003, 1080)},
+	)
+
+	exifSouth := buildTIFF(le,
+		[]tiffEntry{exifASCII(0x0110, "Pixel 6"), exifPointer(0x8825, 2)},
+		[]tiffEntry{exifASCII(0x0001, "S"), exifRational(le, 0x0002, 33, 1, 51, 1, 36, 1), exifASCII(0x0003, "E"), exifRational(le, 0x0004, 151, 1, 12, 1, 36, 1)},
+	)
+
+	// The GPS IFD offset is beyond the data, the coordinates with a zero denominator are invalid.
+	exifInvalid := buildTIFF(le,
+		[]tiffEntry{exifShort(le, 0x0100, 100), exifLong(le, 0x8825, 0xFFFF)},
+	)
+	exifZero := buildTIFF(le,
+		[]tiffEntry{exifPointer(0x8825, 2)},
+		[]tiffEntry{exifRational(le, 0x0002, 48, 0, 0, 1, 0, 1), exifRational(le, 0x0004, 16, 1, 0, 1, 0, 1)},
+	)
+
+	tests := []struct {
+		name     string
+		data     []byte
+		expected Metadata
+	}{
+		{"JPEG without EXIF", buildJPEG(800, 600, nil), Metadata{Width: 800, Height: 600}},
+		{"JPEG with EXIF", buildJPEG(640, 480, exifCanon), Metadata{Width: 640, Height: 480, Camera: "Canon EOS 5D", DateCreated: time.Date(2021, 5, 6, 7, 8, 9, 0, time.UTC), HasGPS: true, Latitude: 48.25, Longitude: -16.375}},
+		{"TIFF big endian", exifApple, Metadata{Width: 1920, Height: 1080, Camera: "Apple iPhone 12"}},
+		{"PNG with eXIf", buildPNG(100, 50, exifSouth), Metadata{Width: 100, Height: 50, Camera: "Pixel 6", HasGPS: true, Latitude: -33.86, Longitude: 151.21}},
+		{"invalid GPS offset", exifInvalid, Metadata{Width: 100}},
+		{"zero denominator", exifZero, Metadata{}},
+		{"truncated EXIF", buildJPEG(10, 20, exifCanon[:40]), Metadata{Width: 10, Height: 20}},
+	}
+
+	for _, test := range tests {
+		checkRead(t, test.name, test.data, test.expected)
+	}
+}
+
+// checkRead reads the metadata from the data and compares it with the expected one. Coordinates are compared with a tolerance.
+func checkRead(t *testing.T, name string, data []byte, expected Metadata) {
+	metadata, err := Read(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Errorf("%s: error %v\n", name, err)
+		return
+	}
+
+	if math.Abs(metadata.Latitude-expected.Latitude) < 1e-9 && math.Abs(metadata.Longitude-expected.Longitude) < 1e-9 {
+		metadata.Latitude, metadata.Longitude = expected.Latitude, expected.Longitude
+	}
+
+	if !reflect.DeepEqual(*metadata, expected) {
+		t.Errorf("%s: metadata %+v\nexpected %+v\n", name, *metadata, expected)
+	}
+}
+
+// ---- ID3 ----
+
+func encodeSyncsafe(value uint32) []byte {
+	return []byte{byte(value>>21) & 0x7F, byte(value>>14) & 0x7F, byte(value>>7) & 0x7F, byte(value) & 0x7F}
+}
+
+// id3Frame creates a frame in the format of the ID3v2 version.
+func id3Frame(version byte, id string, content []byte) (frame []byte) {
+	frame = []byte(id)
+
+	switch version {
+	case 2:
+		frame = append(frame, byte(len(content)>>16), byte(len(content)>>8), byte(len(content)))
+	case 3:
+		frame = append(frame, byte(len(content)>>24), byte(len(content)>>16), byte(len(content)>>8), byte(len(content)), 0, 0)
+	case 4:
+		frame = append(append(frame, encodeSyncsafe(uint32(len(content)))...), 0, 0)
+	}
+
+	return append(frame, content...)
+}
+
+// id3Tag creates an ID3v2 tag containing the frames.
+func id3Tag(version byte, frames ...[]byte) (tag []byte) {
+	content := bytes.Join(frames, nil)
+	tag = append([]byte{'I', 'D', '3', version, 0, 0}, encodeSyncsafe(uint32(len(content)))...)
+	return append(tag, content...)
+}
+
+// id3v1Tag creates an ID3v1 tag.
+func id3v1Tag(title, artist, album string) (tag []byte) {
+	tag = make([]byte, 128)
+	copy(tag, "TAG")
+	copy(tag[3:33], title)
+	copy(tag[33:63], artist)
+	copy(tag[63:93], album)
+	return tag
+}
+
+// mpegAudio creates MPEG-1 layer III audio data at 128 kbit/s and 44100 Hz. The first frame contains a Xing header if frames is not 0.
+func mpegAudio(size int, frames uint32) (data []byte) {
+	data = make([]byte, size)
+	copy(data, []byte{0xFF, 0xFB, 0x90, 0x00})
+
+	if frames > 0 {
+		copy(data[36:], "Xing")
+		data[43] = 0x01
+		binary.BigEndian.PutUint32(data[44:48], frames)
+	}
+
+	return data
+}
+
+func TestReadAudio(t *testing.T) {
+	utf16Artist := []byte{1, 0xFF, 0xFE, 'A', 0, 'r', 0, 't', 0, 'i', 0, 's', 0, 't', 0}
+	front := []byte("front cover")
+	other := []byte("other picture")
+
+	join := func(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+
+	tests := []struct {
+		name     string
+		data     []byte
+		expected Metadata
+	}{
+		{"ID3v2.3", id3Tag(3,
+			id3Frame(3, "TIT2", []byte("\x00Caf\xe9")),
+			id3Frame(3, "TPE1", utf16Artist),
+			id3Frame(3, "TALB", []byte("\x03Album")),
+			id3Frame(3, "TLEN", []byte("\x00185000")),
+			id3Frame(3, "APIC", join([]byte("\x00image/png\x00\x00\x00"), other)),
+			id3Frame(3, "APIC", join([]byte("\x00image/jpeg\x00\x03Cover\x00"), front)),
+		), Metadata{Title: "Café", Artist: "Artist", Album: "Album", Duration: 185, Picture: front}},
+
+		{"ID3v2.4 with Xing header", join(id3Tag(4,
+			id3Frame(4, "TIT2", []byte("\x03Title")),
+			id3Frame(4, "APIC", join([]byte("\x01image/jpeg\x00\x03\xff\xfeC\x00\x00\x00"), front)),
+		), mpegAudio(1000, 100)), Metadata{Title: "Title", Duration: 100 * 1152 / 44100, Picture: front}},
+
+		{"ID3v2.2", id3Tag(2,
+			id3Frame(2, "TT2", []byte("\x00Title 2")),
+			id3Frame(2, "TP1", []byte("\x00Artist 2")),
+			id3Frame(2, "TAL", []byte("\x00Album 2")),
+			id3Frame(2, "PIC", join([]byte("\x00JPG\x03\x00"), front)),
+		), Metadata{Title: "Title 2", Artist: "Artist 2", Album: "Album 2", Picture: front}},
+
+		{"ID3v1 with constant bitrate", join(mpegAudio(16000, 0), id3v1Tag("Title 1", "Artist 1", "Album 1")), Metadata{Title: "Title 1", Artist: "Artist 1", Album: "Album 1", Duration: 1}},
+
+		{"ID3v2 preferred over ID3v1", join(id3Tag(3, id3Frame(3, "TIT2", []byte("\x00Title 2"))), mpegAudio(32000, 0), id3v1Tag("Title 1", "Artist 1", "")), Metadata{Title: "Title 2", Artist: "Artist 1", Duration: 2}},
+
+		{"invalid frame size", id3Tag(3, id3Frame(3, "TIT2", []byte("\x00Title"))[:12]), Metadata{}},
+	}
+
+	for _, test := range tests {
+		checkRead(t, test.name, test.data, test.expected)
+	}
+}
+
+// ---- MP4 ----
+
+// mp4Box creates a box with the content.
+func mp4Box(boxType string, content ...[]byte) (box []byte) {
+	data := bytes.Join(content, nil)
+	box = make([]byte, 4, 8+len(data))
+	binary.BigEndian.PutUint32(box, uint32(8+len(data)))
+	return append(append(box, boxType...), data...)
+}
+
+// mp4LargeBox creates a box with a 64-bit size.
+func mp4LargeBox(boxType string, content []byte) (box []byte) {
+	box = append([]byte{0, 0, 0, 1}, boxType...)
+	box = append(box, make([]byte, 8)...)
+	binary.BigEndian.PutUint64(box[8:16], uint64(16+len(content)))
+	return append(box, content...)
+}
+
+// mp4Header creates a movie header box in version 0 or 1.
+func mp4Header(version byte, timeScale uint32, duration uint64) []byte {
+	if version == 0 {
+		content := make([]byte, 100)
+		binary.BigEndian.PutUint32(content[12:16], timeScale)
+		binary.BigEndian.PutUint32(content[16:20], uint32(duration))
+		return mp4Box("mvhd", content)
+	}
+
+	content := make([]byte, 112)
+	content[0] = 1
+	binary.BigEndian.PutUint32(content[20:24], timeScale)
+	binary.BigEndian.PutUint64(content[24:32], duration)
+	return mp4Box("mvhd", content)
+}
+
+// mp4Track creates a track with a version 0 track header containing the dimensions.
+func mp4Track(width, height uint32) []byte {
+	content := make([]byte, 84)
+	binary.BigEndian.PutUint32(content[76:80], width<<16)
+	binary.BigEndian.PutUint32(content[80:84], height<<16)
+	return mp4Box("trak", mp4Box("tkhd", content))
+}
+
+// mp4Item creates an iTunes metadata item.
+func mp4Item(itemType string, value []byte) []byte {
+	return mp4Box(itemType, mp4Box("data", []byte{0, 0, 0, 1, 0, 0, 0, 0}, value))
+}
+
+func TestReadVideo(t *testing.T) {
+	ftyp := mp4Box("ftyp", []byte("isom\x00\x00\x00\x00isom"))
+	cover := []byte("cover")
+
+	udta := mp4Box("udta", mp4Box("meta", []byte{0, 0, 0, 0}, mp4Box("ilst",
+		mp4Item("\xa9nam", []byte("Movie")),
+		mp4Item("\xa9ART", []byte("Director")),
+		mp4Item("\xa9alb", []byte("Series")),
+		mp4Item("covr", cover),
+	)))
+
+	tests := []struct {
+		name     string
+		data     []byte
+		expected Metadata
+	}{
+		{"MP4", bytes.Join([][]byte{ftyp, mp4Box("moov", mp4Header(0, 1000, 95000), mp4Track(0, 0), mp4Track(1920, 1080), udta), mp4Box("mdat", make([]byte, 100))}, nil),
+			Metadata{Title: "Movie", Artist: "Director", Album: "Series", Duration: 95, Width: 1920, Height: 1080, Picture: cover}},
+
+		{"MP4 movie after large media data", bytes.Join([][]byte{ftyp, mp4LargeBox("mdat", make([]byte, 100)), mp4Box("moov", mp4Header(1, 600, 600*3600), mp4Track(640, 360))}, nil),
+			Metadata{Duration: 3600, Width: 640, Height: 360}},
+
+		{"MP4 truncated movie", bytes.Join([][]byte{ftyp, mp4Box("moov", mp4Header(0, 1000, 95000))[:50]}, nil), Metadata{}},
+
+		{"Matroska", bytes.Join([][]byte{
+			ebmlTest(0x1A45DFA3, ebmlTest(0x4282, []byte("webm"))),
+			{0x18, 0x53, 0x80, 0x67, 0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}, // segment with unknown size
+			ebmlTest(ebmlInfo, ebmlTest(ebmlTimecodeScale, []byte{0x0F, 0x42, 0x40}), ebmlTest(ebmlDuration, ebmlFloat64(65000)), ebmlTest(ebmlTitle, []byte("Matroska Title"))),
+			ebmlTest(ebmlTracks, ebmlTest(ebmlTrackEntry, ebmlTest(0x83, []byte{2})), ebmlTest(ebmlTrackEntry, ebmlTest(ebmlVideo, ebmlTest(ebmlPixelWidth, []byte{0x05, 0x00}), ebmlTest(ebmlPixelHeight, []byte{0x02, 0xD0})))),
+			ebmlTest(ebmlCluster, make([]byte, 200)),
+			ebmlTest(ebmlInfo, ebmlTest(ebmlTitle, []byte("After Cluster"))),
+		}, nil), Metadata{Title: "Matroska Title", Duration: 65, Width: 1280, Height: 720}},
+
+		{"Matroska float32 duration", bytes.Join([][]byte{
+			ebmlTest(0x1A45DFA3, ebmlTest(0x4282, []byte("matroska"))),
+			ebmlTest(ebmlSegment, ebmlTest(ebmlInfo, ebmlTest(ebmlTimecodeScale, []byte{0x3B, 0x9A, 0xCA, 0x00}), ebmlTest(ebmlDuration, ebmlFloat32(120)))),
+		}, nil), Metadata{Duration: 120}},
+	}
+
+	for _, test := range tests {
+		checkRead(t, test.name, test.data, test.expected)
+	}
+}
+
+// ---- Matroska ----
+
+// ebmlTest creates an element. The size uses 1 byte if possible, otherwise 8 bytes.
+func ebmlTest(id uint64, content ...[]byte) (element []byte) {
+	for shift := 24; shift >= 0; shift -= 8 {
+		if b := byte(id >> shift); b != 0 || len(element) > 0 {
+			element = append(element, b)
+		}
+	}
+
+	data := bytes.Join(content, nil)
+	if len(data) < 0x7F {
+		element = append(element, 0x80|byte(len(data)))
+	} else {
+		size := make([]byte, 8)
+		binary.BigEndian.PutUint64(size, uint64(len(data)))
+		size[0] = 0x01
+		element = append(element, size...)
+	}
+
+	return append(element, data...)
+}
+
+func ebmlFloat64(value float64) []byte {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, math.Float64bits(value))
+	return data
+}
+
+func ebmlFloat32(value float32) []byte {
+	data := make([]byte, 4)
+	binary.BigEndian.PutUint32(data, math.Float32bits(value))
+	return data
+}
+
+func TestEBMLVint(t *testing.T) {
+	tests := []struct {
+		data       []byte
+		keepMarker bool
+		value      uint64
+		length     int
+		unknown    bool
+	}{
+		{[]byte{0x81}, false, 1, 1, false},
+		{[]byte{0x40, 0x02}, false, 2, 2, false},
+		{[]byte{0x1A, 0x45, 0xDF, 0xA3}, true, 0x1A45DFA3, 4, false},
+		{[]byte{0x1A, 0x45, 0xDF, 0xA3}, false, 0x0A45DFA3, 4, false},
+		{[]byte{0xFF}, false, 0x7F, 1, true},
+		{[]byte{0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}, false, 1<<56 - 1, 8, true},
+		{[]byte{0x00}, false, 0, 0, false},
+		{[]byte{0x20, 0x01}, false, 0, 0, false}, // truncated
+	}
+
+	for _, test := range tests {
+		value, length, unknown := ebmlVint(test.data, test.keepMarker)
+		if value != test.value || length != test.length || unknown != test.unknown {
+			t.Errorf("ebmlVint %x: %x, %d, %v\n", test.data, value, length, unknown)
+		}
+	}
+}
+
+func TestReadUnsupported(t *testing.T) {
+	for _, data := range [][]byte{[]byte("plain text file"), []byte("ID3"), []byte("RIFF\x00\x00\x00\x00TEXT")} {
+		if _, err := Read(bytes.NewReader(data), int64(len(data))); err != ErrUnsupported {
+			t.Errorf("Read %q: error %v\n", data, err)
+		}
+	}
+}
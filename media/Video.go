@@ -0,0 +1,431 @@
+/*
+File Username:  Video.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+RIFF (WAV, AVI): Chunks with a 4 character ID and 4 byte little endian size. LIST chunks contain a 4 character type followed by sub chunks.
+    The AVI main header "avih" contains the microseconds per frame (offset 0), the total count of frames (offset 16), the width (offset 32) and height (offset 36).
+    The WAV format chunk "fmt " contains the bytes per second (offset 8). The duration is the size of the "data" chunk divided by it.
+
+MP4: Boxes with a 4 byte big endian size and 4 character type. A size of 1 indicates a 64-bit size following the type, 0 that the box extends to the end of the file.
+    moov/mvhd   Time scale and duration
+    moov/trak/tkhd   Width and height of video tracks as 16.16 fixed point numbers
+    moov/udta/meta/ilst   iTunes metadata: ©nam title, ©ART artist, ©alb album, covr cover art
+
+Matroska: EBML elements with variable length IDs and sizes.
+    Segment/Info   TimecodeScale (default 1 ms), Duration (float, in timecode scale units), Title
+    Segment/Tracks/TrackEntry/Video   PixelWidth, PixelHeight
+*/
+
+package media
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"strings"
+)
+
+// ---- RIFF ----
+
+// readRIFF reads the headers of a WAV or AVI file.
+func readRIFF(reader io.ReaderAt, size int64, metadata *Metadata) {
+	var bytesPerSecond, dataSize uint64
+
+	walkRIFF(reader, 12, size, 0, func(id string, offset, length int64) {
+		switch id {
+		case "avih":
+			if data := readAt(reader, offset, 40, size); len(data) == 40 {
+				microSecPerFrame := uint64(binary.LittleEndian.Uint32(data[0:4]))
+				frames := uint64(binary.LittleEndian.Uint32(data[16:20]))
+				metadata.Duration = frames * microSecPerFrame / 1000000
+				metadata.Width = uint64(binary.LittleEndian.Uint32(data[32:36]))
+				metadata.Height = uint64(binary.LittleEndian.Uint32(data[36:40]))
+			}
+
+		case "fmt ":
+			if data := readAt(reader, offset, 12, size); len(data) == 12 {
+				bytesPerSecond = uint64(binary.LittleEndian.Uint32(data[8:12]))
+			}
+
+		case "data":
+			dataSize = uint64(length)
+
+		case "INAM":
+			metadata.Title = riffText(readAt(reader, offset, length, size))
+		case "IART":
+			metadata.Artist = riffText(readAt(reader, offset, length, size))
+		case "IPRD":
+			metadata.Album = riffText(readAt(reader, offset, length, size))
+		}
+	})
+
+	if bytesPerSecond > 0 && dataSize > 0 {
+		metadata.Duration = dataSize / bytesPerSecond
+	}
+}
+
+// walkRIFF calls the callback for each chunk between offset and end. It descends into the LIST chunks hdrl and INFO. The movie data is skipped.
+func walkRIFF(reader io.ReaderAt, offset, end int64, depth int, callback func(id string, offset, length int64)) {
+	for n := 0; n < 1000 && offset+8 <= end; n++ {
+		header := readAt(reader, offset, 12, end)
+		if len(header) < 8 {
+			return
+		}
+
+		id := string(header[0:4])
+		length := int64(binary.LittleEndian.Uint32(header[4:8]))
+
+		if id == "LIST" && len(header) == 12 && depth < 4 {
+			switch string(header[8:12]) {
+			case "hdrl", "INFO":
+				walkRIFF(reader, offset+12, offset+8+length, depth+1, callback)
+			}
+		} else {
+			callback(id, offset+8, length)
+		}
+
+		offset += 8 + length + length%2 // chunks are padded to even sizes
+	}
+}
+
+// riffText decodes a text chunk, which is zero terminated.
+func riffText(data []byte) string {
+	if index := strings.IndexByte(string(data), 0); index >= 0 {
+		data = data[:index]
+	}
+
+	return strings.TrimSpace(string(data))
+}
+
+// ---- MP4 ----
+
+// readMP4 reads the movie box of an MP4 file. It may be stored after the media data.
+func readMP4(reader io.ReaderAt, size int64, metadata *Metadata) {
+	walkMP4Reader(reader, 0, size, func(boxType string, offset, length int64) bool {
+		if boxType != "moov" {
+			return false
+		}
+
+		if length <= headerSizeMax {
+			readMP4Movie(readAt(reader, offset, length, size), metadata)
+		}
+		return true
+	})
+}
+
+// walkMP4Reader calls the callback for each top-level box. The callback returns true to stop.
+func walkMP4Reader(reader io.ReaderAt, offset, end int64, callback func(boxType string, offset, length int64) bool) {
+	for n := 0; n < 1000 && offset+8 <= end; n++ {
+		header := readAt(reader, offset, 16, end)
+		if len(header) < 8 {
+			return
+		}
+
+		boxSize := int64(binary.BigEndian.Uint32(header[0:4]))
+		headerSize := int64(8)
+		switch boxSize {
+		case 0:
+			boxSize = end - offset
+		case 1:
+			if len(header) < 16 {
+				return
+			}
+			boxSize = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerSize = 16
+		}
+		if boxSize < headerSize || offset+boxSize > end {
+			return
+		}
+
+		if callback(string(header[4:8]), offset+headerSize, boxSize-headerSize) {
+			return
+		}
+
+		offset += boxSize
+	}
+}
+
+// walkMP4 calls the callback for each box in the data.
+func walkMP4(data []byte, callback func(boxType string, box []byte)) {
+	for len(data) >= 8 {
+		boxSize := uint64(binary.BigEndian.Uint32(data[0:4]))
+		headerSize := uint64(8)
+		switch boxSize {
+		case 0:
+			boxSize = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return
+			}
+			boxSize = binary.BigEndian.Uint64(data[8:16])
+			headerSize = 16
+		}
+		if boxSize < headerSize || boxSize > uint64(len(data)) {
+			return
+		}
+
+		callback(string(data[4:8]), data[headerSize:boxSize])
+		data = data[boxSize:]
+	}
+}
+
+// readMP4Movie reads the content of the movie box.
+func readMP4Movie(moov []byte, metadata *Metadata) {
+	walkMP4(moov, func(boxType string, box []byte) {
+		switch boxType {
+		case "mvhd":
+			var timeScale, duration uint64
+			if len(box) >= 20 && box[0] == 0 {
+				timeScale = uint64(binary.BigEndian.Uint32(box[12:16]))
+				duration = uint64(binary.BigEndian.Uint32(box[16:20]))
+			} else if len(box) >= 32 && box[0] == 1 {
+				timeScale = uint64(binary.BigEndian.Uint32(box[20:24]))
+				duration = binary.BigEndian.Uint64(box[24:32])
+			}
+			if timeScale > 0 {
+				metadata.Duration = duration / timeScale
+			}
+
+		case "trak":
+			walkMP4(box, func(boxType string, box []byte) {
+				if boxType != "tkhd" || metadata.Width != 0 {
+					return
+				}
+
+				// Width and height are the last 8 bytes. Audio tracks have zero dimensions.
+				var offset int
+				if len(box) >= 84 && box[0] == 0 {
+					offset = 76
+				} else if len(box) >= 96 && box[0] == 1 {
+					offset = 88
+				} else {
+					return
+				}
+
+				metadata.Width = uint64(binary.BigEndian.Uint32(box[offset:offset+4]) >> 16)
+				metadata.Height = uint64(binary.BigEndian.Uint32(box[offset+4:offset+8]) >> 16)
+			})
+
+		case "udta":
+			walkMP4(box, func(boxType string, box []byte) {
+				if boxType != "meta" || len(box) < 4 {
+					return
+				}
+
+				// The meta box starts with version and flags.
+				walkMP4(box[4:], func(boxType string, box []byte) {
+					if boxType == "ilst" {
+						readMP4Items(box, metadata)
+					}
+				})
+			})
+		}
+	})
+}
+
+// readMP4Items reads the iTunes metadata items. The value is stored in a data box: Type (4 bytes), locale (4 bytes), value.
+func readMP4Items(ilst []byte, metadata *Metadata) {
+	walkMP4(ilst, func(itemType string, item []byte) {
+		var value string
+		var data []byte
+		walkMP4(item, func(boxType string, box []byte) {
+			if boxType == "data" && len(box) >= 8 && data == nil {
+				data = box[8:]
+				value = strings.TrimSpace(string(data))
+			}
+		})
+
+		switch itemType {
+		case "\xa9nam":
+			metadata.Title = value
+		case "\xa9ART":
+			metadata.Artist = value
+		case "\xa9alb":
+			metadata.Album = value
+		case "covr":
+			metadata.setPicture(pictureFrontCover, data)
+		}
+	})
+}
+
+// ---- Matroska ----
+
+// Matroska element IDs
+const (
+	ebmlSegment       = 0x18538067
+	ebmlInfo          = 0x1549A966
+	ebmlTimecodeScale = 0x2AD7B1
+	ebmlDuration      = 0x4489
+	ebmlTitle         = 0x7BA9
+	ebmlTracks        = 0x1654AE6B
+	ebmlTrackEntry    = 0xAE
+	ebmlVideo         = 0xE0
+	ebmlPixelWidth    = 0xB0
+	ebmlPixelHeight   = 0xBA
+	ebmlCluster       = 0x1F43B675
+)
+
+// readMatroska reads the segment info and tracks of a Matroska or WebM file. Reading stops at the first cluster, which contains the media data.
+func readMatroska(reader io.ReaderAt, size int64, metadata *Metadata) {
+	// skip the EBML header
+	id, length, headerSize := ebmlElementReader(reader, 0, size)
+	if id != 0x1A45DFA3 {
+		return
+	}
+	offset := headerSize + length
+
+	id, length, headerSize = ebmlElementReader(reader, offset, size)
+	if id != ebmlSegment {
+		return
+	}
+	offset += headerSize
+	end := size
+	if length >= 0 && offset+length < size {
+		end = offset + length
+	}
+
+	for n := 0; n < 1000 && offset < end; n++ {
+		id, length, headerSize = ebmlElementReader(reader, offset, end)
+		if headerSize == 0 || length < 0 || id == ebmlCluster {
+			return
+		}
+
+		switch id {
+		case ebmlInfo:
+			if length <= headerSizeMax {
+				readMatroskaInfo(readAt(reader, offset+headerSize, length, end), metadata)
+			}
+		case ebmlTracks:
+			if length <= headerSizeMax {
+				readMatroskaTracks(readAt(reader, offset+headerSize, length, end), metadata)
+			}
+		}
+
+		offset += headerSize + length
+	}
+}
+
+// readMatroskaInfo reads the segment info element.
+func readMatroskaInfo(data []byte, metadata *Metadata) {
+	timecodeScale := uint64(1000000) // nanoseconds
+	var duration float64
+
+	walkEBML(data, func(id uint64, value []byte) {
+		switch id {
+		case ebmlTimecodeScale:
+			timecodeScale = ebmlUint(value)
+		case ebmlDuration:
+			switch len(value) {
+			case 4:
+				duration = float64(math.Float32frombits(binary.BigEndian.Uint32(value)))
+			case 8:
+				duration = math.Float64frombits(binary.BigEndian.Uint64(value))
+			}
+		case ebmlTitle:
+			metadata.Title = strings.TrimSpace(string(value))
+		}
+	})
+
+	if duration > 0 && !math.IsInf(duration, 0) {
+		metadata.Duration = uint64(duration * float64(timecodeScale) / 1e9)
+	}
+}
+
+// readMatroskaTracks reads the dimensions of the first video track.
+func readMatroskaTracks(data []byte, metadata *Metadata) {
+	walkEBML(data, func(id uint64, entry []byte) {
+		if id != ebmlTrackEntry || metadata.Width != 0 {
+			return
+		}
+
+		walkEBML(entry, func(id uint64, video []byte) {
+			if id != ebmlVideo {
+				return
+			}
+
+			walkEBML(video, func(id uint64, value []byte) {
+				switch id {
+				case ebmlPixelWidth:
+					metadata.Width = ebmlUint(value)
+				case ebmlPixelHeight:
+					metadata.Height = ebmlUint(value)
+				}
+			})
+		})
+	})
+}
+
+// ebmlVint decodes a variable length integer. The count of leading zero bits of the first byte is the count of additional bytes.
+// The ID keeps the length marker bit, sizes do not. Unknown sizes (all value bits set) are returned as -1.
+func ebmlVint(data []byte, keepMarker bool) (value uint64, length int, unknown bool) {
+	if len(data) == 0 || data[0] == 0 {
+		return 0, 0, false
+	}
+
+	length = 1
+	for mask := byte(0x80); data[0]&mask == 0; mask >>= 1 {
+		length++
+	}
+	if length > len(data) || length > 8 {
+		return 0, 0, false
+	}
+
+	value = uint64(data[0])
+	if !keepMarker {
+		value &= uint64(0xFF >> length)
+	}
+	for n := 1; n < length; n++ {
+		value = value<<8 | uint64(data[n])
+	}
+
+	unknown = !keepMarker && value == (uint64(1)<<(7*length))-1
+	return value, length, unknown
+}
+
+// ebmlElement decodes the header of an element. The returned length is -1 if unknown.
+func ebmlElement(data []byte) (id uint64, length int64, headerSize int64) {
+	id, idLength, _ := ebmlVint(data, true)
+	if idLength == 0 {
+		return 0, 0, 0
+	}
+
+	size, sizeLength, unknown := ebmlVint(data[idLength:], false)
+	if sizeLength == 0 {
+		return 0, 0, 0
+	}
+	if unknown {
+		return id, -1, int64(idLength + sizeLength)
+	}
+	if size > math.MaxInt64/2 {
+		return 0, 0, 0
+	}
+
+	return id, int64(size), int64(idLength + sizeLength)
+}
+
+// ebmlElementReader decodes the header of an element at the offset.
+func ebmlElementReader(reader io.ReaderAt, offset, size int64) (id uint64, length int64, headerSize int64) {
+	return ebmlElement(readAt(reader, offset, 12, size))
+}
+
+// walkEBML calls the callback for each child element in the data.
+func walkEBML(data []byte, callback func(id uint64, value []byte)) {
+	for len(data) > 0 {
+		id, length, headerSize := ebmlElement(data)
+		if headerSize == 0 || length < 0 || headerSize+length > int64(len(data)) {
+			return
+		}
+
+		callback(id, data[headerSize:headerSize+length])
+		data = data[headerSize+length:]
+	}
+}
+
+// ebmlUint decodes an unsigned integer element.
+func ebmlUint(data []byte) (value uint64) {
+	for _, b := range data {
+		value = value<<8 | uint64(b)
+	}
+	return value
+}
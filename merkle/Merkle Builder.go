@@ -0,0 +1,147 @@
+/*
+File Username:  Merkle Builder.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+The builder creates the merkle tree incrementally from data written to it, so that the tree can be created while the data is streamed (for example during an upload).
+If the fragment size is not known in advance because the file size is unknown, the data is hashed for each possible fragment size.
+Fragment sizes are excluded as soon as the amount of written data requires a larger one. The right fragment size is selected at the end.
+*/
+
+package merkle
+
+import (
+	"errors"
+
+	"lukechampine.com/blake3"
+)
+
+// fragmentSizes is the list of all fragment sizes returned by CalculateFragmentSize in ascending order.
+var fragmentSizes = []uint64{256 * KB, 512 * KB, 1 * MB, 2 * MB, 8 * MB, 16 * MB, 32 * MB, 64 * MB, 128 * MB, 512 * MB, 1 * GB}
+
+// MerkleTreeBuilder creates a merkle tree from the data written to it.
+type MerkleTreeBuilder struct {
+	fileSize     uint64                 // Count of bytes written
+	fragmentSize uint64                 // Fixed fragment size. 0 if it is calculated from the file size.
+	candidates   []*fragmentHashBuilder // Hashes for each fragment size that is still possible
+}
+
+// fragmentHashBuilder hashes the data in fragments of a fixed size.
+type fragmentHashBuilder struct {
+	fragmentSize   uint64
+	fragmentFill   uint64         // Count of bytes in the current fragment
+	hasher         *blake3.Hasher // Hash of the current fragment
+	fragmentHashes [][]byte       // Hashes of all completed fragments
+}
+
+// NewMerkleTreeBuilder creates a new builder. If the fragment size is 0, it is calculated from the total size of the written data via CalculateFragmentSize.
+// Use a fixed fragment size if the file size is known, since hashing the data for all possible fragment sizes is more expensive.
+func NewMerkleTreeBuilder(fragmentSize uint64) (builder *MerkleTreeBuilder) {
+	builder = &MerkleTreeBuilder{fragmentSize: fragmentSize}
+
+	if fragmentSize > 0 {
+		builder.candidates = []*fragmentHashBuilder{newFragmentHashBuilder(fragmentSize)}
+	} else {
+		for _, size := range fragmentSizes {
+			builder.candidates = append(builder.candidates, newFragmentHashBuilder(size))
+		}
+	}
+
+	return builder
+}
+
+func newFragmentHashBuilder(fragmentSize uint64) *fragmentHashBuilder {
+	return &fragmentHashBuilder{fragmentSize: fragmentSize, hasher: blake3.New(32, nil)}
+}
+
+// Write hashes the data. It never fails. It implements the io.Writer interface.
+func (builder *MerkleTreeBuilder) Write(data []byte) (n int, err error) {
+	for _, candidate := range builder.candidates {
+		candidate.write(data)
+	}
+
+	builder.fileSize += uint64(len(data))
+
+	// Remove fragment sizes that are too small for the current file size. They are sorted ascending.
+	if builder.fragmentSize == 0 {
+		minimum := CalculateFragmentSize(builder.fileSize)
+		for len(builder.candidates) > 1 && builder.candidates[0].fragmentSize < minimum {
+			builder.candidates = builder.candidates[1:]
+		}
+	}
+
+	return len(data), nil
+}
+
+// write hashes the data and completes fragments as needed.
+func (fragments *fragmentHashBuilder) write(data []byte) {
+	for len(data) > 0 {
+		part := fragments.fragmentSize - fragments.fragmentFill
+		if part > uint64(len(data)) {
+			part = uint64(len(data))
+		}
+
+		fragments.hasher.Write(data[:part])
+		fragments.fragmentFill += part
+		data = data[part:]
+
+		if fragments.fragmentFill == fragments.fragmentSize {
+			fragments.fragmentHashes = append(fragments.fragmentHashes, fragments.hasher.Sum(nil))
+			fragments.hasher.Reset()
+			fragments.fragmentFill = 0
+		}
+	}
+}
+
+// Size returns the count of bytes written.
+func (builder *MerkleTreeBuilder) Size() (fileSize uint64) {
+	return builder.fileSize
+}
+
+// Tree returns the merkle tree of the data written so far. The builder may continue to be used afterwards.
+func (builder *MerkleTreeBuilder) Tree() (tree *MerkleTree, err error) {
+	fragmentSize := builder.fragmentSize
+	if fragmentSize == 0 {
+		fragmentSize = CalculateFragmentSize(builder.fileSize)
+	}
+
+	var fragments *fragmentHashBuilder
+	for _, candidate := range builder.candidates {
+		if candidate.fragmentSize == fragmentSize {
+			fragments = candidate
+			break
+		}
+	}
+	if fragments == nil {
+		return nil, errors.New("fragment size not available")
+	}
+
+	tree = &MerkleTree{
+		FileSize:      builder.fileSize,
+		FragmentSize:  fragmentSize,
+		FragmentCount: fileSizeToFragmentCount(builder.fileSize, fragmentSize),
+	}
+
+	tree.FragmentHashes = append(tree.FragmentHashes, fragments.fragmentHashes...)
+	if fragments.fragmentFill > 0 {
+		tree.FragmentHashes = append(tree.FragmentHashes, fragments.hasher.Sum(nil))
+	}
+
+	// Special case: No fragments in case of empty data, or a single fragment. The root hash is the hash of the data.
+	switch tree.FragmentCount {
+	case 0:
+		hash := blake3.Sum256(nil)
+		tree.RootHash = hash[:]
+		tree.FragmentHashes = nil
+		return tree, nil
+
+	case 1:
+		tree.RootHash = tree.FragmentHashes[0]
+		tree.FragmentHashes = nil
+		return tree, nil
+	}
+
+	tree.calculateMiddleHashes(0)
+
+	return tree, nil
+}
@@ -0,0 +1,101 @@
+/*
+File Username:  Merkle Proof.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+A fragment proof contains the hash of a single fragment and the verification hashes (sibling and uncle hashes) needed to calculate the merkle root hash.
+It allows to verify a fragment received from an untrusted peer against the known merkle root hash, without having the entire merkle tree.
+
+The position of each verification hash (left or right) is part of the proof, but it is not trusted: The positions must match the ones expected for the fragment index,
+otherwise a valid fragment could be passed off as a different one of the same file.
+*/
+
+package merkle
+
+import (
+	"bytes"
+
+	"lukechampine.com/blake3"
+)
+
+// FragmentProof proves that a fragment is part of a merkle tree.
+type FragmentProof struct {
+	Fragment           uint64   // Index of the fragment
+	FragmentHash       []byte   // Hash of the fragment data
+	VerificationHashes [][]byte // Verification hashes bottom up. Each has a preceding left (= 0)/right (= 1) indicator. See CreateVerification.
+}
+
+// CreateProof creates the proof for the fragment. It returns nil if the fragment index is invalid, or if the tree was loaded without fragment hashes.
+// If the tree has a single fragment, the fragment hash is the root hash and no verification hashes are needed.
+func (tree *MerkleTree) CreateProof(fragment uint64) (proof *FragmentProof) {
+	if fragment >= tree.FragmentCount {
+		return nil
+	} else if tree.FragmentCount == 1 {
+		return &FragmentProof{Fragment: fragment, FragmentHash: tree.RootHash}
+	} else if uint64(len(tree.FragmentHashes)) != tree.FragmentCount {
+		return nil
+	}
+
+	return &FragmentProof{Fragment: fragment, FragmentHash: tree.FragmentHashes[fragment], VerificationHashes: tree.CreateVerification(fragment)}
+}
+
+// Verify checks the proof against the merkle root hash. The file size and fragment size must be known from a trusted source (for example the file record on the blockchain),
+// since they define the structure of the tree.
+func (proof *FragmentProof) Verify(rootHash []byte, fileSize, fragmentSize uint64) (valid bool) {
+	if fragmentSize == 0 || len(proof.FragmentHash) != 32 {
+		return false
+	}
+
+	// Check the positions of the verification hashes. A fragment without sibling is moved up a level, in which case there is no verification hash.
+	index, count := proof.Fragment, fileSizeToFragmentCount(fileSize, fragmentSize)
+	if index >= count {
+		return false
+	}
+
+	var positions []byte
+	for ; count > 1; index, count = index/2, (count+1)/2 {
+		if index%2 == 0 && index == count-1 {
+			continue
+		} else if index%2 == 0 {
+			positions = append(positions, 1)
+		} else {
+			positions = append(positions, 0)
+		}
+	}
+
+	if len(positions) != len(proof.VerificationHashes) {
+		return false
+	}
+
+	hash := proof.FragmentHash
+	for n, verifyHash := range proof.VerificationHashes {
+		if len(verifyHash) != 33 || verifyHash[0] != positions[n] {
+			return false
+		}
+
+		if verifyHash[0] == 0 {
+			hash = calculateMiddleHash(verifyHash[1:], hash)
+		} else {
+			hash = calculateMiddleHash(hash, verifyHash[1:])
+		}
+	}
+
+	return bytes.Equal(rootHash, hash)
+}
+
+// VerifyData checks if the data matches the fragment hash of the proof. The proof itself must be verified via Verify.
+func (proof *FragmentProof) VerifyData(data []byte) (valid bool) {
+	hash := blake3.Sum256(data)
+	return bytes.Equal(hash[:], proof.FragmentHash)
+}
+
+// FragmentLength returns the size of the fragment. The last fragment may be smaller than the fragment size. It returns 0 if the fragment index is invalid.
+func FragmentLength(fileSize, fragmentSize, fragment uint64) (length uint64) {
+	if fragmentSize == 0 || fragment >= fileSizeToFragmentCount(fileSize, fragmentSize) {
+		return 0
+	} else if remaining := fileSize - fragment*fragmentSize; remaining < fragmentSize {
+		return remaining
+	}
+
+	return fragmentSize
+}
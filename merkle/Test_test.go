@@ -137,3 +137,84 @@ func TestMerkleFileExport(t *testing.T) {
 
 	fmt.Printf("Success. Import/export match.\n")
 }
+
+func TestMerkleTreeBuilder(t *testing.T) {
+	for _, dataSize := range []uint64{0, 100, MinimumFragmentSize, MinimumFragmentSize + 1, 3*MinimumFragmentSize + 7, 11*1024*1024 + 100} {
+		data := make([]byte, dataSize)
+		if _, err := io.ReadFull(rand.Reader, data); err != nil {
+			t.Fatal(err)
+		}
+
+		expected, err := NewMerkleTree(dataSize, CalculateFragmentSize(dataSize), bytes.NewBuffer(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The file size is known (fixed fragment size) or unknown. Data is written in uneven parts.
+		for _, fragmentSize := range []uint64{CalculateFragmentSize(dataSize), 0} {
+			builder := NewMerkleTreeBuilder(fragmentSize)
+			for offset := uint64(0); offset < dataSize; offset += 100000 {
+				end := offset + 100000
+				if end > dataSize {
+					end = dataSize
+				}
+				builder.Write(data[offset:end])
+			}
+
+			tree, err := builder.Tree()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(tree.Export(), expected.Export()) || !bytes.Equal(tree.RootHash, expected.RootHash) {
+				t.Fatalf("merkle tree of builder does not match for size %d fragment size %d", dataSize, fragmentSize)
+			}
+		}
+	}
+}
+
+func TestFragmentProof(t *testing.T) {
+	for _, dataSize := range []uint64{100, 2*MinimumFragmentSize + 1, 5 * MinimumFragmentSize, 11*MinimumFragmentSize + 100} {
+		data := make([]byte, dataSize)
+		if _, err := io.ReadFull(rand.Reader, data); err != nil {
+			t.Fatal(err)
+		}
+
+		fragmentSize := CalculateFragmentSize(dataSize)
+		tree, err := NewMerkleTree(dataSize, fragmentSize, bytes.NewBuffer(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for n := uint64(0); n < tree.FragmentCount; n++ {
+			proof := tree.CreateProof(n)
+			if proof == nil || !proof.Verify(tree.RootHash, dataSize, fragmentSize) {
+				t.Fatalf("proof of fragment %d of %d is invalid", n, tree.FragmentCount)
+			}
+
+			length := FragmentLength(dataSize, fragmentSize, n)
+			if !proof.VerifyData(data[n*fragmentSize : n*fragmentSize+length]) {
+				t.Fatalf("data of fragment %d does not match the proof", n)
+			}
+
+			// The proof must not be valid for a different fragment index.
+			for m := uint64(0); m < tree.FragmentCount; m++ {
+				if m != n && (&FragmentProof{Fragment: m, FragmentHash: proof.FragmentHash, VerificationHashes: proof.VerificationHashes}).Verify(tree.RootHash, dataSize, fragmentSize) {
+					t.Fatalf("proof of fragment %d is valid for fragment %d", n, m)
+				}
+			}
+		}
+
+		if tree.CreateProof(tree.FragmentCount) != nil {
+			t.Fatal("proof created for invalid fragment index")
+		}
+
+		// Imported trees must create the same proofs.
+		imported := ImportMerkleTree(tree.Export())
+		for n := uint64(0); n < tree.FragmentCount; n++ {
+			if !imported.CreateProof(n).Verify(tree.RootHash, dataSize, fragmentSize) {
+				t.Fatalf("proof of fragment %d of the imported tree is invalid", n)
+			}
+		}
+	}
+}
@@ -24,4 +24,7 @@ const (
 
 	// Debug
 	CommandChat = 10 // Chat message [debug]
+
+	// Messaging
+	CommandMessage = 11 // End-to-end encrypted direct message between users.
 )
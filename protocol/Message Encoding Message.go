@@ -0,0 +1,150 @@
+/*
+File Username:  Message Encoding Message.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Direct message encoding:
+Offset  Size   Info
+0       1      Control
+1       16     Message ID
+
+Control = 0: Message
+17      ?      Encrypted message envelope
+
+Control = 1: Acknowledgement
+The acknowledgement is sent with the same sequence number as the message. No additional data.
+
+Encoding of the encrypted message envelope:
+Offset  Size   Info
+0       33     Sender public key compressed
+33      16     Message ID
+49      8      Date sent
+57      24     Nonce
+81      ?      Encrypted text including 16 bytes authentication tag
+
+The text is encrypted end-to-end using XChaCha20-Poly1305. The key is the blake3 hash of the ECDH shared secret between sender and receiver.
+The envelope header (offset 0-81) is authenticated as additional data. Since only the sender and receiver can derive the key, a valid envelope proves the sender.
+The envelope is self-contained so it can be stored in the DHT for offline receivers.
+
+*/
+
+package protocol
+
+import (
+    "crypto/rand"
+    "encoding/binary"
+    "errors"
+    "time"
+
+    "github.com/google/uuid"
+    "github.com/newinfoOffical/core/btcec"
+    "golang.org/x/crypto/chacha20poly1305"
+)
+
+// MessageDirect is the decoded direct message.
+type MessageDirect struct {
+    *MessageRaw           // Underlying raw message.
+    Control     uint8     // Control. See MessageControlX.
+    ID          uuid.UUID // Message ID.
+    Envelope    []byte    // Encrypted message envelope. Only MessageControlSend.
+}
+
+const (
+    MessageControlSend        = 0 // Message
+    MessageControlAcknowledge = 1 // Acknowledgement of a received message
+)
+
+const messagePayloadHeaderSize = 17
+const messageEnvelopeHeaderSize = 81
+
+// MessageTextMax is the max size of the text of a direct message in bytes, so that the message fits into a single packet.
+const MessageTextMax = internetSafeMTU - PacketLengthMin - messagePayloadHeaderSize - messageEnvelopeHeaderSize - chacha20poly1305.Overhead
+
+// DecodeMessageDirect decodes a direct message
+func DecodeMessageDirect(msg *MessageRaw) (result *MessageDirect, err error) {
+    if len(msg.Payload) < messagePayloadHeaderSize {
+        return nil, errors.New("message: invalid minimum length")
+    }
+
+    result = &MessageDirect{MessageRaw: msg, Control: msg.Payload[0]}
+    copy(result.ID[:], msg.Payload[1:17])
+
+    if result.Control == MessageControlSend {
+        if len(msg.Payload) < messagePayloadHeaderSize+messageEnvelopeHeaderSize+chacha20poly1305.Overhead {
+            return nil, errors.New("message: invalid envelope length")
+        }
+        result.Envelope = msg.Payload[messagePayloadHeaderSize:]
+    }
+
+    return result, nil
+}
+
+// EncodeMessageDirect encodes a direct message. The envelope is only used for MessageControlSend.
+func EncodeMessageDirect(control uint8, id uuid.UUID, envelope []byte) (packetRaw []byte, err error) {
+    if control != MessageControlSend {
+        envelope = nil
+    }
+    if isPacketSizeExceed(messagePayloadHeaderSize, len(envelope)) {
+        return nil, errors.New("message encode: envelope too big")
+    }
+
+    raw := make([]byte, messagePayloadHeaderSize+len(envelope))
+    raw[0] = control
+    copy(raw[1:17], id[:])
+    copy(raw[messagePayloadHeaderSize:], envelope)
+
+    return raw, nil
+}
+
+// messageEnvelopeKey returns the symmetric key between the two peers
+func messageEnvelopeKey(privateKey *btcec.PrivateKey, publicKey *btcec.PublicKey) []byte {
+    return HashData(btcec.GenerateSharedSecret(privateKey, publicKey))
+}
+
+// EncryptMessageEnvelope encrypts the text for the receiver and returns the message envelope.
+func EncryptMessageEnvelope(senderPrivateKey *btcec.PrivateKey, receiverPublicKey *btcec.PublicKey, id uuid.UUID, date time.Time, text []byte) (envelope []byte, err error) {
+    if len(text) > MessageTextMax {
+        return nil, errors.New("message text too long")
+    }
+
+    aead, err := chacha20poly1305.NewX(messageEnvelopeKey(senderPrivateKey, receiverPublicKey))
+    if err != nil {
+        return nil, err
+    }
+
+    header := make([]byte, messageEnvelopeHeaderSize)
+    copy(header[0:33], senderPrivateKey.PubKey().SerializeCompressed())
+    copy(header[33:49], id[:])
+    binary.LittleEndian.PutUint64(header[49:57], uint64(date.UTC().Unix()))
+    if _, err = rand.Read(header[57:81]); err != nil {
+        return nil, err
+    }
+
+    return aead.Seal(header, header[57:81], text, header), nil
+}
+
+// DecryptMessageEnvelope decrypts a message envelope sent to the receiver. It fails if the envelope was not encrypted by the sender for the receiver.
+func DecryptMessageEnvelope(receiverPrivateKey *btcec.PrivateKey, envelope []byte) (senderPublicKey *btcec.PublicKey, id uuid.UUID, date time.Time, text []byte, err error) {
+    if len(envelope) < messageEnvelopeHeaderSize+chacha20poly1305.Overhead {
+        return nil, id, date, nil, errors.New("message envelope too small")
+    }
+
+    if senderPublicKey, err = btcec.ParsePubKey(envelope[0:33], btcec.S256()); err != nil {
+        return nil, id, date, nil, err
+    }
+
+    aead, err := chacha20poly1305.NewX(messageEnvelopeKey(receiverPrivateKey, senderPublicKey))
+    if err != nil {
+        return nil, id, date, nil, err
+    }
+
+    header := envelope[:messageEnvelopeHeaderSize]
+    if text, err = aead.Open(nil, header[57:81], envelope[messageEnvelopeHeaderSize:], header); err != nil {
+        return nil, id, date, nil, err
+    }
+
+    copy(id[:], header[33:49])
+    date = time.Unix(int64(binary.LittleEndian.Uint64(header[49:57])), 0)
+
+    return senderPublicKey, id, date, text, nil
+}
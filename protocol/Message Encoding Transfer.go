@@ -17,6 +17,20 @@ Control = 0: Request Start
 Offset + limit must not exceed the file size. Actual data transfer should be sent via lite packets.
 The regular Peernet packets would be too CPU expensive and slow due to public key signing.
 
+Control = 4: Request Proof
+34      8      Index of the first fragment
+42      8      Count of fragments
+
+Control = 5: Proof. Response to Request Proof. It may contain fewer proofs than requested due to the packet size limit.
+34      1      Count of proofs
+35      ?      Proofs. Each proof:
+               8      Fragment index
+               32     Fragment hash
+               1      Count of verification hashes
+               33 * n Verification hashes. Each hash is preceded by the position: 0 = left, 1 = right.
+
+The proofs allow to verify each fragment against the merkle root hash of the file record, regardless of which peer sends the data.
+
 */
 
 package protocol
@@ -26,20 +40,24 @@ import (
     "errors"
 
     "github.com/newinfoOffical/core/btcec"
+    "github.com/newinfoOffical/core/merkle"
     "github.com/google/uuid"
 )
 
 // MessageTransfer is the decoded transfer message.
 // It is sent to initiate a file transfer, and to send data as part of a file transfer. The actual file data is encapsulated via UDT.
 type MessageTransfer struct {
-    *MessageRaw                // Underlying raw message.
-    Control          uint8     // Control. See TransferControlX.
-    TransferProtocol uint8     // Embedded transfer protocol: 0 = UDT
-    Hash             []byte    // Hash of the file to transfer.
-    Offset           uint64    // Offset to start reading at. Only TransferControlRequestStart.
-    Limit            uint64    // Limit (count of bytes) to read starting at the offset. Only TransferControlRequestStart.
-    TransferID       uuid.UUID // Transfer ID to identify lite packets.
-    Data             []byte    // Embedded protocol data. Only TransferControlActive.
+    *MessageRaw                             // Underlying raw message.
+    Control          uint8                  // Control. See TransferControlX.
+    TransferProtocol uint8                  // Embedded transfer protocol: 0 = UDT
+    Hash             []byte                 // Hash of the file to transfer.
+    Offset           uint64                 // Offset to start reading at. Only TransferControlRequestStart.
+    Limit            uint64                 // Limit (count of bytes) to read starting at the offset. Only TransferControlRequestStart.
+    TransferID       uuid.UUID              // Transfer ID to identify lite packets.
+    Data             []byte                 // Embedded protocol data. Only TransferControlActive.
+    Fragment         uint64                 // Index of the first fragment. Only TransferControlRequestProof.
+    FragmentCount    uint64                 // Count of fragments. Only TransferControlRequestProof.
+    Proofs           []merkle.FragmentProof // Proofs of fragments. Only TransferControlProof.
 }
 
 const (
@@ -47,8 +65,13 @@ const (
     TransferControlNotAvailable = 1 // Requested file not available
     TransferControlActive       = 2 // Active file transfer
     TransferControlTerminate    = 3 // Terminate
+    TransferControlRequestProof = 4 // Request proofs of fragments. Data at byte 34 is the index of the first fragment and the count of fragments, each 8 bytes.
+    TransferControlProof        = 5 // Proofs of fragments
 )
 
+// transferProofHashesMax is the max count of verification hashes per proof. It allows 2^64 fragments.
+const transferProofHashesMax = 64
+
 const (
     TransferProtocolUDT = 0 // UDT via lite packets. No encryption.
 )
@@ -85,6 +108,46 @@ func DecodeTransfer(msg *MessageRaw) (result *MessageTransfer, err error) {
         // Data should be transferred via lite packets for performance reasons, but it is allowed to be encapsulated in Peernet packets.
         result.Data = msg.Payload[transferPayloadHeaderSize:]
 
+    case TransferControlRequestProof:
+        if len(msg.Payload) < transferPayloadHeaderSize+16 {
+            return nil, errors.New("transfer: invalid minimum length")
+        }
+
+        result.Fragment = binary.LittleEndian.Uint64(msg.Payload[34 : 34+8])
+        result.FragmentCount = binary.LittleEndian.Uint64(msg.Payload[42 : 42+8])
+
+    case TransferControlProof:
+        if len(msg.Payload) < transferPayloadHeaderSize+1 {
+            return nil, errors.New("transfer: invalid minimum length")
+        }
+
+        count := int(msg.Payload[34])
+        data := msg.Payload[35:]
+
+        for n := 0; n < count; n++ {
+            if len(data) < 8+32+1 {
+                return nil, errors.New("transfer: proof invalid length")
+            }
+
+            proof := merkle.FragmentProof{Fragment: binary.LittleEndian.Uint64(data[0:8]), FragmentHash: make([]byte, HashSize)}
+            copy(proof.FragmentHash, data[8:8+32])
+            countHashes := int(data[40])
+            data = data[41:]
+
+            if countHashes > transferProofHashesMax || len(data) < countHashes*33 {
+                return nil, errors.New("transfer: proof invalid length")
+            }
+
+            for m := 0; m < countHashes; m++ {
+                hash := make([]byte, 33)
+                copy(hash, data[m*33:m*33+33])
+                proof.VerificationHashes = append(proof.VerificationHashes, hash)
+            }
+            data = data[countHashes*33:]
+
+            result.Proofs = append(result.Proofs, proof)
+        }
+
     }
 
     return result, nil
@@ -130,7 +193,62 @@ func EncodeTransfer(senderPrivateKey *btcec.PrivateKey, data []byte, control, tr
     return raw, nil
 }
 
+// EncodeTransferProofRequest encodes a transfer message requesting the proofs of fragments.
+func EncodeTransferProofRequest(hash []byte, fragment, count uint64) (packetRaw []byte) {
+    raw := make([]byte, transferPayloadHeaderSize+16)
+
+    raw[0] = TransferControlRequestProof
+    raw[1] = TransferProtocolUDT
+    copy(raw[2:2+HashSize], hash)
+    binary.LittleEndian.PutUint64(raw[34:34+8], fragment)
+    binary.LittleEndian.PutUint64(raw[42:42+8], count)
+
+    return raw
+}
+
+// EncodeTransferProof encodes a transfer message containing the proofs of fragments. Proofs that do not fit into the message are not encoded.
+// It returns the count of encoded proofs.
+func EncodeTransferProof(hash []byte, proofs []merkle.FragmentProof) (packetRaw []byte, count int, err error) {
+    raw := make([]byte, transferPayloadHeaderSize+1)
+
+    raw[0] = TransferControlProof
+    raw[1] = TransferProtocolUDT
+    copy(raw[2:2+HashSize], hash)
+
+    for _, proof := range proofs {
+        if count == 255 || isPacketSizeExceed(len(raw), 8+32+1+len(proof.VerificationHashes)*33) {
+            break
+        } else if len(proof.FragmentHash) != HashSize || len(proof.VerificationHashes) > transferProofHashesMax {
+            return nil, 0, errors.New("transfer encode: invalid proof")
+        }
+
+        var data [8 + 32 + 1]byte
+        binary.LittleEndian.PutUint64(data[0:8], proof.Fragment)
+        copy(data[8:8+32], proof.FragmentHash)
+        data[40] = byte(len(proof.VerificationHashes))
+        raw = append(raw, data[:]...)
+
+        for _, verifyHash := range proof.VerificationHashes {
+            if len(verifyHash) != 33 {
+                return nil, 0, errors.New("transfer encode: invalid proof")
+            }
+            raw = append(raw, verifyHash...)
+        }
+
+        count++
+    }
+
+    raw[34] = byte(count)
+
+    return raw, count, nil
+}
+
 // IsLast checks if the incoming message is the last one in this transfer.
 func (msg *MessageTransfer) IsLast() bool {
-    return msg.Control == TransferControlTerminate || msg.Control == TransferControlNotAvailable
+    return msg.Control == TransferControlTerminate || msg.Control == TransferControlNotAvailable || msg.Control == TransferControlProof
+}
+
+// IsRequest checks if the incoming message starts a new sequence.
+func (msg *MessageTransfer) IsRequest() bool {
+    return msg.Control == TransferControlRequestStart || msg.Control == TransferControlRequestProof
 }
@@ -2,10 +2,14 @@
 package protocol
 
 import (
+    "bytes"
     "fmt"
     "testing"
+    "time"
 
+    "github.com/google/uuid"
     "github.com/newinfoOffical/core/btcec"
+    "github.com/newinfoOffical/core/merkle"
 )
 
 func TestMessageEncodingAnnouncement(t *testing.T) {
@@ -83,3 +87,73 @@ func TestMessageEncodingResponse(t *testing.T) {
 
     fmt.Printf("Decode:\nUser Agent: %s\nHash2Peers: %v\nHashesNotFound: %v\nFiles embedded: %v\n", result.UserAgent, result.Hash2Peers, result.HashesNotFound, result.FilesEmbed)
 }
+
+func TestMessageEnvelope(t *testing.T) {
+    senderKey, _ := btcec.NewPrivateKey(btcec.S256())
+    receiverKey, _ := btcec.NewPrivateKey(btcec.S256())
+    otherKey, _ := btcec.NewPrivateKey(btcec.S256())
+
+    id := uuid.New()
+    envelope, err := EncryptMessageEnvelope(senderKey, receiverKey.PubKey(), id, time.Now(), []byte("Hello"))
+    if err != nil {
+        t.Fatalf("Error encrypting message: %s\n", err.Error())
+    }
+
+    sender, id2, _, text, err := DecryptMessageEnvelope(receiverKey, envelope)
+    if err != nil {
+        t.Fatalf("Error decrypting message: %s\n", err.Error())
+    } else if !sender.IsEqual(senderKey.PubKey()) || id2 != id || string(text) != "Hello" {
+        t.Fatalf("Message decrypted incorrectly\n")
+    }
+
+    if _, _, _, _, err = DecryptMessageEnvelope(otherKey, envelope); err == nil {
+        t.Fatalf("Message decrypted by another key\n")
+    }
+
+    packet, _ := EncodeMessageDirect(MessageControlSend, id, envelope)
+    decoded, err := DecodeMessageDirect(&MessageRaw{PacketRaw: PacketRaw{Payload: packet}})
+    if err != nil || decoded.ID != id || string(decoded.Envelope) != string(envelope) {
+        t.Fatalf("Direct message decoded incorrectly\n")
+    }
+}
+
+func TestMessageTransferProof(t *testing.T) {
+    data := make([]byte, 5*merkle.MinimumFragmentSize+100)
+    tree, err := merkle.NewMerkleTree(uint64(len(data)), merkle.MinimumFragmentSize, bytes.NewReader(data))
+    if err != nil {
+        t.Fatalf("Error creating merkle tree: %s\n", err.Error())
+    }
+    hash := HashData(data)
+
+    request := EncodeTransferProofRequest(hash, 2, 3)
+    decoded, err := DecodeTransfer(&MessageRaw{PacketRaw: PacketRaw{Payload: request}})
+    if err != nil || decoded.Control != TransferControlRequestProof || !decoded.IsRequest() || decoded.Fragment != 2 || decoded.FragmentCount != 3 || !bytes.Equal(decoded.Hash, hash) {
+        t.Fatalf("Proof request decoded incorrectly\n")
+    }
+
+    var proofs []merkle.FragmentProof
+    for n := uint64(0); n < tree.FragmentCount; n++ {
+        proofs = append(proofs, *tree.CreateProof(n))
+    }
+
+    packet, count, err := EncodeTransferProof(hash, proofs)
+    if err != nil || count != len(proofs) {
+        t.Fatalf("Error encoding proofs: %v\n", err)
+    }
+
+    decoded, err = DecodeTransfer(&MessageRaw{PacketRaw: PacketRaw{Payload: packet}})
+    if err != nil || decoded.Control != TransferControlProof || !decoded.IsLast() || len(decoded.Proofs) != len(proofs) {
+        t.Fatalf("Proofs decoded incorrectly: %v\n", err)
+    }
+
+    for _, proof := range decoded.Proofs {
+        if !proof.Verify(tree.RootHash, tree.FileSize, tree.FragmentSize) {
+            t.Fatalf("Decoded proof of fragment %d is invalid\n", proof.Fragment)
+        }
+    }
+
+    // truncated messages must be rejected
+    if _, err = DecodeTransfer(&MessageRaw{PacketRaw: PacketRaw{Payload: packet[:len(packet)-1]}}); err == nil {
+        t.Fatalf("Truncated proof message decoded\n")
+    }
+}
@@ -0,0 +1,143 @@
+/*
+File name:  Content Index.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+The full-text index stores the words of the content of files, in a separate search index store. The text is extracted by the caller.
+Only the most frequent words of each file are indexed. A subset of them is recorded for publishing, so that other peers can find files by their content:
+
+keyPublishPrefix + file ID    Value: File hash (32 bytes), followed by the hashes of the words to publish (32 bytes each)
+*/
+
+package search
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/newinfoOffical/core/btcec"
+)
+
+// keyPublishPrefix is the key prefix for the words of a file to publish.
+const keyPublishPrefix = "publish "
+
+// IndexContent indexes the words of the text extracted from the content of a file. Only the maxTerms most frequent words are indexed.
+// The hashes of the maxPublish most frequent words are recorded for publishing, see ContentKeywords. Stems are not published.
+// Content that was already indexed for the file ID is not indexed again.
+func (index *SearchIndexStore) IndexContent(publicKey *btcec.PublicKey, blockchainVersion, blockNumber uint64, fileID uuid.UUID, fileHash []byte, text string, maxTerms, maxPublish int) {
+	if index == nil {
+		return
+	}
+
+	keyPublish := append([]byte(keyPublishPrefix), fileID[:]...)
+	if _, found := index.Database.Get(keyPublish); found {
+		return
+	}
+
+	words := index.rules.contentWords(sanitizeGeneric(text))
+	if len(words) > maxTerms {
+		words = words[:maxTerms]
+	}
+
+	publish := append([]byte{}, fileHash...)
+	var terms []string
+
+	for n, word := range words {
+		hashes := make(map[[32]byte]string)
+		index.rules.text2Hashes(word, hashes)
+
+		for hash, wordH := range hashes {
+			index.IndexHash(publicKey, blockchainVersion, blockNumber, fileID, hash[:])
+
+			if wordH == word {
+				terms = append(terms, wordH)
+			}
+		}
+
+		if n < maxPublish {
+			if hash, _ := hashWord(word); hash != nil {
+				publish = append(publish, hash...)
+			}
+		}
+	}
+
+	index.addTerms(terms)
+
+	index.Database.Set(keyPublish, publish)
+}
+
+// IsContentIndexed checks if the content of the file is already indexed.
+func (index *SearchIndexStore) IsContentIndexed(fileID uuid.UUID) bool {
+	if index == nil {
+		return false
+	}
+
+	_, found := index.Database.Get(append([]byte(keyPublishPrefix), fileID[:]...))
+	return found
+}
+
+// contentWords returns the distinct words of the text, most frequent first. Stop words are skipped.
+func (rules *textRules) contentWords(text string) (words []string) {
+	count := make(map[string]int)
+
+	for _, word := range strings.FieldsFunc(text, isWordSeparator) {
+		word = normalizeWord(strings.TrimPrefix(word, "#"))
+		if len(word) < wordMinLength || rules.isStopWord(word) {
+			continue
+		}
+
+		if count[word] == 0 {
+			words = append(words, word)
+		}
+		count[word]++
+	}
+
+	// The order of first occurrence is kept for words with the same count.
+	sort.SliceStable(words, func(i, j int) bool { return count[words[i]] > count[words[j]] })
+
+	return words
+}
+
+// ContentKeywords calls the callback for each file with the hashes of the words to publish.
+func (index *SearchIndexStore) ContentKeywords(callback func(fileID uuid.UUID, fileHash []byte, wordHashes [][]byte)) {
+	if index == nil {
+		return
+	}
+
+	index.Database.Iterate(func(key, value []byte) {
+		if !strings.HasPrefix(string(key), keyPublishPrefix) || len(key) != len(keyPublishPrefix)+16 || len(value) < 32 || len(value)%32 != 0 {
+			return
+		}
+
+		var fileID uuid.UUID
+		copy(fileID[:], key[len(keyPublishPrefix):])
+
+		var wordHashes [][]byte
+		for offset := 32; offset < len(value); offset += 32 {
+			wordHashes = append(wordHashes, value[offset:offset+32])
+		}
+
+		callback(fileID, value[:32], wordHashes)
+	})
+}
+
+// Reset deletes the entire index and marks it as current. It is used for indexes that are not built from blockchains, such as the full-text index.
+func (index *SearchIndexStore) Reset() {
+	if index == nil {
+		return
+	}
+
+	index.maintenance.Lock()
+	defer index.maintenance.Unlock()
+
+	index.clear()
+	index.writeIndexVersion()
+	index.RebuildRequired = false
+}
+
+// HashWord returns the hash of a single word as it is indexed. It returns nil if the word is too short.
+func HashWord(word string) (hash []byte) {
+	hash, _ = hashWord(sanitizeGeneric(word))
+	return hash
+}
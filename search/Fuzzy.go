@@ -0,0 +1,237 @@
+/*
+File name:  Fuzzy.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Fuzzy search tolerates typos in the search term. Candidate words are found via trigrams in the term dictionary and then verified by their edit distance.
+Each word is padded with '$' at the beginning and end before splitting into trigrams, so that short words still have enough trigrams.
+For example "cat" has the trigrams "$ca", "cat", "at$".
+
+A single edit (insert, delete, replace, or swap of adjacent characters) changes at most 4 trigrams. Candidates that share fewer trigrams than possible for the max distance are skipped.
+Trigrams that are shared by too many words are ignored, since they hardly narrow the candidates. Candidates are verified in the order of shared trigrams, so the result does not depend on map order.
+*/
+
+package search
+
+import (
+	"sort"
+	"unicode/utf8"
+)
+
+// Limits for fuzzy search.
+const (
+	FuzzyDistanceMax      = 2    // Max edit distance that can be requested.
+	fuzzyExpansionMax     = 10   // Max count of words a single word expands to.
+	fuzzyShortWordLength  = 6    // Words shorter than this are limited to an edit distance of 1.
+	fuzzyCandidateScanMax = 5000 // Max count of candidates to verify via edit distance for a single word.
+	fuzzyTrigramWordsMax  = 5000 // Trigrams with more words are ignored.
+)
+
+// trigrams returns the unique trigrams of the padded word.
+func trigrams(word string) (grams []string) {
+	runes := []rune("$" + word + "$")
+	unique := make(map[string]struct{})
+
+	for n := 0; n+3 <= len(runes); n++ {
+		gram := string(runes[n : n+3])
+		if _, ok := unique[gram]; !ok {
+			unique[gram] = struct{}{}
+			grams = append(grams, gram)
+		}
+	}
+
+	return grams
+}
+
+// addTrigrams adds the word to the trigram index. The dictionary must be locked by the caller.
+func (dictionary *termDictionary) addTrigrams(word string) {
+	for _, gram := range trigrams(word) {
+		words, ok := dictionary.trigrams[gram]
+		if !ok {
+			words = make(map[string]struct{})
+			dictionary.trigrams[gram] = words
+		}
+		words[word] = struct{}{}
+	}
+}
+
+// removeTrigrams removes the word from the trigram index. The dictionary must be locked by the caller.
+func (dictionary *termDictionary) removeTrigrams(word string) {
+	for _, gram := range trigrams(word) {
+		if words, ok := dictionary.trigrams[gram]; ok {
+			delete(words, word)
+			if len(words) == 0 {
+				delete(dictionary.trigrams, gram)
+			}
+		}
+	}
+}
+
+// FuzzyMatch is a word from the index that is similar to a search word.
+type FuzzyMatch struct {
+	Word     string // Indexed word
+	Distance int    // Edit distance to the search word
+}
+
+// ExpandFuzzy returns indexed words within the max edit distance of the word, excluding the word itself. The closest words are returned first.
+// Words shorter than fuzzyShortWordLength are limited to an edit distance of 1.
+func (index *SearchIndexStore) ExpandFuzzy(word string, maxDistance int) (matches []FuzzyMatch) {
+	if index == nil || maxDistance <= 0 {
+		return nil
+	}
+
+	word = normalizeWord(word)
+	wordRunes := []rune(word)
+
+	if len(wordRunes) < wordMinLength {
+		return nil
+	} else if maxDistance > FuzzyDistanceMax {
+		maxDistance = FuzzyDistanceMax
+	}
+	if len(wordRunes) < fuzzyShortWordLength {
+		maxDistance = 1
+	}
+
+	grams := trigrams(word)
+	minShared := len(grams) - 4*maxDistance
+	if minShared < 1 {
+		minShared = 1
+	}
+
+	var orphans []string
+
+	index.dictionary.RLock()
+
+	// Count the shared trigrams per candidate. Ignored trigrams count as shared, since they cannot be checked.
+	shared := make(map[string]int)
+	ignored := 0
+	for _, gram := range grams {
+		words := index.dictionary.trigrams[gram]
+		if len(words) > fuzzyTrigramWordsMax {
+			ignored++
+			continue
+		}
+
+		for candidate := range words {
+			if lengthDiff := utf8.RuneCountInString(candidate) - len(wordRunes); lengthDiff > maxDistance || -lengthDiff > maxDistance {
+				continue
+			}
+			shared[candidate]++
+		}
+	}
+
+	type fuzzyCandidate struct {
+		word   string
+		shared int
+	}
+
+	var candidates []fuzzyCandidate
+	for candidate, count := range shared {
+		if count+ignored >= minShared && candidate != word {
+			candidates = append(candidates, fuzzyCandidate{word: candidate, shared: count})
+		}
+	}
+
+	// most shared trigrams first
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].shared != candidates[j].shared {
+			return candidates[i].shared > candidates[j].shared
+		}
+		return candidates[i].word < candidates[j].word
+	})
+
+	if len(candidates) > fuzzyCandidateScanMax {
+		candidates = candidates[:fuzzyCandidateScanMax]
+	}
+
+	for _, candidateS := range candidates {
+		candidate := candidateS.word
+		candidateRunes := []rune(candidate)
+
+		distance := editDistance(wordRunes, candidateRunes, maxDistance)
+		if distance > maxDistance {
+			continue
+		}
+
+		// lazy removal of words that are no longer indexed
+		if hash, _ := hashWord(candidate); hash == nil {
+			continue
+		} else if _, found := index.Database.Get(hash); !found {
+			orphans = append(orphans, candidate)
+			continue
+		}
+
+		matches = append(matches, FuzzyMatch{Word: candidate, Distance: distance})
+	}
+
+	index.dictionary.RUnlock()
+
+	if len(orphans) > 0 {
+		index.removeTerms(orphans)
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Distance != matches[j].Distance {
+			return matches[i].Distance < matches[j].Distance
+		}
+		return matches[i].Word < matches[j].Word
+	})
+
+	if len(matches) > fuzzyExpansionMax {
+		matches = matches[:fuzzyExpansionMax]
+	}
+
+	return matches
+}
+
+// editDistance returns the optimal string alignment distance (Levenshtein with swaps of adjacent characters).
+// If the distance exceeds the max distance, it may return max distance + 1 early.
+func editDistance(a, b []rune, maxDistance int) int {
+	// rows of the distance matrix: 2 rows back, previous, current
+	rowPrev2 := make([]int, len(b)+1)
+	rowPrev := make([]int, len(b)+1)
+	row := make([]int, len(b)+1)
+
+	for j := range rowPrev {
+		rowPrev[j] = j
+	}
+
+	rowPrevMin := 0
+
+	for i := 1; i <= len(a); i++ {
+		row[0] = i
+		rowMin := row[0]
+
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			row[j] = minInt(minInt(rowPrev[j]+1, row[j-1]+1), rowPrev[j-1]+cost)
+
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				row[j] = minInt(row[j], rowPrev2[j-2]+1)
+			}
+
+			rowMin = minInt(rowMin, row[j])
+		}
+
+		// A swap may refer back 2 rows, so both rows must exceed the max distance.
+		if rowMin > maxDistance && rowPrevMin > maxDistance {
+			return maxDistance + 1
+		}
+		rowPrevMin = rowMin
+
+		rowPrev2, rowPrev, row = rowPrev, row, rowPrev2
+	}
+
+	return rowPrev[len(b)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
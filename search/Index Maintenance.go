@@ -0,0 +1,521 @@
+/*
+File name:  Index Maintenance.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Maintenance operations on the search index:
+* Rebuild    Deletes the entire index and indexes all blocks of the user's blockchain and the global blockchain cache again.
+* Check      Detects corrupt and orphaned records, and optionally repairs them.
+* Statistic  Counts terms and records.
+
+Keys used in the search index database:
+Length 32               Hash of a word, value: list of index records
+Length 33               Public key compressed, value: list of reverse index records
+keyTermPrefix + word    Term dictionary, empty value
+keyIndexVersion         Index version marker, value: see below
+keyPublishPrefix + ID   Words of a file to publish, only in the full-text index. See Content Index.go.
+
+Words are only stored as hashes in the index. The term dictionary cannot be restored from the hashes; it is only repopulated by a rebuild.
+
+Index version marker:
+Offset  Size   Info
+0       2      Index version, see indexVersion
+2       ?      Language of the text rules
+
+The marker is written after a successful rebuild. If it does not match the current version and language, the index must be rebuilt.
+*/
+
+package search
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/newinfoOffical/core/blockchain"
+	"github.com/newinfoOffical/core/btcec"
+)
+
+// keyIndexVersion is the key of the index version marker.
+const keyIndexVersion = "index version"
+
+// indexVersion is the version of the rules for normalizing, tokenizing, and hashing text. It must be increased whenever they change, which triggers a rebuild of existing indexes.
+// Version 2 indexes the media tags title, artist, album, and camera.
+const indexVersion = 2
+
+// isIndexVersionCurrent checks if the index was built with the current version and language.
+func (index *SearchIndexStore) isIndexVersionCurrent() bool {
+	raw, found := index.Database.Get([]byte(keyIndexVersion))
+	if !found || len(raw) < 2 {
+		return false
+	}
+
+	return binary.LittleEndian.Uint16(raw[0:2]) == indexVersion && string(raw[2:]) == index.rules.languageName()
+}
+
+// writeIndexVersion writes the index version marker.
+func (index *SearchIndexStore) writeIndexVersion() {
+	raw := make([]byte, 2)
+	binary.LittleEndian.PutUint16(raw[0:2], indexVersion)
+	raw = append(raw, []byte(index.rules.languageName())...)
+
+	index.Database.Set([]byte(keyIndexVersion), raw)
+}
+
+// IndexSources are the blockchains the search index is built from.
+type IndexSources struct {
+	User       *blockchain.Blockchain                 // The user's blockchain.
+	Multi      *blockchain.MultiStore                 // The global blockchain cache. Nil if not available.
+	DecodeHook func(decoded *blockchain.BlockDecoded) // Optional: Called for each decoded block from the global blockchain cache before it is indexed.
+}
+
+// IndexProgress reports the progress of a maintenance operation. It is safe for concurrent use.
+type IndexProgress struct {
+	total, done int
+	sync.RWMutex
+}
+
+// Get returns the count of processed items and the total count. The total is 0 if not yet known.
+func (progress *IndexProgress) Get() (done, total int) {
+	if progress == nil {
+		return 0, 0
+	}
+
+	progress.RLock()
+	defer progress.RUnlock()
+
+	return progress.done, progress.total
+}
+
+func (progress *IndexProgress) setTotal(total int) {
+	if progress == nil {
+		return
+	}
+
+	progress.Lock()
+	progress.total = total
+	progress.done = 0
+	progress.Unlock()
+}
+
+func (progress *IndexProgress) add(count int) {
+	if progress == nil {
+		return
+	}
+
+	progress.Lock()
+	progress.done += count
+	progress.Unlock()
+}
+
+// IndexStatistic contains counts of terms and records in the search index.
+type IndexStatistic struct {
+	Terms          int `json:"terms"`          // Count of words in the term dictionary.
+	Hashes         int `json:"hashes"`         // Count of hashed words.
+	IndexRecords   int `json:"indexrecords"`   // Count of index records. Each one links a hash to a file.
+	Files          int `json:"files"`          // Count of unique files referenced by index records.
+	Blockchains    int `json:"blockchains"`    // Count of blockchains with a reverse record.
+	ReverseRecords int `json:"reverserecords"` // Count of reverse index records.
+	CorruptRecords int `json:"corruptrecords"` // Count of keys with a corrupt value.
+}
+
+// IndexCheckResult is the result of checking the search index.
+type IndexCheckResult struct {
+	IndexStatistic
+	OrphanedRecords int  `json:"orphanedrecords"` // Index records that refer to a blockchain version or block that is no longer available.
+	MissingReverse  int  `json:"missingreverse"`  // Index records without a matching reverse record.
+	OrphanedReverse int  `json:"orphanedreverse"` // Reverse records without a matching index record.
+	OrphanedTerms   int  `json:"orphanedterms"`   // Words in the term dictionary that are no longer indexed.
+	Repaired        bool `json:"repaired"`        // Whether the detected issues were repaired.
+}
+
+// reverseEntry is a single reverse record, identifying an index record for a blockchain.
+type reverseEntry struct {
+	hash   [32]byte
+	fileID uuid.UUID
+}
+
+// indexSnapshot is a decoded copy of all records in the search index.
+type indexSnapshot struct {
+	records      map[[32]byte][]*SearchIndexRecord // Hash -> index records. Corrupt records are not included.
+	reverse      map[string]map[reverseEntry]struct{}
+	terms        []string
+	corruptKeys  [][]byte
+	corruptCount int
+}
+
+// snapshot reads all records from the database. The caller should lock the index to get a consistent state.
+func (index *SearchIndexStore) snapshot(progress *IndexProgress) (snapshot *indexSnapshot) {
+	snapshot = &indexSnapshot{
+		records: make(map[[32]byte][]*SearchIndexRecord),
+		reverse: make(map[string]map[reverseEntry]struct{}),
+	}
+
+	index.Database.Iterate(func(key, value []byte) {
+		switch {
+		case strings.HasPrefix(string(key), keyTermPrefix):
+			snapshot.terms = append(snapshot.terms, string(key[len(keyTermPrefix):]))
+
+		case len(key) == 32:
+			var hash [32]byte
+			copy(hash[:], key)
+
+			if len(value)%indexRecordSize != 0 {
+				snapshot.corruptKeys = append(snapshot.corruptKeys, hash[:])
+				snapshot.corruptCount++
+				break
+			}
+
+			for offset := 0; offset < len(value); offset += indexRecordSize {
+				if record := decodeIndexRecord(value[offset : offset+indexRecordSize]); record != nil {
+					snapshot.records[hash] = append(snapshot.records[hash], record)
+				} else {
+					snapshot.corruptCount++
+				}
+			}
+
+		case len(key) == 33:
+			if len(value)%reverseIndexRecordSize != 0 {
+				snapshot.corruptKeys = append(snapshot.corruptKeys, append([]byte{}, key...))
+				snapshot.corruptCount++
+				break
+			}
+
+			entries := make(map[reverseEntry]struct{})
+			for offset := 0; offset < len(value); offset += reverseIndexRecordSize {
+				var entry reverseEntry
+				copy(entry.hash[:], value[offset:offset+32])
+				copy(entry.fileID[:], value[offset+32:offset+32+16])
+				entries[entry] = struct{}{}
+			}
+			snapshot.reverse[string(key)] = entries
+		}
+
+		progress.add(1)
+	})
+
+	return snapshot
+}
+
+// statistic returns the counts of the snapshot.
+func (snapshot *indexSnapshot) statistic() (stats IndexStatistic) {
+	files := make(map[uuid.UUID]struct{})
+
+	for _, records := range snapshot.records {
+		stats.IndexRecords += len(records)
+		for _, record := range records {
+			files[record.FileID] = struct{}{}
+		}
+	}
+
+	for _, entries := range snapshot.reverse {
+		stats.ReverseRecords += len(entries)
+	}
+
+	stats.Terms = len(snapshot.terms)
+	stats.Hashes = len(snapshot.records)
+	stats.Files = len(files)
+	stats.Blockchains = len(snapshot.reverse)
+	stats.CorruptRecords = snapshot.corruptCount
+
+	return stats
+}
+
+// Statistic returns counts of terms and records in the search index.
+func (index *SearchIndexStore) Statistic() (stats IndexStatistic) {
+	if index == nil {
+		return stats
+	}
+
+	index.RLock()
+	snapshot := index.snapshot(nil)
+	index.RUnlock()
+
+	return snapshot.statistic()
+}
+
+// errMaintenanceRunning is returned if another maintenance operation is already running.
+var errMaintenanceRunning = errors.New("index maintenance already running")
+
+// Rebuild deletes the entire search index and indexes all blocks from the sources again. Progress is counted in blockchains.
+// New blocks may be indexed concurrently while the rebuild is running.
+func (index *SearchIndexStore) Rebuild(sources IndexSources, progress *IndexProgress) (err error) {
+	if index == nil {
+		return errors.New("search index not available")
+	} else if !index.maintenance.TryLock() {
+		return errMaintenanceRunning
+	}
+	defer index.maintenance.Unlock()
+
+	// collect the headers first, since the global blockchain cache may not be modified while iterating
+	var headers []*blockchain.MultiBlockchainHeader
+	if sources.Multi != nil {
+		sources.Multi.IterateBlockchains(func(header *blockchain.MultiBlockchainHeader) {
+			headers = append(headers, header)
+		})
+	}
+
+	progress.setTotal(len(headers) + 1)
+
+	index.clear()
+
+	// the user's blockchain
+	if sources.User != nil {
+		publicKey, height, version := sources.User.Header()
+
+		for blockN := uint64(0); blockN < height; blockN++ {
+			raw, status, err := sources.User.GetBlockRaw(blockN)
+			if err != nil || status != blockchain.StatusOK {
+				continue
+			}
+
+			decoded, status, err := blockchain.DecodeBlockRaw(raw)
+			if err != nil || status != blockchain.StatusOK {
+				continue
+			}
+
+			index.indexBlockDecoded(publicKey, version, blockN, decoded.RecordsDecoded, false)
+		}
+	}
+	progress.add(1)
+
+	// all blockchains in the global blockchain cache
+	for _, header := range headers {
+		for _, blockN := range header.ListBlocks {
+			raw, found := sources.Multi.ReadBlock(header.PublicKey, header.Version, blockN)
+			if !found {
+				continue
+			}
+
+			decoded, status, err := blockchain.DecodeBlockRaw(raw)
+			if err != nil || status != blockchain.StatusOK {
+				continue
+			}
+
+			if sources.DecodeHook != nil {
+				sources.DecodeHook(decoded)
+			}
+
+			index.indexBlockDecoded(header.PublicKey, header.Version, blockN, decoded.RecordsDecoded, false)
+		}
+
+		progress.add(1)
+	}
+
+	index.writeIndexVersion()
+	index.RebuildRequired = false
+
+	return nil
+}
+
+// clear deletes all keys in the database and resets the term dictionary.
+func (index *SearchIndexStore) clear() {
+	index.Lock()
+
+	var keys [][]byte
+	index.Database.Iterate(func(key, value []byte) {
+		keys = append(keys, append([]byte{}, key...))
+	})
+
+	for _, key := range keys {
+		index.Database.Delete(key)
+	}
+
+	index.Unlock()
+
+	index.dictionary.Lock()
+	index.dictionary.terms = nil
+	index.dictionary.pending = make(map[string]struct{})
+	index.dictionary.trigrams = make(map[string]map[string]struct{})
+	index.dictionary.Unlock()
+}
+
+// Check detects corrupt and orphaned records in the search index. If repair is set, they are deleted and the reverse records are recreated from the index records.
+// An index record is orphaned if the blockchain version or block it refers to is no longer available in the sources. Progress is counted in database keys.
+func (index *SearchIndexStore) Check(sources IndexSources, repair bool, progress *IndexProgress) (result IndexCheckResult, err error) {
+	if index == nil {
+		return result, errors.New("search index not available")
+	} else if !index.maintenance.TryLock() {
+		return result, errMaintenanceRunning
+	}
+	defer index.maintenance.Unlock()
+
+	progress.setTotal(int(index.Database.Count()))
+
+	if repair {
+		index.Lock()
+	} else {
+		index.RLock()
+	}
+
+	snapshot := index.snapshot(progress)
+	result.IndexStatistic = snapshot.statistic()
+
+	isBlockAvailable := newBlockAvailability(sources)
+
+	// Valid index records and the reverse records expected for them.
+	validRecords := make(map[[32]byte][]*SearchIndexRecord)
+	expectedReverse := make(map[string]map[reverseEntry]struct{})
+
+	for hash, records := range snapshot.records {
+		for _, record := range records {
+			if !isBlockAvailable(record.PublicKey, record.BlockchainVersion, record.BlockNumber) {
+				result.OrphanedRecords++
+				continue
+			}
+
+			validRecords[hash] = append(validRecords[hash], record)
+
+			key := string(record.PublicKey.SerializeCompressed())
+			entry := reverseEntry{hash: hash, fileID: record.FileID}
+
+			if _, ok := snapshot.reverse[key][entry]; !ok {
+				result.MissingReverse++
+			}
+
+			if expectedReverse[key] == nil {
+				expectedReverse[key] = make(map[reverseEntry]struct{})
+			}
+			expectedReverse[key][entry] = struct{}{}
+		}
+	}
+
+	for key, entries := range snapshot.reverse {
+		for entry := range entries {
+			if _, ok := expectedReverse[key][entry]; !ok {
+				result.OrphanedReverse++
+			}
+		}
+	}
+
+	var orphanedTerms []string
+	for _, word := range snapshot.terms {
+		if hash, _ := hashWord(word); hash != nil {
+			var hash32 [32]byte
+			copy(hash32[:], hash)
+			if _, ok := validRecords[hash32]; ok {
+				continue
+			}
+		}
+		orphanedTerms = append(orphanedTerms, word)
+	}
+	result.OrphanedTerms = len(orphanedTerms)
+
+	if !repair {
+		index.RUnlock()
+		return result, nil
+	}
+
+	// delete corrupt keys and rewrite all index and reverse records
+	for _, key := range snapshot.corruptKeys {
+		index.Database.Delete(key)
+	}
+
+	for hash, records := range snapshot.records {
+		valid := validRecords[hash]
+		if len(valid) == 0 {
+			index.Database.Delete(hash[:])
+			continue
+		} else if len(valid) == len(records) {
+			continue
+		}
+
+		var raw []byte
+		for _, record := range valid {
+			raw = append(raw, encodeIndexRecord(record.PublicKey, record.BlockchainVersion, record.BlockNumber, record.FileID)...)
+		}
+		index.Database.Set(hash[:], raw)
+	}
+
+	for key := range snapshot.reverse {
+		if _, ok := expectedReverse[key]; !ok {
+			index.Database.Delete([]byte(key))
+		}
+	}
+
+	for key, entries := range expectedReverse {
+		if reverseEntriesEqual(snapshot.reverse[key], entries) {
+			continue
+		}
+
+		raw := make([]byte, 0, len(entries)*reverseIndexRecordSize)
+		for entry := range entries {
+			raw = append(raw, entry.hash[:]...)
+			raw = append(raw, entry.fileID[:]...)
+		}
+		index.Database.Set([]byte(key), raw)
+	}
+
+	index.Unlock()
+
+	if len(orphanedTerms) > 0 {
+		index.removeTerms(orphanedTerms)
+	}
+
+	result.Repaired = true
+
+	return result, nil
+}
+
+// newBlockAvailability returns a function that checks if a block is available in the sources. Headers of the global blockchain cache are cached.
+func newBlockAvailability(sources IndexSources) func(publicKey *btcec.PublicKey, version, blockNumber uint64) bool {
+	var userPublicKey []byte
+	var userHeight, userVersion uint64
+
+	if sources.User != nil {
+		var publicKey *btcec.PublicKey
+		publicKey, userHeight, userVersion = sources.User.Header()
+		userPublicKey = publicKey.SerializeCompressed()
+	}
+
+	// list of blocks per blockchain, nil if not available
+	cache := make(map[string]map[uint64]struct{})
+	cacheVersion := make(map[string]uint64)
+
+	return func(publicKey *btcec.PublicKey, version, blockNumber uint64) bool {
+		key := publicKey.SerializeCompressed()
+
+		if bytes.Equal(key, userPublicKey) {
+			return version == userVersion && blockNumber < userHeight
+		} else if sources.Multi == nil {
+			return false
+		}
+
+		blocks, ok := cache[string(key)]
+		if !ok {
+			if header, found, err := sources.Multi.ReadBlockchainHeader(publicKey); err == nil && found {
+				blocks = make(map[uint64]struct{})
+				for _, number := range header.ListBlocks {
+					blocks[number] = struct{}{}
+				}
+				cacheVersion[string(key)] = header.Version
+			}
+			cache[string(key)] = blocks
+		}
+
+		if blocks == nil || cacheVersion[string(key)] != version {
+			return false
+		}
+
+		_, ok = blocks[blockNumber]
+		return ok
+	}
+}
+
+// reverseEntriesEqual checks if both sets of reverse records are equal.
+func reverseEntriesEqual(a, b map[reverseEntry]struct{}) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for entry := range a {
+		if _, ok := b[entry]; !ok {
+			return false
+		}
+	}
+
+	return true
+}
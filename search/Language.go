@@ -0,0 +1,174 @@
+/*
+File name:  Language.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Language specific rules for indexing and searching text. They are optional and set per search index.
+Stemming reduces words to a common stem, so that "movies" finds "movie". Both the word and its stem are indexed and searched.
+Stop words are common words that are neither indexed nor searched as individual words.
+
+Stemmers are light suffix strippers. They do not need to return linguistically correct stems, but must return the same stem for all forms of a word.
+Changing any rule requires increasing indexVersion, since the existing index must be rebuilt.
+*/
+
+package search
+
+import (
+	"strings"
+)
+
+// Supported languages for stemming and stop words.
+const (
+	LanguageNone    = ""   // No stemming and stop words.
+	LanguageEnglish = "en" // English
+	LanguageGerman  = "de" // German
+)
+
+// textRules are the language specific rules for indexing and searching text. A nil pointer is valid and means no rules.
+type textRules struct {
+	language  string
+	stem      func(word string) string
+	stopWords map[string]struct{}
+}
+
+// newTextRules returns the rules for the language. It returns nil for LanguageNone, and false if the language is not supported.
+func newTextRules(language string) (rules *textRules, ok bool) {
+	switch language {
+	case LanguageNone:
+		return nil, true
+	case LanguageEnglish:
+		return &textRules{language: language, stem: stemEnglish, stopWords: wordSet(stopWordsEnglish)}, true
+	case LanguageGerman:
+		return &textRules{language: language, stem: stemGerman, stopWords: wordSet(stopWordsGerman)}, true
+	}
+
+	return nil, false
+}
+
+// languageName returns the language of the rules.
+func (rules *textRules) languageName() string {
+	if rules == nil {
+		return LanguageNone
+	}
+	return rules.language
+}
+
+// isStopWord checks if the normalized word is a stop word.
+func (rules *textRules) isStopWord(word string) bool {
+	if rules == nil {
+		return false
+	}
+
+	_, ok := rules.stopWords[word]
+	return ok
+}
+
+// stemWord returns the stem of the normalized word. It returns the word itself if there is no stem.
+func (rules *textRules) stemWord(word string) string {
+	if rules == nil || !isLowerASCII(word) {
+		return word
+	}
+
+	if stem := rules.stem(word); len(stem) >= wordMinLength {
+		return stem
+	}
+
+	return word
+}
+
+func wordSet(words string) (set map[string]struct{}) {
+	set = make(map[string]struct{})
+	for _, word := range strings.Fields(words) {
+		set[word] = struct{}{}
+	}
+	return set
+}
+
+// isLowerASCII checks if the word only contains the letters a-z. Stemmers only apply to such words.
+func isLowerASCII(word string) bool {
+	for n := 0; n < len(word); n++ {
+		if word[n] < 'a' || word[n] > 'z' {
+			return false
+		}
+	}
+	return true
+}
+
+func isVowel(char byte) bool {
+	return strings.IndexByte("aeiouy", char) >= 0
+}
+
+// hasVowel checks if the word contains a vowel.
+func hasVowel(word string) bool {
+	for n := 0; n < len(word); n++ {
+		if isVowel(word[n]) {
+			return true
+		}
+	}
+	return false
+}
+
+// ---- English ----
+
+const stopWordsEnglish = "a an and are as at be but by for from has have in is it its of on or that the this to was were will with"
+
+// stemEnglish is a light English stemmer based on step 1 of the Porter stemmer. A final "e" is removed so that "hope", "hoped", and "hoping" share the stem "hop".
+func stemEnglish(word string) string {
+	if len(word) < 4 {
+		return word
+	}
+
+	// plurals
+	switch {
+	case strings.HasSuffix(word, "sses"):
+		word = word[:len(word)-2]
+	case strings.HasSuffix(word, "ies"):
+		word = word[:len(word)-2]
+	case strings.HasSuffix(word, "ss"), strings.HasSuffix(word, "us"):
+	case strings.HasSuffix(word, "s"):
+		word = word[:len(word)-1]
+	}
+
+	// past tense and progressive
+	for _, suffix := range []string{"ing", "ed"} {
+		if stem := strings.TrimSuffix(word, suffix); stem != word && len(stem) >= 3 && hasVowel(stem) {
+			word = stem
+
+			// double consonant: "running" -> "run"
+			if last := word[len(word)-1]; last == word[len(word)-2] && !isVowel(last) && strings.IndexByte("lsz", last) < 0 {
+				word = word[:len(word)-1]
+			}
+			break
+		}
+	}
+
+	// "city" and "cities" share the stem "citi"
+	if strings.HasSuffix(word, "y") && hasVowel(word[:len(word)-1]) {
+		word = word[:len(word)-1] + "i"
+	}
+
+	if strings.HasSuffix(word, "e") && len(word) > 3 {
+		word = word[:len(word)-1]
+	}
+
+	return word
+}
+
+// ---- German ----
+
+const stopWordsGerman = "aber als am an auch auf aus bei das dass dem den der des die ein eine einem einen einer eines es im in ist mit nicht oder sich sie sind und von vom zu zum zur"
+
+// stemGerman is a light German stemmer based on CISTEM. Umlauts and ß are already folded by the normalization.
+func stemGerman(word string) string {
+	for len(word) > 3 {
+		if len(word) > 5 && (strings.HasSuffix(word, "em") || strings.HasSuffix(word, "er") || strings.HasSuffix(word, "nd")) {
+			word = word[:len(word)-2]
+		} else if last := word[len(word)-1]; last == 'e' || last == 's' || last == 'n' || last == 't' {
+			word = word[:len(word)-1]
+		} else {
+			break
+		}
+	}
+
+	return word
+}
@@ -4,6 +4,10 @@ Copyright:  2021 Peernet s.r.o.
 Author:     Peter Kleissner
 
 Normalizing text so that it can be hashed.
+
+1. Sanitize:    Invalid UTF-8 is removed and the text is NFKC normalized. Compatibility characters such as full-width letters or ligatures are replaced by their regular form.
+2. Tokenize:    See text2Hashes.
+3. Normalize:   Each word is lowercased and diacritics are removed from Latin, Greek, and Cyrillic letters ("Café" -> "cafe").
 */
 
 package search
@@ -11,16 +15,66 @@ package search
 import (
 	"path"
 	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
 )
 
 // sanitizeGeneric sanitizes the text. It intentionally does not lowercase the text so CamelCase can be detcted later.
 func sanitizeGeneric(filename string) string {
 	filename = strings.ToValidUTF8(filename, "")
+	filename = norm.NFKC.String(filename)
 	filename = strings.TrimSpace(filename)
 
 	return filename
 }
 
+// normalizeWord normalizes a word for hashing. It is lowercased and diacritics are removed. Wildcard characters are kept.
+func normalizeWord(word string) string {
+	return strings.TrimSpace(normalizeText(word))
+}
+
+// normalizeText lowercases the text and removes diacritics.
+func normalizeText(text string) string {
+	return foldDiacritics(strings.ToLower(norm.NFKC.String(text)))
+}
+
+// foldLetters are letters that are not decomposed by Unicode normalization, with their replacement.
+var foldLetters = map[rune]string{'ß': "ss", 'æ': "ae", 'œ': "oe", 'ø': "o", 'đ': "d", 'ð': "d", 'ł': "l", 'ı': "i", 'þ': "th"}
+
+// foldDiacritics removes diacritics from Latin, Greek, and Cyrillic letters. Marks in other scripts are kept, since they may change the meaning (for example the Japanese dakuten).
+func foldDiacritics(text string) string {
+	isFold := false
+	for _, char := range text {
+		if char >= 0x80 {
+			isFold = true
+			break
+		}
+	}
+	if !isFold { // fast path for ASCII
+		return text
+	}
+
+	var output strings.Builder
+	var base rune
+
+	for _, char := range norm.NFD.String(text) {
+		if unicode.Is(unicode.Mn, char) && unicode.In(base, unicode.Latin, unicode.Greek, unicode.Cyrillic) {
+			continue
+		} else if replacement, ok := foldLetters[char]; ok {
+			output.WriteString(replacement)
+		} else {
+			output.WriteRune(char)
+		}
+
+		if !unicode.Is(unicode.Mn, char) {
+			base = char
+		}
+	}
+
+	return norm.NFC.String(output.String())
+}
+
 // sanitizeInputTerm sanitizes a search term provided by the end user. It includes the generic rules that are done on indexing.
 func sanitizeInputTerm(inputTerm string) (outputTerm string, isExact, isWildcard bool) {
 	inputTerm = sanitizeGeneric(inputTerm)
@@ -32,7 +86,7 @@ func sanitizeInputTerm(inputTerm string) (outputTerm string, isExact, isWildcard
 		isExact = true
 	}
 
-	isWildcard = strings.Contains(inputTerm, "*")
+	isWildcard = isWildcardTerm(inputTerm)
 
 	return inputTerm, isExact, isWildcard
 }
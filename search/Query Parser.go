@@ -0,0 +1,514 @@
+/*
+File name:  Query Parser.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Parser for the structured search query language. See Query.go for the syntax.
+
+Grammar:
+query   = or
+or      = and { "OR" and }
+and     = unary { [ "AND" ] unary }
+unary   = ( "NOT" | "-" ) unary | primary
+primary = "(" or ")" | field ":" value | phrase | word
+*/
+
+package search
+
+import (
+	"encoding/hex"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/newinfoOffical/core/blockchain"
+)
+
+// queryMaxLength is the max length of a query in bytes.
+const queryMaxLength = 1024
+
+// queryMaxDepth is the max nesting depth of parentheses and NOT operators.
+const queryMaxDepth = 16
+
+// Token types of the query tokenizer
+const (
+	tokenWord   = iota // Word, optionally with a field qualifier
+	tokenPhrase        // Phrase in double quotes, optionally with a field qualifier
+	tokenOpen          // (
+	tokenClose         // )
+)
+
+type queryToken struct {
+	kind  int
+	field string // Field qualifier without the colon, if any. Always lowercase.
+	text  string // Word or phrase
+}
+
+// queryFields are all supported field qualifiers.
+var queryFields = map[string]struct{}{"name": {}, "folder": {}, "desc": {}, "title": {}, "artist": {}, "album": {}, "camera": {}, "ext": {}, "type": {}, "format": {}, "size": {}, "date": {}, "node": {}, "duration": {}, "width": {}, "height": {}}
+
+// tokenizeQuery splits the query into tokens.
+func tokenizeQuery(text string) (tokens []queryToken, err error) {
+	runes := []rune(text)
+
+	for n := 0; n < len(runes); {
+		switch {
+		case unicode.IsSpace(runes[n]):
+			n++
+
+		case runes[n] == '(':
+			tokens = append(tokens, queryToken{kind: tokenOpen})
+			n++
+
+		case runes[n] == ')':
+			tokens = append(tokens, queryToken{kind: tokenClose})
+			n++
+
+		default:
+			// read the word until space, parenthesis, or quote
+			start := n
+			for n < len(runes) && !unicode.IsSpace(runes[n]) && runes[n] != '(' && runes[n] != ')' && runes[n] != '"' {
+				n++
+			}
+			word := string(runes[start:n])
+
+			// field qualifier?
+			var field string
+			if colon := strings.Index(word, ":"); colon > 0 {
+				if _, ok := queryFields[strings.ToLower(word[:colon])]; ok {
+					field = strings.ToLower(word[:colon])
+					word = word[colon+1:]
+				}
+			}
+
+			// phrase, either standalone or as field value
+			if n < len(runes) && runes[n] == '"' && word == "" {
+				end := n + 1
+				for end < len(runes) && runes[end] != '"' {
+					end++
+				}
+				if end >= len(runes) {
+					return nil, errors.New("missing closing quote")
+				}
+
+				tokens = append(tokens, queryToken{kind: tokenPhrase, field: field, text: string(runes[n+1 : end])})
+				n = end + 1
+				continue
+			}
+
+			if word == "" {
+				return nil, errors.New("missing value for field " + field)
+			}
+
+			tokens = append(tokens, queryToken{kind: tokenWord, field: field, text: word})
+		}
+	}
+
+	return tokens, nil
+}
+
+// queryParser is a recursive descent parser over the tokens.
+type queryParser struct {
+	tokens []queryToken
+	pos    int
+	depth  int
+}
+
+func (parser *queryParser) peek() (token *queryToken) {
+	if parser.pos < len(parser.tokens) {
+		return &parser.tokens[parser.pos]
+	}
+	return nil
+}
+
+// isOperator checks if the token is the operator keyword. Operators must be uppercase; lowercase "and", "or", "not" are regular words.
+func isOperator(token *queryToken, operator string) bool {
+	return token != nil && token.kind == tokenWord && token.field == "" && token.text == operator
+}
+
+func (parser *queryParser) parseOr() (node queryNode, err error) {
+	node, err = parser.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := []queryNode{node}
+
+	for isOperator(parser.peek(), "OR") {
+		parser.pos++
+
+		if node, err = parser.parseAnd(); err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+
+	return &queryOr{nodes: nodes}, nil
+}
+
+func (parser *queryParser) parseAnd() (node queryNode, err error) {
+	var nodes []queryNode
+
+	for {
+		token := parser.peek()
+		if token == nil || token.kind == tokenClose || isOperator(token, "OR") {
+			break
+		} else if isOperator(token, "AND") {
+			parser.pos++
+			continue
+		}
+
+		if node, err = parser.parseUnary(); err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+
+	if len(nodes) == 0 {
+		return nil, errors.New("empty expression")
+	} else if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+
+	return &queryAnd{nodes: nodes}, nil
+}
+
+func (parser *queryParser) parseUnary() (node queryNode, err error) {
+	token := parser.peek()
+
+	if isOperator(token, "NOT") || token.kind == tokenWord && token.field == "" && token.text == "-" {
+		parser.pos++
+
+		if parser.depth++; parser.depth > queryMaxDepth {
+			return nil, errors.New("query too complex")
+		}
+		defer func() { parser.depth-- }()
+
+		if parser.peek() == nil {
+			return nil, errors.New("missing expression after NOT")
+		}
+
+		if node, err = parser.parseUnary(); err != nil {
+			return nil, err
+		}
+		return &queryNot{node: node}, nil
+	}
+
+	// negation prefix directly attached to a word or field, for example -draft or -ext:tmp
+	if token.kind == tokenWord && token.field == "" && strings.HasPrefix(token.text, "-") && len(token.text) > 1 {
+		negated := *token
+		negated.text = token.text[1:]
+		if colon := strings.Index(negated.text, ":"); colon > 0 {
+			if _, ok := queryFields[strings.ToLower(negated.text[:colon])]; ok {
+				negated.field = strings.ToLower(negated.text[:colon])
+				negated.text = negated.text[colon+1:]
+			}
+		}
+		parser.pos++
+
+		if node, err = parseQueryTerm(&negated); err != nil {
+			return nil, err
+		}
+		return &queryNot{node: node}, nil
+	}
+
+	return parser.parsePrimary()
+}
+
+func (parser *queryParser) parsePrimary() (node queryNode, err error) {
+	token := parser.peek()
+	parser.pos++
+
+	switch token.kind {
+	case tokenOpen:
+		if parser.depth++; parser.depth > queryMaxDepth {
+			return nil, errors.New("query too complex")
+		}
+		defer func() { parser.depth-- }()
+
+		if node, err = parser.parseOr(); err != nil {
+			return nil, err
+		}
+
+		if token := parser.peek(); token == nil || token.kind != tokenClose {
+			return nil, errors.New("missing closing parenthesis")
+		}
+		parser.pos++
+
+		return node, nil
+
+	case tokenClose:
+		return nil, errors.New("unexpected closing parenthesis")
+	}
+
+	return parseQueryTerm(token)
+}
+
+// parseQueryTerm parses a single word or phrase, optionally with a field qualifier.
+func parseQueryTerm(token *queryToken) (node queryNode, err error) {
+	isPhrase := token.kind == tokenPhrase
+
+	switch token.field {
+	case "":
+		return newQueryText(queryFieldAny, token.text, isPhrase), nil
+
+	case "name":
+		return newQueryText(queryFieldName, token.text, isPhrase), nil
+
+	case "folder":
+		return newQueryText(queryFieldFolder, token.text, isPhrase), nil
+
+	case "desc":
+		return newQueryText(queryFieldDescription, token.text, isPhrase), nil
+
+	case "title":
+		return newQueryText(queryFieldTitle, token.text, isPhrase), nil
+
+	case "artist":
+		return newQueryText(queryFieldArtist, token.text, isPhrase), nil
+
+	case "album":
+		return newQueryText(queryFieldAlbum, token.text, isPhrase), nil
+
+	case "camera":
+		return newQueryText(queryFieldCamera, token.text, isPhrase), nil
+
+	case "ext":
+		return &queryExtension{extension: normalizeWord(strings.TrimPrefix(token.text, "."))}, nil
+
+	case "type":
+		number, err := strconv.ParseUint(token.text, 10, 8)
+		if err != nil {
+			return nil, errors.New("invalid file type")
+		}
+		return &queryType{fileType: uint8(number)}, nil
+
+	case "format":
+		number, err := strconv.ParseUint(token.text, 10, 16)
+		if err != nil {
+			return nil, errors.New("invalid file format")
+		}
+		return &queryFormat{fileFormat: uint16(number)}, nil
+
+	case "size":
+		return parseQuerySize(token.text)
+
+	case "date":
+		return parseQueryDate(token.text)
+
+	case "node":
+		nodeID, err := hex.DecodeString(token.text)
+		if err != nil || len(nodeID) != 32 {
+			return nil, errors.New("invalid node ID")
+		}
+		return &queryNodeID{nodeID: nodeID}, nil
+
+	case "duration":
+		return parseQueryTagNumber(blockchain.TagDuration, token.text, parseDuration)
+
+	case "width":
+		return parseQueryTagNumber(blockchain.TagWidth, token.text, parseNumber)
+
+	case "height":
+		return parseQueryTagNumber(blockchain.TagHeight, token.text, parseNumber)
+	}
+
+	return nil, errors.New("unknown field")
+}
+
+// parseQueryRange parses a comparison (>, >=, <, <=, =) or a range (a..b) into the lower and upper bound text. Empty bounds are not set.
+// The returned flags indicate whether the bound is exclusive.
+func parseQueryRange(text string) (lower, upper string, lowerExclusive, upperExclusive bool) {
+	switch {
+	case strings.HasPrefix(text, ">="):
+		return text[2:], "", false, false
+	case strings.HasPrefix(text, "<="):
+		return "", text[2:], false, false
+	case strings.HasPrefix(text, ">"):
+		return text[1:], "", true, false
+	case strings.HasPrefix(text, "<"):
+		return "", text[1:], false, true
+	case strings.HasPrefix(text, "="):
+		return text[1:], text[1:], false, false
+	}
+
+	if n := strings.Index(text, ".."); n >= 0 {
+		return text[:n], text[n+2:], false, false
+	}
+
+	return text, text, false, false
+}
+
+// parseQuerySize parses a size condition, for example size:>100MB or size:1KB..2MB. Units are B, KB, MB, GB, TB as multiples of 1024.
+func parseQuerySize(text string) (node queryNode, err error) {
+	lower, upper, lowerExclusive, upperExclusive := parseQueryRange(text)
+	if lower == "" && upper == "" {
+		return nil, errors.New("invalid size")
+	}
+
+	size := &querySize{min: 0, max: math.MaxUint64}
+
+	if lower != "" {
+		if size.min, err = parseSize(lower); err != nil {
+			return nil, err
+		}
+		if lowerExclusive {
+			size.min++
+		}
+	}
+
+	if upper != "" {
+		if size.max, err = parseSize(upper); err != nil {
+			return nil, err
+		}
+		if upperExclusive {
+			if size.max == 0 {
+				return nil, errors.New("invalid size")
+			}
+			size.max--
+		}
+	}
+
+	return size, nil
+}
+
+// parseSize parses a size with an optional unit.
+func parseSize(text string) (size uint64, err error) {
+	text = strings.ToUpper(strings.TrimSpace(text))
+
+	units := []struct {
+		suffix     string
+		multiplier uint64
+	}{{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}}
+
+	multiplier := uint64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(text, unit.suffix) {
+			text = strings.TrimSpace(strings.TrimSuffix(text, unit.suffix))
+			multiplier = unit.multiplier
+			break
+		}
+	}
+
+	number, err := strconv.ParseFloat(text, 64)
+	if err != nil || number < 0 || number*float64(multiplier) >= math.MaxUint64 {
+		return 0, errors.New("invalid size")
+	}
+
+	return uint64(number * float64(multiplier)), nil
+}
+
+// parseQueryTagNumber parses a condition on a number tag, for example width:>=1920 or duration:1m..5m. The parse function converts a single bound.
+func parseQueryTagNumber(tagType uint16, text string, parse func(text string) (uint64, error)) (node queryNode, err error) {
+	lower, upper, lowerExclusive, upperExclusive := parseQueryRange(text)
+	if lower == "" && upper == "" {
+		return nil, errors.New("invalid number")
+	}
+
+	number := &queryTagNumber{tagType: tagType, min: 0, max: math.MaxUint64}
+
+	if lower != "" {
+		if number.min, err = parse(lower); err != nil {
+			return nil, err
+		}
+		if lowerExclusive {
+			number.min++
+		}
+	}
+
+	if upper != "" {
+		if number.max, err = parse(upper); err != nil {
+			return nil, err
+		}
+		if upperExclusive {
+			if number.max == 0 {
+				return nil, errors.New("invalid number")
+			}
+			number.max--
+		}
+	}
+
+	return number, nil
+}
+
+// parseNumber parses a plain number.
+func parseNumber(text string) (number uint64, err error) {
+	if number, err = strconv.ParseUint(strings.TrimSpace(text), 10, 64); err != nil {
+		return 0, errors.New("invalid number")
+	}
+	return number, nil
+}
+
+// parseDuration parses a duration in seconds. Plain numbers are seconds, otherwise units like 90s, 5m or 1h30m are required.
+func parseDuration(text string) (seconds uint64, err error) {
+	text = strings.ToLower(strings.TrimSpace(text))
+
+	if seconds, err = strconv.ParseUint(text, 10, 64); err == nil {
+		return seconds, nil
+	}
+
+	duration, err := time.ParseDuration(text)
+	if err != nil || duration < 0 {
+		return 0, errors.New("invalid duration")
+	}
+
+	return uint64(duration / time.Second), nil
+}
+
+// parseQueryDate parses a date condition, for example date:2022..2023 or date:>2022-06. Dates are in UTC.
+// Each date is a period (year, month, or day). The lower bound starts at the beginning of the period, the upper bound ends at the end of its period.
+func parseQueryDate(text string) (node queryNode, err error) {
+	lower, upper, lowerExclusive, upperExclusive := parseQueryRange(text)
+	if lower == "" && upper == "" {
+		return nil, errors.New("invalid date")
+	}
+
+	date := &queryDate{}
+
+	if lower != "" {
+		start, end, err := parseDatePeriod(lower)
+		if err != nil {
+			return nil, err
+		}
+		if date.from = start; lowerExclusive {
+			date.from = end.Add(time.Nanosecond)
+		}
+	}
+
+	if upper != "" {
+		start, end, err := parseDatePeriod(upper)
+		if err != nil {
+			return nil, err
+		}
+		if date.to = end; upperExclusive {
+			date.to = start.Add(-time.Nanosecond)
+		}
+	}
+
+	return date, nil
+}
+
+// parseDatePeriod parses a year (2006), month (2006-01), or day (2006-01-02) and returns the first and last moment of the period.
+func parseDatePeriod(text string) (start, end time.Time, err error) {
+	formats := []struct {
+		layout string
+		years  int
+		months int
+		days   int
+	}{{"2006", 1, 0, 0}, {"2006-01", 0, 1, 0}, {"2006-01-02", 0, 0, 1}}
+
+	for _, format := range formats {
+		if start, err = time.Parse(format.layout, text); err == nil {
+			return start, start.AddDate(format.years, format.months, format.days).Add(-time.Nanosecond), nil
+		}
+	}
+
+	return start, end, errors.New("invalid date")
+}
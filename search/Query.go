@@ -0,0 +1,560 @@
+/*
+File name:  Query.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+Structured search queries combine words, phrases, and field conditions with boolean operators.
+
+Syntax:
+word            Word in the file name, folder, description, or media tags (title, artist, album, camera). Wildcards * and ? are supported.
+"a phrase"      Phrase in the file name, folder, description, or media tags
+a b, a AND b    Both must match
+a OR b          Either must match
+NOT a, -a       Must not match
+( )             Grouping
+
+Fields:
+name:           Word or phrase in the file name
+folder:         Word or phrase in the folder
+desc:           Word or phrase in the description
+title:          Word or phrase in the title of a song or video
+artist:         Word or phrase in the artist
+album:          Word or phrase in the album
+camera:         Word or phrase in the camera make and model
+ext:            File extension, for example ext:pdf
+type:           File type number, see core.TypeX
+format:         File format number, see core.FormatX
+size:           File size, for example size:>100MB, size:<=1GB, size:1MB..10MB. Units are multiples of 1024.
+date:           Date shared (UTC), for example date:2022, date:2022..2023, date:>=2022-06-01
+node:           Node ID of the owner (hex encoded)
+duration:       Duration of audio or video, for example duration:>5m, duration:30s..2m. Plain numbers are seconds.
+width:          Width of the picture or video in pixels, for example width:>=1920
+height:         Height of the picture or video in pixels
+
+The query is executed in two steps. First, candidates are looked up in the index via the words that are not negated.
+Each candidate must then be verified via Query.Match against the decoded file record, which evaluates all conditions.
+Conditions use the same semantics as the search filters: size and date bounds are inclusive, and files without a shared date never match a date condition.
+Files without the tag never match a duration, width, or height condition.
+Words are normalized and stemmed by the same rules as the index. Stop words are not indexed; they are only verified on the file, but cannot find candidates on their own.
+*/
+
+package search
+
+import (
+	"bytes"
+	"errors"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/newinfoOffical/core/blockchain"
+)
+
+// Query is a parsed structured search query.
+type Query struct {
+	root  queryNode
+	rules *textRules // Text rules of the index the query is executed on. Set by SearchQuery.
+}
+
+// ParseQuery parses a structured search query. It fails if the syntax is invalid, or if the query has no words that can be looked up in the index.
+func ParseQuery(text string) (query *Query, err error) {
+	if len(text) > queryMaxLength {
+		return nil, errors.New("query too long")
+	}
+
+	tokens, err := tokenizeQuery(sanitizeGeneric(text))
+	if err != nil {
+		return nil, err
+	} else if len(tokens) == 0 {
+		return nil, errors.New("empty query")
+	}
+
+	parser := &queryParser{tokens: tokens}
+
+	root, err := parser.parseOr()
+	if err != nil {
+		return nil, err
+	} else if parser.pos < len(parser.tokens) {
+		return nil, errors.New("unexpected closing parenthesis")
+	} else if !root.isSearchable() {
+		return nil, errors.New("query requires at least one word that is not negated")
+	}
+
+	return &Query{root: root}, nil
+}
+
+// Match checks if the file matches the query. It uses the text rules of the index that the query was last executed on via SearchQuery.
+func (query *Query) Match(file *blockchain.BlockRecordFile) bool {
+	return query.root.match(newQueryFile(file, query.rules))
+}
+
+// MatchFile checks if the file matches the query using the text rules of the index. Unlike Match it does not require the query to be executed first.
+func (index *SearchIndexStore) MatchFile(query *Query, file *blockchain.BlockRecordFile) bool {
+	if query == nil {
+		return false
+	}
+
+	var rules *textRules
+	if index != nil {
+		rules = index.rules
+	}
+
+	return query.root.match(newQueryFile(file, rules))
+}
+
+// SearchQuery returns candidates for the query from the index. Each candidate must be verified via query.Match on the decoded file.
+func (index *SearchIndexStore) SearchQuery(query *Query) (candidates []SearchIndexRecord) {
+	if index == nil || query == nil {
+		return nil
+	}
+
+	query.rules = index.rules
+
+	for _, record := range query.root.candidates(index) {
+		candidates = append(candidates, *record)
+	}
+
+	return candidates
+}
+
+// ---- query nodes ----
+
+// queryNode is a single node of the parsed query.
+type queryNode interface {
+	// match checks if the file matches the node.
+	match(file *queryFile) bool
+
+	// isSearchable checks if candidates can be looked up in the index for this node.
+	isSearchable() bool
+
+	// candidates looks up candidates in the index. It must only be called if isSearchable returns true.
+	candidates(index *SearchIndexStore) map[uuid.UUID]*SearchIndexRecord
+}
+
+// queryFile contains the normalized fields of a file for matching.
+type queryFile struct {
+	file  *blockchain.BlockRecordFile
+	rules *textRules
+	date  time.Time
+
+	text  [queryFieldCount]string              // Normalized text per field
+	raw   [queryFieldCount]string              // Sanitized text per field as indexed. CamelCase is only detected on the original case.
+	words [queryFieldCount]map[string]struct{} // Words per field. Created on first use.
+
+	numbers map[uint16]uint64 // Number tags of the file, see queryTagNumber
+}
+
+func newQueryFile(file *blockchain.BlockRecordFile, rules *textRules) (queryF *queryFile) {
+	queryF = &queryFile{file: file, rules: rules, numbers: make(map[uint16]uint64)}
+
+	for _, tag := range file.Tags {
+		switch tag.Type {
+		case blockchain.TagDateShared:
+			queryF.date, _ = tag.Date()
+		case blockchain.TagDuration, blockchain.TagWidth, blockchain.TagHeight:
+			queryF.numbers[tag.Type] = tag.Number()
+		default:
+			if field, ok := queryFieldTags[tag.Type]; ok {
+				queryF.raw[field] = sanitizeGeneric(tag.Text())
+				queryF.text[field] = normalizeText(queryF.raw[field])
+			}
+		}
+	}
+
+	return queryF
+}
+
+// Text fields. The words of each field are the same as indexed.
+const (
+	queryFieldName        = 0
+	queryFieldFolder      = 1
+	queryFieldDescription = 2
+	queryFieldTitle       = 3
+	queryFieldArtist      = 4
+	queryFieldAlbum       = 5
+	queryFieldCamera      = 6
+	queryFieldCount       = 7 // Count of text fields
+	queryFieldAny         = 7 // Any text field
+)
+
+// queryFieldTags maps the tags to their text field.
+var queryFieldTags = map[uint16]int{
+	blockchain.TagName:        queryFieldName,
+	blockchain.TagFolder:      queryFieldFolder,
+	blockchain.TagDescription: queryFieldDescription,
+	blockchain.TagTitle:       queryFieldTitle,
+	blockchain.TagArtist:      queryFieldArtist,
+	blockchain.TagAlbum:       queryFieldAlbum,
+	blockchain.TagCamera:      queryFieldCamera,
+}
+
+// fieldText returns the normalized text of the field.
+func (queryF *queryFile) fieldText(field int) string {
+	return queryF.text[field]
+}
+
+// fieldWords returns the words of the field as they are indexed, including stems.
+func (queryF *queryFile) fieldWords(field int) map[string]struct{} {
+	if queryF.words[field] != nil {
+		return queryF.words[field]
+	}
+
+	hashes := make(map[[32]byte]string)
+
+	switch field {
+	case queryFieldName:
+		queryF.rules.filename2Hashes(queryF.raw[field], "", hashes)
+	case queryFieldFolder:
+		queryF.rules.text2Hashes(strings.NewReplacer("\\", " ", "/", " ").Replace(queryF.raw[field]), hashes)
+	default:
+		queryF.rules.text2Hashes(queryF.raw[field], hashes)
+	}
+
+	queryF.words[field] = make(map[string]struct{})
+	for _, word := range hashes {
+		queryF.words[field][word] = struct{}{}
+	}
+
+	return queryF.words[field]
+}
+
+// queryText matches a word or phrase in one or all text fields.
+type queryText struct {
+	field    int    // See queryFieldX
+	text     string // Normalized word or phrase. Spaces in phrases are normalized to single spaces.
+	isPhrase bool
+	words    []string // Words of a phrase that are used for the index lookup
+}
+
+func newQueryText(field int, text string, isPhrase bool) (node *queryText) {
+	node = &queryText{field: field, text: normalizeText(text), isPhrase: isPhrase}
+
+	if isPhrase {
+		node.words = strings.Fields(node.text)
+		node.text = strings.Join(node.words, " ")
+	}
+
+	return node
+}
+
+func (node *queryText) match(file *queryFile) bool {
+	if node.field == queryFieldAny {
+		for field := 0; field < queryFieldCount; field++ {
+			if node.matchField(file, field) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return node.matchField(file, node.field)
+}
+
+func (node *queryText) matchField(file *queryFile, field int) bool {
+	text := file.fieldText(field)
+
+	if node.isPhrase {
+		return strings.Contains(strings.Join(strings.Fields(text), " "), node.text)
+	}
+
+	words := file.fieldWords(field)
+
+	if isWildcardTerm(node.text) {
+		for word := range words {
+			if matchWildcard(node.text, word) {
+				return true
+			}
+		}
+		return false
+	} else if len(node.text) < wordMinLength || file.rules.isStopWord(node.text) {
+		// short words and stop words are not indexed as words
+		return containsWord(text, node.text)
+	}
+
+	if _, ok := words[node.text]; ok {
+		return true
+	}
+
+	_, ok := words[file.rules.stemWord(node.text)]
+	return ok
+}
+
+// containsWord checks if the text contains the word, delimited by word separators.
+func containsWord(text, word string) bool {
+	for _, field := range strings.FieldsFunc(text, isWordSeparator) {
+		if field == word {
+			return true
+		}
+	}
+	return false
+}
+
+func (node *queryText) isSearchable() bool {
+	if !node.isPhrase {
+		return len(node.text) >= wordMinLength
+	}
+
+	for _, word := range node.words {
+		if len(word) >= wordMinLength {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (node *queryText) candidates(index *SearchIndexStore) (results map[uuid.UUID]*SearchIndexRecord) {
+	// a word, either plain or with wildcards. Words in phrases are always plain.
+	lookupWord := func(word string) (results map[uuid.UUID]*SearchIndexRecord) {
+		results = make(map[uuid.UUID]*SearchIndexRecord)
+
+		if !node.isPhrase && isWildcardTerm(word) {
+			for _, wordExpanded := range index.ExpandWildcard(word) {
+				if hash, wordH := hashWord(wordExpanded); hash != nil {
+					index.LookupHash(SearchSelector{Hash: hash, Word: wordH, Wildcard: true}, results)
+				}
+			}
+		} else if hash, wordH := hashWord(word); hash != nil {
+			index.LookupHash(SearchSelector{Hash: hash, Word: wordH}, results)
+
+			if hashStem, wordStem := hashWord(index.rules.stemWord(wordH)); hashStem != nil && wordStem != wordH {
+				index.LookupHash(SearchSelector{Hash: hashStem, Word: wordStem}, results)
+			}
+		}
+
+		return results
+	}
+
+	if !node.isPhrase {
+		// Stop words are not indexed and cannot find candidates. Nil means no restriction.
+		if index.rules.isStopWord(node.text) {
+			return nil
+		}
+		return lookupWord(node.text)
+	}
+
+	// A phrase matches the full file name, or candidates must contain all of its words.
+	for _, word := range node.words {
+		if len(word) < wordMinLength || index.rules.isStopWord(word) {
+			continue
+		}
+
+		resultsWord := lookupWord(word)
+		if results == nil {
+			results = resultsWord
+		} else {
+			results = intersectRecords(results, resultsWord)
+		}
+	}
+
+	// only stop words
+	if results == nil {
+		return nil
+	}
+
+	if hash, wordH := hashWord(node.text); hash != nil {
+		index.LookupHash(SearchSelector{Hash: hash, Word: wordH, ExactSearch: true}, results)
+	}
+
+	return results
+}
+
+// queryAnd requires all nodes to match.
+type queryAnd struct {
+	nodes []queryNode
+}
+
+func (node *queryAnd) match(file *queryFile) bool {
+	for _, child := range node.nodes {
+		if !child.match(file) {
+			return false
+		}
+	}
+	return true
+}
+
+func (node *queryAnd) isSearchable() bool {
+	for _, child := range node.nodes {
+		if child.isSearchable() {
+			return true
+		}
+	}
+	return false
+}
+
+func (node *queryAnd) candidates(index *SearchIndexStore) (results map[uuid.UUID]*SearchIndexRecord) {
+	for _, child := range node.nodes {
+		if !child.isSearchable() {
+			continue
+		}
+
+		resultsChild := child.candidates(index)
+		if resultsChild == nil {
+			continue
+		} else if results == nil {
+			results = resultsChild
+		} else {
+			results = intersectRecords(results, resultsChild)
+		}
+	}
+
+	return results
+}
+
+// queryOr requires any node to match.
+type queryOr struct {
+	nodes []queryNode
+}
+
+func (node *queryOr) match(file *queryFile) bool {
+	for _, child := range node.nodes {
+		if child.match(file) {
+			return true
+		}
+	}
+	return false
+}
+
+func (node *queryOr) isSearchable() bool {
+	for _, child := range node.nodes {
+		if !child.isSearchable() {
+			return false
+		}
+	}
+	return true
+}
+
+func (node *queryOr) candidates(index *SearchIndexStore) (results map[uuid.UUID]*SearchIndexRecord) {
+	results = make(map[uuid.UUID]*SearchIndexRecord)
+
+	for _, child := range node.nodes {
+		for id, record := range child.candidates(index) {
+			if existing, ok := results[id]; ok {
+				existing.Selectors = append(existing.Selectors, record.Selectors...)
+			} else {
+				results[id] = record
+			}
+		}
+	}
+
+	return results
+}
+
+// queryNot requires the node not to match. Candidates cannot be looked up for negated nodes.
+type queryNot struct {
+	node queryNode
+}
+
+func (node *queryNot) match(file *queryFile) bool {
+	return !node.node.match(file)
+}
+
+func (node *queryNot) isSearchable() bool {
+	return false
+}
+
+func (node *queryNot) candidates(index *SearchIndexStore) map[uuid.UUID]*SearchIndexRecord {
+	return nil
+}
+
+// queryCondition is embedded in all nodes that are only conditions on the file record and cannot be looked up in the index.
+type queryCondition struct{}
+
+func (node *queryCondition) isSearchable() bool {
+	return false
+}
+
+func (node *queryCondition) candidates(index *SearchIndexStore) map[uuid.UUID]*SearchIndexRecord {
+	return nil
+}
+
+// queryExtension matches the file extension.
+type queryExtension struct {
+	queryCondition
+	extension string // Lowercase extension without dot
+}
+
+func (node *queryExtension) match(file *queryFile) bool {
+	return strings.TrimPrefix(path.Ext(file.text[queryFieldName]), ".") == node.extension
+}
+
+// queryType matches the file type.
+type queryType struct {
+	queryCondition
+	fileType uint8
+}
+
+func (node *queryType) match(file *queryFile) bool {
+	return file.file.Type == node.fileType
+}
+
+// queryFormat matches the file format.
+type queryFormat struct {
+	queryCondition
+	fileFormat uint16
+}
+
+func (node *queryFormat) match(file *queryFile) bool {
+	return file.file.Format == node.fileFormat
+}
+
+// querySize matches the file size. Both bounds are inclusive.
+type querySize struct {
+	queryCondition
+	min, max uint64
+}
+
+func (node *querySize) match(file *queryFile) bool {
+	return file.file.Size >= node.min && file.file.Size <= node.max
+}
+
+// queryDate matches the date shared. Both bounds are inclusive and optional.
+type queryDate struct {
+	queryCondition
+	from, to time.Time
+}
+
+func (node *queryDate) match(file *queryFile) bool {
+	if file.date.IsZero() {
+		return false
+	}
+
+	return (node.from.IsZero() || !file.date.Before(node.from)) && (node.to.IsZero() || !file.date.After(node.to))
+}
+
+// queryTagNumber matches a number tag, for example the duration. Both bounds are inclusive. Files without the tag never match.
+type queryTagNumber struct {
+	queryCondition
+	tagType  uint16
+	min, max uint64
+}
+
+func (node *queryTagNumber) match(file *queryFile) bool {
+	number, ok := file.numbers[node.tagType]
+	return ok && number >= node.min && number <= node.max
+}
+
+// queryNodeID matches the owner of the file.
+type queryNodeID struct {
+	queryCondition
+	nodeID []byte
+}
+
+func (node *queryNodeID) match(file *queryFile) bool {
+	return bytes.Equal(file.file.NodeID, node.nodeID)
+}
+
+// intersectRecords returns the records that are in both maps. Selectors are merged.
+func intersectRecords(a, b map[uuid.UUID]*SearchIndexRecord) (results map[uuid.UUID]*SearchIndexRecord) {
+	results = make(map[uuid.UUID]*SearchIndexRecord)
+
+	for id, record := range a {
+		if recordB, ok := b[id]; ok {
+			record.Selectors = append(record.Selectors, recordB.Selectors...)
+			results[id] = record
+		}
+	}
+
+	return results
+}
@@ -22,6 +22,8 @@ type SearchSelector struct {
     Word        string // Normalized version of the word
     Hash        []byte // Hash of the word
     ExactSearch bool   // Indicates this is an exact search term, for example a full filename.
+    Wildcard    bool   // Indicates the word was found by expanding a wildcard or prefix term.
+    Fuzzy       bool   // Indicates the word was found by fuzzy matching. It is similar but not equal to a word in the search term.
 }
 
 // SearchIndexRecord identifies a hash to a given file
@@ -29,6 +31,9 @@ type SearchIndexRecord struct {
     // List of selectors that found the result. Multiple keywords may find the same file.
     Selectors []SearchSelector
 
+    // Fuzzy indicates that the result was only found via fuzzy matching.
+    Fuzzy bool
+
     // result data
     FileID            uuid.UUID
     PublicKey         *btcec.PublicKey
@@ -38,21 +43,39 @@ type SearchIndexRecord struct {
 
 // This database stores hashes of keywords for file search.
 type SearchIndexStore struct {
-    Database store.Store // The database storing the blockchain.
+    Database    store.Store    // The database storing the blockchain.
+    dictionary  termDictionary // Sorted dictionary of all indexed words.
+    maintenance sync.Mutex     // Serializes maintenance operations. See Index Maintenance.go.
+    rules       *textRules     // Language specific rules for stemming and stop words. Nil if none.
     sync.RWMutex
+
+    // RebuildRequired indicates that the index was created with different text rules, or by an older version. It must be rebuilt for search results to be accurate.
+    RebuildRequired bool
+
+    // FilterNewFile is called for each file that was not indexed before. It is not called for files indexed by a rebuild.
+    FilterNewFile func(publicKey *btcec.PublicKey, blockchainVersion, blockNumber uint64, file *blockchain.BlockRecordFile)
 }
 
-func InitSearchIndexStore(DatabaseDirectory string) (searchIndex *SearchIndexStore, err error) {
+// InitSearchIndexStore opens the search index. Language enables stemming and stop words, see LanguageX. It may be empty.
+func InitSearchIndexStore(DatabaseDirectory string, Language string) (searchIndex *SearchIndexStore, err error) {
     if DatabaseDirectory == "" {
         return
     }
 
     searchIndex = &SearchIndexStore{}
 
+    var ok bool
+    if searchIndex.rules, ok = newTextRules(Language); !ok {
+        return nil, errors.New("unsupported language")
+    }
+
     if searchIndex.Database, err = store.NewPogrebStore(DatabaseDirectory); err != nil {
         return nil, err
     }
 
+    searchIndex.loadTermDictionary()
+    searchIndex.RebuildRequired = !searchIndex.isIndexVersionCurrent()
+
     return searchIndex, nil
 }
 
@@ -76,9 +99,15 @@ func (index *SearchIndexStore) IndexNewBlockDecoded(publicKey *btcec.PublicKey,
         return
     }
 
+    index.indexBlockDecoded(publicKey, blockchainVersion, blockNumber, recordsDecoded, true)
+}
+
+// indexBlockDecoded indexes the file records of a decoded block. If notify is set, FilterNewFile is called for files that were not indexed before.
+func (index *SearchIndexStore) indexBlockDecoded(publicKey *btcec.PublicKey, blockchainVersion, blockNumber uint64, recordsDecoded []interface{}, notify bool) {
     for _, decodedR := range recordsDecoded {
         if file, ok := decodedR.(blockchain.BlockRecordFile); ok {
             var filename, folder, description string
+            var media []string
             for _, tag := range file.Tags {
                 switch tag.Type {
                 case blockchain.TagName:
@@ -87,15 +116,45 @@ func (index *SearchIndexStore) IndexNewBlockDecoded(publicKey *btcec.PublicKey,
                     folder = sanitizeGeneric(tag.Text())
                 case blockchain.TagDescription:
                     description = sanitizeGeneric(tag.Text())
+                case blockchain.TagTitle, blockchain.TagArtist, blockchain.TagAlbum, blockchain.TagCamera:
+                    media = append(media, sanitizeGeneric(tag.Text()))
                 }
             }
 
             hashes := make(map[[32]byte]string)
-            filename2Hashes(filename, folder, hashes)
-            text2Hashes(description, hashes)
+            index.rules.filename2Hashes(filename, folder, hashes)
+            index.rules.text2Hashes(description, hashes)
+            for _, text := range media {
+                index.rules.text2Hashes(text, hashes)
+            }
+
+            // Stems are not added to the term dictionary, since they are not necessarily real words.
+            words := make(map[[32]byte]string)
+            if index.rules != nil {
+                (*textRules)(nil).filename2Hashes(filename, folder, words)
+                (*textRules)(nil).text2Hashes(description, words)
+                for _, text := range media {
+                    (*textRules)(nil).text2Hashes(text, words)
+                }
+            }
+
+            isNew := false
+            var terms []string
+
+            for hash, word := range hashes {
+                if index.IndexHash(publicKey, blockchainVersion, blockNumber, file.ID, hash[:]) == nil {
+                    isNew = true
+                }
+
+                if _, isWord := words[hash]; isWord || index.rules == nil {
+                    terms = append(terms, word)
+                }
+            }
+
+            index.addTerms(terms)
 
-            for hash := range hashes {
-                index.IndexHash(publicKey, blockchainVersion, blockNumber, file.ID, hash[:])
+            if isNew && notify && index.FilterNewFile != nil {
+                index.FilterNewFile(publicKey, blockchainVersion, blockNumber, &file)
             }
         }
     }
@@ -7,15 +7,32 @@ Author:     Peter Kleissner
 package search
 
 import (
+	"sort"
+	"strings"
+
 	"github.com/google/uuid"
 )
 
+// SearchOptions are per query settings for searching the index.
+type SearchOptions struct {
+	Fuzzy int // Max edit distance for typo tolerant matching of words. 0 = disabled. Max is FuzzyDistanceMax.
+}
+
+// Search searches the index for the term. Words may contain the wildcards * and ?, unless the term is an exact search.
 func (index *SearchIndexStore) Search(term string) (results []SearchIndexRecord) {
+	results, _ = index.SearchWithOptions(term, SearchOptions{})
+	return results
+}
+
+// SearchWithOptions searches the index for the term using the options.
+// If fuzzy matching is enabled, results that were only found via fuzzy matching are flagged and returned after all other results.
+// The suggestion is the term with unknown words replaced by the closest indexed words ("did you mean"). It is empty if there is no suggestion.
+func (index *SearchIndexStore) SearchWithOptions(term string, options SearchOptions) (results []SearchIndexRecord, suggestion string) {
 	if index == nil { // Search index may not be available.
-		return nil
+		return nil, ""
 	}
 
-	termS, isExact, _ := sanitizeInputTerm(term)
+	termS, isExact, isWildcard := sanitizeInputTerm(term)
 
 	if len(termS) < wordMinLength {
 		return
@@ -24,8 +41,20 @@ func (index *SearchIndexStore) Search(term string) (results []SearchIndexRecord)
 	resultMap := make(map[uuid.UUID]*SearchIndexRecord)
 	resultMapToSlice := func() (results []SearchIndexRecord) {
 		for _, result := range resultMap {
+			result.Fuzzy = true
+			for _, selector := range result.Selectors {
+				if !selector.Fuzzy {
+					result.Fuzzy = false
+					break
+				}
+			}
+
 			results = append(results, *result)
 		}
+
+		// fuzzy results are ranked below exact ones
+		sort.SliceStable(results, func(i, j int) bool { return !results[i].Fuzzy && results[j].Fuzzy })
+
 		return results
 	}
 
@@ -37,13 +66,83 @@ func (index *SearchIndexStore) Search(term string) (results []SearchIndexRecord)
 
 	// exact search only?
 	if isExact {
-		return resultMapToSlice()
+		return resultMapToSlice(), ""
+	}
+
+	termWords := strings.Fields(strings.ToLower(termS))
+
+	// Wildcard and prefix terms are expanded via the term dictionary. They are removed from the term before the regular word search.
+	if isWildcard {
+		var words []string
+
+		for _, word := range strings.Fields(termS) {
+			if !isWildcardTerm(word) {
+				words = append(words, word)
+				continue
+			}
+
+			for _, wordExpanded := range index.ExpandWildcard(word) {
+				if hash, wordH := hashWord(wordExpanded); hash != nil {
+					index.LookupHash(SearchSelector{Hash: hash, Word: wordH, Wildcard: true}, resultMap)
+				}
+			}
+		}
+
+		termS = strings.Join(words, " ")
+	}
+
+	// Fuzzy matching of each word. Unknown words are replaced by the closest match for the suggestion.
+	if options.Fuzzy > 0 {
+		var words []string
+		isSuggestion := false
+
+		for _, word := range termWords {
+			// wildcard words are not fuzzy matched, but remain in the suggestion
+			if isWildcard && isWildcardTerm(word) {
+				words = append(words, word)
+				continue
+			}
+
+			// stop words are not indexed
+			if index.rules.isStopWord(normalizeWord(word)) {
+				words = append(words, word)
+				continue
+			}
+
+			// A word is known if it or its stem is indexed.
+			isKnown := true
+			if hash, wordH := hashWord(word); hash != nil {
+				if _, isKnown = index.Database.Get(hash); !isKnown {
+					if hashStem, _ := hashWord(index.rules.stemWord(wordH)); hashStem != nil {
+						_, isKnown = index.Database.Get(hashStem)
+					}
+				}
+			}
+
+			matches := index.ExpandFuzzy(word, options.Fuzzy)
+
+			for _, match := range matches {
+				if hash, wordH := hashWord(match.Word); hash != nil {
+					index.LookupHash(SearchSelector{Hash: hash, Word: wordH, Fuzzy: true}, resultMap)
+				}
+			}
+
+			if !isKnown && len(matches) > 0 {
+				word = matches[0].Word
+				isSuggestion = true
+			}
+			words = append(words, word)
+		}
+
+		if isSuggestion {
+			suggestion = strings.Join(words, " ")
+		}
 	}
 
 	// break up the term into hashes
 	hashes := make(map[[32]byte]string)
 
-	text2Hashes(termS, hashes)
+	index.rules.text2Hashes(termS, hashes)
 
 	// The exact search was already performed, exclude it.
 	hashMapDelete(hashExact, hashes)
@@ -53,5 +152,5 @@ func (index *SearchIndexStore) Search(term string) (results []SearchIndexRecord)
 		index.LookupHash(SearchSelector{Hash: hash[:], Word: keyword}, resultMap)
 	}
 
-	return resultMapToSlice()
+	return resultMapToSlice(), suggestion
 }
@@ -0,0 +1,239 @@
+/*
+File name:  Term Dictionary.go
+Copyright:  2021 Peernet s.r.o.
+Author:     Peter Kleissner
+
+The term dictionary is a sorted list of all indexed words. It is kept alongside the hashed keyword index to support prefix and wildcard search.
+Each word is stored in the database with the key prefix "term " and an empty value, and loaded into memory at startup.
+New words are collected in a pending set and merged into the sorted list on the next wildcard expansion, so that indexing many words does not shift the list for each word.
+Words that are no longer indexed are removed lazily when a wildcard or fuzzy expansion encounters them.
+
+Wildcards:
+*       Matches any sequence of characters, including none
+?       Matches exactly one character
+*/
+
+package search
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// keyTermPrefix is the key prefix for words in the term dictionary.
+const keyTermPrefix = "term "
+
+// Limits for expanding wildcard terms.
+const (
+	wildcardExpansionMax = 100    // Max count of words a single wildcard term expands to.
+	wildcardScanMax      = 100000 // Max count of words scanned for a single wildcard term.
+	wildcardMinLiteral   = 2      // Min count of non-wildcard characters in a wildcard term.
+)
+
+// termDictionary is the in-memory sorted list of indexed words.
+type termDictionary struct {
+	terms    []string                       // Sorted list of words
+	pending  map[string]struct{}            // Words added but not yet merged into the sorted list
+	trigrams map[string]map[string]struct{} // Words per trigram for fuzzy search. See Fuzzy.go.
+	sync.RWMutex
+}
+
+// loadTermDictionary loads the term dictionary from the database.
+func (index *SearchIndexStore) loadTermDictionary() {
+	index.dictionary.Lock()
+	defer index.dictionary.Unlock()
+
+	index.dictionary.terms = nil
+	index.dictionary.pending = make(map[string]struct{})
+	index.dictionary.trigrams = make(map[string]map[string]struct{})
+
+	index.Database.Iterate(func(key, value []byte) {
+		if strings.HasPrefix(string(key), keyTermPrefix) {
+			word := string(key[len(keyTermPrefix):])
+			index.dictionary.terms = append(index.dictionary.terms, word)
+			index.dictionary.addTrigrams(word)
+		}
+	})
+
+	sort.Strings(index.dictionary.terms)
+}
+
+// addTerms adds words to the term dictionary if not already present. They are merged into the sorted list later, see mergePending.
+func (index *SearchIndexStore) addTerms(words []string) {
+	index.dictionary.Lock()
+	defer index.dictionary.Unlock()
+
+	for _, word := range words {
+		if index.dictionary.contains(word) {
+			continue
+		}
+
+		index.dictionary.pending[word] = struct{}{}
+		index.dictionary.addTrigrams(word)
+
+		index.Database.Set([]byte(keyTermPrefix+word), nil)
+	}
+}
+
+// contains checks if the word is in the sorted list or pending. The dictionary must be locked by the caller.
+func (dictionary *termDictionary) contains(word string) bool {
+	if _, ok := dictionary.pending[word]; ok {
+		return true
+	}
+
+	n := sort.SearchStrings(dictionary.terms, word)
+	return n < len(dictionary.terms) && dictionary.terms[n] == word
+}
+
+// mergePending merges the pending words into the sorted list. The dictionary must be write locked by the caller.
+func (dictionary *termDictionary) mergePending() {
+	if len(dictionary.pending) == 0 {
+		return
+	}
+
+	words := make([]string, 0, len(dictionary.pending))
+	for word := range dictionary.pending {
+		words = append(words, word)
+	}
+	sort.Strings(words)
+
+	merged := make([]string, 0, len(dictionary.terms)+len(words))
+	n, m := 0, 0
+	for n < len(dictionary.terms) && m < len(words) {
+		if dictionary.terms[n] < words[m] {
+			merged = append(merged, dictionary.terms[n])
+			n++
+		} else {
+			merged = append(merged, words[m])
+			m++
+		}
+	}
+	merged = append(merged, dictionary.terms[n:]...)
+	merged = append(merged, words[m:]...)
+
+	dictionary.terms = merged
+	dictionary.pending = make(map[string]struct{})
+}
+
+// removeTerms removes words from the term dictionary. Words that were indexed again in the meantime are kept.
+func (index *SearchIndexStore) removeTerms(words []string) {
+	index.dictionary.Lock()
+	defer index.dictionary.Unlock()
+
+	for _, word := range words {
+		if hash, _ := hashWord(word); hash != nil {
+			if _, found := index.Database.Get(hash); found {
+				continue
+			}
+		}
+
+		if _, ok := index.dictionary.pending[word]; ok {
+			delete(index.dictionary.pending, word)
+			index.dictionary.removeTrigrams(word)
+		} else if n := sort.SearchStrings(index.dictionary.terms, word); n < len(index.dictionary.terms) && index.dictionary.terms[n] == word {
+			index.dictionary.terms = append(index.dictionary.terms[:n], index.dictionary.terms[n+1:]...)
+			index.dictionary.removeTrigrams(word)
+		}
+
+		index.Database.Delete([]byte(keyTermPrefix + word))
+	}
+}
+
+// isWildcardTerm checks if the word contains a wildcard character.
+func isWildcardTerm(word string) bool {
+	return strings.ContainsAny(word, "*?")
+}
+
+// ExpandWildcard returns all indexed words that match the wildcard term. A term ending with * is a prefix search.
+// It returns no words if the term has fewer than wildcardMinLiteral non-wildcard characters. The result is limited to wildcardExpansionMax words.
+func (index *SearchIndexStore) ExpandWildcard(term string) (words []string) {
+	if index == nil {
+		return nil
+	}
+
+	term = normalizeWord(term)
+
+	if utf8.RuneCountInString(strings.NewReplacer("*", "", "?", "").Replace(term)) < wildcardMinLiteral {
+		return nil
+	}
+
+	// The literal prefix narrows the range of the sorted dictionary to scan.
+	prefix := term
+	if n := strings.IndexAny(term, "*?"); n >= 0 {
+		prefix = term[:n]
+	}
+
+	var orphans []string
+
+	index.dictionary.Lock()
+	index.dictionary.mergePending()
+	index.dictionary.Unlock()
+
+	index.dictionary.RLock()
+
+	scanned := 0
+	for n := sort.SearchStrings(index.dictionary.terms, prefix); n < len(index.dictionary.terms) && scanned < wildcardScanMax && len(words) < wildcardExpansionMax; n++ {
+		word := index.dictionary.terms[n]
+		if !strings.HasPrefix(word, prefix) {
+			break
+		}
+		scanned++
+
+		if !matchWildcard(term, word) {
+			continue
+		}
+
+		// lazy removal of words that are no longer indexed
+		if hash, _ := hashWord(word); hash == nil {
+			continue
+		} else if _, found := index.Database.Get(hash); !found {
+			orphans = append(orphans, word)
+			continue
+		}
+
+		words = append(words, word)
+	}
+
+	index.dictionary.RUnlock()
+
+	if len(orphans) > 0 {
+		index.removeTerms(orphans)
+	}
+
+	return words
+}
+
+// matchWildcard checks if the word matches the pattern. The pattern may contain * and ? wildcards.
+func matchWildcard(pattern, word string) bool {
+	p := []rune(pattern)
+	w := []rune(word)
+
+	// Iterative matching with backtracking to the last *.
+	pIndex, wIndex := 0, 0
+	starIndex, starMatch := -1, 0
+
+	for wIndex < len(w) {
+		if pIndex < len(p) && (p[pIndex] == '?' || p[pIndex] == w[wIndex]) {
+			pIndex++
+			wIndex++
+		} else if pIndex < len(p) && p[pIndex] == '*' {
+			starIndex = pIndex
+			starMatch = wIndex
+			pIndex++
+		} else if starIndex >= 0 {
+			pIndex = starIndex + 1
+			starMatch++
+			wIndex = starMatch
+		} else {
+			return false
+		}
+	}
+
+	for pIndex < len(p) && p[pIndex] == '*' {
+		pIndex++
+	}
+
+	return pIndex == len(p)
+}
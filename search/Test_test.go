@@ -0,0 +1,420 @@
+package search
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/newinfoOffical/core/blockchain"
+	"github.com/newinfoOffical/core/btcec"
+	"github.com/newinfoOffical/core/protocol"
+	"github.com/newinfoOffical/core/store"
+)
+
+// initTestIndex returns a search index in memory without language rules.
+func initTestIndex() (index *SearchIndexStore) {
+	index = &SearchIndexStore{Database: store.NewMemoryStore()}
+	index.loadTermDictionary()
+	return index
+}
+
+func TestMatchWildcard(t *testing.T) {
+	tests := []struct {
+		pattern, word string
+		match         bool
+	}{
+		{"test*", "testing", true},
+		{"test*", "test", true},
+		{"test*", "tes", false},
+		{"te?t", "test", true},
+		{"te?t", "tet", false},
+		{"*ing", "testing", true},
+		{"t*t*g", "testing", true},
+		{"t*x", "testing", false},
+		{"????", "test", true},
+		{"????", "tests", false},
+		{"*", "", true},
+		{"ü?er", "über", true},
+	}
+
+	for _, test := range tests {
+		if match := matchWildcard(test.pattern, test.word); match != test.match {
+			t.Errorf("matchWildcard(%q, %q) = %v, expected %v\n", test.pattern, test.word, match, test.match)
+		}
+	}
+}
+
+func TestTermDictionary(t *testing.T) {
+	index := initTestIndex()
+
+	words := []string{"testing", "tester", "test", "other"}
+	index.addTerms(words)
+	index.addTerms([]string{"test"})
+
+	for _, word := range words {
+		hash, _ := hashWord(word)
+		index.Database.Set(hash, nil)
+	}
+
+	expanded := index.ExpandWildcard("test*")
+	if len(expanded) != 3 || expanded[0] != "test" || expanded[1] != "tester" || expanded[2] != "testing" {
+		t.Fatalf("ExpandWildcard returned %v\n", expanded)
+	}
+
+	// a word that is indexed again must not be removed
+	index.removeTerms([]string{"tester"})
+	if expanded = index.ExpandWildcard("tester"); len(expanded) != 1 {
+		t.Fatalf("Indexed word was removed from the term dictionary\n")
+	}
+
+	// orphaned words are removed lazily
+	hash, _ := hashWord("testing")
+	index.Database.Delete(hash)
+
+	if expanded = index.ExpandWildcard("test*"); len(expanded) != 2 {
+		t.Fatalf("Orphaned word was expanded: %v\n", expanded)
+	} else if _, found := index.Database.Get([]byte(keyTermPrefix + "testing")); found {
+		t.Fatalf("Orphaned word was not removed from the database\n")
+	}
+
+	// the dictionary must survive a reload
+	index.loadTermDictionary()
+	if expanded = index.ExpandWildcard("test*"); len(expanded) != 2 {
+		t.Fatalf("ExpandWildcard after reload returned %v\n", expanded)
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b        string
+		maxDistance int
+		distance    int
+	}{
+		{"test", "test", 2, 0},
+		{"test", "tset", 2, 1},
+		{"test", "tests", 2, 1},
+		{"test", "tst", 2, 1},
+		{"test", "text", 2, 1},
+		{"test", "taxt", 2, 2},
+		{"test", "house", 2, 3},
+		{"", "abc", 3, 3},
+		{"straße", "strasse", 2, 2},
+	}
+
+	for _, test := range tests {
+		if distance := editDistance([]rune(test.a), []rune(test.b), test.maxDistance); distance != test.distance {
+			t.Errorf("editDistance(%q, %q) = %d, expected %d\n", test.a, test.b, distance, test.distance)
+		}
+	}
+}
+
+func TestExpandFuzzy(t *testing.T) {
+	index := initTestIndex()
+
+	words := []string{"testing", "tasting", "texting", "resting", "house"}
+	index.addTerms(words)
+	for _, word := range words {
+		hash, _ := hashWord(word)
+		index.Database.Set(hash, nil)
+	}
+
+	// the result must be sorted by distance and then by word, regardless of map order
+	for n := 0; n < 10; n++ {
+		matches := index.ExpandFuzzy("testing", 1)
+		if len(matches) != 3 || matches[0].Word != "resting" || matches[1].Word != "tasting" || matches[2].Word != "texting" {
+			t.Fatalf("ExpandFuzzy returned %v\n", matches)
+		}
+	}
+
+	if matches := index.ExpandFuzzy("hous", 1); len(matches) != 1 || matches[0].Word != "house" || matches[0].Distance != 1 {
+		t.Fatalf("ExpandFuzzy returned %v\n", matches)
+	}
+}
+
+func TestTokenizeQuery(t *testing.T) {
+	tests := []struct {
+		query  string
+		tokens []queryToken
+		fail   bool
+	}{
+		{"holiday photos", []queryToken{{kind: tokenWord, text: "holiday"}, {kind: tokenWord, text: "photos"}}, false},
+		{"Name:beach", []queryToken{{kind: tokenWord, field: "name", text: "beach"}}, false},
+		{"unknown:beach", []queryToken{{kind: tokenWord, text: "unknown:beach"}}, false},
+		{`title:"summer of 69"`, []queryToken{{kind: tokenPhrase, field: "title", text: "summer of 69"}}, false},
+		{"(a OR b)", []queryToken{{kind: tokenOpen}, {kind: tokenWord, text: "a"}, {kind: tokenWord, text: "OR"}, {kind: tokenWord, text: "b"}, {kind: tokenClose}}, false},
+		{`"open phrase`, nil, true},
+		{"name:", nil, true},
+	}
+
+	for _, test := range tests {
+		tokens, err := tokenizeQuery(test.query)
+		if (err != nil) != test.fail {
+			t.Errorf("tokenizeQuery(%q) error %v\n", test.query, err)
+			continue
+		} else if len(tokens) != len(test.tokens) {
+			t.Errorf("tokenizeQuery(%q) returned %v\n", test.query, tokens)
+			continue
+		}
+
+		for n := range tokens {
+			if tokens[n] != test.tokens[n] {
+				t.Errorf("tokenizeQuery(%q) token %d is %v, expected %v\n", test.query, n, tokens[n], test.tokens[n])
+			}
+		}
+	}
+}
+
+func TestParseQuery(t *testing.T) {
+	file := &blockchain.BlockRecordFile{Size: 2 << 20}
+	file.Tags = append(file.Tags, blockchain.TagFromText(blockchain.TagName, "Beach Holiday.jpg"))
+	file.Tags = append(file.Tags, blockchain.TagFromText(blockchain.TagFolder, "photos\\2022"))
+	file.Tags = append(file.Tags, blockchain.TagFromDate(blockchain.TagDateShared, time.Date(2022, 6, 15, 12, 0, 0, 0, time.UTC)))
+	file.Tags = append(file.Tags, blockchain.TagFromNumber(blockchain.TagWidth, 1920))
+
+	tests := []struct {
+		query string
+		match bool
+		fail  bool
+	}{
+		{"beach", true, false},
+		{"beach holiday", true, false},
+		{"beach AND mountain", false, false},
+		{"beach OR mountain", true, false},
+		{"beach NOT holiday", false, false},
+		{"beach -mountain", true, false},
+		{"name:beach", true, false},
+		{"folder:beach", false, false},
+		{`name:"beach holiday"`, true, false},
+		{"beach size:>1MB", true, false},
+		{"beach size:<1MB", false, false},
+		{"beach size:1MB..2MB", true, false},
+		{"beach date:2022", true, false},
+		{"beach date:>2022-06", false, false},
+		{"beach date:2022-06-15", true, false},
+		{"beach width:>=1920", true, false},
+		{"beach height:>0", false, false},
+		{"(beach OR mountain) AND holiday", true, false},
+		{"NOT beach", false, true},
+		{"size:>1MB", false, true},
+		{"(beach", false, true},
+		{"beach)", false, true},
+		{"beach size:abc", false, true},
+		{"beach date:2022-13", false, true},
+		{"beach node:1234", false, true},
+	}
+
+	for _, test := range tests {
+		query, err := ParseQuery(test.query)
+		if (err != nil) != test.fail {
+			t.Errorf("ParseQuery(%q) error %v\n", test.query, err)
+		} else if err == nil && query.Match(file) != test.match {
+			t.Errorf("ParseQuery(%q) match is %v, expected %v\n", test.query, !test.match, test.match)
+		}
+	}
+}
+
+func TestParseQueryBounds(t *testing.T) {
+	sizes := []struct {
+		text     string
+		min, max uint64
+	}{
+		{">1KB", 1025, math.MaxUint64},
+		{">=1KB", 1024, math.MaxUint64},
+		{"<1KB", 0, 1023},
+		{"<=1KB", 0, 1024},
+		{"=1KB", 1024, 1024},
+		{"1KB..2KB", 1024, 2048},
+		{"1.5KB", 1536, 1536},
+	}
+
+	for _, test := range sizes {
+		node, err := parseQuerySize(test.text)
+		if err != nil {
+			t.Errorf("parseQuerySize(%q) error %v\n", test.text, err)
+		} else if size := node.(*querySize); size.min != test.min || size.max != test.max {
+			t.Errorf("parseQuerySize(%q) = %d..%d, expected %d..%d\n", test.text, size.min, size.max, test.min, test.max)
+		}
+	}
+
+	dates := []struct {
+		text     string
+		from, to time.Time
+	}{
+		{"2022", time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC).Add(-time.Nanosecond)},
+		{"2022-02", time.Date(2022, 2, 1, 0, 0, 0, 0, time.UTC), time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC).Add(-time.Nanosecond)},
+		{">2022-02", time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC), time.Time{}},
+		{"<2022-02-10", time.Time{}, time.Date(2022, 2, 10, 0, 0, 0, 0, time.UTC).Add(-time.Nanosecond)},
+		{">=2022-02-10", time.Date(2022, 2, 10, 0, 0, 0, 0, time.UTC), time.Time{}},
+		{"<=2022-02-10", time.Time{}, time.Date(2022, 2, 11, 0, 0, 0, 0, time.UTC).Add(-time.Nanosecond)},
+		{"2021..2022-06", time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2022, 7, 1, 0, 0, 0, 0, time.UTC).Add(-time.Nanosecond)},
+	}
+
+	for _, test := range dates {
+		node, err := parseQueryDate(test.text)
+		if err != nil {
+			t.Errorf("parseQueryDate(%q) error %v\n", test.text, err)
+		} else if date := node.(*queryDate); !date.from.Equal(test.from) || !date.to.Equal(test.to) {
+			t.Errorf("parseQueryDate(%q) = %v..%v, expected %v..%v\n", test.text, date.from, date.to, test.from, test.to)
+		}
+	}
+
+	for _, text := range []string{"", "<0", "-1", "abc"} {
+		if _, err := parseQuerySize(text); err == nil {
+			t.Errorf("parseQuerySize(%q) accepted\n", text)
+		}
+	}
+}
+
+func TestIndexRebuildCheck(t *testing.T) {
+	privateKey, _ := btcec.NewPrivateKey(btcec.S256())
+
+	userBlockchain, err := blockchain.Init(privateKey, filepath.Join(t.TempDir(), "blockchain"))
+	if err != nil {
+		t.Fatalf("Error opening blockchain: %s\n", err.Error())
+	}
+
+	file := blockchain.BlockRecordFile{ID: uuid.New(), Hash: protocol.HashData([]byte("data")), MerkleRootHash: protocol.HashData([]byte("data")), Size: 4}
+	file.Tags = append(file.Tags, blockchain.TagFromText(blockchain.TagName, "Beach Holiday.jpg"))
+
+	if _, _, status := userBlockchain.AddFiles([]blockchain.BlockRecordFile{file}); status != blockchain.StatusOK {
+		t.Fatalf("Error adding file: status %d\n", status)
+	}
+
+	index := initTestIndex()
+	sources := IndexSources{User: userBlockchain}
+
+	var progress IndexProgress
+	if err := index.Rebuild(sources, &progress); err != nil {
+		t.Fatalf("Error rebuilding index: %s\n", err.Error())
+	} else if done, total := progress.Get(); done != total {
+		t.Fatalf("Rebuild progress %d of %d\n", done, total)
+	}
+
+	stats := index.Statistic()
+	if stats.Files != 1 || stats.Blockchains != 1 || stats.IndexRecords == 0 || stats.IndexRecords != stats.ReverseRecords || stats.Terms == 0 {
+		t.Fatalf("Statistic after rebuild %+v\n", stats)
+	} else if words := index.ExpandWildcard("beach"); len(words) != 1 {
+		t.Fatalf("Term dictionary not rebuilt\n")
+	}
+
+	if result, err := index.Check(sources, false, nil); err != nil || result.CorruptRecords != 0 || result.OrphanedRecords != 0 || result.MissingReverse != 0 || result.OrphanedReverse != 0 || result.OrphanedTerms != 0 {
+		t.Fatalf("Check of a valid index returned %+v, %v\n", result, err)
+	}
+
+	// a corrupt record and a record of a blockchain version that no longer exists
+	hashCorrupt := protocol.HashData([]byte("corrupt"))
+	index.Database.Set(hashCorrupt, []byte{1, 2, 3})
+
+	_, _, version := userBlockchain.Header()
+	hashOrphan, _ := hashWord("orphan")
+	index.IndexHash(privateKey.PubKey(), version+1, 0, uuid.New(), hashOrphan)
+	index.addTerms([]string{"orphan"})
+
+	result, err := index.Check(sources, true, nil)
+	if err != nil || result.CorruptRecords != 1 || result.OrphanedRecords != 1 || result.OrphanedReverse != 1 || result.OrphanedTerms != 1 || !result.Repaired {
+		t.Fatalf("Check returned %+v, %v\n", result, err)
+	}
+
+	if result, err = index.Check(sources, false, nil); err != nil || result.CorruptRecords != 0 || result.OrphanedRecords != 0 || result.OrphanedReverse != 0 || result.OrphanedTerms != 0 {
+		t.Fatalf("Check after repair returned %+v, %v\n", result, err)
+	} else if result.Files != 1 {
+		t.Fatalf("Repair deleted valid records\n")
+	}
+}
+
+func TestNormalizeText(t *testing.T) {
+	tests := []struct {
+		text, normalized string
+	}{
+		{"Hello World", "hello world"},
+		{"Ｈｅｌｌｏ", "hello"},           // full-width letters
+		{"ﬁle", "file"},              // ligature
+		{"Café Crème", "cafe creme"}, // diacritics
+		{"Straße", "strasse"},
+		{"Øresund", "oresund"},
+		{"Ελληνικά", "ελληνικα"},
+		{"Ёлка", "елка"},
+		{"ガイド", "ガイド"},   // the dakuten changes the meaning and is kept
+		{"ｶﾞｲﾄﾞ", "ガイド"}, // half-width katakana
+	}
+
+	for _, test := range tests {
+		if normalized := normalizeText(sanitizeGeneric(test.text)); normalized != test.normalized {
+			t.Errorf("normalizeText(%q) = %q, expected %q\n", test.text, normalized, test.normalized)
+		}
+	}
+}
+
+func TestText2Hashes(t *testing.T) {
+	rulesEnglish, _ := newTextRules(LanguageEnglish)
+	rulesGerman, _ := newTextRules(LanguageGerman)
+
+	tests := []struct {
+		rules    *textRules
+		text     string
+		words    []string // words that must be hashed
+		excluded []string // words that must not be hashed
+	}{
+		{nil, "東京大学", []string{"東京大学", "東京", "京大", "大学"}, nil},
+		{nil, "映画ABC", []string{"映画abc", "映画", "abc"}, nil},
+		{nil, "MyHolidayPhotos", []string{"myholidayphotos", "holiday", "photos"}, nil},
+		{nil, "#summer rock&roll", []string{"summer", "rock&roll"}, []string{"#summer"}},
+		{nil, "the cat", []string{"the", "cat"}, nil},
+		{rulesEnglish, "the running cities", []string{"running", "run", "cities", "citi"}, []string{"the"}},
+		{rulesGerman, "Die Häuser", []string{"hauser", "hau"}, []string{"die"}},
+	}
+
+	for _, test := range tests {
+		hashes := make(map[[32]byte]string)
+		test.rules.text2Hashes(sanitizeGeneric(test.text), hashes)
+
+		words := make(map[string]struct{})
+		for _, word := range hashes {
+			words[word] = struct{}{}
+		}
+
+		for _, word := range test.words {
+			if _, ok := words[word]; !ok {
+				t.Errorf("text2Hashes(%q) is missing %q, got %v\n", test.text, word, words)
+			}
+		}
+		for _, word := range test.excluded {
+			if _, ok := words[word]; ok {
+				t.Errorf("text2Hashes(%q) contains %q\n", test.text, word)
+			}
+		}
+	}
+}
+
+func TestStemWord(t *testing.T) {
+	rulesEnglish, _ := newTextRules(LanguageEnglish)
+	rulesGerman, _ := newTextRules(LanguageGerman)
+
+	// all forms of a word must share the same stem
+	tests := []struct {
+		rules *textRules
+		words []string
+	}{
+		{rulesEnglish, []string{"movie", "movies"}},
+		{rulesEnglish, []string{"hope", "hoped", "hoping", "hopes"}},
+		{rulesEnglish, []string{"run", "running", "runs"}},
+		{rulesEnglish, []string{"city", "cities"}},
+		{rulesGerman, []string{"katze", "katzen"}},
+		{rulesGerman, []string{"spielen", "spielt", "spiel"}},
+	}
+
+	for _, test := range tests {
+		stem := test.rules.stemWord(test.words[0])
+		for _, word := range test.words[1:] {
+			if stemW := test.rules.stemWord(word); stemW != stem {
+				t.Errorf("stemWord(%q) = %q, expected %q\n", word, stemW, stem)
+			}
+		}
+	}
+
+	if stem := rulesEnglish.stemWord("café"); stem != "café" {
+		t.Errorf("Non-ASCII word was stemmed to %q\n", stem)
+	}
+}
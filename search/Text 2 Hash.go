@@ -18,31 +18,74 @@ const wordMinLength = 3
 
 // text2Hashes creates hashes from words in the text. Text may be CamelCased.
 // Text must be already validated for valid UTF8 when calling this function.
-func text2Hashes(text string, hashes map[[32]byte]string) {
-	words := strings.FieldsFunc(text, func(char rune) bool {
-		if unicode.IsSpace(char) {
-			return true
-		}
-		return strings.ContainsAny(string(char), "+-._()[],–")
-	})
+// Words are separated by spaces, punctuation, and symbols. Text in scripts that are written without spaces (Chinese, Japanese, Korean) is additionally split into overlapping bigrams.
+// Stop words of the rules are skipped, and stems are hashed in addition to the words.
+func (rules *textRules) text2Hashes(text string, hashes map[[32]byte]string) {
+	words := strings.FieldsFunc(text, isWordSeparator)
 
 	for _, word := range words {
 		// remove hash tag prefix
 		word = strings.TrimPrefix(word, "#")
 
-		hashWordMap(word, hashes)
+		rules.hashWordMap(word, hashes)
 
 		// CamelCase word detection
 		for _, word2 := range CamelCaseSplit(word) {
 			if word2 != word {
-				hashWordMap(word2, hashes)
+				rules.hashWordMap(word2, hashes)
+			}
+		}
+
+		// bigrams of CJK text
+		for _, run := range cjkRuns(word) {
+			if string(run) != word {
+				hashWordMap(string(run), hashes)
 			}
+			for n := 0; n+2 <= len(run); n++ {
+				hashWordMap(string(run[n:n+2]), hashes)
+			}
+		}
+	}
+}
+
+// isWordSeparator checks if the character separates words. Hash tags, apostrophes, and ampersands are kept within words.
+func isWordSeparator(char rune) bool {
+	if unicode.IsSpace(char) {
+		return true
+	} else if char == '#' || char == '\'' || char == '’' || char == '&' {
+		return false
+	}
+
+	return unicode.IsPunct(char) || unicode.IsSymbol(char)
+}
+
+// isCJK checks if the character is of a script that is written without spaces between words.
+func isCJK(char rune) bool {
+	return unicode.In(char, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+// cjkRuns returns all runs of consecutive CJK characters in the word.
+func cjkRuns(word string) (runs [][]rune) {
+	var run []rune
+
+	for _, char := range word {
+		if isCJK(char) {
+			run = append(run, char)
+		} else if len(run) > 0 {
+			runs = append(runs, run)
+			run = nil
 		}
 	}
+
+	if len(run) > 0 {
+		runs = append(runs, run)
+	}
+
+	return runs
 }
 
 // filename2Hashes creates hashes based on the filename and folder.
-func filename2Hashes(filename, folder string, hashes map[[32]byte]string) {
+func (rules *textRules) filename2Hashes(filename, folder string, hashes map[[32]byte]string) {
 	if len(filename) < wordMinLength {
 		return
 	}
@@ -55,16 +98,30 @@ func filename2Hashes(filename, folder string, hashes map[[32]byte]string) {
 	hashWordMap(filename, hashes)
 
 	// Hash each individual word of the filename and directory
-	text2Hashes(filename, hashes)
+	rules.text2Hashes(filename, hashes)
 
 	folder = strings.ReplaceAll(folder, "\\", " ")
 	folder = strings.ReplaceAll(folder, "/", " ")
-	text2Hashes(folder, hashes)
+	rules.text2Hashes(folder, hashes)
+}
+
+// hashWordMap hashes a word and its stem and stores them on the map, unless it is a stop word.
+func (rules *textRules) hashWordMap(word string, hashes map[[32]byte]string) {
+	word = normalizeWord(word)
+	if rules.isStopWord(word) {
+		return
+	}
+
+	hashWordMap(word, hashes)
+
+	if stem := rules.stemWord(word); stem != word {
+		hashWordMap(stem, hashes)
+	}
 }
 
-// hashWordMap hashes a word and stores it on the map. This immediately deduplicated hashes. It always lowercases the word.
+// hashWordMap hashes a word and stores it on the map. This immediately deduplicated hashes. It always normalizes the word.
 func hashWordMap(word string, hashes map[[32]byte]string) {
-	word = strings.TrimSpace(strings.ToLower(word))
+	word = normalizeWord(word)
 	if len(word) < wordMinLength {
 		return
 	}
@@ -84,9 +141,9 @@ func hashMapDelete(hash []byte, hashes map[[32]byte]string) {
 	delete(hashes, hashB)
 }
 
-// hashWord hashes a single word. It returns nil if not suitable. It always lowercases the word.
+// hashWord hashes a single word. It returns nil if not suitable. It always normalizes the word.
 func hashWord(word string) (hash []byte, wordHashed string) {
-	word = strings.TrimSpace(strings.ToLower(word))
+	word = normalizeWord(word)
 	if len(word) < wordMinLength {
 		return
 	}
@@ -0,0 +1,424 @@
+/*
+File Username:  Chunk Store.go
+Copyright:  2021 Peernet Foundation s.r.o.
+Author:     Peter Kleissner
+
+The optional chunk store deduplicates identical parts of different files. Files are split into chunks using content-defined chunking (gear hash),
+so that inserting or removing a few bytes only changes the chunks around the modification. Chunks are addressed by their blake3 hash and stored once.
+Each chunk has a reference count, which is the count of references in manifests. Chunks are deleted when their reference count drops to 0.
+
+Chunks are stored in the sub-folder "_Chunks" using the same path structure as files. The reference counts are stored in "_Chunks/_References".
+Instead of the data file, a chunked file has a manifest companion file that lists its chunks:
+
+Offset  Size    Info
+0       8       File size
+8       36 * n  Chunks: Hash (32 bytes) and Size (4 bytes)
+
+Chunks are verified against their hash when read. Corrupt chunks are moved into the quarantine folder, so that they are stored again when a file containing them is added.
+*/
+
+package warehouse
+
+import (
+    "bytes"
+    "encoding/binary"
+    "encoding/hex"
+    "errors"
+    "io"
+    "os"
+    "path/filepath"
+    "sort"
+    "sync"
+
+    "github.com/newinfoOffical/core/store"
+    "lukechampine.com/blake3"
+)
+
+// Manifest companion files list the chunks of files stored in the chunk store.
+const chunkManifestExt = ".chunks"
+
+// Chunk sizes. The average size is defined by the count of bits in chunkMask. Smaller chunks improve deduplication but increase the count of files on disk.
+const (
+    chunkSizeMin = 64 * 1024   // Minimum chunk size, except for the last chunk of a file.
+    chunkSizeMax = 1024 * 1024 // Maximum chunk size.
+    chunkMask    = uint64(1<<18-1) << (64 - 18)
+
+    chunkManifestHeaderSize = 8
+    chunkManifestEntrySize  = hashSize + 4
+)
+
+// errChunkCorrupt is returned when reading a chunk that does not match its hash or is missing.
+var errChunkCorrupt = errors.New("chunk is corrupt or missing")
+
+// gearTable contains a random number for each byte value. It must never change, otherwise existing chunks would no longer be deduplicated.
+var gearTable = func() (table [256]uint64) {
+    // splitmix64 with a fixed seed
+    seed := uint64(0x5065657264617461)
+    for n := range table {
+        seed += 0x9E3779B97F4A7C15
+        z := seed
+        z = (z ^ z>>30) * 0xBF58476D1CE4E5B9
+        z = (z ^ z>>27) * 0x94D049BB133111EB
+        table[n] = z ^ z>>31
+    }
+    return table
+}()
+
+// chunkStore stores chunks of files.
+type chunkStore struct {
+    directory  string      // Main directory of the chunks
+    quarantine string      // Folder for corrupt chunks
+    references store.Store // Reference count of each chunk
+    sync.Mutex             // Synchronizes reference counting with creating and deleting chunks
+}
+
+// openChunkStore opens the chunk store. It is created if it does not exist.
+func openChunkStore(directory, quarantine string) (chunks *chunkStore, err error) {
+    if err = createDirectory(directory); err != nil {
+        return nil, err
+    }
+
+    chunks = &chunkStore{directory: directory, quarantine: quarantine}
+    if chunks.references, err = store.NewPogrebStore(filepath.Join(directory, "_References")); err != nil {
+        return nil, err
+    }
+
+    return chunks, nil
+}
+
+// chunkPath returns the full path of the chunk.
+func (chunks *chunkStore) chunkPath(hash []byte) (directory, path string) {
+    directory, filename := buildPath(chunks.directory, hex.EncodeToString(hash))
+    return directory, filepath.Join(directory, filename)
+}
+
+// put stores the chunk if it does not exist yet and increases its reference count.
+func (chunks *chunkStore) put(hash, data []byte) (err error) {
+    chunks.Lock()
+    defer chunks.Unlock()
+
+    directory, path := chunks.chunkPath(hash)
+
+    if _, err = os.Stat(path); err != nil {
+        if err = createDirectory(directory); err != nil {
+            return err
+        }
+
+        // Write to a temporary file first, so that a partially written chunk is never used.
+        tmpFile, err := os.CreateTemp(directory, "chunk")
+        if err != nil {
+            return err
+        }
+        _, err = tmpFile.Write(data)
+        if errC := tmpFile.Close(); err == nil {
+            err = errC
+        }
+        if err == nil {
+            err = os.Rename(tmpFile.Name(), path)
+        }
+        if err != nil {
+            os.Remove(tmpFile.Name())
+            return err
+        }
+    }
+
+    return chunks.setReferenceCount(hash, chunks.referenceCount(hash)+1)
+}
+
+// release decreases the reference count of the chunk. The chunk is deleted if it is no longer referenced.
+func (chunks *chunkStore) release(hash []byte) {
+    chunks.Lock()
+    defer chunks.Unlock()
+
+    count := chunks.referenceCount(hash)
+    if count > 1 {
+        chunks.setReferenceCount(hash, count-1)
+        return
+    }
+
+    chunks.references.Delete(hash)
+
+    _, path := chunks.chunkPath(hash)
+    os.Remove(path)
+}
+
+// referenceCount returns the reference count of the chunk. The lock must be held.
+func (chunks *chunkStore) referenceCount(hash []byte) (count uint64) {
+    if data, found := chunks.references.Get(hash); found && len(data) == 8 {
+        return binary.LittleEndian.Uint64(data)
+    }
+    return 0
+}
+
+// setReferenceCount stores the reference count of the chunk. The lock must be held.
+func (chunks *chunkStore) setReferenceCount(hash []byte, count uint64) (err error) {
+    var data [8]byte
+    binary.LittleEndian.PutUint64(data[:], count)
+
+    return chunks.references.Set(hash, data[:])
+}
+
+// read reads the chunk and verifies it against the hash. A corrupt chunk is moved into the quarantine folder.
+func (chunks *chunkStore) read(hash []byte, size uint64) (data []byte, err error) {
+    _, path := chunks.chunkPath(hash)
+
+    data, err = os.ReadFile(path)
+    if err != nil && os.IsNotExist(err) {
+        return nil, errChunkCorrupt
+    } else if err != nil {
+        return nil, err
+    }
+
+    if hashData := blake3.Sum256(data); uint64(len(data)) == size && bytes.Equal(hashData[:], hash) {
+        return data, nil
+    }
+
+    chunks.Lock()
+    if createDirectory(chunks.quarantine) == nil {
+        os.Rename(path, filepath.Join(chunks.quarantine, hex.EncodeToString(hash)+".chunk"))
+    }
+    chunks.Unlock()
+
+    return nil, errChunkCorrupt
+}
+
+// ---- manifest ----
+
+// chunkManifest lists the chunks of a file.
+type chunkManifest struct {
+    fileSize uint64
+    chunks   []chunkEntry
+}
+
+type chunkEntry struct {
+    hash   []byte
+    size   uint64
+    offset uint64 // Offset of the chunk in the file
+}
+
+func (manifest *chunkManifest) encode() (data []byte) {
+    data = make([]byte, chunkManifestHeaderSize, chunkManifestHeaderSize+len(manifest.chunks)*chunkManifestEntrySize)
+    binary.LittleEndian.PutUint64(data[0:8], manifest.fileSize)
+
+    var size [4]byte
+    for _, chunk := range manifest.chunks {
+        binary.LittleEndian.PutUint32(size[:], uint32(chunk.size))
+        data = append(data, chunk.hash...)
+        data = append(data, size[:]...)
+    }
+
+    return data
+}
+
+func decodeChunkManifest(data []byte) (manifest *chunkManifest, err error) {
+    if len(data) < chunkManifestHeaderSize || (len(data)-chunkManifestHeaderSize)%chunkManifestEntrySize != 0 {
+        return nil, errors.New("invalid chunk manifest")
+    }
+
+    manifest = &chunkManifest{fileSize: binary.LittleEndian.Uint64(data[0:8])}
+
+    var offset uint64
+    for index := chunkManifestHeaderSize; index < len(data); index += chunkManifestEntrySize {
+        chunk := chunkEntry{
+            hash:   data[index : index+hashSize],
+            size:   uint64(binary.LittleEndian.Uint32(data[index+hashSize : index+chunkManifestEntrySize])),
+            offset: offset,
+        }
+        manifest.chunks = append(manifest.chunks, chunk)
+        offset += chunk.size
+    }
+
+    if offset != manifest.fileSize {
+        return nil, errors.New("invalid chunk manifest size")
+    }
+
+    return manifest, nil
+}
+
+// readChunkManifest reads the manifest companion file.
+func readChunkManifest(path string) (manifest *chunkManifest, err error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+
+    return decodeChunkManifest(data)
+}
+
+// readChunkManifestSize reads only the file size from the manifest companion file.
+func readChunkManifestSize(path string) (fileSize uint64, err error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return 0, err
+    }
+    defer file.Close()
+
+    header := make([]byte, chunkManifestHeaderSize)
+    if _, err = io.ReadFull(file, header); err != nil {
+        return 0, err
+    }
+
+    return binary.LittleEndian.Uint64(header), nil
+}
+
+// releaseChunks releases all chunks referenced by the manifest.
+func (chunks *chunkStore) releaseChunks(manifest *chunkManifest) {
+    for _, chunk := range manifest.chunks {
+        chunks.release(chunk.hash)
+    }
+}
+
+// ---- chunking ----
+
+// chunkWriter splits the written data into content-defined chunks and stores them in the chunk store.
+// If writing fails or the file is not used, the chunks must be released via releaseChunks.
+type chunkWriter struct {
+    chunks   *chunkStore
+    manifest chunkManifest
+    buffer   []byte // Data of the current chunk
+    position int    // Position in the buffer up to which the gear hash was calculated
+    gear     uint64 // Gear hash of the current chunk
+    err      error  // Error storing a chunk
+}
+
+func newChunkWriter(chunks *chunkStore) *chunkWriter {
+    return &chunkWriter{chunks: chunks}
+}
+
+// Write chunks the data. It implements the io.Writer interface.
+func (writer *chunkWriter) Write(data []byte) (n int, err error) {
+    writer.buffer = append(writer.buffer, data...)
+
+    for {
+        cut := writer.findCut()
+        if cut == 0 {
+            break
+        }
+
+        if err = writer.storeChunk(writer.buffer[:cut]); err != nil {
+            return 0, err
+        }
+
+        remaining := copy(writer.buffer, writer.buffer[cut:])
+        writer.buffer = writer.buffer[:remaining]
+        writer.position = 0
+        writer.gear = 0
+    }
+
+    return len(data), nil
+}
+
+// findCut returns the size of the next chunk in the buffer, or 0 if more data is needed.
+// The first chunkSizeMin bytes of each chunk are skipped, since a cut is not allowed there.
+func (writer *chunkWriter) findCut() (cut int) {
+    if writer.position < chunkSizeMin {
+        writer.position = chunkSizeMin
+    }
+
+    for ; writer.position < len(writer.buffer); writer.position++ {
+        writer.gear = writer.gear<<1 + gearTable[writer.buffer[writer.position]]
+
+        if writer.gear&chunkMask == 0 || writer.position+1 >= chunkSizeMax {
+            writer.position++
+            return writer.position
+        }
+    }
+
+    return 0
+}
+
+// Close stores the remaining data as last chunk.
+func (writer *chunkWriter) Close() (err error) {
+    if len(writer.buffer) == 0 {
+        return nil
+    }
+
+    err = writer.storeChunk(writer.buffer)
+    writer.buffer = nil
+
+    return err
+}
+
+func (writer *chunkWriter) storeChunk(data []byte) (err error) {
+    hash := blake3.Sum256(data)
+    if err = writer.chunks.put(hash[:], data); err != nil {
+        writer.err = err
+        return err
+    }
+
+    writer.manifest.chunks = append(writer.manifest.chunks, chunkEntry{hash: hash[:], size: uint64(len(data)), offset: writer.manifest.fileSize})
+    writer.manifest.fileSize += uint64(len(data))
+
+    return nil
+}
+
+// ---- reading ----
+
+// chunkedFile reads a file from its chunks. It implements the io.ReadSeekCloser interface.
+type chunkedFile struct {
+    chunks   *chunkStore
+    manifest *chunkManifest
+    position uint64 // Current position in the file
+    current  int    // Index of the loaded chunk, -1 if none
+    data     []byte // Data of the loaded chunk
+}
+
+func (chunks *chunkStore) openFile(manifest *chunkManifest) *chunkedFile {
+    return &chunkedFile{chunks: chunks, manifest: manifest, current: -1}
+}
+
+// Read reads data from the current position.
+func (file *chunkedFile) Read(data []byte) (n int, err error) {
+    if file.position >= file.manifest.fileSize {
+        return 0, io.EOF
+    }
+
+    // find the chunk containing the position
+    index := sort.Search(len(file.manifest.chunks), func(n int) bool {
+        chunk := file.manifest.chunks[n]
+        return chunk.offset+chunk.size > file.position
+    })
+
+    if index != file.current {
+        chunk := file.manifest.chunks[index]
+        if file.data, err = file.chunks.read(chunk.hash, chunk.size); err != nil {
+            file.current = -1
+            return 0, err
+        }
+        file.current = index
+    }
+
+    n = copy(data, file.data[file.position-file.manifest.chunks[index].offset:])
+    file.position += uint64(n)
+
+    return n, nil
+}
+
+// Seek sets the position for the next read.
+func (file *chunkedFile) Seek(offset int64, whence int) (position int64, err error) {
+    switch whence {
+    case io.SeekStart:
+        position = offset
+    case io.SeekCurrent:
+        position = int64(file.position) + offset
+    case io.SeekEnd:
+        position = int64(file.manifest.fileSize) + offset
+    default:
+        return 0, errors.New("invalid whence")
+    }
+
+    if position < 0 {
+        return 0, errors.New("negative position")
+    }
+
+    file.position = uint64(position)
+
+    return position, nil
+}
+
+// Close releases the loaded chunk.
+func (file *chunkedFile) Close() (err error) {
+    file.data = nil
+    file.current = -1
+    return nil
+}
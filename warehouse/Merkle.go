@@ -61,24 +61,28 @@ func (wh *Warehouse) createMerkleCompanionFile(dataFilePath string) (status int,
         return StatusOK, nil
     }
 
-    // Create a new merkle file. If one exists, overwrite.
-    merkleFile := dataFilePath + merkleCompanionExt
+    // create the merkle tree and write it to the companion file
+    fragmentSize := merkle.CalculateFragmentSize(fileSize)
+    tree, err := merkle.NewMerkleTree(fileSize, fragmentSize, dataFile)
+    if err != nil {
+        return StatusErrorCreateMerkle, err
+    }
 
-    fileM, err := os.OpenFile(merkleFile, os.O_WRONLY|os.O_CREATE, 0666) // 666 = All uses can read/write
+    return wh.writeMerkleCompanionFile(dataFilePath, tree)
+}
+
+// writeMerkleCompanionFile writes the merkle tree to the companion file of the data file. If the merkle companion file already exists, it is overwritten.
+func (wh *Warehouse) writeMerkleCompanionFile(dataFilePath string, tree *merkle.MerkleTree) (status int, err error) {
+    fileM, err := os.OpenFile(dataFilePath+merkleCompanionExt, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666) // 666 = All uses can read/write
     if err != nil {
         return StatusErrorCreateTarget, err
     }
     defer fileM.Close()
 
-    // create the merkle tree and write it to the companion file
-    fragmentSize := merkle.CalculateFragmentSize(fileSize)
-    tree, err := merkle.NewMerkleTree(fileSize, fragmentSize, dataFile)
-    if err != nil {
+    if _, err = fileM.Write(tree.Export()); err != nil {
         return StatusErrorCreateMerkle, err
     }
 
-    fileM.Write(tree.Export())
-
     return StatusOK, nil
 }
 
@@ -0,0 +1,143 @@
+/*
+File Username:  Scrub.go
+Copyright:  2021 Peernet Foundation s.r.o.
+Author:     Peter Kleissner
+
+Files are addressed by their hash, but the data on disk may change afterwards due to bit rot or manual edits.
+VerifyFile re-hashes the data and checks the merkle companion file. Corrupt files are moved into the quarantine folder via QuarantineFile,
+so that they are no longer served. The quarantine folder is a sub-folder named "_Quarantine" and is not covered by IterateFiles.
+*/
+
+package warehouse
+
+import (
+    "bytes"
+    "encoding/hex"
+    "io"
+    "os"
+    "path/filepath"
+    "time"
+
+    "github.com/newinfoOffical/core/merkle"
+    "lukechampine.com/blake3"
+)
+
+// verifyBufferSize is the size of the buffer used to read files for verification. It is also the unit for rate limiting.
+const verifyBufferSize = 64 * 1024
+
+// VerifyFile reads the file and checks if the data matches the hash. For files bigger than the minimum fragment size it also checks the merkle companion file.
+// Since the merkle companion file is derived from the data, a missing or invalid one is recreated and merkleRepaired is set.
+// Rate is the max count of bytes to read per second; 0 = unlimited.
+// Return status codes: StatusInvalidHash, StatusFileNotFound, StatusErrorOpenFile, StatusErrorReadFile, StatusFileCorrupt, StatusErrorCreateTarget, StatusErrorCreateMerkle, StatusOK
+func (wh *Warehouse) VerifyFile(hash []byte, rate uint64) (status int, merkleRepaired bool, err error) {
+    path, fileSize, status, err := wh.FileExists(hash)
+    if status != StatusOK {
+        return status, false, err
+    }
+
+    file, status, err := wh.openFile(hash)
+    if status != StatusOK {
+        return status, false, err
+    }
+    defer file.Close()
+
+    // hash the data and create the merkle tree at the same time, so the data is only read once
+    hashWriter := blake3.New(hashSize, nil)
+    treeBuilder := merkle.NewMerkleTreeBuilder(merkle.CalculateFragmentSize(fileSize))
+    writer := io.MultiWriter(hashWriter, treeBuilder)
+
+    buffer := make([]byte, verifyBufferSize)
+    started := time.Now()
+    var bytesRead uint64
+
+    for {
+        n, err := file.Read(buffer)
+        if n > 0 {
+            writer.Write(buffer[:n])
+            bytesRead += uint64(n)
+
+            // wait if reading faster than the rate
+            if rate > 0 {
+                if wait := time.Duration(bytesRead*uint64(time.Second)/rate) - time.Since(started); wait > 0 {
+                    time.Sleep(wait)
+                }
+            }
+        }
+        if err == io.EOF {
+            break
+        } else if err == errChunkCorrupt {
+            return StatusFileCorrupt, false, nil
+        } else if err != nil {
+            return StatusErrorReadFile, false, err
+        }
+    }
+
+    if !bytes.Equal(hashWriter.Sum(nil), hash) {
+        return StatusFileCorrupt, false, nil
+    }
+
+    // Files up to the minimum fragment size do not have a merkle companion file.
+    if treeBuilder.Size() <= merkle.MinimumFragmentSize {
+        return StatusOK, false, nil
+    }
+
+    tree, err := treeBuilder.Tree()
+    if err != nil {
+        return StatusErrorCreateMerkle, false, err
+    }
+
+    if dataM, err := os.ReadFile(path + merkleCompanionExt); err == nil && bytes.Equal(dataM, tree.Export()) {
+        return StatusOK, false, nil
+    }
+
+    if status, err = wh.writeMerkleCompanionFile(path, tree); status != StatusOK {
+        return status, false, err
+    }
+
+    return StatusOK, true, nil
+}
+
+// QuarantineFile moves the file and its merkle companion file into the quarantine folder. Afterwards the file is considered not existing.
+// For files in the chunk store, the manifest is moved and the chunks are released. Corrupt chunks are already quarantined when read.
+// Return status codes: StatusInvalidHash, StatusFileNotFound, StatusErrorCreatePath, StatusErrorMoveFile, StatusOK
+func (wh *Warehouse) QuarantineFile(hash []byte) (status int, err error) {
+    path, _, status, err := wh.FileExists(hash)
+    if status != StatusOK {
+        return status, err
+    }
+
+    if err = createDirectory(wh.quarantinePath()); err != nil {
+        return StatusErrorCreatePath, err
+    }
+
+    target := filepath.Join(wh.quarantinePath(), hex.EncodeToString(hash))
+
+    source, sourceTarget := path, target
+    var manifest *chunkManifest
+    if pathM, _, status, _ := wh.chunkManifestExists(hash); status == StatusOK {
+        manifest, _ = readChunkManifest(pathM)
+        source, sourceTarget = pathM, target+chunkManifestExt
+    }
+
+    if err = os.Rename(source, sourceTarget); err != nil {
+        return StatusErrorMoveFile, err
+    }
+
+    if manifest != nil {
+        wh.chunks.releaseChunks(manifest)
+    }
+
+    // The merkle companion file may not exist.
+    if _, err := os.Stat(path + merkleCompanionExt); err == nil {
+        if err = os.Rename(path+merkleCompanionExt, target+merkleCompanionExt); err != nil {
+            os.Remove(path + merkleCompanionExt)
+        }
+    }
+
+    return StatusOK, nil
+}
+
+// quarantinePath returns the folder for quarantined files.
+func (wh *Warehouse) quarantinePath() string {
+    return filepath.Join(wh.Directory, "_Quarantine")
+}
@@ -34,11 +34,20 @@ const (
     StatusErrorCreateTarget   = 14 // Error creating target file.
     StatusErrorCreateMerkle   = 15 // Error creating merkle tree.
     StatusErrorMerkleTreeFile = 16 // Invalid merkle tree companion file.
+    StatusFileCorrupt         = 17 // File data does not match the hash.
+    StatusErrorMoveFile       = 18 // Error moving file.
+    StatusErrorChunkStore     = 19 // Error storing data in the chunk store.
 )
 
 // CreateFile creates a new file in the warehouse
-// If fileSize is provided, creating the merkle tree is significantly faster as it will be created on the fly. If the file size is unknown, set the size to 0.
+// The merkle tree is created on the fly while the data is copied, so the data is only read once. If the file size is unknown, set the size to 0.
+// Providing the file size is faster, since otherwise the data is hashed for all possible fragment sizes.
+// If chunking is enabled, the file is stored in the chunk store.
 func (wh *Warehouse) CreateFile(data io.Reader, fileSize uint64, uploadStatus io.Writer) (hash []byte, status int, err error) {
+    if wh.chunking {
+        return wh.createFileChunked(data, fileSize, uploadStatus)
+    }
+
     // create a temporary file to hold the body content
     tmpFile, err := wh.tempFile()
     if err != nil {
@@ -47,10 +56,12 @@ func (wh *Warehouse) CreateFile(data io.Reader, fileSize uint64, uploadStatus io
 
     tmpFileName := tmpFile.Name()
 
-    // create merkle tree in parallel if the file size is known (which means the fragment size can be calculated)
+    // create the merkle tree in parallel. If the file size is known, the fragment size can be calculated already.
+    var fragmentSize uint64
     if fileSize > 0 {
-        // TODO
+        fragmentSize = merkle.CalculateFragmentSize(fileSize)
     }
+    treeBuilder := merkle.NewMerkleTreeBuilder(fragmentSize)
 
     // create the hash-writer
     hashWriter := blake3.New(hashSize, nil)
@@ -58,10 +69,10 @@ func (wh *Warehouse) CreateFile(data io.Reader, fileSize uint64, uploadStatus io
     var mw io.Writer
 
     if uploadStatus != nil {
-        // the multi-writer writes to the temp-file and the hash simultaneously
-        mw = io.MultiWriter(tmpFile, hashWriter, uploadStatus)
+        // the multi-writer writes to the temp-file, the hash, and the merkle tree simultaneously
+        mw = io.MultiWriter(tmpFile, hashWriter, treeBuilder, uploadStatus)
     } else {
-        mw = io.MultiWriter(tmpFile, hashWriter)
+        mw = io.MultiWriter(tmpFile, hashWriter, treeBuilder)
     }
 
     // copy into the multiwriter
@@ -109,9 +120,14 @@ func (wh *Warehouse) CreateFile(data io.Reader, fileSize uint64, uploadStatus io
             }
         }
 
-        // create the merkle tree companion file
-        if fileSize == 0 || fileSize > merkle.MinimumFragmentSize {
-            if status, err = wh.createMerkleCompanionFile(pathFull); status != StatusOK {
+        // Create the merkle tree companion file. Only if the provided file size was wrong, the fragment size does not match and the file must be read again.
+        if treeBuilder.Size() > merkle.MinimumFragmentSize {
+            if tree, err := treeBuilder.Tree(); err == nil && tree.FragmentSize == merkle.CalculateFragmentSize(tree.FileSize) {
+                status, err = wh.writeMerkleCompanionFile(pathFull, tree)
+            } else {
+                status, err = wh.createMerkleCompanionFile(pathFull)
+            }
+            if status != StatusOK {
                 return hash, status, err
             }
         }
@@ -120,6 +136,111 @@ func (wh *Warehouse) CreateFile(data io.Reader, fileSize uint64, uploadStatus io
     return hash, StatusOK, nil
 }
 
+// createFileChunked creates a new file in the chunk store. The data is chunked while it is copied, so no temporary file is needed for the data.
+func (wh *Warehouse) createFileChunked(data io.Reader, fileSize uint64, uploadStatus io.Writer) (hash []byte, status int, err error) {
+    // create the merkle tree in parallel. If the file size is known, the fragment size can be calculated already.
+    var fragmentSize uint64
+    if fileSize > 0 {
+        fragmentSize = merkle.CalculateFragmentSize(fileSize)
+    }
+    treeBuilder := merkle.NewMerkleTreeBuilder(fragmentSize)
+
+    // create the hash-writer and the chunker
+    hashWriter := blake3.New(hashSize, nil)
+    chunker := newChunkWriter(wh.chunks)
+
+    writers := []io.Writer{chunker, hashWriter, treeBuilder}
+    if uploadStatus != nil {
+        writers = append(writers, uploadStatus)
+    }
+
+    // copy into the multiwriter
+    if _, err = io.Copy(io.MultiWriter(writers...), data); err == nil {
+        err = chunker.Close()
+    }
+    if err != nil {
+        wh.chunks.releaseChunks(&chunker.manifest)
+        if chunker.err != nil {
+            return nil, StatusErrorChunkStore, err
+        }
+        return nil, StatusErrorWriteTempFile, err
+    }
+
+    hash = hashWriter.Sum(nil)
+
+    // Check if the file exists
+    if _, _, status, _ := wh.FileExists(hash); status == StatusOK {
+        // file exists already, chunks not needed
+        wh.chunks.releaseChunks(&chunker.manifest)
+
+        // return success
+        return hash, StatusOK, nil
+    }
+
+    // Destination
+    pathFull, err := wh.createFilePath(hash)
+    if err != nil {
+        wh.chunks.releaseChunks(&chunker.manifest)
+        return nil, StatusErrorCreatePath, err
+    }
+
+    // Write the manifest into a temporary file first, so that a partially written manifest is never used.
+    tmpFile, err := wh.tempFile()
+    if err != nil {
+        wh.chunks.releaseChunks(&chunker.manifest)
+        return nil, StatusErrorCreateTempFile, err
+    }
+
+    tmpFileName := tmpFile.Name()
+
+    if _, err = tmpFile.Write(chunker.manifest.encode()); err != nil {
+        tmpFile.Close()
+        os.Remove(tmpFileName)
+        wh.chunks.releaseChunks(&chunker.manifest)
+        return nil, StatusErrorWriteTempFile, err
+    }
+
+    if err := tmpFile.Close(); err != nil {
+        os.Remove(tmpFileName)
+        wh.chunks.releaseChunks(&chunker.manifest)
+        return nil, StatusErrorCloseTempFile, err
+    }
+
+    // The same file may be created at the same time. Only one manifest may reference the chunks, otherwise they would never be released.
+    wh.chunks.Lock()
+    _, _, statusE, _ := wh.FileExists(hash)
+    if statusE != StatusOK {
+        err = os.Rename(tmpFileName, pathFull+chunkManifestExt)
+    }
+    wh.chunks.Unlock()
+
+    if statusE == StatusOK || err != nil {
+        os.Remove(tmpFileName)
+        wh.chunks.releaseChunks(&chunker.manifest)
+
+        if err != nil {
+            return nil, StatusErrorRenameTempFile, err
+        }
+        return hash, StatusOK, nil
+    }
+
+    // Create the merkle tree companion file. Only if the provided file size was wrong, the fragment size does not match and the file must be read again.
+    if treeBuilder.Size() > merkle.MinimumFragmentSize {
+        tree, err := treeBuilder.Tree()
+        if err != nil || tree.FragmentSize != merkle.CalculateFragmentSize(tree.FileSize) {
+            if tree, err = merkle.NewMerkleTree(treeBuilder.Size(), merkle.CalculateFragmentSize(treeBuilder.Size()), wh.chunks.openFile(&chunker.manifest)); err != nil {
+                return hash, StatusErrorCreateMerkle, err
+            }
+        }
+
+        if status, err = wh.writeMerkleCompanionFile(pathFull, tree); status != StatusOK {
+            return hash, status, err
+        }
+    }
+
+    return hash, StatusOK, nil
+}
+
 // CreateFileFromPath creates a file from an existing file path.
 // Warning: An attacker could supply any local file using this function, put them into storage and read them! No input path verification or limitation is done.
 func (wh *Warehouse) CreateFileFromPath(file string) (hash []byte, status int, err error) {
@@ -145,25 +266,63 @@ func (wh *Warehouse) CreateFileFromPath(file string) (hash []byte, status int, e
 // Offset is the position in the file to start reading. Limit (0 = not used) defines how many bytes to read starting at the offset.
 // Return status codes: StatusInvalidHash, StatusFileNotFound, StatusErrorOpenFile, StatusErrorSeekFile, StatusErrorReadFile, StatusOK
 func (wh *Warehouse) ReadFile(hash []byte, offset, limit int64, writer io.Writer) (status int, bytesRead int64, err error) {
+    reader, status, err := wh.openFile(hash)
+    if status != StatusOK {
+        return status, 0, err
+    }
+    defer reader.Close()
+
+    // seek to offset, if provided
+    if offset > 0 {
+        if _, err = reader.Seek(offset, io.SeekStart); err != nil {
+            return StatusErrorSeekFile, 0, err
+        }
+    }
+
+    // read the file and copy it into the output
+    if limit > 0 {
+        bytesRead, err = io.Copy(writer, io.LimitReader(reader, limit))
+    } else {
+        bytesRead, err = io.Copy(writer, reader)
+    }
+
+    // do not consider EOF an error if all bytes were read
+    if err != nil {
+        return StatusErrorReadFile, bytesRead, err
+    }
+
+    return StatusOK, bytesRead, nil
+}
+
+// openFile opens the file for reading. Files in the chunk store are assembled from their chunks while reading.
+// Return status codes: StatusInvalidHash, StatusFileNotFound, StatusErrorOpenFile, StatusOK
+func (wh *Warehouse) openFile(hash []byte) (reader io.ReadSeekCloser, status int, err error) {
     // validate the hash and build the path
     // 17.01.2022: This code previously used wh.FileExists which is not performant when used frequently. It is faster to instead catch the file-not-exist error on os.Open.
     hashA, err := ValidateHash(hash)
     if err != nil {
-        return StatusInvalidHash, 0, err
+        return nil, StatusInvalidHash, err
     }
 
     a, b := buildPath(wh.Directory, hashA)
     path := filepath.Join(a, b)
 
     // read the file from disk
-    var reader io.ReadSeeker
     retryCount := 0
 retryOpenFile:
 
     file, err := os.Open(path)
     if err != nil && os.IsNotExist(err) {
-        // Catch the error file not exist here.
-        return StatusFileNotFound, 0, err
+        // Catch the error file not exist here. The file may be stored in the chunk store instead.
+        if wh.chunks != nil {
+            if manifest, errM := readChunkManifest(path + chunkManifestExt); errM == nil {
+                return wh.chunks.openFile(manifest), StatusOK, nil
+            } else if !os.IsNotExist(errM) {
+                return nil, StatusErrorOpenFile, errM
+            }
+        }
+
+        return nil, StatusFileNotFound, err
     } else if err != nil {
         // There may be a race condition when the file is being written: "The process cannot access the file because it is being used by another process."
         // Wait up to 3 times for 400ms.
@@ -173,49 +332,39 @@ retryOpenFile:
             goto retryOpenFile
         }
 
-        return StatusErrorOpenFile, 0, err
-    }
-    defer file.Close()
-
-    reader = file
-
-    // seek to offset, if provided
-    if offset > 0 {
-        if _, err = reader.Seek(offset, io.SeekStart); err != nil {
-            return StatusErrorSeekFile, 0, err
-        }
-    }
-
-    // read the file and copy it into the output
-    if limit > 0 {
-        bytesRead, err = io.Copy(writer, io.LimitReader(reader, limit))
-    } else {
-        bytesRead, err = io.Copy(writer, reader)
-    }
-
-    // do not consider EOF an error if all bytes were read
-    if err != nil {
-        return StatusErrorReadFile, bytesRead, err
+        return nil, StatusErrorOpenFile, err
     }
 
-    return StatusOK, bytesRead, nil
+    return file, StatusOK, nil
 }
 
-// DeleteFile deletes a file from the warehouse
+// DeleteFile deletes a file from the warehouse. For files in the chunk store, chunks that are no longer referenced are deleted.
 func (wh *Warehouse) DeleteFile(hash []byte) (status int, err error) {
     path, _, status, err := wh.FileExists(hash)
     if status != StatusOK {
         return status, err
     }
 
+    // The manifest is read before deleting it, but the chunks are only released after it is deleted.
+    var manifest *chunkManifest
+    if pathM, _, status, _ := wh.chunkManifestExists(hash); status == StatusOK {
+        path = pathM
+        manifest, _ = readChunkManifest(pathM)
+    }
+
     if err := os.Remove(path); err != nil {
         return StatusErrorDeleteFile, err
     }
 
+    if manifest != nil {
+        wh.chunks.releaseChunks(manifest)
+    }
+
     return StatusOK, nil
 }
 
 // FileExists checks if the file exists. It returns StatusInvalidHash, StatusFileNotFound, or StatusOK.
+// For files in the chunk store, the data is not stored at the returned path. Companion files are still stored next to it. See chunkManifestExists for the manifest.
 func (wh *Warehouse) FileExists(hash []byte) (path string, fileSize uint64, status int, err error) {
     hashA, err := ValidateHash(hash)
     if err != nil {
@@ -230,11 +379,37 @@ func (wh *Warehouse) FileExists(hash []byte) (path string, fileSize uint64, stat
         return path, uint64(fileInfo.Size()), StatusOK, nil
     }
 
+    if _, fileSize, status, _ := wh.chunkManifestExists(hash); status == StatusOK {
+        return path, fileSize, StatusOK, nil
+    }
+
     return "", 0, StatusFileNotFound, os.ErrNotExist
 }
 
-// DeleteWarehouse deletes all files in the warehouse
+// chunkManifestExists checks if the file is stored in the chunk store. The path is the one of the manifest companion file, the file size is the one of the file data.
+// It returns StatusInvalidHash, StatusFileNotFound, or StatusOK.
+func (wh *Warehouse) chunkManifestExists(hash []byte) (path string, fileSize uint64, status int, err error) {
+    hashA, err := ValidateHash(hash)
+    if err != nil {
+        return "", 0, StatusInvalidHash, err
+    } else if wh.chunks == nil {
+        return "", 0, StatusFileNotFound, os.ErrNotExist
+    }
+
+    a, b := buildPath(wh.Directory, hashA)
+    path = filepath.Join(a, b) + chunkManifestExt
+
+    if fileSize, err = readChunkManifestSize(path); err != nil {
+        return "", 0, StatusFileNotFound, os.ErrNotExist
+    }
+
+    return path, fileSize, StatusOK, nil
+}
+
+// DeleteWarehouse deletes all files in the warehouse including quarantined ones
 func (wh *Warehouse) DeleteWarehouse() (err error) {
+    os.RemoveAll(wh.quarantinePath())
+
     return wh.IterateFiles(func(Hash []byte, Size int64) (Continue bool) {
         wh.DeleteFile(Hash)
 
@@ -0,0 +1,238 @@
+package warehouse
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/newinfoOffical/core/merkle"
+)
+
+// testData returns pseudo-random data. The same seed returns the same data.
+func testData(size int, seed int64) (data []byte) {
+	data = make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func initTestWarehouse(t *testing.T) (wh *Warehouse) {
+	wh, err := Init(t.TempDir())
+	if err != nil {
+		t.Fatalf("Error initializing warehouse: %s\n", err.Error())
+	}
+	return wh
+}
+
+// createTestFile stores the data in the warehouse and returns the hash.
+func createTestFile(t *testing.T, wh *Warehouse, data []byte) (hash []byte) {
+	hash, status, err := wh.CreateFile(bytes.NewReader(data), uint64(len(data)), nil)
+	if status != StatusOK {
+		t.Fatalf("Error creating file: status %d error %v\n", status, err)
+	}
+	return hash
+}
+
+func TestVerifyFile(t *testing.T) {
+	wh := initTestWarehouse(t)
+
+	flipByte := func(path string) {
+		data, _ := os.ReadFile(path)
+		data[len(data)/2] ^= 1
+		os.WriteFile(path, data, 0666)
+	}
+
+	tests := []struct {
+		name           string
+		size           int
+		change         func(path string)
+		status         int
+		merkleRepaired bool
+	}{
+		{"small", 1000, func(path string) {}, StatusOK, false},
+		{"empty", 0, func(path string) {}, StatusOK, false},
+		{"large", merkle.MinimumFragmentSize*3 + 10, func(path string) {}, StatusOK, false},
+		{"merkle missing", merkle.MinimumFragmentSize + 1, func(path string) { os.Remove(path + merkleCompanionExt) }, StatusOK, true},
+		{"merkle corrupt", merkle.MinimumFragmentSize * 2, func(path string) { flipByte(path + merkleCompanionExt) }, StatusOK, true},
+		{"small corrupt", 1000, flipByte, StatusFileCorrupt, false},
+		{"large corrupt", merkle.MinimumFragmentSize * 2, flipByte, StatusFileCorrupt, false},
+		{"truncated", 1000, func(path string) { os.Truncate(path, 999) }, StatusFileCorrupt, false},
+	}
+
+	for n, test := range tests {
+		data := testData(test.size, int64(n))
+		hash := createTestFile(t, wh, data)
+
+		path, _, _, _ := wh.FileExists(hash)
+		test.change(path)
+
+		status, merkleRepaired, err := wh.VerifyFile(hash, 0)
+		if status != test.status || merkleRepaired != test.merkleRepaired {
+			t.Errorf("%s: status %d merkle repaired %v error %v\n", test.name, status, merkleRepaired, err)
+			continue
+		}
+
+		// a repaired merkle companion file must be valid
+		if merkleRepaired {
+			if status, merkleRepaired, _ = wh.VerifyFile(hash, 0); status != StatusOK || merkleRepaired {
+				t.Errorf("%s: merkle companion file not repaired\n", test.name)
+			}
+		}
+
+		if status != StatusFileCorrupt {
+			continue
+		}
+
+		// Quarantined files are considered not existing, and the file can be stored again.
+		if status, err = wh.QuarantineFile(hash); status != StatusOK {
+			t.Errorf("%s: quarantine status %d error %v\n", test.name, status, err)
+		} else if _, _, status, _ = wh.FileExists(hash); status != StatusFileNotFound {
+			t.Errorf("%s: quarantined file exists\n", test.name)
+		} else if _, err = os.Stat(filepath.Join(wh.quarantinePath(), hex.EncodeToString(hash))); err != nil {
+			t.Errorf("%s: file not in quarantine folder\n", test.name)
+		}
+
+		createTestFile(t, wh, data)
+		if status, _, _ = wh.VerifyFile(hash, 0); status != StatusOK {
+			t.Errorf("%s: status %d after storing again\n", test.name, status)
+		}
+	}
+
+	// files that do not exist and invalid hashes
+	if status, _, _ := wh.VerifyFile(make([]byte, hashSize), 0); status != StatusFileNotFound {
+		t.Errorf("Not existing file status %d\n", status)
+	} else if status, _, _ = wh.VerifyFile([]byte{1, 2, 3}, 0); status != StatusInvalidHash {
+		t.Errorf("Invalid hash status %d\n", status)
+	}
+
+	// the read rate is limited
+	hash := createTestFile(t, wh, testData(merkle.MinimumFragmentSize, 100))
+	if status, _, _ := wh.VerifyFile(hash, 10*1024*1024); status != StatusOK {
+		t.Errorf("Rate limited status %d\n", status)
+	}
+}
+
+func TestChunkStore(t *testing.T) {
+	plain := initTestWarehouse(t)
+	chunked := initTestWarehouse(t)
+	if err := chunked.EnableChunking(); err != nil {
+		t.Fatalf("Error enabling chunking: %s\n", err.Error())
+	}
+
+	// two similar files, the second one has a few bytes inserted in the middle
+	dataA := testData(chunkSizeMax*3, 1)
+	dataB := append(append(append([]byte{}, dataA[:len(dataA)/2]...), testData(100, 2)...), dataA[len(dataA)/2:]...)
+
+	hashA := createTestFile(t, chunked, dataA)
+	hashB := createTestFile(t, chunked, dataB)
+	createTestFile(t, plain, dataA)
+
+	// the data path is returned for files in the chunk store
+	path, fileSize, status, _ := chunked.FileExists(hashA)
+	if status != StatusOK || fileSize != uint64(len(dataA)) || filepath.Ext(path) == chunkManifestExt {
+		t.Fatalf("Chunked file exists: status %d size %d path %s\n", status, fileSize, path)
+	} else if status, merkleRepaired, err := chunked.VerifyFile(hashA, 0); status != StatusOK || merkleRepaired {
+		t.Errorf("Chunked file verify status %d merkle repaired %v error %v\n", status, merkleRepaired, err)
+	}
+
+	// reading must return the same bytes as plain storage
+	for _, read := range []struct{ offset, limit int64 }{
+		{0, 0}, {1, 10}, {chunkSizeMin - 1, chunkSizeMax * 2}, {chunkSizeMax + 3, 0}, {int64(len(dataA)) - 5, 0}, {int64(len(dataA)) - 5, 100},
+	} {
+		var bufferP, bufferC bytes.Buffer
+		statusP, _, _ := plain.ReadFile(hashA, read.offset, read.limit, &bufferP)
+		statusC, _, err := chunked.ReadFile(hashA, read.offset, read.limit, &bufferC)
+		if statusP != StatusOK || statusC != StatusOK || !bytes.Equal(bufferP.Bytes(), bufferC.Bytes()) {
+			t.Errorf("Read offset %d limit %d: status %d error %v, %d bytes instead of %d\n", read.offset, read.limit, statusC, err, bufferC.Len(), bufferP.Len())
+		}
+	}
+
+	readManifest := func(hash []byte) (manifest *chunkManifest) {
+		path, _, status, err := chunked.chunkManifestExists(hash)
+		if status != StatusOK {
+			t.Fatalf("Manifest not found: status %d error %v\n", status, err)
+		}
+		if manifest, err = readChunkManifest(path); err != nil {
+			t.Fatalf("Error reading manifest: %s\n", err.Error())
+		}
+		return manifest
+	}
+
+	// identical parts of both files are stored once
+	chunksA := make(map[string]bool)
+	for _, chunk := range readManifest(hashA).chunks {
+		chunksA[string(chunk.hash)] = true
+	}
+	var shared, onlyB [][]byte
+	for _, chunk := range readManifest(hashB).chunks {
+		if chunksA[string(chunk.hash)] {
+			shared = append(shared, chunk.hash)
+		} else {
+			onlyB = append(onlyB, chunk.hash)
+		}
+	}
+	if len(shared) == 0 || len(onlyB) == 0 || len(shared) >= len(chunksA) {
+		t.Fatalf("Deduplication: %d shared chunks, %d chunks only in second file, %d chunks in first file\n", len(shared), len(onlyB), len(chunksA))
+	}
+	for _, hash := range shared {
+		if count := chunked.chunks.referenceCount(hash); count != 2 {
+			t.Errorf("Shared chunk reference count %d\n", count)
+		}
+	}
+
+	// deleting releases the chunks
+	if status, err := chunked.DeleteFile(hashB); status != StatusOK {
+		t.Fatalf("Delete status %d error %v\n", status, err)
+	} else if _, _, status, _ = chunked.FileExists(hashB); status != StatusFileNotFound {
+		t.Errorf("Deleted file exists\n")
+	}
+	for _, hash := range shared {
+		if count := chunked.chunks.referenceCount(hash); count != 1 {
+			t.Errorf("Shared chunk reference count %d after delete\n", count)
+		}
+	}
+	for _, hash := range onlyB {
+		if _, path := chunked.chunks.chunkPath(hash); chunked.chunks.referenceCount(hash) != 0 {
+			t.Errorf("Released chunk still referenced\n")
+		} else if _, err := os.Stat(path); err == nil {
+			t.Errorf("Released chunk not deleted\n")
+		}
+	}
+
+	var buffer bytes.Buffer
+	if status, _, _ := chunked.ReadFile(hashA, 0, 0, &buffer); status != StatusOK || !bytes.Equal(buffer.Bytes(), dataA) {
+		t.Errorf("Remaining file changed after delete: status %d\n", status)
+	}
+
+	// a corrupt chunk is moved into quarantine and the file can no longer be read
+	chunkHash := shared[0]
+	_, chunkPath := chunked.chunks.chunkPath(chunkHash)
+	chunkData, _ := os.ReadFile(chunkPath)
+	chunkData[0] ^= 1
+	os.WriteFile(chunkPath, chunkData, 0666)
+
+	if status, _, _ := chunked.ReadFile(hashA, 0, 0, io.Discard); status == StatusOK {
+		t.Errorf("Reading corrupt chunk succeeded\n")
+	} else if _, err := os.Stat(filepath.Join(chunked.quarantinePath(), hex.EncodeToString(chunkHash)+".chunk")); err != nil {
+		t.Errorf("Corrupt chunk not in quarantine folder\n")
+	}
+	if status, _, _ := chunked.ReadFile(hashA, 0, 0, io.Discard); status == StatusOK {
+		t.Errorf("Reading quarantined chunk succeeded\n")
+	} else if status, _, _ = chunked.VerifyFile(hashA, 0); status != StatusFileCorrupt {
+		t.Errorf("File with quarantined chunk verify status %d\n", status)
+	}
+
+	// quarantining the file moves the manifest and releases the chunks
+	if status, err := chunked.QuarantineFile(hashA); status != StatusOK {
+		t.Errorf("Quarantine status %d error %v\n", status, err)
+	} else if _, _, status, _ = chunked.FileExists(hashA); status != StatusFileNotFound {
+		t.Errorf("Quarantined file exists\n")
+	} else if _, err = os.Stat(filepath.Join(chunked.quarantinePath(), hex.EncodeToString(hashA)+chunkManifestExt)); err != nil {
+		t.Errorf("Manifest not in quarantine folder\n")
+	} else if count := chunked.chunks.referenceCount(shared[len(shared)-1]); count != 0 {
+		t.Errorf("Chunk reference count %d after quarantine\n", count)
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 // Blake3 hash size = 32 bytes.
@@ -22,9 +23,12 @@ const (
 type Warehouse struct {
 	Directory string // The main directory for the files
 	Temp      string // Temporary folder
+
+	chunks   *chunkStore // Chunk store. Nil if it was never enabled.
+	chunking bool        // Whether new files are stored in the chunk store.
 }
 
-// Init initializes the warehouse
+// Init initializes the warehouse. If the chunk store exists, it is opened so that files stored in it can be read, but new files are only stored in it if enabled via EnableChunking.
 func Init(Directory string) (wh *Warehouse, err error) {
 	// The temp folder will always be a sub-folder named "_Temp"
 	wh = &Warehouse{Directory: Directory, Temp: filepath.Join(Directory, "_Temp")}
@@ -36,7 +40,31 @@ func Init(Directory string) (wh *Warehouse, err error) {
 		return nil, err
 	}
 
-	return
+	if _, err = os.Stat(wh.chunksPath()); err == nil {
+		if wh.chunks, err = openChunkStore(wh.chunksPath(), wh.quarantinePath()); err != nil {
+			return nil, err
+		}
+	}
+
+	return wh, nil
+}
+
+// EnableChunking stores new files in the chunk store, which deduplicates identical parts of different files. Existing files are not converted.
+func (wh *Warehouse) EnableChunking() (err error) {
+	if wh.chunks == nil {
+		if wh.chunks, err = openChunkStore(wh.chunksPath(), wh.quarantinePath()); err != nil {
+			return err
+		}
+	}
+
+	wh.chunking = true
+
+	return nil
+}
+
+// chunksPath returns the folder of the chunk store.
+func (wh *Warehouse) chunksPath() string {
+	return filepath.Join(wh.Directory, "_Chunks")
 }
 
 // ---- hash functions ----
@@ -121,6 +149,18 @@ func (wh *Warehouse) IterateFiles(Callback func(Hash []byte, Size int64) (Contin
 
 			for _, file3 := range files2 {
 				name3 := file3.Name()
+				size := file3.Size()
+
+				// Files in the chunk store are represented by their manifest.
+				if strings.HasSuffix(name3, chunkManifestExt) && !file3.IsDir() {
+					name3 = strings.TrimSuffix(name3, chunkManifestExt)
+					fileSize, err := readChunkManifestSize(filepath.Join(wh.Directory, name1, name2, file3.Name()))
+					if err != nil {
+						continue
+					}
+					size = int64(fileSize)
+				}
+
 				_, err = hex.DecodeString(name3)
 
 				// finally we are only looking for files
@@ -128,8 +168,6 @@ func (wh *Warehouse) IterateFiles(Callback func(Hash []byte, Size int64) (Contin
 					continue
 				}
 
-				size := file3.Size()
-
 				hash, err := hex.DecodeString(name1 + name2 + name3)
 				if err != nil {
 					return err
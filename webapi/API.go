@@ -40,6 +40,10 @@ type WebapiInstance struct {
 	// upload info
 	uploads      map[uuid.UUID]*UploadStatus
 	uploadsMutex sync.RWMutex
+
+	// search index maintenance, see Search Index.go
+	indexOperation      *indexOperation
+	indexOperationMutex sync.Mutex
 }
 
 // API error
@@ -86,13 +90,20 @@ func Start(Backend *core.Backend, ListenAddresses []string, UseSSL bool, Certifi
 	api.Router.HandleFunc("/status/config", api.apiStatusConfig).Methods("GET")
 	api.Router.HandleFunc("/account/info", api.apiAccountInfo).Methods("GET")
 	api.Router.HandleFunc("/account/delete", api.apiAccountDelete).Methods("GET")
+	api.Router.HandleFunc("/account/rotate", api.apiAccountRotate).Methods("GET")
 	api.Router.HandleFunc("/blockchain/header", api.apiBlockchainHeaderFunc).Methods("GET")
 	api.Router.HandleFunc("/blockchain/append", api.apiBlockchainAppend).Methods("POST")
 	api.Router.HandleFunc("/blockchain/read", api.apiBlockchainRead).Methods("GET")
+	api.Router.HandleFunc("/blockchain/compact", api.apiBlockchainCompact).Methods("GET")
+	api.Router.HandleFunc("/blockchain/export", api.apiBlockchainExport).Methods("GET")
+	api.Router.HandleFunc("/blockchain/import", api.apiBlockchainImport).Methods("POST")
+	api.Router.HandleFunc("/blockchain/verify", api.apiBlockchainVerify).Methods("GET")
 	api.Router.HandleFunc("/blockchain/file/add", api.apiBlockchainFileAdd).Methods("POST")
 	api.Router.HandleFunc("/blockchain/file/list", api.apiBlockchainFileList).Methods("GET")
 	api.Router.HandleFunc("/blockchain/file/delete", api.apiBlockchainFileDelete).Methods("POST")
 	api.Router.HandleFunc("/blockchain/file/update", api.apiBlockchainFileUpdate).Methods("POST")
+	api.Router.HandleFunc("/blockchain/certificate/add", api.apiBlockchainCertificateAdd).Methods("POST")
+	api.Router.HandleFunc("/blockchain/certificate/list", api.apiBlockchainCertificateList).Methods("GET")
 	api.Router.HandleFunc("/blockchain/view", api.apiExploreNodeID).Methods("GET")
 	api.Router.HandleFunc("/merge/directory", api.apiMergeDirectory).Methods("GET")
 	api.Router.HandleFunc("/profile/list", api.apiProfileList).Methods("GET")
@@ -104,6 +115,17 @@ func Start(Backend *core.Backend, ListenAddresses []string, UseSSL bool, Certifi
 	api.Router.HandleFunc("/search/result/ws", api.apiSearchResultStream).Methods("GET")
 	api.Router.HandleFunc("/search/statistic", api.apiSearchStatistic).Methods("GET")
 	api.Router.HandleFunc("/search/terminate", api.apiSearchTerminate).Methods("GET")
+	api.Router.HandleFunc("/search/index/statistic", api.apiSearchIndexStatistic).Methods("GET")
+	api.Router.HandleFunc("/search/index/rebuild", api.apiSearchIndexRebuild).Methods("GET")
+	api.Router.HandleFunc("/search/index/check", api.apiSearchIndexCheck).Methods("GET")
+	api.Router.HandleFunc("/search/index/status", api.apiSearchIndexStatus).Methods("GET")
+	api.Router.HandleFunc("/search/saved/create", api.apiSavedSearchCreate).Methods("POST")
+	api.Router.HandleFunc("/search/saved/list", api.apiSavedSearchList).Methods("GET")
+	api.Router.HandleFunc("/search/saved/delete", api.apiSavedSearchDelete).Methods("GET")
+	api.Router.HandleFunc("/search/saved/inbox", api.apiSavedSearchInbox).Methods("GET")
+	api.Router.HandleFunc("/search/saved/inbox/read", api.apiSavedSearchInboxRead).Methods("GET")
+	api.Router.HandleFunc("/search/saved/inbox/delete", api.apiSavedSearchInboxDelete).Methods("GET")
+	api.Router.HandleFunc("/search/saved/ws", api.apiSavedSearchStream).Methods("GET")
 	api.Router.HandleFunc("/explore", api.apiExplore).Methods("GET")
 	api.Router.HandleFunc("/file/format", api.apiFileFormat).Methods("GET")
 	api.Router.HandleFunc("/download/start", api.apiDownloadStart).Methods("GET")
@@ -117,8 +139,23 @@ func Start(Backend *core.Backend, ListenAddresses []string, UseSSL bool, Certifi
 	api.Router.HandleFunc("/warehouse/read", api.apiWarehouseReadFile).Methods("GET")
 	api.Router.HandleFunc("/warehouse/read/path", api.apiWarehouseReadFilePath).Methods("GET")
 	api.Router.HandleFunc("/warehouse/delete", api.apiWarehouseDeleteFile).Methods("GET")
+	api.Router.HandleFunc("/warehouse/scrub/status", api.apiWarehouseScrubStatus).Methods("GET")
+	api.Router.HandleFunc("/warehouse/scrub/start", api.apiWarehouseScrubStart).Methods("GET")
 	api.Router.HandleFunc("/file/read", api.apiFileRead).Methods("GET")
 	api.Router.HandleFunc("/file/view", api.apiFileView).Methods("GET")
+	api.Router.HandleFunc("/file/thumbnail", api.apiFileThumbnail).Methods("GET")
+	api.Router.HandleFunc("/file/rate", api.apiFileRate).Methods("POST")
+	api.Router.HandleFunc("/file/report", api.apiFileReport).Methods("POST")
+	api.Router.HandleFunc("/file/rating", api.apiFileRating).Methods("GET")
+	api.Router.HandleFunc("/subscription/add", api.apiSubscriptionAdd).Methods("GET")
+	api.Router.HandleFunc("/subscription/remove", api.apiSubscriptionRemove).Methods("GET")
+	api.Router.HandleFunc("/subscription/list", api.apiSubscriptionList).Methods("GET")
+	api.Router.HandleFunc("/feed", api.apiFeed).Methods("GET")
+	api.Router.HandleFunc("/feed/ws", api.apiFeedStream).Methods("GET")
+	api.Router.HandleFunc("/message/send", api.apiMessageSend).Methods("POST")
+	api.Router.HandleFunc("/message/list", api.apiMessageList).Methods("GET")
+	api.Router.HandleFunc("/message/conversations", api.apiMessageConversations).Methods("GET")
+	api.Router.HandleFunc("/message/ws", api.apiMessageStream).Methods("GET")
 
 	for _, listen := range ListenAddresses {
 		go startWebAPI(Backend, listen, UseSSL, CertificateFile, CertificateKey, api.Router, "API", TimeoutRead, TimeoutWrite)
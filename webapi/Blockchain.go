@@ -42,9 +42,10 @@ type apiBlockchainBlockRaw struct {
 }
 
 type apiBlockchainBlockStatus struct {
-    Status  int    `json:"status"`  // See blockchain.StatusX.
-    Height  uint64 `json:"height"`  // Height of the blockchain (number of blocks).
-    Version uint64 `json:"version"` // Version of the blockchain.
+    Status     int               `json:"status"`               // See blockchain.StatusX.
+    Height     uint64            `json:"height"`               // Height of the blockchain (number of blocks).
+    Version    uint64            `json:"version"`              // Version of the blockchain.
+    Mismatches []apiFileMismatch `json:"mismatches,omitempty"` // Files whose type and format were corrected because they did not match the content. Only used by /blockchain/file/add.
 }
 
 /*
@@ -71,6 +72,108 @@ func (api *WebapiInstance) apiBlockchainAppend(w http.ResponseWriter, r *http.Re
     EncodeJSON(api.Backend, w, r, apiBlockchainBlockStatus{Status: status, Height: newHeight, Version: newVersion})
 }
 
+/*
+apiBlockchainCompact repacks all records of the blockchain into as few blocks as possible. If this saves blocks, a new version of the blockchain is created.
+Compaction is also done automatically when new blocks are added, depending on the config setting CompactFragmentation.
+
+Request:    GET /blockchain/compact
+Response:   200 with JSON structure apiBlockchainBlockStatus
+*/
+func (api *WebapiInstance) apiBlockchainCompact(w http.ResponseWriter, r *http.Request) {
+    newHeight, newVersion, status := api.Backend.UserBlockchain.Compact()
+
+    EncodeJSON(api.Backend, w, r, apiBlockchainBlockStatus{Status: status, Height: newHeight, Version: newVersion})
+}
+
+/*
+apiBlockchainExport downloads the blockchain as archive. It can be imported on another machine via /blockchain/import.
+
+Request:    GET /blockchain/export?files=[0|1]
+            files=1 includes all files referenced by the blockchain that are stored in the warehouse.
+Response:   200 with the archive as binary data
+*/
+func (api *WebapiInstance) apiBlockchainExport(w http.ResponseWriter, r *http.Request) {
+    r.ParseForm()
+    includeFiles, _ := strconv.ParseBool(r.Form.Get("files"))
+
+    w.Header().Set("Content-Type", "application/octet-stream")
+    w.Header().Set("Content-Disposition", "attachment; filename=\"blockchain.archive\"")
+
+    if status, err := api.Backend.ExportBlockchain(w, includeFiles); status != blockchain.StatusOK || err != nil {
+        // The HTTP status may already be sent. The client detects the truncated archive on import.
+        api.Backend.LogError("apiBlockchainExport", "status %d error: %v\n", status, err)
+    }
+}
+
+type apiBlockchainImport struct {
+    Status  int    `json:"status"`  // See blockchain.StatusX.
+    Height  uint64 `json:"height"`  // Height of the blockchain (number of blocks).
+    Version uint64 `json:"version"` // Version of the blockchain.
+    Files   int    `json:"files"`   // Count of files imported into the warehouse.
+}
+
+/*
+apiBlockchainImport replaces the blockchain with the one from the archive. The archive must be of the same user (private key).
+Signatures and hash links of all blocks are verified before the blockchain is replaced. Files included in the archive are stored in the warehouse.
+
+Request:    POST /blockchain/import with the archive as binary data in the body
+Response:   200 with JSON structure apiBlockchainImport
+*/
+func (api *WebapiInstance) apiBlockchainImport(w http.ResponseWriter, r *http.Request) {
+    newHeight, newVersion, files, status, err := api.Backend.ImportBlockchain(r.Body)
+    if err != nil {
+        api.Backend.LogError("apiBlockchainImport", "status %d error: %v\n", status, err)
+    }
+
+    EncodeJSON(api.Backend, w, r, apiBlockchainImport{Status: status, Height: newHeight, Version: newVersion, Files: files})
+}
+
+type apiBlockchainCorruption struct {
+    BlockNumber uint64 `json:"blocknumber"` // Block number
+    Type        int    `json:"type"`        // See blockchain.CorruptionX.
+    Error       string `json:"error"`       // Error details
+}
+
+type apiBlockchainVerify struct {
+    Status      int                       `json:"status"`      // See blockchain.StatusX. Only set if a repair was requested.
+    Height      uint64                    `json:"height"`      // Height of the blockchain (number of blocks).
+    Version     uint64                    `json:"version"`     // Version of the blockchain.
+    Corruptions []apiBlockchainCorruption `json:"corruptions"` // List of corruptions found. Empty if the blockchain is valid.
+    Repaired    []apiBlockchainCorruption `json:"repaired"`    // List of corruptions that were repaired automatically at startup. Records in these blocks were lost.
+}
+
+/*
+apiBlockchainVerify verifies the blockchain and returns all corruptions found. If repair is set, a corrupt blockchain is repaired.
+Repairing keeps all valid blocks and re-encodes them under a new version. Records in corrupt blocks are lost.
+The blockchain is also verified and repaired at startup; those corruptions are returned in the repaired list.
+
+Request:    GET /blockchain/verify?repair=[0|1]
+Response:   200 with JSON structure apiBlockchainVerify
+*/
+func (api *WebapiInstance) apiBlockchainVerify(w http.ResponseWriter, r *http.Request) {
+    r.ParseForm()
+    repair, _ := strconv.ParseBool(r.Form.Get("repair"))
+
+    var result apiBlockchainVerify
+
+    corruptions := api.Backend.UserBlockchain.Verify()
+    for _, corruption := range corruptions {
+        result.Corruptions = append(result.Corruptions, apiBlockchainCorruption{BlockNumber: corruption.BlockNumber, Type: corruption.Type, Error: corruption.Err.Error()})
+    }
+
+    for _, corruption := range api.Backend.UserBlockchainRepairStatus() {
+        result.Repaired = append(result.Repaired, apiBlockchainCorruption{BlockNumber: corruption.BlockNumber, Type: corruption.Type, Error: corruption.Err.Error()})
+    }
+
+    if repair && len(corruptions) > 0 {
+        result.Height, result.Version, result.Status = api.Backend.UserBlockchain.Repair()
+    } else {
+        _, result.Height, result.Version = api.Backend.UserBlockchain.Header()
+    }
+
+    EncodeJSON(api.Backend, w, r, result)
+}
+
 type apiBlockchainBlock struct {
     Status            int                 `json:"status"`            // See blockchain.StatusX.
     PeerID            string              `json:"peerid"`            // Peer ID hex encoded.
@@ -113,6 +216,9 @@ func (api *WebapiInstance) apiBlockchainRead(w http.ResponseWriter, r *http.Requ
             case blockchain.BlockRecordProfile:
                 result.RecordsDecoded = append(result.RecordsDecoded, blockRecordProfileToAPI(v))
 
+            case blockchain.BlockRecordCertificate:
+                result.RecordsDecoded = append(result.RecordsDecoded, api.blockRecordCertificateToAPI(v))
+
             }
         }
     }
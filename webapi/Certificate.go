@@ -0,0 +1,80 @@
+/*
+File Username:  Certificate.go
+Copyright:  2021 Peernet Foundation s.r.o.
+Author:     Peter Kleissner
+*/
+
+package webapi
+
+import (
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/newinfoOffical/core/blockchain"
+)
+
+// apiBlockRecordCertificate is a certificate issued by a 3rd party that certifies a profile field or a file.
+type apiBlockRecordCertificate struct {
+	Issuer     string    `json:"issuer"`     // Public key of the issuer, hex encoded.
+	Type       uint8     `json:"type"`       // Certificate type: 0 = profile field, 1 = file. See blockchain.CertificateTypeX.
+	Field      uint16    `json:"field"`      // Profile field type. Only for profile field certificates.
+	Hash       []byte    `json:"hash"`       // Hash of the profile field data, or the file hash.
+	Expiration time.Time `json:"expiration"` // Expiration date. Zero if it does not expire.
+	Trusted    bool      `json:"trusted"`    // Whether the issuer is trusted and the certificate is not expired.
+}
+
+// apiCertificates contains a list of certificates
+type apiCertificates struct {
+	Certificates []apiBlockRecordCertificate `json:"certificates"` // List of decoded certificates. Only used when this structure is returned from the API.
+	Raw          [][]byte                    `json:"raw"`          // Certificates as encoded by the issuer. Only used as input.
+	Status       int                         `json:"status"`       // Status of the operation, only used when this structure is returned from the API. See blockchain.StatusX.
+}
+
+/*
+apiBlockchainCertificateAdd adds certificates to the blockchain. The certificates must be encoded and signed by the issuer for the current user.
+
+Request:    POST /blockchain/certificate/add with JSON structure apiCertificates
+Response:   200 with JSON structure apiBlockchainBlockStatus
+*/
+func (api *WebapiInstance) apiBlockchainCertificateAdd(w http.ResponseWriter, r *http.Request) {
+	var input apiCertificates
+	if err := DecodeJSON(w, r, &input); err != nil {
+		return
+	}
+
+	newHeight, newVersion, status := api.Backend.UserBlockchain.CertificateAdd(input.Raw)
+
+	EncodeJSON(api.Backend, w, r, apiBlockchainBlockStatus{Status: status, Height: newHeight, Version: newVersion})
+}
+
+/*
+apiBlockchainCertificateList lists all certificates stored on the blockchain.
+
+Request:    GET /blockchain/certificate/list
+Response:   200 with JSON structure apiCertificates
+*/
+func (api *WebapiInstance) apiBlockchainCertificateList(w http.ResponseWriter, r *http.Request) {
+	certificates, status := api.Backend.UserBlockchain.CertificateList()
+
+	result := apiCertificates{Status: status}
+
+	for n := range certificates {
+		result.Certificates = append(result.Certificates, api.blockRecordCertificateToAPI(certificates[n]))
+	}
+
+	EncodeJSON(api.Backend, w, r, result)
+}
+
+// --- conversion from core to API data ---
+
+func (api *WebapiInstance) blockRecordCertificateToAPI(input blockchain.BlockRecordCertificate) (output apiBlockRecordCertificate) {
+	return apiBlockRecordCertificate{
+		Issuer:     hex.EncodeToString(input.Issuer.SerializeCompressed()),
+		Type:       input.Type,
+		Field:      input.Field,
+		Hash:       input.Hash,
+		Expiration: input.Expiration,
+		Trusted:    api.Backend.IsCertificateTrusted(&input),
+	}
+}
@@ -0,0 +1,106 @@
+/*
+File Username:  Content Rating.go
+Copyright:  2021 Peernet Foundation s.r.o.
+Author:     Peter Kleissner
+*/
+
+package webapi
+
+import (
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/newinfoOffical/core/blockchain"
+)
+
+// apiContentRating is a rating of a file shared by any user
+type apiContentRating struct {
+	ID      uuid.UUID `json:"id"`      // File ID.
+	Hash    []byte    `json:"hash"`    // Blake3 hash of the file data.
+	NodeID  []byte    `json:"nodeid"`  // Node ID of the file owner.
+	Score   uint8     `json:"score"`   // Score between 1 and 5.
+	Comment string    `json:"comment"` // Optional comment.
+}
+
+// apiContentReport is a report of a file shared by any user
+type apiContentReport struct {
+	ID      uuid.UUID `json:"id"`      // File ID.
+	Hash    []byte    `json:"hash"`    // Blake3 hash of the file data.
+	NodeID  []byte    `json:"nodeid"`  // Node ID of the file owner.
+	Reason  uint8     `json:"reason"`  // Reason: 0 = Other, 1 = Spam, 2 = Malware, 3 = Illegal, 4 = Copyright. See blockchain.ReportReasonX.
+	Comment string    `json:"comment"` // Optional comment.
+}
+
+// apiContentRatingAggregate is the aggregated rating of a file seen across all cached blockchains
+type apiContentRatingAggregate struct {
+	Status       int    `json:"status"`       // Status: 0 = Success, 1 = No ratings or reports known for the file.
+	Score        uint64 `json:"score"`        // Average rating score multiplied by 100.
+	CountRatings uint64 `json:"countratings"` // Count of ratings.
+	CountReports uint64 `json:"countreports"` // Count of reports.
+}
+
+/*
+apiFileRate rates a file. The rating is stored in the user's blockchain.
+
+Request:    POST /file/rate with JSON structure apiContentRating
+Response:   200 with JSON structure apiBlockchainBlockStatus
+*/
+func (api *WebapiInstance) apiFileRate(w http.ResponseWriter, r *http.Request) {
+	var input apiContentRating
+	if err := DecodeJSON(w, r, &input); err != nil {
+		return
+	}
+
+	rating := blockchain.BlockRecordContentRating{NodeID: input.NodeID, FileID: input.ID, Hash: input.Hash, Score: input.Score, Comment: input.Comment}
+
+	newHeight, newVersion, status := api.Backend.UserBlockchain.ContentRatingAdd([]blockchain.BlockRecordContentRating{rating})
+
+	EncodeJSON(api.Backend, w, r, apiBlockchainBlockStatus{Status: status, Height: newHeight, Version: newVersion})
+}
+
+/*
+apiFileReport reports a file. The report is stored in the user's blockchain.
+
+Request:    POST /file/report with JSON structure apiContentReport
+Response:   200 with JSON structure apiBlockchainBlockStatus
+*/
+func (api *WebapiInstance) apiFileReport(w http.ResponseWriter, r *http.Request) {
+	var input apiContentReport
+	if err := DecodeJSON(w, r, &input); err != nil {
+		return
+	}
+
+	report := blockchain.BlockRecordContentReport{NodeID: input.NodeID, FileID: input.ID, Hash: input.Hash, Reason: input.Reason, Comment: input.Comment}
+
+	newHeight, newVersion, status := api.Backend.UserBlockchain.ContentReportAdd([]blockchain.BlockRecordContentReport{report})
+
+	EncodeJSON(api.Backend, w, r, apiBlockchainBlockStatus{Status: status, Height: newHeight, Version: newVersion})
+}
+
+/*
+apiFileRating returns the aggregated rating of a file seen across all cached blockchains. Each blockchain counts once with its latest rating and report. The user's own blockchain is not included.
+
+Request:    GET /file/rating?hash=[hash]
+Response:   200 with JSON structure apiContentRatingAggregate
+*/
+func (api *WebapiInstance) apiFileRating(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	hash, valid := DecodeBlake3Hash(r.Form.Get("hash"))
+	if !valid {
+		http.Error(w, "", http.StatusBadRequest)
+		return
+	}
+
+	if api.Backend.GlobalBlockchainCache == nil {
+		EncodeJSON(api.Backend, w, r, apiContentRatingAggregate{Status: 1})
+		return
+	}
+
+	rating, found := api.Backend.GlobalBlockchainCache.Store.ReadContentRating(hash)
+	if !found {
+		EncodeJSON(api.Backend, w, r, apiContentRatingAggregate{Status: 1})
+		return
+	}
+
+	EncodeJSON(api.Backend, w, r, apiContentRatingAggregate{Status: 0, Score: rating.AverageScore(), CountRatings: rating.CountRatings, CountReports: rating.CountReports})
+}
@@ -13,7 +13,10 @@ import (
     "os"
     "time"
 
+    "github.com/newinfoOffical/core"
+    "github.com/newinfoOffical/core/protocol"
     "github.com/newinfoOffical/core/warehouse"
+    "lukechampine.com/blake3"
 )
 
 // Starts the download.
@@ -55,19 +58,41 @@ func (info *downloadInfo) Download() {
         return
     }
 
+    // The fragments are verified against the merkle root hash of the file record, so that corrupt data is detected early.
+    // If the file record is not cached, only the hash of the entire file is verified at the end.
+    var verifier *core.FragmentVerifier
+    if record, found := info.backend.ReadFileByHash(info.peer.PublicKey, info.hash); found {
+        if record.Size != fileSize || record.FragmentSize == 0 {
+            info.status = DownloadCanceled
+            return
+        }
+
+        verifier = info.peer.NewFragmentVerifier(info.hash, record.MerkleRootHash, record.Size, record.FragmentSize)
+    }
+
+    hashWriter := blake3.New(protocol.HashSize, nil)
+
     info.file.Size = fileSize
     info.status = DownloadActive
 
     // download in a loop
     var fileOffset, totalRead uint64
     dataRemaining := fileSize
-    readSize := uint64(4096)
+
+    var fragment []byte // Data of the current fragment. It is stored once verified.
+    var fragmentN, fragmentOffset uint64
 
     for dataRemaining > 0 {
         //fmt.Printf("data remaining:  downloaded %d from total %d   = %d %%\n", totalRead, fileSize, totalRead*100/fileSize)
+        readSize := uint64(4096)
         if dataRemaining < readSize {
             readSize = dataRemaining
         }
+        if verifier != nil {
+            if remaining := verifier.FragmentLength(fragmentN) - uint64(len(fragment)); remaining < readSize {
+                readSize = remaining
+            }
+        }
 
         data := make([]byte, readSize)
         n, err := reader.Read(data)
@@ -81,11 +106,31 @@ func (info *downloadInfo) Download() {
             return
         }
 
-        info.storeDownloadData(data[:n], fileOffset)
+        hashWriter.Write(data)
+
+        if verifier == nil {
+            info.storeDownloadData(data, fileOffset)
+        } else if fragment = append(fragment, data...); uint64(len(fragment)) == verifier.FragmentLength(fragmentN) {
+            if valid, err := verifier.Verify(fragmentN, fragment); err != nil || !valid {
+                info.status = DownloadCanceled
+                return
+            }
+
+            info.storeDownloadData(fragment, fragmentOffset)
+
+            fragmentOffset += uint64(len(fragment))
+            fragmentN++
+            fragment = fragment[:0]
+        }
 
         fileOffset += uint64(n)
     }
 
+    if !bytes.Equal(hashWriter.Sum(nil), info.hash) {
+        info.status = DownloadCanceled
+        return
+    }
+
     //fmt.Printf("data finished:  downloaded %d from total %d   = %d %%\n", totalRead, fileSize, totalRead*100/fileSize)
 
     info.Finish()
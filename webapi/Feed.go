@@ -0,0 +1,204 @@
+/*
+File Username:  Feed.go
+Copyright:  2021 Peernet Foundation s.r.o.
+Author:     Peter Kleissner
+
+/subscription/add       Follow a user
+/subscription/remove    Unfollow a user
+/subscription/list      List followed users
+/feed                   Recent changes from followed users
+/feed/ws                Websocket to receive changes from followed users as stream
+
+*/
+
+package webapi
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/newinfoOffical/core"
+)
+
+// apiSubscription is a followed user
+type apiSubscription struct {
+	NodeID []byte    `json:"nodeid"` // Node ID of the followed user.
+	Date   time.Time `json:"date"`   // Date subscribed.
+}
+
+// apiSubscriptionList is the list of followed users
+type apiSubscriptionList struct {
+	Subscriptions []apiSubscription `json:"subscriptions"` // List of followed users.
+}
+
+// apiSubscriptionStatus is the result of following or unfollowing a user
+type apiSubscriptionStatus struct {
+	Status int `json:"status"` // Status: 0 = Success, 1 = Blockchain cache not available.
+}
+
+// apiFeedEvent is a change in the blockchain of a followed user
+type apiFeedEvent struct {
+	Type    int                     `json:"type"`    // Type: 0 = File shared, 1 = File removed, 2 = Profile changed. See core.FeedX.
+	NodeID  []byte                  `json:"nodeid"`  // Node ID of the followed user.
+	Date    time.Time               `json:"date"`    // Date the change was seen.
+	File    *apiFile                `json:"file"`    // File shared or removed. Only set for file events.
+	Profile []apiBlockRecordProfile `json:"profile"` // Changed profile fields. Only set for profile events.
+}
+
+// apiFeed is a list of feed events
+type apiFeed struct {
+	Events []apiFeedEvent `json:"events"` // Events, newest first.
+}
+
+/*
+apiSubscriptionAdd follows a user. Its blockchain is kept in the cache and changes are reported in the feed.
+
+Request:    GET /subscription/add?node=[node ID]
+Response:   200 with JSON structure apiSubscriptionStatus
+*/
+func (api *WebapiInstance) apiSubscriptionAdd(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	nodeID, valid := DecodeBlake3Hash(r.Form.Get("node"))
+	if !valid {
+		http.Error(w, "", http.StatusBadRequest)
+		return
+	}
+
+	status := 0
+	if err := api.Backend.Subscribe(nodeID); err != nil {
+		status = 1
+	}
+
+	EncodeJSON(api.Backend, w, r, apiSubscriptionStatus{Status: status})
+}
+
+/*
+apiSubscriptionRemove unfollows a user.
+
+Request:    GET /subscription/remove?node=[node ID]
+Response:   200 with JSON structure apiSubscriptionStatus
+*/
+func (api *WebapiInstance) apiSubscriptionRemove(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	nodeID, valid := DecodeBlake3Hash(r.Form.Get("node"))
+	if !valid {
+		http.Error(w, "", http.StatusBadRequest)
+		return
+	}
+
+	status := 0
+	if err := api.Backend.Unsubscribe(nodeID); err != nil {
+		status = 1
+	}
+
+	EncodeJSON(api.Backend, w, r, apiSubscriptionStatus{Status: status})
+}
+
+/*
+apiSubscriptionList lists all followed users.
+
+Request:    GET /subscription/list
+Response:   200 with JSON structure apiSubscriptionList
+*/
+func (api *WebapiInstance) apiSubscriptionList(w http.ResponseWriter, r *http.Request) {
+	result := apiSubscriptionList{Subscriptions: []apiSubscription{}}
+
+	for _, subscription := range api.Backend.ListSubscriptions() {
+		result.Subscriptions = append(result.Subscriptions, apiSubscription{NodeID: subscription.NodeID, Date: subscription.DateSubscribed})
+	}
+
+	EncodeJSON(api.Backend, w, r, result)
+}
+
+/*
+apiFeed returns the recent changes from followed users. Only changes seen since the client started are available.
+
+Request:    GET /feed?limit=[optional max events]&since=[optional date]
+Response:   200 with JSON structure apiFeed
+
+	Since is optional and in the format "2006-01-02 15:04:05". Only events after that date are returned.
+*/
+func (api *WebapiInstance) apiFeed(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	limit, _ := strconv.Atoi(r.Form.Get("limit"))
+
+	var since time.Time
+	if sinceA := r.Form.Get("since"); sinceA != "" {
+		var err error
+		if since, err = time.Parse(apiDateFormat, sinceA); err != nil {
+			http.Error(w, "", http.StatusBadRequest)
+			return
+		}
+	}
+
+	result := apiFeed{Events: []apiFeedEvent{}}
+
+	for _, event := range api.Backend.FeedEvents(since, limit) {
+		result.Events = append(result.Events, feedEventToAPI(event))
+	}
+
+	EncodeJSON(api.Backend, w, r, result)
+}
+
+/*
+apiFeedStream provides a websocket to receive changes from followed users as stream.
+
+Request:    GET /feed/ws
+Result:     If successful, upgrades to a websocket and sends JSON structure apiFeedEvent messages.
+*/
+func (api *WebapiInstance) apiFeedStream(w http.ResponseWriter, r *http.Request) {
+	// upgrade to websocket
+	conn, err := WSUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// gorilla will automatically respond with "400 Bad Request", no other response is therefore necessary
+		return
+	}
+
+	defer conn.Close()
+
+	events := make(chan *core.FeedEvent, 100)
+	api.Backend.RegisterFeedMonitor(events)
+	defer api.Backend.UnregisterFeedMonitor(events)
+
+	// The reader detects when the client closes the connection.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case event := <-events:
+			if err := conn.WriteJSON(feedEventToAPI(event)); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
+
+// --- conversion from core to API data ---
+
+func feedEventToAPI(event *core.FeedEvent) (output apiFeedEvent) {
+	output = apiFeedEvent{Type: event.Type, NodeID: event.NodeID, Date: event.Date}
+
+	switch event.Type {
+	case core.FeedFileShared, core.FeedFileRemoved:
+		file := blockRecordFileToAPI(event.File, false)
+		output.File = &file
+
+	case core.FeedProfileChanged:
+		for _, field := range event.Profile {
+			output.Profile = append(output.Profile, blockRecordProfileToAPI(field))
+		}
+	}
+
+	return output
+}
@@ -2,15 +2,23 @@
 File:  File Detection.go
 Copyright:  2021 Peernet Foundation s.r.o.
 Author:     Peter Kleissner
+
+The file type and format are detected by the extension and by the content (the signature at the beginning of the file data).
+If both are available, they are reconciled: The extension is used if it is consistent with the content, since it is more granular (for example CSV files are detected as text).
+Otherwise the content takes precedence and the file is flagged as mismatch. Executable content with a non-executable extension is flagged as disguised.
 */
 
 package webapi
 
 import (
+    "bytes"
+    "encoding/binary"
+    "io"
     "net/http"
     "os"
     "path"
     "strings"
+    "unicode/utf8"
 
     "github.com/newinfoOffical/core"
 )
@@ -55,7 +63,7 @@ func FileTranslateExtension(extension string) (fileType, fileFormat uint16) {
     case "xls", "xlsx", "ods":
         return core.TypeDocument, core.FormatExcel
 
-    case "gif", "jpg", "jpeg", "png", "svg", "bmp", "tif", "tiff", "jfif", "webp":
+    case "gif", "jpg", "jpeg", "png", "svg", "bmp", "tif", "tiff", "jfif", "webp", "heic", "heif", "avif":
         return core.TypePicture, core.FormatPicture
 
     case "mp4", "flv", "avi", "mov", "mpg", "mpeg", "h264", "3g2", "3gp", "mkv", "wmv", "webm", "ts":
@@ -175,33 +183,316 @@ func FileDataToHTTPContentType(Path string) (httpContentType string, err error)
     return httpContentType, nil
 }
 
-// FileDetectType detects the File Type and File Format of a file. It uses the extension if available, otherwise the file data, for detection.
+// FileDetectType detects the File Type and File Format of a file. It uses the extension and the file data for detection.
 func FileDetectType(Path string) (fileType, fileFormat uint16, err error) {
-    // If a file extension is available, use that to detect the file type and file format.
-    // Otherwise, use the initial file data for detection.
-    if ext1, _, valid := PathToExtension(Path); valid {
-        fileType, fileFormat = FileTranslateExtension(ext1)
-        return fileType, fileFormat, nil
-    }
+    detection, err := FileDetectPath(Path)
+    return detection.FileType, detection.FileFormat, err
+}
 
-    httpContentType, err := FileDataToHTTPContentType(Path)
+// FileDetectPath detects the File Type and File Format of a file on disk by its extension and content.
+func FileDetectPath(Path string) (detection FileDetection, err error) {
+    file, err := os.Open(Path)
     if err != nil {
-        return core.TypeBinary, core.FormatBinary, err
+        return FileDetection{FileType: core.TypeBinary, FileFormat: core.FormatBinary}, err
+    }
+    defer file.Close()
+
+    data := make([]byte, fileSniffLength)
+    n, err := io.ReadFull(file, data)
+    if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+        return FileDetection{FileType: core.TypeBinary, FileFormat: core.FormatBinary}, err
+    }
+
+    return FileDetectData(Path, data[:n]), nil
+}
+
+// FileDetectData detects the File Type and File Format by the extension of the file name and the data. The data should contain the first fileSniffLength bytes of the file.
+func FileDetectData(name string, data []byte) (detection FileDetection) {
+    fileType, fileFormat := fileTranslateName(name)
+    contentType, contentFormat, detected := FileDetectContent(data)
+
+    return FileReconcile(fileType, fileFormat, contentType, contentFormat, detected)
+}
+
+// fileTranslateName translates the extension of the file name to a File Type and File Format. If there is no extension, types are 0.
+func fileTranslateName(name string) (fileType, fileFormat uint16) {
+    if extension, _, valid := PathToExtension(name); valid {
+        return FileTranslateExtension(extension)
+    }
+
+    return core.TypeBinary, core.FormatBinary
+}
+
+// FileDetection is the result of detecting the File Type and File Format of a file.
+type FileDetection struct {
+    FileType   uint16 `json:"filetype"`   // File Type. See core.TypeX.
+    FileFormat uint16 `json:"fileformat"` // File Format. See core.FormatX.
+    Mismatch   bool   `json:"mismatch"`   // Whether the content does not match the extension or the declared format. The content takes precedence.
+    Disguised  bool   `json:"disguised"`  // Whether the content is executable, but the extension or the declared format is not. This indicates a malicious file.
+}
+
+// FileReconcile reconciles the declared File Type and File Format (from the extension or set by the user) with the one detected by the content.
+func FileReconcile(fileType, fileFormat, contentType, contentFormat uint16, contentDetected bool) (detection FileDetection) {
+    switch {
+    case !contentDetected:
+        return FileDetection{FileType: fileType, FileFormat: fileFormat}
+
+    case fileFormat == core.FormatBinary:
+        return FileDetection{FileType: contentType, FileFormat: contentFormat}
+
+    case fileFormatsCompatible(fileFormat, contentFormat):
+        return FileDetection{FileType: fileType, FileFormat: fileFormat}
+    }
+
+    return FileDetection{FileType: contentType, FileFormat: contentFormat, Mismatch: true, Disguised: isFormatExecutable(contentFormat) && !isFormatExecutable(fileFormat)}
+}
+
+// fileFormatsCompatible checks if the format detected by the content is consistent with the declared format.
+// Detection by content is less granular for some formats. For example, CSV files are detected as text, and Office documents may be detected as ZIP containers.
+func fileFormatsCompatible(declared, content uint16) bool {
+    if declared == content {
+        return true
     }
 
-    fileType, fileFormat = HTTPContentTypeToCore(httpContentType)
-    return fileType, fileFormat, nil
+    switch content {
+    case core.FormatText:
+        switch declared {
+        case core.FormatCSV, core.FormatHTML, core.FormatDatabase, core.FormatEmail, core.FormatPeernetSearch, core.FormatExecutable: // Executable text files are scripts such as .bat and .cmd.
+            return true
+        }
+
+    case core.FormatHTML, core.FormatEmail:
+        return declared == core.FormatText
+
+    case core.FormatContainer:
+        switch declared {
+        case core.FormatWord, core.FormatExcel, core.FormatPowerpoint, core.FormatEbook, core.FormatAPK, core.FormatCompressed:
+            return true
+        }
+
+    case core.FormatCompressed:
+        return declared == core.FormatContainer
+
+    case core.FormatExecutable:
+        return declared == core.FormatInstaller
+
+    case core.FormatAudio:
+        return declared == core.FormatVideo // Containers such as MP4, Ogg, and WebM are used for both.
+
+    case core.FormatVideo:
+        return declared == core.FormatAudio
+    }
+
+    return false
+}
+
+// isFormatExecutable checks if the format contains executable code.
+func isFormatExecutable(fileFormat uint16) bool {
+    return fileFormat == core.FormatExecutable || fileFormat == core.FormatInstaller || fileFormat == core.FormatAPK
+}
+
+// fileSniffLength is the count of bytes at the beginning of a file used to detect the format by content. ISO images have their signature at offset 32769.
+const fileSniffLength = 36 * 1024
+
+// FileDetectContent detects the File Type and File Format by the signature of the file data. Detected is false if the format is not known.
+// The data should contain the first fileSniffLength bytes of the file.
+func FileDetectContent(data []byte) (fileType, fileFormat uint16, detected bool) {
+    hasPrefix := func(offset int, signature string) bool {
+        return len(data) >= offset+len(signature) && string(data[offset:offset+len(signature)]) == signature
+    }
+
+    switch {
+    case len(data) == 0:
+        return core.TypeBinary, core.FormatBinary, false
+
+    // documents
+    case hasPrefix(0, "%PDF-"):
+        return core.TypeDocument, core.FormatPDF, true
+    case hasPrefix(0, "{\\rtf"):
+        return core.TypeDocument, core.FormatWord, true
+    case hasPrefix(0, "\xD0\xCF\x11\xE0\xA1\xB1\x1A\xE1"): // OLE2 compound file used by legacy Office documents and MSI installers
+        return detectCompoundFile(data)
+    case hasPrefix(0, "PK\x03\x04"):
+        return detectZIP(data)
+
+    // pictures
+    case hasPrefix(0, "\xFF\xD8\xFF"), hasPrefix(0, "\x89PNG\r\n\x1A\n"), hasPrefix(0, "GIF87a"), hasPrefix(0, "GIF89a"), hasPrefix(0, "II*\x00"), hasPrefix(0, "MM\x00*"),
+        hasPrefix(0, "BM") && len(data) >= 26 && data[14] >= 12 && data[15] == 0 && data[16] == 0 && data[17] == 0, // BMP with a valid DIB header size
+        hasPrefix(0, "RIFF") && hasPrefix(8, "WEBP"),
+        hasPrefix(4, "ftyp") && isImageBrand(data[8:min(len(data), 12)]): // HEIF and AVIF
+        return core.TypePicture, core.FormatPicture, true
+
+    // audio
+    case hasPrefix(0, "ID3"), hasPrefix(0, "fLaC"), hasPrefix(0, "MThd"), hasPrefix(0, "RIFF") && hasPrefix(8, "WAVE"),
+        hasPrefix(4, "ftypM4A "), hasPrefix(4, "ftypM4B "),
+        len(data) >= 2 && data[0] == 0xFF && (data[1]&0xF6 == 0xF0 || data[1]&0xE6 == 0xE2): // AAC ADTS or MPEG audio layer III
+        return core.TypeAudio, core.FormatAudio, true
+    case hasPrefix(0, "OggS"):
+        if bytes.Contains(data[:min(len(data), 128)], []byte("\x80theora")) {
+            return core.TypeVideo, core.FormatVideo, true
+        }
+        return core.TypeAudio, core.FormatAudio, true
+
+    // video
+    case hasPrefix(4, "ftyp"), hasPrefix(0, "\x1A\x45\xDF\xA3"), hasPrefix(0, "RIFF") && hasPrefix(8, "AVI "), hasPrefix(0, "FLV\x01"),
+        hasPrefix(0, "\x00\x00\x01\xBA"), hasPrefix(0, "\x00\x00\x01\xB3"), // MPEG program stream, MPEG video
+        hasPrefix(0, "\x30\x26\xB2\x75\x8E\x66\xCF\x11"), // ASF/WMV
+        len(data) >= 377 && data[0] == 0x47 && data[188] == 0x47 && data[376] == 0x47: // MPEG transport stream with 188 byte packets
+        return core.TypeVideo, core.FormatVideo, true
+
+    // containers and compressed files
+    case hasPrefix(0, "Rar!\x1A\x07"), hasPrefix(0, "7z\xBC\xAF\x27\x1C"), hasPrefix(257, "ustar"):
+        return core.TypeContainer, core.FormatContainer, true
+    case hasPrefix(0, "\x1F\x8B"), hasPrefix(0, "BZh"), hasPrefix(0, "\xFD7zXZ\x00"), hasPrefix(0, "\x28\xB5\x2F\xFD"):
+        return core.TypeCompressed, core.FormatCompressed, true
+    case hasPrefix(32769, "CD001"):
+        return core.TypeContainer, core.FormatISO, true
+
+    // executables
+    case hasPrefix(0, "MZ") && isPEHeader(data), hasPrefix(0, "\x7FELF"), hasPrefix(0, "\xFE\xED\xFA\xCE"), hasPrefix(0, "\xFE\xED\xFA\xCF"), hasPrefix(0, "\xCE\xFA\xED\xFE"), hasPrefix(0, "\xCF\xFA\xED\xFE"),
+        hasPrefix(0, "\xCA\xFE\xBA\xBE") && len(data) >= 8 && data[4] == 0 && data[5] == 0 && data[6] == 0 && data[7] < 20, // Mach-O universal binary. Java class files share the signature but have a larger version number.
+        hasPrefix(0, "#!"):
+        return core.TypeExecutable, core.FormatExecutable, true
+
+    // other
+    case hasPrefix(0, "SQLite format 3\x00"):
+        return core.TypeBinary, core.FormatDatabase, true
+    case hasPrefix(60, "BOOKMOBI"):
+        return core.TypeEbook, core.FormatEbook, true
+    }
+
+    return detectText(data)
+}
+
+// isImageBrand checks if the major brand of an ISO base media file (MP4 and others) is used for still images. HEIF and AVIF files use the same structure as MP4 videos.
+func isImageBrand(brand []byte) bool {
+    switch string(brand) {
+    case "heic", "heix", "heim", "heis", "hevc", "hevx", "mif1", "msf1", "avif", "avis":
+        return true
+    }
+
+    return false
+}
+
+// isPEHeader checks if the DOS header points to a PE header. The offset of the PE header is stored at offset 0x3C of the DOS header.
+// Only checking the "MZ" signature would detect text files that start with these letters as executable.
+func isPEHeader(data []byte) bool {
+    if len(data) < 64 {
+        return false
+    }
+
+    offset := binary.LittleEndian.Uint32(data[0x3C:0x40])
+    return uint64(offset)+4 <= uint64(len(data)) && string(data[offset:offset+4]) == "PE\x00\x00"
+}
+
+// detectCompoundFile detects the format of an OLE2 compound file by the names of the streams, which are UTF-16 encoded in the directory.
+// The directory is not parsed; it is expected within the first bytes for small files. MSI installers use encoded stream names and are not detected.
+func detectCompoundFile(data []byte) (fileType, fileFormat uint16, detected bool) {
+    utf16 := func(text string) []byte {
+        var encoded []byte
+        for _, c := range []byte(text) {
+            encoded = append(encoded, c, 0)
+        }
+        return encoded
+    }
+
+    switch {
+    case bytes.Contains(data, utf16("WordDocument")):
+        return core.TypeDocument, core.FormatWord, true
+    case bytes.Contains(data, utf16("Workbook")), bytes.Contains(data, utf16("Book\x00")):
+        return core.TypeDocument, core.FormatExcel, true
+    case bytes.Contains(data, utf16("PowerPoint Document")):
+        return core.TypeDocument, core.FormatPowerpoint, true
+    }
+
+    return core.TypeBinary, core.FormatBinary, false
+}
+
+// detectZIP detects the format of a ZIP file by the names of the first entries, which are stored in the local file headers.
+// Office Open XML, OpenDocument, EPUB and APK files are ZIP files. OpenDocument and EPUB files store their MIME type in the first entry "mimetype".
+func detectZIP(data []byte) (fileType, fileFormat uint16, detected bool) {
+    switch {
+    case bytes.Contains(data, []byte("mimetypeapplication/epub+zip")):
+        return core.TypeEbook, core.FormatEbook, true
+    case bytes.Contains(data, []byte("mimetypeapplication/vnd.oasis.opendocument.text")):
+        return core.TypeDocument, core.FormatWord, true
+    case bytes.Contains(data, []byte("mimetypeapplication/vnd.oasis.opendocument.spreadsheet")):
+        return core.TypeDocument, core.FormatExcel, true
+    case bytes.Contains(data, []byte("mimetypeapplication/vnd.oasis.opendocument.presentation")):
+        return core.TypeDocument, core.FormatPowerpoint, true
+    case bytes.Contains(data, []byte("AndroidManifest.xml")), bytes.Contains(data, []byte("classes.dex")):
+        return core.TypeExecutable, core.FormatAPK, true
+    case bytes.Contains(data, []byte("[Content_Types].xml")) || bytes.Contains(data, []byte("_rels/.rels")):
+        switch {
+        case bytes.Contains(data, []byte("word/")):
+            return core.TypeDocument, core.FormatWord, true
+        case bytes.Contains(data, []byte("xl/")):
+            return core.TypeDocument, core.FormatExcel, true
+        case bytes.Contains(data, []byte("ppt/")):
+            return core.TypeDocument, core.FormatPowerpoint, true
+        }
+    }
+
+    return core.TypeContainer, core.FormatContainer, true
+}
+
+// detectText detects text files. The data must be valid UTF-8 (or UTF-16 with a byte order mark) without control characters. HTML, XML, SVG and emails are detected by their beginning.
+func detectText(data []byte) (fileType, fileFormat uint16, detected bool) {
+    if bytes.HasPrefix(data, []byte("\xFF\xFE")) || bytes.HasPrefix(data, []byte("\xFE\xFF")) {
+        return core.TypeText, core.FormatText, true
+    }
+
+    data = bytes.TrimPrefix(data, []byte("\xEF\xBB\xBF"))
+
+    // The last character may be truncated.
+    text := data
+    if len(text) >= fileSniffLength {
+        for n := 0; n < utf8.UTFMax && len(text) > 0 && !utf8.Valid(text); n++ {
+            text = text[:len(text)-1]
+        }
+    }
+    if !utf8.Valid(text) {
+        return core.TypeBinary, core.FormatBinary, false
+    }
+    for _, c := range text {
+        if c < 0x20 && c != '\t' && c != '\n' && c != '\r' && c != '\f' {
+            return core.TypeBinary, core.FormatBinary, false
+        }
+    }
+
+    start := strings.ToLower(string(bytes.TrimSpace(text[:min(len(text), 1024)])))
+
+    switch {
+    case strings.HasPrefix(start, "<?xml") && strings.Contains(start, "<svg"), strings.HasPrefix(start, "<svg"):
+        return core.TypePicture, core.FormatPicture, true
+    case strings.HasPrefix(start, "<!doctype html"), strings.HasPrefix(start, "<html"), strings.HasPrefix(start, "<head"), strings.HasPrefix(start, "<body"), strings.HasPrefix(start, "<?xml"):
+        return core.TypeText, core.FormatHTML, true
+    case strings.HasPrefix(start, "return-path:"), strings.HasPrefix(start, "received:"), strings.HasPrefix(start, "delivered-to:"), strings.HasPrefix(start, "from ") && strings.Contains(start, "\nfrom:"):
+        return core.TypeText, core.FormatEmail, true
+    }
+
+    return core.TypeText, core.FormatText, true
+}
+
+// min returns the smaller number.
+func min(a, b int) int {
+    if a < b {
+        return a
+    }
+    return b
 }
 
 type apiResponseFileFormat struct {
     Status     int    `json:"status"`     // Status: 0 = Success, 1 = Error reading file
     FileType   uint16 `json:"filetype"`   // File Type.
     FileFormat uint16 `json:"fileformat"` // File Format.
+    Mismatch   bool   `json:"mismatch"`   // Whether the content does not match the file extension. The detected type and format are based on the content.
+    Disguised  bool   `json:"disguised"`  // Whether the content is executable, but the file extension is not. This indicates a malicious file.
 }
 
 /*
 apiFileFormat detects the file type and file format of the specified file.
-It will primarily use the file extension for detection. If unavailable, it uses the first 512 bytes of the file data to detect the type.
+It uses both the file extension and the signature at the beginning of the file data for detection. If they do not match, the content takes precedence.
 
 Request:    GET /file/format?path=[file path on disk]
 Result:     200 with JSON structure apiResponseFileFormat
@@ -214,11 +505,11 @@ func (api *WebapiInstance) apiFileFormat(w http.ResponseWriter, r *http.Request)
         return
     }
 
-    fileType, fileFormat, err := FileDetectType(filePath)
+    detection, err := FileDetectPath(filePath)
     if err != nil {
         EncodeJSON(api.Backend, w, r, apiResponseFileFormat{Status: 1})
         return
     }
 
-    EncodeJSON(api.Backend, w, r, apiResponseFileFormat{Status: 0, FileType: fileType, FileFormat: fileFormat})
+    EncodeJSON(api.Backend, w, r, apiResponseFileFormat{Status: 0, FileType: detection.FileType, FileFormat: detection.FileFormat, Mismatch: detection.Mismatch, Disguised: detection.Disguised})
 }
@@ -0,0 +1,170 @@
+package webapi
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/newinfoOffical/core"
+)
+
+// testPE returns a minimal DOS header pointing to a PE header.
+func testPE() []byte {
+	data := make([]byte, 128)
+	copy(data, "MZ")
+	data[0x3C] = 0x40
+	copy(data[0x40:], "PE\x00\x00")
+	return data
+}
+
+// testZIP returns the beginning of a ZIP file with the entry names stored in the local file headers.
+func testZIP(names ...string) []byte {
+	var data []byte
+	for _, name := range names {
+		data = append(data, "PK\x03\x04"...)
+		data = append(data, make([]byte, 26)...)
+		data = append(data, name...)
+	}
+	return data
+}
+
+// testFtyp returns the beginning of an ISO base media file with the major brand.
+func testFtyp(brand string) []byte {
+	return []byte("\x00\x00\x00\x18ftyp" + brand + "\x00\x00\x00\x00mif1")
+}
+
+// testUTF16 encodes the ASCII text as UTF-16 little endian, as used for the stream names of compound files.
+func testUTF16(text string) string {
+	var encoded []byte
+	for _, c := range []byte(text) {
+		encoded = append(encoded, c, 0)
+	}
+	return string(encoded)
+}
+
+func TestFileDetectContent(t *testing.T) {
+	tests := []struct {
+		name       string
+		data       []byte
+		fileType   uint16
+		fileFormat uint16
+		detected   bool
+	}{
+		{"empty", nil, core.TypeBinary, core.FormatBinary, false},
+		{"pdf", []byte("%PDF-1.7\n"), core.TypeDocument, core.FormatPDF, true},
+		{"rtf", []byte("{\\rtf1\\ansi"), core.TypeDocument, core.FormatWord, true},
+		{"doc", []byte("\xD0\xCF\x11\xE0\xA1\xB1\x1A\xE1" + testUTF16("WordDocument")), core.TypeDocument, core.FormatWord, true},
+		{"xls", []byte("\xD0\xCF\x11\xE0\xA1\xB1\x1A\xE1" + testUTF16("Workbook")), core.TypeDocument, core.FormatExcel, true},
+		{"compound unknown", []byte("\xD0\xCF\x11\xE0\xA1\xB1\x1A\xE1\x00\x01"), core.TypeBinary, core.FormatBinary, false},
+		{"zip", testZIP("readme.txt"), core.TypeContainer, core.FormatContainer, true},
+		{"docx", testZIP("[Content_Types].xml", "word/document.xml"), core.TypeDocument, core.FormatWord, true},
+		{"xlsx", testZIP("[Content_Types].xml", "xl/workbook.xml"), core.TypeDocument, core.FormatExcel, true},
+		{"pptx", testZIP("[Content_Types].xml", "ppt/presentation.xml"), core.TypeDocument, core.FormatPowerpoint, true},
+		{"odt", testZIP("mimetypeapplication/vnd.oasis.opendocument.text"), core.TypeDocument, core.FormatWord, true},
+		{"ods", testZIP("mimetypeapplication/vnd.oasis.opendocument.spreadsheet"), core.TypeDocument, core.FormatExcel, true},
+		{"odp", testZIP("mimetypeapplication/vnd.oasis.opendocument.presentation"), core.TypeDocument, core.FormatPowerpoint, true},
+		{"epub", testZIP("mimetypeapplication/epub+zip"), core.TypeEbook, core.FormatEbook, true},
+		{"apk", testZIP("AndroidManifest.xml", "classes.dex"), core.TypeExecutable, core.FormatAPK, true},
+		{"jpeg", []byte("\xFF\xD8\xFF\xE0\x00\x10JFIF"), core.TypePicture, core.FormatPicture, true},
+		{"png", []byte("\x89PNG\r\n\x1A\n\x00"), core.TypePicture, core.FormatPicture, true},
+		{"gif", []byte("GIF89a\x01\x00"), core.TypePicture, core.FormatPicture, true},
+		{"webp", []byte("RIFF\x00\x00\x00\x00WEBPVP8 "), core.TypePicture, core.FormatPicture, true},
+		{"heic", testFtyp("heic"), core.TypePicture, core.FormatPicture, true},
+		{"heif", testFtyp("mif1"), core.TypePicture, core.FormatPicture, true},
+		{"avif", testFtyp("avif"), core.TypePicture, core.FormatPicture, true},
+		{"mp4", testFtyp("isom"), core.TypeVideo, core.FormatVideo, true},
+		{"m4a", testFtyp("M4A "), core.TypeAudio, core.FormatAudio, true},
+		{"mkv", []byte("\x1A\x45\xDF\xA3\x01"), core.TypeVideo, core.FormatVideo, true},
+		{"mp3", []byte("ID3\x04\x00"), core.TypeAudio, core.FormatAudio, true},
+		{"ogg vorbis", []byte("OggS\x00\x02\x01vorbis"), core.TypeAudio, core.FormatAudio, true},
+		{"ogg theora", []byte("OggS\x00\x02\x80theora"), core.TypeVideo, core.FormatVideo, true},
+		{"gzip", []byte("\x1F\x8B\x08\x00"), core.TypeCompressed, core.FormatCompressed, true},
+		{"pe", testPE(), core.TypeExecutable, core.FormatExecutable, true},
+		{"elf", []byte("\x7FELF\x02\x01\x01"), core.TypeExecutable, core.FormatExecutable, true},
+		{"mach-o universal", []byte("\xCA\xFE\xBA\xBE\x00\x00\x00\x02"), core.TypeExecutable, core.FormatExecutable, true},
+		{"java class", []byte("\xCA\xFE\xBA\xBE\x00\x00\x00\x34"), core.TypeBinary, core.FormatBinary, false},
+		{"script", []byte("#!/bin/sh\necho test\n"), core.TypeExecutable, core.FormatExecutable, true},
+		{"sqlite", []byte("SQLite format 3\x00"), core.TypeBinary, core.FormatDatabase, true},
+		{"text", []byte("Hello world\n"), core.TypeText, core.FormatText, true},
+		{"text mz", []byte("MZ-800 emulator notes\n"), core.TypeText, core.FormatText, true},
+		{"text mz long", []byte("MZ" + strings.Repeat("-800 emulator notes\n", 10)), core.TypeText, core.FormatText, true},
+		{"dos header without pe", append([]byte("MZ"), make([]byte, 126)...), core.TypeBinary, core.FormatBinary, false},
+		{"utf-16 text", []byte("\xFF\xFEH\x00i\x00"), core.TypeText, core.FormatText, true},
+		{"html", []byte("<!DOCTYPE html>\n<html>"), core.TypeText, core.FormatHTML, true},
+		{"svg", []byte("<?xml version=\"1.0\"?>\n<svg xmlns=\"http://www.w3.org/2000/svg\">"), core.TypePicture, core.FormatPicture, true},
+		{"email", []byte("Return-Path: <a@example.com>\nReceived: from x\n"), core.TypeText, core.FormatEmail, true},
+		{"binary", []byte{0x00, 0x01, 0x02, 0x03}, core.TypeBinary, core.FormatBinary, false},
+	}
+
+	for _, test := range tests {
+		fileType, fileFormat, detected := FileDetectContent(test.data)
+		if fileType != test.fileType || fileFormat != test.fileFormat || detected != test.detected {
+			t.Errorf("%s: detected type %d format %d (%v), expected type %d format %d (%v)\n", test.name, fileType, fileFormat, detected, test.fileType, test.fileFormat, test.detected)
+		}
+	}
+}
+
+func TestFileDetectData(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      []byte
+		detection FileDetection
+	}{
+		{"notes.txt", []byte("MZ-800 emulator notes\n"), FileDetection{FileType: core.TypeText, FileFormat: core.FormatText}},
+		{"data.csv", []byte("a,b\n1,2\n"), FileDetection{FileType: core.TypeText, FileFormat: core.FormatCSV}},
+		{"page.htm", []byte("just text"), FileDetection{FileType: core.TypeText, FileFormat: core.FormatHTML}},
+		{"run.bat", []byte("@echo off\n"), FileDetection{FileType: core.TypeExecutable, FileFormat: core.FormatExecutable}},
+		{"report.docx", testZIP("[Content_Types].xml", "word/document.xml"), FileDetection{FileType: core.TypeDocument, FileFormat: core.FormatWord}},
+		{"book.epub", testZIP("mimetypeapplication/epub+zip"), FileDetection{FileType: core.TypeEbook, FileFormat: core.FormatEbook}},
+		{"archive.tar.gz", []byte("\x1F\x8B\x08\x00"), FileDetection{FileType: core.TypeCompressed, FileFormat: core.FormatCompressed}},
+		{"photo.heic", testFtyp("heic"), FileDetection{FileType: core.TypePicture, FileFormat: core.FormatPicture}},
+		{"song.mp4", []byte("ID3\x04\x00"), FileDetection{FileType: core.TypeVideo, FileFormat: core.FormatVideo}},
+		{"setup.msi", testPE(), FileDetection{FileType: core.TypeExecutable, FileFormat: core.FormatInstaller}},
+		{"noextension", []byte("%PDF-1.4"), FileDetection{FileType: core.TypeDocument, FileFormat: core.FormatPDF}},
+		{"unknown.txt", []byte{0x00, 0x01}, FileDetection{FileType: core.TypeText, FileFormat: core.FormatText}},
+		{"photo.jpg", []byte("%PDF-1.4"), FileDetection{FileType: core.TypeDocument, FileFormat: core.FormatPDF, Mismatch: true}},
+		{"photo.jpg", testPE(), FileDetection{FileType: core.TypeExecutable, FileFormat: core.FormatExecutable, Mismatch: true, Disguised: true}},
+		{"invoice.pdf", testZIP("AndroidManifest.xml"), FileDetection{FileType: core.TypeExecutable, FileFormat: core.FormatAPK, Mismatch: true, Disguised: true}},
+		{"document.docx", []byte("#!/bin/sh\n"), FileDetection{FileType: core.TypeExecutable, FileFormat: core.FormatExecutable, Mismatch: true, Disguised: true}},
+		{"tool.exe", testZIP("readme.txt"), FileDetection{FileType: core.TypeContainer, FileFormat: core.FormatContainer, Mismatch: true}},
+	}
+
+	for _, test := range tests {
+		if detection := FileDetectData(test.name, test.data); detection != test.detection {
+			t.Errorf("%s: detection %+v, expected %+v\n", test.name, detection, test.detection)
+		}
+	}
+}
+
+func TestFileFormatsCompatible(t *testing.T) {
+	tests := []struct {
+		declared, content uint16
+		compatible        bool
+	}{
+		{core.FormatPDF, core.FormatPDF, true},
+		{core.FormatCSV, core.FormatText, true},
+		{core.FormatExecutable, core.FormatText, true},
+		{core.FormatText, core.FormatHTML, true},
+		{core.FormatWord, core.FormatContainer, true},
+		{core.FormatAPK, core.FormatContainer, true},
+		{core.FormatContainer, core.FormatCompressed, true},
+		{core.FormatInstaller, core.FormatExecutable, true},
+		{core.FormatVideo, core.FormatAudio, true},
+		{core.FormatAudio, core.FormatVideo, true},
+		{core.FormatText, core.FormatExecutable, false},
+		{core.FormatPicture, core.FormatExecutable, false},
+		{core.FormatPDF, core.FormatAPK, false},
+		{core.FormatExecutable, core.FormatContainer, false},
+		{core.FormatPicture, core.FormatVideo, false},
+	}
+
+	for _, test := range tests {
+		if compatible := fileFormatsCompatible(test.declared, test.content); compatible != test.compatible {
+			t.Errorf("Declared format %d, content format %d: compatible %v\n", test.declared, test.content, compatible)
+		}
+	}
+
+	// content that is not detected keeps the declared format
+	if detection := FileReconcile(core.TypePicture, core.FormatPicture, core.TypeBinary, core.FormatBinary, false); detection != (FileDetection{FileType: core.TypePicture, FileFormat: core.FormatPicture}) {
+		t.Errorf("Undetected content changed the declared format: %+v\n", detection)
+	}
+}
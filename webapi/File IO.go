@@ -7,6 +7,7 @@ Author:     Peter Kleissner
 package webapi
 
 import (
+    "bytes"
     "errors"
     "io"
     "net/http"
@@ -234,6 +235,105 @@ func (api *WebapiInstance) apiFileView(w http.ResponseWriter, r *http.Request) {
     io.Copy(w, io.LimitReader(reader, int64(transferSize)))
 }
 
+// thumbnailSizeMax is the max size of a thumbnail that is downloaded from a remote peer.
+const thumbnailSizeMax = 1024 * 1024
+
+/*
+apiFileThumbnail returns the thumbnail of a file. The hash is the one of the thumbnail as provided in the Thumbnail tag (type 18) of the file, not the hash of the file itself.
+If the thumbnail is not available in the local warehouse, it is downloaded from the remote peer. The node is only required in that case.
+Downloaded thumbnails are limited to 1 MB and verified against the hash. They are not stored locally.
+The Content-Type is detected from the content. Thumbnails of pictures and audio files are JPEG pictures, the ones of text files are plain text.
+Instead of providing the node ID, the peer ID is also accepted in the &node= parameter.
+The default timeout for connecting to the peer is 10 seconds.
+
+Request:    GET /file/thumbnail?hash=[thumbnail hash]&node=[node ID]
+
+	Optional: &timeout=[seconds]
+
+Response:   200 with the thumbnail
+
+	400 if the parameters are invalid
+	404 if the thumbnail was not found or is too large
+	502 if unable to find or connect to the remote peer in time, or if the remote peer returned invalid data
+*/
+func (api *WebapiInstance) apiFileThumbnail(w http.ResponseWriter, r *http.Request) {
+    r.ParseForm()
+    var err error
+
+    // validate hashes (must be blake3) and other input
+    hash, valid1 := DecodeBlake3Hash(r.Form.Get("hash"))
+    nodeID, valid2 := DecodeBlake3Hash(r.Form.Get("node"))
+    publicKey, err3 := core.PublicKeyFromPeerID(r.Form.Get("node"))
+    if !valid1 || (r.Form.Get("node") != "" && !valid2 && err3 != nil) {
+        http.Error(w, "", http.StatusBadRequest)
+        return
+    }
+
+    timeoutSeconds, _ := strconv.Atoi(r.Form.Get("timeout"))
+    if timeoutSeconds == 0 {
+        timeoutSeconds = 10
+    }
+    timeout := time.Duration(timeoutSeconds) * time.Second
+
+    // Is the thumbnail available in the local warehouse?
+    if _, fileSize, status, _ := api.Backend.UserWarehouse.FileExists(hash); status == warehouse.StatusOK {
+        if fileSize > thumbnailSizeMax {
+            w.WriteHeader(http.StatusNotFound)
+            return
+        }
+
+        var buffer bytes.Buffer
+        if status, _, _ := api.Backend.UserWarehouse.ReadFile(hash, 0, int64(fileSize), &buffer); status != warehouse.StatusOK {
+            w.WriteHeader(http.StatusNotFound)
+            return
+        }
+
+        writeThumbnail(w, buffer.Bytes())
+        return
+    }
+
+    // try connecting via node ID or peer ID?
+    var peer *core.PeerInfo
+
+    if valid2 {
+        peer, err = PeerConnectNode(api.Backend, nodeID, timeout)
+    } else if err3 == nil {
+        peer, err = PeerConnectPublicKey(api.Backend, publicKey, timeout)
+    } else {
+        w.WriteHeader(http.StatusNotFound)
+        return
+    }
+    if err != nil {
+        w.WriteHeader(http.StatusBadGateway)
+        return
+    }
+
+    reader, fileSize, transferSize, err := FileStartReader(peer, hash, 0, 0, r.Context().Done())
+    if reader != nil {
+        defer reader.Close()
+    }
+    if err != nil || reader == nil || fileSize > thumbnailSizeMax || transferSize != fileSize {
+        w.WriteHeader(http.StatusNotFound)
+        return
+    }
+
+    data := make([]byte, transferSize)
+    if _, err := io.ReadFull(reader, data); err != nil || !bytes.Equal(protocol.HashData(data), hash) {
+        w.WriteHeader(http.StatusBadGateway)
+        return
+    }
+
+    writeThumbnail(w, data)
+}
+
+// writeThumbnail sends the thumbnail. Since thumbnails are identified by their hash, their content never changes and may be cached by the client.
+func writeThumbnail(w http.ResponseWriter, data []byte) {
+    w.Header().Set("Content-Type", http.DetectContentType(data))
+    w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+    w.Header().Set("Cache-Control", "max-age=31536000, immutable")
+    w.Write(data)
+}
+
 // PeerConnectPublicKey attempts to connect to the peer specified by its public key (= peer ID).
 func PeerConnectPublicKey(backend *core.Backend, publicKey *btcec.PublicKey, timeout time.Duration) (peer *core.PeerInfo, err error) {
     if publicKey == nil {
@@ -7,6 +7,7 @@ Author:     Peter Kleissner
 package webapi
 
 import (
+	"bytes"
 	"encoding/hex"
 	"net/http"
 	"strconv"
@@ -48,6 +49,17 @@ type apiFile struct {
 	Metadata       []apiFileMetadata `json:"metadata"`       // Additional metadata.
 	Username       string            `json:"username"`       // Username of the user who uploaded the file
 	ProfilePicture []byte            `json:"ProfilePicture"` // ProfilePicture of the particular user
+	Verified       bool              `json:"verified"`       // Whether the file is certified by a trusted certificate issuer. Read only.
+	Recipients     []string          `json:"recipients"`     // Peer IDs of the recipients of a private file. Empty for public files.
+	Fuzzy          bool              `json:"fuzzy"`          // Whether the file was only found via fuzzy matching of the search term. Read only.
+}
+
+// apiFileMismatch is a file whose declared type and format did not match the content. The detected type and format are used instead.
+type apiFileMismatch struct {
+	ID         uuid.UUID `json:"id"`         // ID of the file.
+	FileType   uint16    `json:"filetype"`   // File Type detected by the content. See core.TypeX.
+	FileFormat uint16    `json:"fileformat"` // File Format detected by the content. See core.FormatX.
+	Disguised  bool      `json:"disguised"`  // Whether the content is executable, but the declared format is not. This indicates a malicious file.
 }
 
 // --- conversion from core to API data ---
@@ -55,6 +67,10 @@ type apiFile struct {
 func blockRecordFileToAPI(input blockchain.BlockRecordFile, localNode bool) (output apiFile) {
 	output = apiFile{ID: input.ID, Hash: input.Hash, HashHex: hex.EncodeToString(input.Hash), NodeID: input.NodeID, NodeIDHex: hex.EncodeToString(input.NodeID), Type: input.Type, Format: input.Format, Size: input.Size, Username: input.Username, ProfilePicture: input.ProfilePicture, Metadata: []apiFileMetadata{}}
 
+	for _, recipient := range input.Recipients {
+		output.Recipients = append(output.Recipients, hex.EncodeToString(recipient.SerializeCompressed()))
+	}
+
 	NumberOfNodesShared := false
 
 	for _, tag := range input.Tags {
@@ -83,6 +99,46 @@ func blockRecordFileToAPI(input blockchain.BlockRecordFile, localNode bool) (out
 		case blockchain.TagSharedByGeoIP:
 			output.Metadata = append(output.Metadata, apiFileMetadata{Type: tag.Type, Name: "Shared By GeoIP", Text: tag.Text()})
 
+		case blockchain.TagRatingScore:
+			output.Metadata = append(output.Metadata, apiFileMetadata{Type: tag.Type, Name: "Rating Score", Number: tag.Number()})
+
+		case blockchain.TagRatingCount:
+			output.Metadata = append(output.Metadata, apiFileMetadata{Type: tag.Type, Name: "Rating Count", Number: tag.Number()})
+
+		case blockchain.TagReportCount:
+			output.Metadata = append(output.Metadata, apiFileMetadata{Type: tag.Type, Name: "Report Count", Number: tag.Number()})
+
+		case blockchain.TagTitle:
+			output.Metadata = append(output.Metadata, apiFileMetadata{Type: tag.Type, Name: "Title", Text: tag.Text()})
+
+		case blockchain.TagArtist:
+			output.Metadata = append(output.Metadata, apiFileMetadata{Type: tag.Type, Name: "Artist", Text: tag.Text()})
+
+		case blockchain.TagAlbum:
+			output.Metadata = append(output.Metadata, apiFileMetadata{Type: tag.Type, Name: "Album", Text: tag.Text()})
+
+		case blockchain.TagDuration:
+			output.Metadata = append(output.Metadata, apiFileMetadata{Type: tag.Type, Name: "Duration", Number: tag.Number()})
+
+		case blockchain.TagWidth:
+			output.Metadata = append(output.Metadata, apiFileMetadata{Type: tag.Type, Name: "Width", Number: tag.Number()})
+
+		case blockchain.TagHeight:
+			output.Metadata = append(output.Metadata, apiFileMetadata{Type: tag.Type, Name: "Height", Number: tag.Number()})
+
+		case blockchain.TagCamera:
+			output.Metadata = append(output.Metadata, apiFileMetadata{Type: tag.Type, Name: "Camera", Text: tag.Text()})
+
+		case blockchain.TagGPS:
+			output.Metadata = append(output.Metadata, apiFileMetadata{Type: tag.Type, Name: "GPS", Text: tag.Text()})
+
+		case blockchain.TagThumbnail:
+			output.Metadata = append(output.Metadata, apiFileMetadata{Type: tag.Type, Name: "Thumbnail", Blob: tag.Data})
+
+		case blockchain.TagUnavailable:
+			date, _ := tag.Date()
+			output.Metadata = append(output.Metadata, apiFileMetadata{Type: tag.Type, Name: "Unavailable", Date: date})
+
 		default:
 			output.Metadata = append(output.Metadata, apiFileMetadata{Type: tag.Type, Blob: tag.Data})
 		}
@@ -95,9 +151,18 @@ func blockRecordFileToAPI(input blockchain.BlockRecordFile, localNode bool) (out
 	return output
 }
 
-func blockRecordFileFromAPI(input apiFile) (output blockchain.BlockRecordFile) {
+// blockRecordFileFromAPI converts the API file to the block record. It fails if a recipient is not a valid peer ID.
+func blockRecordFileFromAPI(input apiFile) (output blockchain.BlockRecordFile, err error) {
 	output = blockchain.BlockRecordFile{ID: input.ID, Hash: input.Hash, Type: input.Type, Format: input.Format, Size: input.Size}
 
+	for _, recipient := range input.Recipients {
+		publicKey, err := core.PublicKeyFromPeerID(recipient)
+		if err != nil {
+			return output, err
+		}
+		output.Recipients = append(output.Recipients, publicKey)
+	}
+
 	if input.Name != "" {
 		output.Tags = append(output.Tags, blockchain.TagFromText(blockchain.TagName, input.Name))
 	}
@@ -116,15 +181,21 @@ func blockRecordFileFromAPI(input apiFile) (output blockchain.BlockRecordFile) {
 		switch meta.Type {
 		case blockchain.TagName, blockchain.TagFolder, blockchain.TagDescription: // auto mapped tags
 
-		case blockchain.TagDateCreated:
+		case blockchain.TagDateCreated, blockchain.TagUnavailable:
 			output.Tags = append(output.Tags, blockchain.TagFromDate(meta.Type, meta.Date))
 
+		case blockchain.TagTitle, blockchain.TagArtist, blockchain.TagAlbum, blockchain.TagCamera, blockchain.TagGPS:
+			output.Tags = append(output.Tags, blockchain.TagFromText(meta.Type, meta.Text))
+
+		case blockchain.TagDuration, blockchain.TagWidth, blockchain.TagHeight:
+			output.Tags = append(output.Tags, blockchain.TagFromNumber(meta.Type, meta.Number))
+
 		default:
 			output.Tags = append(output.Tags, blockchain.BlockRecordFileTag{Type: meta.Type, Data: meta.Blob})
 		}
 	}
 
-	return output
+	return output, nil
 }
 
 // --- File API ---
@@ -141,6 +212,10 @@ Each file must be already stored in the Warehouse (virtual folders are exempt).
 If any file is not stored in the Warehouse, the function aborts with the status code StatusNotInWarehouse.
 If the block record encoding fails for any file, this function aborts with the status code StatusCorruptBlockRecord.
 In case the function aborts, the blockchain remains unchanged.
+Files with recipients are private. Their record is encrypted and the file data is only served to the recipients. Recipients are peer IDs.
+Metadata such as title, artist, duration and dimensions is extracted from pictures, audio and video files and added as tags, unless provided in the metadata of the file.
+Public pictures, audio files with cover art and text files get a thumbnail, which is stored as separate file in the warehouse and referenced by the Thumbnail tag.
+The file type and format are verified against the content. If they do not match, the type and format detected by the content are used and the file is reported in the mismatches field.
 
 Request:    POST /blockchain/file/add with JSON structure apiBlockAddFiles
 Response:   200 with JSON structure apiBlockchainBlockStatus
@@ -155,6 +230,8 @@ func (api *WebapiInstance) apiBlockchainFileAdd(w http.ResponseWriter, r *http.R
 	}
 
 	var filesAdd []blockchain.BlockRecordFile
+	var mismatches []apiFileMismatch
+	var thumbnails [][]byte // Thumbnails created by this request
 
 	for _, file := range input.Files {
 		if len(file.Hash) != protocol.HashSize {
@@ -167,29 +244,21 @@ func (api *WebapiInstance) apiBlockchainFileAdd(w http.ResponseWriter, r *http.R
 			file.ID = uuid.New()
 		}
 
-		// Verify that the file exists in the warehouse. Folders are exempt from this check as they are only virtual.
-		if !file.IsVirtualFolder() {
-			if _, err := warehouse.ValidateHash(file.Hash); err != nil {
-				api.Backend.LogError("blockchain.AddFile", "error: %v", err)
-				http.Error(w, "", http.StatusBadRequest)
-				return
-			} else if _, fileSize, status, _ := api.Backend.UserWarehouse.FileExists(file.Hash); status != warehouse.StatusOK {
-				EncodeJSON(api.Backend, w, r, apiBlockchainBlockStatus{Status: blockchain.StatusNotInWarehouse})
-				return
-			} else {
-				file.Size = fileSize
-			}
-		} else {
-			file.Hash = protocol.HashData(nil)
-			file.Size = 0
+		blockRecord, mismatch, thumbnail, status, err := api.fileRecordFromAPI(file)
+		if thumbnail != nil {
+			thumbnails = append(thumbnails, thumbnail)
 		}
-
-		blockRecord := blockRecordFileFromAPI(file)
-
-		// Set the merkle tree info as appropriate.
-		if !setFileMerkleInfo(api.Backend, &blockRecord) {
-			EncodeJSON(api.Backend, w, r, apiBlockchainBlockStatus{Status: blockchain.StatusNotInWarehouse})
+		if err != nil {
+			api.Backend.LogError("blockchain.AddFile", "error: %v", err)
+			api.deleteThumbnails(thumbnails, input.Files)
+			http.Error(w, "", http.StatusBadRequest)
+			return
+		} else if status != blockchain.StatusOK {
+			api.deleteThumbnails(thumbnails, input.Files)
+			EncodeJSON(api.Backend, w, r, apiBlockchainBlockStatus{Status: status})
 			return
+		} else if mismatch != nil {
+			mismatches = append(mismatches, *mismatch)
 		}
 
 		filesAdd = append(filesAdd, blockRecord)
@@ -197,10 +266,15 @@ func (api *WebapiInstance) apiBlockchainFileAdd(w http.ResponseWriter, r *http.R
 
 	newHeight, newVersion, status := api.Backend.UserBlockchain.AddFiles(filesAdd)
 
+	// Thumbnails of files that were not added are not needed.
+	if status != blockchain.StatusOK {
+		api.deleteThumbnails(thumbnails, input.Files)
+	}
+
 	// Temporary log to check the output for warehouse API
 	api.Backend.LogError("blockchain.AddFile", "output %v", apiBlockchainBlockStatus{Status: status, Height: newHeight, Version: newVersion})
 
-	EncodeJSON(api.Backend, w, r, apiBlockchainBlockStatus{Status: status, Height: newHeight, Version: newVersion})
+	EncodeJSON(api.Backend, w, r, apiBlockchainBlockStatus{Status: status, Height: newHeight, Version: newVersion, Mismatches: mismatches})
 }
 
 /*
@@ -237,7 +311,7 @@ func (api *WebapiInstance) apiBlockchainFileList(w http.ResponseWriter, r *http.
 
 /*
 apiBlockchainFileDelete deletes files with the provided IDs. Other fields are ignored.
-It will automatically delete the file in the Warehouse if there are no other references.
+It will automatically delete the file and its thumbnail in the Warehouse if there are no other references.
 
 Request:    POST /blockchain/file/delete with JSON structure apiBlockAddFiles
 Response:   200 with JSON structure apiBlockchainBlockStatus
@@ -256,11 +330,13 @@ func (api *WebapiInstance) apiBlockchainFileDelete(w http.ResponseWriter, r *htt
 
 	newHeight, newVersion, deletedFiles, status := api.Backend.UserBlockchain.DeleteFiles(deleteIDs)
 
-	// If successfully deleted from the blockchain, delete from the Warehouse in case there are no other references.
+	// If successfully deleted from the blockchain, delete from the Warehouse in case there are no other references. The same applies to the thumbnail.
 	if status == blockchain.StatusOK {
 		for n := range deletedFiles {
-			if files, status := api.Backend.UserBlockchain.FileExists(deletedFiles[n].Hash); status == blockchain.StatusOK && len(files) == 0 {
-				api.Backend.UserWarehouse.DeleteFile(deletedFiles[n].Hash)
+			api.Backend.DeleteFileUnreferenced(deletedFiles[n].Hash)
+
+			if tag := deletedFiles[n].GetTag(blockchain.TagThumbnail); tag != nil {
+				api.Backend.DeleteFileUnreferenced(tag.Data)
 			}
 		}
 	}
@@ -283,6 +359,7 @@ func (api *WebapiInstance) apiBlockchainFileUpdate(w http.ResponseWriter, r *htt
 	}
 
 	var filesAdd []blockchain.BlockRecordFile
+	var thumbnails [][]byte // Thumbnails created by this request
 
 	for _, file := range input.Files {
 		if len(file.Hash) != protocol.HashSize {
@@ -293,38 +370,118 @@ func (api *WebapiInstance) apiBlockchainFileUpdate(w http.ResponseWriter, r *htt
 			return
 		}
 
-		// Verify that the file exists in the warehouse. Folders are exempt from this check as they are only virtual.
-		if !file.IsVirtualFolder() {
-			if _, err := warehouse.ValidateHash(file.Hash); err != nil {
-				http.Error(w, "", http.StatusBadRequest)
-				return
-			} else if _, fileSize, status, _ := api.Backend.UserWarehouse.FileExists(file.Hash); status != warehouse.StatusOK {
-				EncodeJSON(api.Backend, w, r, apiBlockchainBlockStatus{Status: blockchain.StatusNotInWarehouse})
-				return
-			} else {
-				file.Size = fileSize
-			}
-		} else {
-			file.Hash = protocol.HashData(nil)
-			file.Size = 0
+		blockRecord, _, thumbnail, status, err := api.fileRecordFromAPI(file)
+		if thumbnail != nil {
+			thumbnails = append(thumbnails, thumbnail)
 		}
-
-		blockRecord := blockRecordFileFromAPI(file)
-
-		// Set the merkle tree info as appropriate.
-		if !setFileMerkleInfo(api.Backend, &blockRecord) {
-			EncodeJSON(api.Backend, w, r, apiBlockchainBlockStatus{Status: blockchain.StatusNotInWarehouse})
+		if err != nil {
+			api.deleteThumbnails(thumbnails, input.Files)
+			http.Error(w, "", http.StatusBadRequest)
+			return
+		} else if status != blockchain.StatusOK {
+			api.deleteThumbnails(thumbnails, input.Files)
+			EncodeJSON(api.Backend, w, r, apiBlockchainBlockStatus{Status: status})
 			return
 		}
 
 		filesAdd = append(filesAdd, blockRecord)
 	}
 
+	// The thumbnails of the replaced records are deleted if they are no longer referenced, for example if the file hash changed.
+	var thumbnailsReplaced [][]byte
+	if files, status := api.Backend.UserBlockchain.ListFiles(); status == blockchain.StatusOK {
+		for n := range files {
+			for m := range filesAdd {
+				if files[n].ID == filesAdd[m].ID {
+					if tag := files[n].GetTag(blockchain.TagThumbnail); tag != nil {
+						thumbnailsReplaced = append(thumbnailsReplaced, tag.Data)
+					}
+				}
+			}
+		}
+	}
+
 	newHeight, newVersion, status := api.Backend.UserBlockchain.ReplaceFiles(filesAdd)
 
+	if status != blockchain.StatusOK {
+		api.deleteThumbnails(thumbnails, input.Files)
+	} else {
+		for _, thumbnail := range thumbnailsReplaced {
+			api.Backend.DeleteFileUnreferenced(thumbnail)
+		}
+	}
+
 	EncodeJSON(api.Backend, w, r, apiBlockchainBlockStatus{Status: status, Height: newHeight, Version: newVersion})
 }
 
+// fileRecordFromAPI verifies a file provided by the caller and returns the block record to store on the blockchain.
+// The file must exist in the warehouse, and its type and format are verified against the content. Metadata and the thumbnail are added to the record.
+// The status is StatusNotInWarehouse if the file does not exist in the warehouse. An error is returned for invalid input.
+// The thumbnail is the hash of the thumbnail if it was newly stored in the warehouse, see deleteThumbnails.
+func (api *WebapiInstance) fileRecordFromAPI(file apiFile) (blockRecord blockchain.BlockRecordFile, mismatch *apiFileMismatch, thumbnail []byte, status int, err error) {
+	// Verify that the file exists in the warehouse. Folders are exempt from this check as they are only virtual.
+	if !file.IsVirtualFolder() {
+		if _, err := warehouse.ValidateHash(file.Hash); err != nil {
+			return blockRecord, nil, nil, 0, err
+		} else if _, fileSize, status, _ := api.Backend.UserWarehouse.FileExists(file.Hash); status != warehouse.StatusOK {
+			return blockRecord, nil, nil, blockchain.StatusNotInWarehouse, nil
+		} else {
+			file.Size = fileSize
+		}
+
+		// Verify the declared type and format against the content, so that for example executables cannot be shared disguised as documents.
+		// If no format is declared, the extension of the file name is used.
+		fileType, fileFormat := uint16(file.Type), file.Format
+		if fileFormat == core.FormatBinary {
+			fileType, fileFormat = fileTranslateName(file.Name)
+		}
+
+		detection := api.warehouseDetect(file.Hash, fileType, fileFormat)
+		if detection.Mismatch {
+			mismatch = &apiFileMismatch{ID: file.ID, FileType: detection.FileType, FileFormat: detection.FileFormat, Disguised: detection.Disguised}
+		}
+		file.Type, file.Format = uint8(detection.FileType), detection.FileFormat
+	} else {
+		file.Hash = protocol.HashData(nil)
+		file.Size = 0
+	}
+
+	if blockRecord, err = blockRecordFileFromAPI(file); err != nil {
+		return blockRecord, nil, nil, 0, err
+	}
+
+	// Set the merkle tree info as appropriate.
+	if !setFileMerkleInfo(api.Backend, &blockRecord) {
+		return blockRecord, nil, nil, blockchain.StatusNotInWarehouse, nil
+	}
+
+	// Add metadata extracted from pictures, audio and video files. Tags provided by the caller take precedence.
+	// The thumbnail is stored as separate file in the warehouse.
+	if !file.IsVirtualFolder() {
+		api.Backend.ExtractMediaTags(&blockRecord)
+		thumbnail, _ = api.Backend.CreateThumbnail(&blockRecord)
+	}
+
+	return blockRecord, mismatch, thumbnail, blockchain.StatusOK, nil
+}
+
+// deleteThumbnails deletes the thumbnails created by a request that failed. Thumbnails that are identical to one of the files of the request are kept.
+func (api *WebapiInstance) deleteThumbnails(thumbnails [][]byte, files []apiFile) {
+	for _, thumbnail := range thumbnails {
+		isFile := false
+		for n := range files {
+			if bytes.Equal(files[n].Hash, thumbnail) {
+				isFile = true
+				break
+			}
+		}
+
+		if !isFile {
+			api.Backend.DeleteFileUnreferenced(thumbnail)
+		}
+	}
+}
+
 // ---- metadata functions ----
 
 // GetMetadata returns the specified metadata or nil if not available.
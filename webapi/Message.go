@@ -0,0 +1,173 @@
+/*
+File Username:  Message.go
+Copyright:  2021 Peernet Foundation s.r.o.
+Author:     Peter Kleissner
+
+/message/send           Send an encrypted direct message
+/message/list           List messages of a conversation
+/message/conversations  List conversations
+/message/ws             Websocket to receive new and updated messages as stream
+
+*/
+
+package webapi
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/newinfoOffical/core"
+)
+
+// apiMessage is a direct message sent to or received from another user
+type apiMessage struct {
+	ID         uuid.UUID `json:"id"`         // Message ID.
+	NodeID     []byte    `json:"nodeid"`     // Node ID of the other user.
+	Sent       bool      `json:"sent"`       // Whether the message was sent by the user. Otherwise it was received.
+	Status     int       `json:"status"`     // Status: 0 = Pending (sent, not yet delivered), 1 = Delivered, 2 = Received, 3 = In inbox (sent and stored in the DHT inbox of the receiver, not yet delivered). See core.MessageStatusX.
+	DateSent   time.Time `json:"datesent"`   // Date the message was sent.
+	DateStatus time.Time `json:"datestatus"` // Date the message was delivered or received.
+	Text       string    `json:"text"`       // Text.
+}
+
+// apiMessageSend is the request to send a direct message
+type apiMessageSend struct {
+	NodeID []byte `json:"nodeid"` // Node ID of the receiver.
+	Text   string `json:"text"`   // Text.
+}
+
+// apiMessageSendResult is the result of sending a direct message
+type apiMessageSendResult struct {
+	Status  int         `json:"status"`  // Status: 0 = Success, 1 = Message store not available, 2 = Receiver not found, 3 = Text too long. See core.MessageSendX.
+	Message *apiMessage `json:"message"` // The message, if successful.
+}
+
+// apiMessageList is a list of messages
+type apiMessageList struct {
+	Messages []apiMessage `json:"messages"` // Messages, oldest first.
+}
+
+// apiConversation is a summary of all messages exchanged with another user
+type apiConversation struct {
+	NodeID        []byte    `json:"nodeid"`        // Node ID of the other user.
+	CountMessages int       `json:"countmessages"` // Count of messages.
+	CountPending  int       `json:"countpending"`  // Count of sent messages not yet delivered.
+	DateLast      time.Time `json:"datelast"`      // Date of the last message.
+}
+
+// apiConversationList is a list of conversations
+type apiConversationList struct {
+	Conversations []apiConversation `json:"conversations"` // Conversations, most recent first.
+}
+
+/*
+apiMessageSend sends an end-to-end encrypted direct message. If the receiver is offline, it is delivered later.
+
+Request:    POST /message/send with JSON structure apiMessageSend
+Response:   200 with JSON structure apiMessageSendResult
+*/
+func (api *WebapiInstance) apiMessageSend(w http.ResponseWriter, r *http.Request) {
+	var input apiMessageSend
+	if err := DecodeJSON(w, r, &input); err != nil {
+		return
+	}
+
+	message, status := api.Backend.SendMessage(input.NodeID, input.Text)
+
+	result := apiMessageSendResult{Status: status}
+	if message != nil {
+		messageA := directMessageToAPI(message)
+		result.Message = &messageA
+	}
+
+	EncodeJSON(api.Backend, w, r, result)
+}
+
+/*
+apiMessageList lists all messages exchanged with another user.
+
+Request:    GET /message/list?node=[node ID]
+Response:   200 with JSON structure apiMessageList
+*/
+func (api *WebapiInstance) apiMessageList(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	nodeID, valid := DecodeBlake3Hash(r.Form.Get("node"))
+	if !valid {
+		http.Error(w, "", http.StatusBadRequest)
+		return
+	}
+
+	result := apiMessageList{Messages: []apiMessage{}}
+
+	for _, message := range api.Backend.ListMessages(nodeID) {
+		result.Messages = append(result.Messages, directMessageToAPI(message))
+	}
+
+	EncodeJSON(api.Backend, w, r, result)
+}
+
+/*
+apiMessageConversations lists all conversations.
+
+Request:    GET /message/conversations
+Response:   200 with JSON structure apiConversationList
+*/
+func (api *WebapiInstance) apiMessageConversations(w http.ResponseWriter, r *http.Request) {
+	result := apiConversationList{Conversations: []apiConversation{}}
+
+	for _, conversation := range api.Backend.ListConversations() {
+		result.Conversations = append(result.Conversations, apiConversation{NodeID: conversation.NodeID, CountMessages: conversation.CountMessages, CountPending: conversation.CountPending, DateLast: conversation.DateLast})
+	}
+
+	EncodeJSON(api.Backend, w, r, result)
+}
+
+/*
+apiMessageStream provides a websocket to receive new and updated messages as stream. This includes received messages and delivery updates of sent ones.
+
+Request:    GET /message/ws
+Result:     If successful, upgrades to a websocket and sends JSON structure apiMessage messages.
+*/
+func (api *WebapiInstance) apiMessageStream(w http.ResponseWriter, r *http.Request) {
+	// upgrade to websocket
+	conn, err := WSUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// gorilla will automatically respond with "400 Bad Request", no other response is therefore necessary
+		return
+	}
+
+	defer conn.Close()
+
+	messages := make(chan *core.DirectMessage, 100)
+	api.Backend.RegisterMessageMonitor(messages)
+	defer api.Backend.UnregisterMessageMonitor(messages)
+
+	// The reader detects when the client closes the connection.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case message := <-messages:
+			if err := conn.WriteJSON(directMessageToAPI(message)); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
+
+// --- conversion from core to API data ---
+
+func directMessageToAPI(message *core.DirectMessage) (output apiMessage) {
+	return apiMessage{ID: message.ID, NodeID: message.NodeID, Sent: message.Sent, Status: message.Status, DateSent: message.DateSent, DateStatus: message.DateStatus, Text: message.Text}
+}
@@ -28,6 +28,8 @@ type apiBlockRecordProfile struct {
     // Depending on the exact type, one of the below fields is used for proper encoding:
     Text string `json:"text"` // Text value. UTF-8 encoding.
     Blob []byte `json:"blob"` // Binary data
+    // Verified indicates that the field is certified by a trusted certificate issuer. It is read-only.
+    Verified bool `json:"verified"`
 }
 
 /*
@@ -49,6 +51,7 @@ func (api *WebapiInstance) apiProfileList(w http.ResponseWriter, r *http.Request
         //_, node, _ := api.Backend.FindNode(NodeID, 100)
 
         _, peers, _ := api.Backend.FindNode(NodeID, time.Second*5)
+        api.Backend.PrioritizeBlockchainSync(peers)
         // First iteration of the entire blockchain to search for the profile
         // image and Username of the user
 
@@ -71,12 +74,17 @@ func (api *WebapiInstance) apiProfileList(w http.ResponseWriter, r *http.Request
             }
         }
 
+        api.setProfileVerified(result.Fields, api.Backend.ReadCertificates(peers.PublicKey, peers.BlockchainVersion))
+
     } else {
         fields, status = api.Backend.UserBlockchain.ProfileList()
         result.Status = status
         for n := range fields {
             result.Fields = append(result.Fields, blockRecordProfileToAPI(fields[n]))
         }
+
+        certificates, _ := api.Backend.UserBlockchain.CertificateList()
+        api.setProfileVerified(result.Fields, certificates)
     }
 
     EncodeJSON(api.Backend, w, r, result)
@@ -114,6 +122,12 @@ func (api *WebapiInstance) apiProfileRead(w http.ResponseWriter, r *http.Request
         }
     }
 
+    // The field is read from the user's blockchain, therefore its certificates apply.
+    if len(result.Fields) > 0 {
+        certificates, _ := api.Backend.UserBlockchain.CertificateList()
+        api.setProfileVerified(result.Fields, certificates)
+    }
+
     EncodeJSON(api.Backend, w, r, result)
 }
 
@@ -198,3 +212,11 @@ func blockRecordProfileFromAPI(input apiBlockRecordProfile) (output blockchain.B
 
     return output
 }
+
+// setProfileVerified sets the verified flag of the fields that are certified by a trusted issuer.
+func (api *WebapiInstance) setProfileVerified(fields []apiBlockRecordProfile, certificates []blockchain.BlockRecordCertificate) {
+    for n := range fields {
+        field := blockRecordProfileFromAPI(fields[n])
+        fields[n].Verified = api.Backend.IsProfileFieldVerified(certificates, &field)
+    }
+}
@@ -0,0 +1,297 @@
+/*
+File Username:  Saved Search.go
+Copyright:  2021 Peernet Foundation s.r.o.
+Author:     Peter Kleissner
+
+/search/saved/create        Create a saved search
+/search/saved/list          List all saved searches
+/search/saved/delete        Delete a saved search and its notifications
+/search/saved/inbox         List notifications of new files matching saved searches
+/search/saved/inbox/read    Mark a notification as read
+/search/saved/inbox/delete  Delete a notification
+/search/saved/ws            Websocket to receive new notifications as stream
+
+Saved searches are evaluated against every file that is newly added to the local search index.
+*/
+
+package webapi
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/newinfoOffical/core"
+)
+
+// apiSavedSearchCreate is the request to create a saved search. The filters have the same meaning as in SearchRequest.
+type apiSavedSearchCreate struct {
+	Name       string `json:"name"`       // Name of the saved search.
+	Query      string `json:"query"`      // Structured query, for example `name:report AND ext:pdf -draft`. See the search package for the syntax.
+	DateFrom   string `json:"datefrom"`   // Date from, both from/to are required if set. Format "2006-01-02 15:04:05".
+	DateTo     string `json:"dateto"`     // Date to, both from/to are required if set. Format "2006-01-02 15:04:05".
+	FileType   int    `json:"filetype"`   // File type such as binary, text document etc. See core.TypeX. -1 = not used.
+	FileFormat int    `json:"fileformat"` // File format such as PDF, Word, Ebook, etc. See core.FormatX. -1 = not used.
+	SizeMin    int    `json:"sizemin"`    // Min file size in bytes. -1 = not used.
+	SizeMax    int    `json:"sizemax"`    // Max file size in bytes. -1 = not used.
+	NodeID     string `json:"node"`       // Node ID of the owner, hex encoded. Empty = not used.
+	RatingMin  int    `json:"ratingmin"`  // Min average rating score multiplied by 100, i.e. 350 for 3.5. 0 = not used.
+	NoReported bool   `json:"noreported"` // Exclude files that were reported.
+}
+
+// apiSavedSearch is a saved search
+type apiSavedSearch struct {
+	ID          uuid.UUID `json:"id"`          // Saved search ID.
+	Name        string    `json:"name"`        // Name of the saved search.
+	Query       string    `json:"query"`       // Structured query.
+	DateFrom    string    `json:"datefrom"`    // Date from. Empty if not used.
+	DateTo      string    `json:"dateto"`      // Date to. Empty if not used.
+	FileType    int       `json:"filetype"`    // File type. -1 = not used.
+	FileFormat  int       `json:"fileformat"`  // File format. -1 = not used.
+	SizeMin     int       `json:"sizemin"`     // Min file size in bytes. -1 = not used.
+	SizeMax     int       `json:"sizemax"`     // Max file size in bytes. -1 = not used.
+	NodeID      []byte    `json:"nodeid"`      // Node ID of the owner. Empty if not used.
+	RatingMin   int       `json:"ratingmin"`   // Min average rating score multiplied by 100. 0 = not used.
+	NoReported  bool      `json:"noreported"`  // Exclude files that were reported.
+	DateCreated time.Time `json:"datecreated"` // Date the saved search was created.
+}
+
+// apiSavedSearchResult is the result of creating a saved search
+type apiSavedSearchResult struct {
+	Status int             `json:"status"` // Status: 0 = Success, 1 = Not available, 2 = Invalid query, 3 = Max count of saved searches reached. See core.SavedSearchX.
+	Search *apiSavedSearch `json:"search"` // The saved search, if successful.
+}
+
+// apiSavedSearchList is a list of saved searches
+type apiSavedSearchList struct {
+	Searches []apiSavedSearch `json:"searches"` // Saved searches, oldest first.
+}
+
+// apiSavedSearchNotification informs about a new file matching a saved search
+type apiSavedSearchNotification struct {
+	ID                uuid.UUID `json:"id"`                // Notification ID.
+	SearchID          uuid.UUID `json:"searchid"`          // Saved search ID.
+	Date              time.Time `json:"date"`              // Date the file was found.
+	Read              bool      `json:"read"`              // Whether the notification was marked as read.
+	NodeID            []byte    `json:"nodeid"`            // Node ID of the owner of the file.
+	BlockchainVersion uint64    `json:"blockchainversion"` // Blockchain version.
+	BlockNumber       uint64    `json:"blocknumber"`       // Block number that contains the file.
+	FileID            uuid.UUID `json:"fileid"`            // File ID.
+	Hash              []byte    `json:"hash"`              // Hash of the file.
+	Size              uint64    `json:"size"`              // Size of the file.
+	Type              uint8     `json:"type"`              // File type. See core.TypeX.
+	Format            uint16    `json:"format"`            // File format. See core.FormatX.
+	Name              string    `json:"name"`              // File name.
+	File              *apiFile  `json:"file"`              // The file record. Only set if the block is still available. Not set for websocket messages.
+}
+
+// apiSavedSearchInbox is a list of notifications
+type apiSavedSearchInbox struct {
+	Notifications []apiSavedSearchNotification `json:"notifications"` // Notifications, newest first.
+}
+
+// apiSavedSearchStatus is the result of deleting or updating a saved search or notification
+type apiSavedSearchStatus struct {
+	Status int `json:"status"` // Status: 0 = Success, 1 = Not found.
+}
+
+/*
+apiSavedSearchCreate creates a saved search. All files that are newly added to the local search index and match the query and filters create a notification.
+
+Request:    POST /search/saved/create with JSON structure apiSavedSearchCreate
+Response:   200 with JSON structure apiSavedSearchResult
+*/
+func (api *WebapiInstance) apiSavedSearchCreate(w http.ResponseWriter, r *http.Request) {
+	var input apiSavedSearchCreate
+	if err := DecodeJSON(w, r, &input); err != nil {
+		return
+	}
+
+	nodeID, valid := DecodeBlake3Hash(input.NodeID)
+	if !valid {
+		nodeID = nil
+	}
+	searchFilter := inputToSearchFilter(SortNone, input.FileType, input.FileFormat, input.DateFrom, input.DateTo, input.SizeMin, input.SizeMax, nodeID, input.RatingMin, input.NoReported)
+
+	filter := core.SavedSearchFilter{IsDates: searchFilter.IsDates, DateFrom: searchFilter.DateFrom, DateTo: searchFilter.DateTo, FileType: searchFilter.FileType, FileFormat: searchFilter.FileFormat, SizeMin: searchFilter.SizeMin, SizeMax: searchFilter.SizeMax, NodeID: searchFilter.NodeID, RatingMin: searchFilter.RatingMin, NoReported: searchFilter.NoReported}
+
+	savedS, status := api.Backend.CreateSavedSearch(input.Name, input.Query, filter)
+
+	result := apiSavedSearchResult{Status: status}
+	if savedS != nil {
+		savedA := savedSearchToAPI(savedS)
+		result.Search = &savedA
+	}
+
+	EncodeJSON(api.Backend, w, r, result)
+}
+
+/*
+apiSavedSearchList lists all saved searches.
+
+Request:    GET /search/saved/list
+Response:   200 with JSON structure apiSavedSearchList
+*/
+func (api *WebapiInstance) apiSavedSearchList(w http.ResponseWriter, r *http.Request) {
+	result := apiSavedSearchList{Searches: []apiSavedSearch{}}
+
+	for _, savedS := range api.Backend.ListSavedSearches() {
+		result.Searches = append(result.Searches, savedSearchToAPI(savedS))
+	}
+
+	EncodeJSON(api.Backend, w, r, result)
+}
+
+/*
+apiSavedSearchDelete deletes a saved search and all of its notifications.
+
+Request:    GET /search/saved/delete?id=[saved search ID]
+Response:   200 with JSON structure apiSavedSearchStatus
+*/
+func (api *WebapiInstance) apiSavedSearchDelete(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	id, err := uuid.Parse(r.Form.Get("id"))
+	if err != nil {
+		http.Error(w, "", http.StatusBadRequest)
+		return
+	}
+
+	EncodeJSON(api.Backend, w, r, savedSearchStatus(api.Backend.DeleteSavedSearch(id)))
+}
+
+/*
+apiSavedSearchInbox lists the notifications of new files matching saved searches.
+If the saved search ID is omitted, notifications of all saved searches are returned.
+
+Request:    GET /search/saved/inbox?id=[saved search ID]&unread=[0|1]
+Response:   200 with JSON structure apiSavedSearchInbox
+*/
+func (api *WebapiInstance) apiSavedSearchInbox(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	var searchID uuid.UUID
+	if idA := r.Form.Get("id"); idA != "" {
+		var err error
+		if searchID, err = uuid.Parse(idA); err != nil {
+			http.Error(w, "", http.StatusBadRequest)
+			return
+		}
+	}
+	unreadOnly, _ := strconv.ParseBool(r.Form.Get("unread"))
+
+	result := apiSavedSearchInbox{Notifications: []apiSavedSearchNotification{}}
+
+	for _, notification := range api.Backend.ListSavedSearchNotifications(searchID, unreadOnly) {
+		notificationA := savedSearchNotificationToAPI(notification)
+
+		if file, _, found, _ := api.Backend.ReadFile(notification.PublicKey, notification.BlockchainVersion, notification.BlockNumber, notification.FileID); found {
+			fileA := blockRecordFileToAPI(file, false)
+			notificationA.File = &fileA
+		}
+
+		result.Notifications = append(result.Notifications, notificationA)
+	}
+
+	EncodeJSON(api.Backend, w, r, result)
+}
+
+/*
+apiSavedSearchInboxRead marks a notification as read.
+
+Request:    GET /search/saved/inbox/read?id=[notification ID]
+Response:   200 with JSON structure apiSavedSearchStatus
+*/
+func (api *WebapiInstance) apiSavedSearchInboxRead(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	id, err := uuid.Parse(r.Form.Get("id"))
+	if err != nil {
+		http.Error(w, "", http.StatusBadRequest)
+		return
+	}
+
+	EncodeJSON(api.Backend, w, r, savedSearchStatus(api.Backend.MarkSavedSearchNotificationRead(id)))
+}
+
+/*
+apiSavedSearchInboxDelete deletes a notification.
+
+Request:    GET /search/saved/inbox/delete?id=[notification ID]
+Response:   200 with JSON structure apiSavedSearchStatus
+*/
+func (api *WebapiInstance) apiSavedSearchInboxDelete(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	id, err := uuid.Parse(r.Form.Get("id"))
+	if err != nil {
+		http.Error(w, "", http.StatusBadRequest)
+		return
+	}
+
+	EncodeJSON(api.Backend, w, r, savedSearchStatus(api.Backend.DeleteSavedSearchNotification(id)))
+}
+
+/*
+apiSavedSearchStream provides a websocket to receive new notifications of saved searches as stream.
+
+Request:    GET /search/saved/ws
+Result:     If successful, upgrades to a websocket and sends JSON structure apiSavedSearchNotification messages.
+*/
+func (api *WebapiInstance) apiSavedSearchStream(w http.ResponseWriter, r *http.Request) {
+	// upgrade to websocket
+	conn, err := WSUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// gorilla will automatically respond with "400 Bad Request", no other response is therefore necessary
+		return
+	}
+
+	defer conn.Close()
+
+	notifications := make(chan *core.SavedSearchNotification, 100)
+	api.Backend.RegisterSavedSearchMonitor(notifications)
+	defer api.Backend.UnregisterSavedSearchMonitor(notifications)
+
+	// The reader detects when the client closes the connection.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case notification := <-notifications:
+			if err := conn.WriteJSON(savedSearchNotificationToAPI(notification)); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
+
+func savedSearchStatus(found bool) apiSavedSearchStatus {
+	if !found {
+		return apiSavedSearchStatus{Status: 1}
+	}
+	return apiSavedSearchStatus{Status: 0}
+}
+
+// --- conversion from core to API data ---
+
+func savedSearchToAPI(savedS *core.SavedSearch) (output apiSavedSearch) {
+	output = apiSavedSearch{ID: savedS.ID, Name: savedS.Name, Query: savedS.Query, FileType: savedS.Filter.FileType, FileFormat: savedS.Filter.FileFormat, SizeMin: savedS.Filter.SizeMin, SizeMax: savedS.Filter.SizeMax, NodeID: savedS.Filter.NodeID, RatingMin: savedS.Filter.RatingMin, NoReported: savedS.Filter.NoReported, DateCreated: savedS.DateCreated}
+
+	if savedS.Filter.IsDates {
+		output.DateFrom = savedS.Filter.DateFrom.UTC().Format(apiDateFormat)
+		output.DateTo = savedS.Filter.DateTo.UTC().Format(apiDateFormat)
+	}
+
+	return output
+}
+
+func savedSearchNotificationToAPI(notification *core.SavedSearchNotification) (output apiSavedSearchNotification) {
+	return apiSavedSearchNotification{ID: notification.ID, SearchID: notification.SearchID, Date: notification.Date, Read: notification.Read, NodeID: notification.NodeID, BlockchainVersion: notification.BlockchainVersion, BlockNumber: notification.BlockNumber, FileID: notification.FileID, Hash: notification.Hash, Size: notification.Size, Type: notification.Type, Format: notification.Format, Name: notification.Name}
+}
@@ -12,9 +12,11 @@ import (
     "time"
 
     "github.com/newinfoOffical/core/blockchain"
+    "github.com/newinfoOffical/core/search"
 )
 
-func (api *WebapiInstance) dispatchSearch(input SearchRequest, NodeID []byte) (job *SearchJob) {
+// dispatchSearch starts a new search job. If query is not nil, it is used instead of the search term.
+func (api *WebapiInstance) dispatchSearch(input SearchRequest, NodeID []byte, query *search.Query) (job *SearchJob) {
     Timeout := input.Parse()
     Filter := input.ToSearchFilter()
 
@@ -24,29 +26,48 @@ func (api *WebapiInstance) dispatchSearch(input SearchRequest, NodeID []byte) (j
     // todo: create actual search clients!
     job.Status = SearchStatusLive
 
-    go job.localSearch(api, input.Term)
+    go job.localSearch(api, input.Term, search.SearchOptions{Fuzzy: input.Fuzzy}, query)
 
     api.RemoveJobDefer(job, job.timeout+time.Minute*10)
 
     return job
 }
 
-func (job *SearchJob) localSearch(api *WebapiInstance, term string) {
+func (job *SearchJob) localSearch(api *WebapiInstance, term string, options search.SearchOptions, query *search.Query) {
     if api.Backend.SearchIndex == nil {
         job.Status = SearchStatusNoIndex
         return
     }
 
-    results := api.Backend.SearchIndex.Search(term)
+    var results []search.SearchIndexRecord
+    var suggestion string
+
+    if query != nil {
+        // The index only returns candidates for the structured query. The conditions are verified on each file below.
+        results = api.Backend.SearchIndex.SearchQuery(query)
+    } else {
+        results, suggestion = api.Backend.SearchIndex.SearchWithOptions(term, options)
+
+        // Files of the user that contain the term in their content. Duplicates are removed below.
+        resultsContent, _ := api.Backend.ContentIndex.SearchWithOptions(term, search.SearchOptions{})
+        results = append(results, resultsContent...)
+    }
 
     job.ResultSync.Lock()
 
+    job.Suggestion = suggestion
+
+    // certificates of the blockchains of the results, cached since they are needed for every file
+    certificatesMap := make(map[string][]blockchain.BlockRecordCertificate)
+
 resultLoop:
     for _, result := range results {
 
         file, _, found, err := api.Backend.ReadFile(result.PublicKey, result.BlockchainVersion, result.BlockNumber, result.FileID)
         if err != nil || !found {
             continue
+        } else if query != nil && !query.Match(&file) {
+            continue
         }
 
         // Deduplicate based on file hash from the same peer.
@@ -56,7 +77,9 @@ resultLoop:
             }
         }
 
-        if bytes.Equal(file.NodeID, api.Backend.SelfNodeID()) {
+        if file.GetTag(blockchain.TagUnavailable) != nil {
+            // The peer does not serve the file until it is repaired.
+        } else if bytes.Equal(file.NodeID, api.Backend.SelfNodeID()) {
             // Indicates data from the current user.
             file.Tags = append(file.Tags, blockchain.TagFromNumber(blockchain.TagSharedByCount, 1))
         } else if peer := api.Backend.NodelistLookup(file.NodeID); peer != nil {
@@ -71,8 +94,18 @@ resultLoop:
             }
         }
 
+        file.Tags = append(file.Tags, api.Backend.ContentRatingTags(file.Hash)...)
+
         // new result
         newFile := blockRecordFileToAPI(file, false)
+        newFile.Fuzzy = result.Fuzzy
+
+        certificates, ok := certificatesMap[string(result.PublicKey.SerializeCompressed())]
+        if !ok {
+            certificates = api.Backend.ReadCertificates(result.PublicKey, result.BlockchainVersion)
+            certificatesMap[string(result.PublicKey.SerializeCompressed())] = certificates
+        }
+        newFile.Verified = api.Backend.IsFileVerified(certificates, &file)
 
         if newFile.NodeID != nil {
             job.Files = append(job.Files, &newFile)
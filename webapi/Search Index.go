@@ -0,0 +1,195 @@
+/*
+File Username:  Search Index.go
+Copyright:  2021 Peernet Foundation s.r.o.
+Author:     Peter Kleissner
+
+/search/index/statistic     Statistics of the local search index
+/search/index/rebuild       Rebuild the search index from all blockchains
+/search/index/check         Check the search index for orphaned records and optionally repair it
+/search/index/status        Progress of the current or last rebuild or check
+
+Only one rebuild or check runs at a time. It runs in the background; its progress is returned by /search/index/status.
+*/
+
+package webapi
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/newinfoOffical/core/search"
+)
+
+// Status codes of search index maintenance
+const (
+	IndexStatusIdle         = 0 // No operation was started.
+	IndexStatusRunning      = 1 // The operation is running.
+	IndexStatusFinished     = 2 // The operation finished successfully.
+	IndexStatusError        = 3 // The operation failed. See the error field.
+	IndexStatusNotAvailable = 4 // The search index is not available.
+	IndexStatusBusy         = 5 // Another operation is already running. The status of that operation is returned.
+)
+
+// indexOperation is a running or finished maintenance operation on the search index.
+type indexOperation struct {
+	operation string
+	progress  search.IndexProgress
+	started   time.Time
+	ended     time.Time
+	err       error
+	result    *search.IndexCheckResult
+}
+
+type apiSearchIndexStatistic struct {
+	Status int `json:"status"` // See IndexStatusX. 0 on success.
+	search.IndexStatistic
+}
+
+type apiSearchIndexOperation struct {
+	Status     int                      `json:"status"`     // See IndexStatusX.
+	Operation  string                   `json:"operation"`  // Operation: "rebuild", "check", or "repair".
+	Done       int                      `json:"done"`       // Count of items processed. For a rebuild these are blockchains, for a check database records.
+	Total      int                      `json:"total"`      // Total count of items. 0 if not yet known.
+	Percentage float64                  `json:"percentage"` // Percentage done. Rounded to 2 decimal points. Between 0.00 and 100.00.
+	DateStart  time.Time                `json:"datestart"`  // Date the operation started.
+	DateEnd    time.Time                `json:"dateend"`    // Date the operation ended. Only set if finished.
+	Error      string                   `json:"error"`      // Error message if the operation failed.
+	Result     *search.IndexCheckResult `json:"result"`     // Result of a check or repair. Only set if finished.
+}
+
+/*
+apiSearchIndexStatistic returns counts of terms and records in the local search index.
+
+Request:    GET /search/index/statistic
+Response:   200 with JSON structure apiSearchIndexStatistic
+*/
+func (api *WebapiInstance) apiSearchIndexStatistic(w http.ResponseWriter, r *http.Request) {
+	if api.Backend.SearchIndex == nil {
+		EncodeJSON(api.Backend, w, r, apiSearchIndexStatistic{Status: IndexStatusNotAvailable})
+		return
+	}
+
+	EncodeJSON(api.Backend, w, r, apiSearchIndexStatistic{Status: 0, IndexStatistic: api.Backend.SearchIndex.Statistic()})
+}
+
+/*
+apiSearchIndexRebuild starts rebuilding the search index. The entire index is deleted and all blocks of the user's blockchain and the global blockchain cache are indexed again.
+Search results are incomplete until the rebuild is finished.
+
+Request:    GET /search/index/rebuild
+Response:   200 with JSON structure apiSearchIndexOperation
+*/
+func (api *WebapiInstance) apiSearchIndexRebuild(w http.ResponseWriter, r *http.Request) {
+	EncodeJSON(api.Backend, w, r, api.startIndexOperation("rebuild", func(operation *indexOperation) error {
+		return api.Backend.SearchIndex.Rebuild(api.Backend.SearchIndexSources(), &operation.progress)
+	}))
+}
+
+/*
+apiSearchIndexCheck starts checking the search index for corrupt and orphaned records. If repair is set, they are deleted.
+Words in the term dictionary cannot be restored by a repair; use /search/index/rebuild instead.
+
+Request:    GET /search/index/check?repair=[0|1]
+Response:   200 with JSON structure apiSearchIndexOperation
+*/
+func (api *WebapiInstance) apiSearchIndexCheck(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	repair, _ := strconv.ParseBool(r.Form.Get("repair"))
+
+	name := "check"
+	if repair {
+		name = "repair"
+	}
+
+	EncodeJSON(api.Backend, w, r, api.startIndexOperation(name, func(operation *indexOperation) error {
+		result, err := api.Backend.SearchIndex.Check(api.Backend.SearchIndexSources(), repair, &operation.progress)
+		if err == nil {
+			api.indexOperationMutex.Lock()
+			operation.result = &result
+			api.indexOperationMutex.Unlock()
+		}
+		return err
+	}))
+}
+
+/*
+apiSearchIndexStatus returns the progress of the current or last rebuild or check.
+
+Request:    GET /search/index/status
+Response:   200 with JSON structure apiSearchIndexOperation
+*/
+func (api *WebapiInstance) apiSearchIndexStatus(w http.ResponseWriter, r *http.Request) {
+	api.indexOperationMutex.Lock()
+	defer api.indexOperationMutex.Unlock()
+
+	EncodeJSON(api.Backend, w, r, api.indexOperationStatus())
+}
+
+// startIndexOperation starts the operation in the background, unless another one is already running.
+func (api *WebapiInstance) startIndexOperation(name string, run func(operation *indexOperation) error) (status apiSearchIndexOperation) {
+	if api.Backend.SearchIndex == nil {
+		return apiSearchIndexOperation{Status: IndexStatusNotAvailable}
+	}
+
+	api.indexOperationMutex.Lock()
+	defer api.indexOperationMutex.Unlock()
+
+	if api.indexOperation != nil && api.indexOperation.ended.IsZero() {
+		status = api.indexOperationStatus()
+		status.Status = IndexStatusBusy
+		return status
+	}
+
+	operation := &indexOperation{operation: name, started: time.Now()}
+	api.indexOperation = operation
+
+	go func() {
+		err := run(operation)
+
+		api.indexOperationMutex.Lock()
+		operation.err = err
+		operation.ended = time.Now()
+		api.indexOperationMutex.Unlock()
+
+		if err != nil {
+			api.Backend.LogError("startIndexOperation", "search index %s error: %v\n", name, err)
+		}
+	}()
+
+	return api.indexOperationStatus()
+}
+
+// indexOperationStatus returns the status of the current or last operation. The caller must lock indexOperationMutex.
+func (api *WebapiInstance) indexOperationStatus() (status apiSearchIndexOperation) {
+	operation := api.indexOperation
+	if operation == nil {
+		return apiSearchIndexOperation{Status: IndexStatusIdle}
+	}
+
+	status.Operation = operation.operation
+	status.Done, status.Total = operation.progress.Get()
+	status.DateStart = operation.started
+	status.DateEnd = operation.ended
+	status.Result = operation.result
+
+	switch {
+	case operation.ended.IsZero():
+		status.Status = IndexStatusRunning
+	case operation.err != nil:
+		status.Status = IndexStatusError
+		status.Error = operation.err.Error()
+	default:
+		status.Status = IndexStatusFinished
+	}
+
+	if status.Total > 0 {
+		status.Percentage = math.Round(float64(status.Done)/float64(status.Total)*100*100) / 100
+		if status.Percentage > 100 {
+			status.Percentage = 100
+		}
+	}
+
+	return status
+}
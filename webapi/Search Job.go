@@ -27,6 +27,8 @@ type SearchFilter struct {
     SizeMin    int       // Min file size in bytes. -1 = not used.
     SizeMax    int       // Max file size in bytes. -1 = not used.
     NodeID     []byte    // Filter based on a NodeID provided
+    RatingMin  int       // Min average rating score multiplied by 100. 0 = not used.
+    NoReported bool      // Exclude files that were reported.
 }
 
 // SearchJob is a collection of search jobs
@@ -53,6 +55,9 @@ type SearchJob struct {
     // Status indicates the overall search status. This will be removed later when relying on search clients.
     Status int
 
+    // Suggestion is the "did you mean" suggestion of the local search, if any. Access is synced via ResultSync.
+    Suggestion string
+
     // runtime data
     //clients        []*SearchClient // all search clients
     clientsMutex sync.Mutex // mutex for manipulating client list
@@ -260,6 +265,14 @@ func (job *SearchJob) isFileFiltered(file *apiFile) bool {
         return false
     }
 
+    if job.filtersRuntime.RatingMin > 0 && file.GetMetadata(blockchain.TagRatingScore).GetNumber() < uint64(job.filtersRuntime.RatingMin) {
+        return false
+    }
+
+    if job.filtersRuntime.NoReported && file.GetMetadata(blockchain.TagReportCount).GetNumber() > 0 {
+        return false
+    }
+
     return true
 }
 
@@ -269,9 +282,11 @@ func SortFiles(files []*apiFile, Sort int) (sorted []*apiFile) {
     case SortRelevanceAsc:
         sort.SliceStable(files, func(i, j int) bool { return files[i].Date.Before(files[j].Date) }) // first as date for secondary sorting
         //sort.SliceStable(files, func(i, j int) bool { return files[i].Score < files[j].Score }) // TODO
+        sort.SliceStable(files, func(i, j int) bool { return files[i].Fuzzy && !files[j].Fuzzy }) // fuzzy matches are less relevant
     case SortRelevanceDec:
         sort.SliceStable(files, func(i, j int) bool { return files[j].Date.Before(files[i].Date) }) // first as date for secondary sorting
         //sort.SliceStable(files, func(i, j int) bool { return files[i].Score > files[j].Score }) // TODO
+        sort.SliceStable(files, func(i, j int) bool { return !files[i].Fuzzy && files[j].Fuzzy }) // fuzzy matches are less relevant
 
     case SortDateAsc:
         sort.SliceStable(files, func(i, j int) bool { return files[i].Date.Before(files[j].Date) })
@@ -302,6 +317,14 @@ func SortFiles(files []*apiFile, Sort int) (sorted []*apiFile) {
     return files
 }
 
+// GetSuggestion returns the "did you mean" suggestion, if any.
+func (job *SearchJob) GetSuggestion() (suggestion string) {
+    job.ResultSync.Lock()
+    defer job.ResultSync.Unlock()
+
+    return job.Suggestion
+}
+
 // IsSearchResults checks if search results may be expected (either files are in queue or a search is running)
 func (job *SearchJob) IsSearchResults() bool {
     // check for any available results. Do not use any lock here as this is read only.
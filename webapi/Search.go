@@ -22,6 +22,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/newinfoOffical/core/search"
 )
 
 // SearchRequest is the information from the end-user for the search. Filters and sort order may be applied when starting the search, or at runtime when getting the results.
@@ -38,6 +39,10 @@ type SearchRequest struct {
 	SizeMin     int         `json:"sizemin"`    // Min file size in bytes. -1 = not used.
 	SizeMax     int         `json:"sizemax"`    // Max file size in bytes. -1 = not used.
 	NodeID      string      `json:"node"`
+	RatingMin   int         `json:"ratingmin"`  // Min average rating score multiplied by 100, i.e. 350 for 3.5. 0 = not used.
+	NoReported  bool        `json:"noreported"` // Exclude files that were reported.
+	Fuzzy       int         `json:"fuzzy"`      // Max edit distance for typo tolerant matching of words. 0 = disabled. Max 2.
+	Query       string      `json:"query"`      // Optional: Structured query, for example `name:report AND ext:pdf -draft`. If set, it is used instead of the term. See the search package for the syntax.
 }
 
 // Sort orders
@@ -63,9 +68,10 @@ type SearchRequestResponse struct {
 
 // SearchResult contains the search results.
 type SearchResult struct {
-	Status    int         `json:"status"`    // Status: 0 = Success with results, 1 = No more results available, 2 = Search ID not found, 3 = No results yet available keep trying
-	Files     []apiFile   `json:"files"`     // List of files found
-	Statistic interface{} `json:"statistic"` // Statistics of all results (independent from applied filters), if requested. Only set if files are returned (= if statistics changed). See SearchStatisticData.
+	Status     int         `json:"status"`     // Status: 0 = Success with results, 1 = No more results available, 2 = Search ID not found, 3 = No results yet available keep trying
+	Files      []apiFile   `json:"files"`      // List of files found
+	Statistic  interface{} `json:"statistic"`  // Statistics of all results (independent from applied filters), if requested. Only set if files are returned (= if statistics changed). See SearchStatisticData.
+	Suggestion string      `json:"suggestion"` // "Did you mean" suggestion. Only set if fuzzy matching is enabled and the term contains unknown words.
 }
 
 // SearchStatistic contains statistics on search results. Statistics are always calculated over all results, regardless of any applied runtime filters.
@@ -81,7 +87,7 @@ const apiDateFormat = "2006-01-02 15:04:05"
 apiSearch submits a search request
 
 Request:    POST /search with JSON SearchRequest
-Result:     200 on success with JSON SearchRequestResponse
+Result:     200 on success with JSON SearchRequestResponse. Status 1 if the structured query is invalid.
 
 	400 on invalid JSON
 */
@@ -93,6 +99,15 @@ func (api *WebapiInstance) apiSearch(w http.ResponseWriter, r *http.Request) {
 
 	NodeId, _ := DecodeBlake3Hash(r.URL.Query().Get("node"))
 
+	var query *search.Query
+	if input.Query != "" {
+		var err error
+		if query, err = search.ParseQuery(input.Query); err != nil {
+			EncodeJSON(api.Backend, w, r, SearchRequestResponse{Status: 1})
+			return
+		}
+	}
+
 	if input.Timeout <= 0 {
 		input.Timeout = 20
 	}
@@ -108,7 +123,7 @@ func (api *WebapiInstance) apiSearch(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	job := api.dispatchSearch(input, NodeId)
+	job := api.dispatchSearch(input, NodeId, query)
 
 	EncodeJSON(api.Backend, w, r, SearchRequestResponse{Status: 0, ID: job.id})
 }
@@ -126,6 +141,8 @@ Optional parameters:
 	&from=[Date From]&to=[Date To]
 	&sizemin=[Minimum file size]
 	&sizemax=[Maximum file size]
+	&ratingmin=[Minimum average rating score multiplied by 100]
+	&noreported=[0|1]
 	&sort=[sort order]
 	&offset=[absolute offset] with &limit=[records] to get items pagination style. Returned items (and ones before) are automatically frozen.
 
@@ -164,8 +181,10 @@ func (api *WebapiInstance) apiSearchResult(w http.ResponseWriter, r *http.Reques
 		if !valid {
 			nodeID = nil
 		}
+		ratingMin, _ := strconv.Atoi(r.Form.Get("ratingmin"))
+		noReported, _ := strconv.ParseBool(r.Form.Get("noreported"))
 
-		filter := inputToSearchFilter(sort, fileType, fileFormat, dateFrom, dateTo, sizeMin, sizeMax, nodeID)
+		filter := inputToSearchFilter(sort, fileType, fileFormat, dateFrom, dateTo, sizeMin, sizeMax, nodeID, ratingMin, noReported)
 
 		job.RuntimeFilter(filter)
 	}
@@ -180,6 +199,7 @@ func (api *WebapiInstance) apiSearchResult(w http.ResponseWriter, r *http.Reques
 
 	var result SearchResult
 	result.Files = []apiFile{}
+	result.Suggestion = job.GetSuggestion()
 
 	// loop over results
 	for n := range resultFiles {
@@ -267,6 +287,7 @@ func (api *WebapiInstance) apiSearchResultStream(w http.ResponseWriter, r *http.
 		// loop over results
 		var result SearchResult
 		result.Files = []apiFile{}
+		result.Suggestion = job.GetSuggestion()
 
 		for n := range resultFiles {
 			result.Files = append(result.Files, *resultFiles[n])
@@ -440,10 +461,10 @@ func (input *SearchRequest) ToSearchFilter() (output SearchFilter) {
 	if !valid {
 		hash = nil
 	}
-	return inputToSearchFilter(input.Sort, input.FileType, input.FileFormat, input.DateFrom, input.DateTo, input.SizeMin, input.SizeMax, hash)
+	return inputToSearchFilter(input.Sort, input.FileType, input.FileFormat, input.DateFrom, input.DateTo, input.SizeMin, input.SizeMax, hash, input.RatingMin, input.NoReported)
 }
 
-func inputToSearchFilter(Sort, FileType, FileFormat int, DateFrom, DateTo string, SizeMin, SizeMax int, NodeID []byte) (output SearchFilter) {
+func inputToSearchFilter(Sort, FileType, FileFormat int, DateFrom, DateTo string, SizeMin, SizeMax int, NodeID []byte, RatingMin int, NoReported bool) (output SearchFilter) {
 	output.Sort = Sort
 	output.FileType = FileType
 	output.FileFormat = FileFormat
@@ -462,5 +483,8 @@ func inputToSearchFilter(Sort, FileType, FileFormat int, DateFrom, DateTo string
 		output.NodeID = NodeID
 	}
 
+	output.RatingMin = RatingMin
+	output.NoReported = NoReported
+
 	return
 }
@@ -32,6 +32,9 @@ func (api *WebapiInstance) queryRecentShared(backend *core.Backend, fileType int
 			return
 		}
 		peerList = append(peerList, peer)
+
+		// The user is browsing this blockchain. Make sure the cache is up to date.
+		api.Backend.PrioritizeBlockchainSync(peer)
 	}
 
 	// Files from peers exceeding the limit. It is used if from all peers the total limit is not reached.
@@ -85,6 +88,7 @@ func (api *WebapiInstance) queryRecentShared(backend *core.Backend, fileType int
 						sharedByGeoIP := fmt.Sprintf("%.4f", latitude) + "," + fmt.Sprintf("%.4f", longitude)
 						file.Tags = append(file.Tags, blockchain.TagFromText(blockchain.TagSharedByGeoIP, sharedByGeoIP))
 					}
+					file.Tags = append(file.Tags, backend.ContentRatingTags(file.Hash)...)
 
 					file.Username = Name
 					file.ProfilePicture = ProfilePicture
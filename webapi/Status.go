@@ -83,6 +83,47 @@ func (api *WebapiInstance) apiAccountDelete(w http.ResponseWriter, r *http.Reque
     w.WriteHeader(http.StatusOK)
 }
 
+type apiResponseAccountRotate struct {
+    Status  int    `json:"status"`  // See blockchain.StatusX.
+    PeerID  string `json:"peerid"`  // New peer ID hex encoded.
+    NodeID  string `json:"nodeid"`  // New node ID hex encoded.
+    Height  uint64 `json:"height"`  // Height of the migrated blockchain.
+    Version uint64 `json:"version"` // Version of the migrated blockchain.
+}
+
+/*
+apiAccountRotate replaces the private key of the current account, for example if the old one is suspected compromised.
+The blockchain is handed over to the new key via a key migration record signed by the old key, and all file records are re-published under the new identity.
+The confirm parameter must include the user's choice.
+
+Request:    GET /account/rotate?confirm=[0 or 1]
+Result:     204 if the user choses not to rotate the key
+
+	200 with JSON structure apiResponseAccountRotate
+*/
+func (api *WebapiInstance) apiAccountRotate(w http.ResponseWriter, r *http.Request) {
+    r.ParseForm()
+    if confirm, _ := strconv.ParseBool(r.Form.Get("confirm")); !confirm {
+        w.WriteHeader(http.StatusNoContent)
+        return
+    }
+
+    publicKey, newHeight, newVersion, status, err := api.Backend.RotateKey()
+    if err != nil {
+        api.Backend.LogError("apiAccountRotate", "status %d error: %v", status, err)
+        EncodeJSON(api.Backend, w, r, apiResponseAccountRotate{Status: status})
+        return
+    }
+
+    EncodeJSON(api.Backend, w, r, apiResponseAccountRotate{
+        Status:  status,
+        PeerID:  hex.EncodeToString(publicKey.SerializeCompressed()),
+        NodeID:  hex.EncodeToString(api.Backend.SelfNodeID()),
+        Height:  newHeight,
+        Version: newVersion,
+    })
+}
+
 /*
 apiStatusPeers returns the information about peers currently connected.
 The GeoIP information may not alawys be available, for example if the GeoIP file is not available or the mapping from IP address to location is not available.
@@ -7,17 +7,26 @@ Author:     Peter Kleissner
 package webapi
 
 import (
+    "bytes"
     "github.com/google/uuid"
     "net/http"
     "strconv"
+    "time"
 
+    "github.com/newinfoOffical/core"
+    "github.com/newinfoOffical/core/blockchain"
     "github.com/newinfoOffical/core/warehouse"
 )
 
 // WarehouseResult is the response to creating a new file in the warehouse
 type WarehouseResult struct {
-    Status int    `json:"status"` // See warehouse.StatusX.
-    Hash   []byte `json:"hash"`   // Hash of the file.
+    Status     int               `json:"status"`     // See warehouse.StatusX.
+    Hash       []byte            `json:"hash"`       // Hash of the file.
+    FileType   uint16            `json:"filetype"`   // File Type detected by the file name and content. See core.TypeX.
+    FileFormat uint16            `json:"fileformat"` // File Format detected by the file name and content. See core.FormatX.
+    Mismatch   bool              `json:"mismatch"`   // Whether the content does not match the file extension. The detected type and format are based on the content.
+    Disguised  bool              `json:"disguised"`  // Whether the content is executable, but the file extension is not. This indicates a malicious file.
+    Metadata   []apiFileMetadata `json:"metadata"`   // Metadata extracted from pictures, audio and video files. The same tags are added when the file is shared via /blockchain/file/add.
 }
 
 /*
@@ -25,6 +34,8 @@ ApiWarehouseCreateFile creates a file in the warehouse.
 
 Request:    POST /warehouse/create with raw data to create as new file
 Response:   200 with JSON structure WarehouseResult
+
+    The file type and format are detected by the file name and the content.
 */
 func (api *WebapiInstance) ApiWarehouseCreateFile(w http.ResponseWriter, r *http.Request) {
     // changing parameter to take ID as a parameter for upload and file itself
@@ -75,10 +86,38 @@ func (api *WebapiInstance) ApiWarehouseCreateFile(w http.ResponseWriter, r *http
         return
     }
 
+    result := api.warehouseResult(status, hash, handler.Filename)
+
     // Temporary log to check the output for warehouse API
-    api.Backend.LogError("warehouse.CreateFile", "output %v", WarehouseResult{Status: status, Hash: hash})
+    api.Backend.LogError("warehouse.CreateFile", "output %v", result)
 
-    EncodeJSON(api.Backend, w, r, WarehouseResult{Status: status, Hash: hash})
+    EncodeJSON(api.Backend, w, r, result)
+}
+
+// warehouseResult returns the result for a file created in the warehouse. It detects the file type and format, and extracts the metadata.
+func (api *WebapiInstance) warehouseResult(status int, hash []byte, fileName string) (result WarehouseResult) {
+    result = WarehouseResult{Status: status, Hash: hash}
+    if status != warehouse.StatusOK {
+        return result
+    }
+
+    fileType, fileFormat := fileTranslateName(fileName)
+    detection := api.warehouseDetect(hash, fileType, fileFormat)
+    result.FileType, result.FileFormat, result.Mismatch, result.Disguised = detection.FileType, detection.FileFormat, detection.Mismatch, detection.Disguised
+
+    tags, _ := api.Backend.MediaTags(hash, detection.FileFormat)
+    result.Metadata = blockRecordFileToAPI(blockchain.BlockRecordFile{Tags: tags}, true).Metadata
+
+    return result
+}
+
+// warehouseDetect detects the file type and format of a file in the warehouse by its content, and reconciles it with the declared file type and format.
+func (api *WebapiInstance) warehouseDetect(hash []byte, fileType, fileFormat uint16) (detection FileDetection) {
+    var buffer bytes.Buffer
+    api.Backend.UserWarehouse.ReadFile(hash, 0, fileSniffLength, &buffer)
+
+    contentType, contentFormat, detected := FileDetectContent(buffer.Bytes())
+    return FileReconcile(fileType, fileFormat, contentType, contentFormat, detected)
 }
 
 /*
@@ -103,7 +142,7 @@ func (api *WebapiInstance) apiWarehouseCreateFilePath(w http.ResponseWriter, r *
         api.Backend.LogError("warehouse.CreateFile", "status %d error: %v", status, err)
     }
 
-    EncodeJSON(api.Backend, w, r, WarehouseResult{Status: status, Hash: hash})
+    EncodeJSON(api.Backend, w, r, api.warehouseResult(status, hash, filePath))
 }
 
 /*
@@ -200,3 +239,62 @@ func (api *WebapiInstance) apiWarehouseReadFilePath(w http.ResponseWriter, r *ht
 
     EncodeJSON(api.Backend, w, r, WarehouseResult{Status: status, Hash: hash})
 }
+
+// apiWarehouseScrubStatus is the status of the integrity check of the warehouse.
+type apiWarehouseScrubStatus struct {
+    Status         int       `json:"status"`         // Status of starting a check: 0 = Success, 1 = A check is already running.
+    Running        bool      `json:"running"`        // Whether a check is currently running.
+    Started        time.Time `json:"started"`        // Start of the current or last check.
+    Finished       time.Time `json:"finished"`       // End of the last check. Zero if no check finished yet.
+    FilesTotal     uint64    `json:"filestotal"`     // Count of files in the warehouse at the start of the check.
+    FilesChecked   uint64    `json:"fileschecked"`   // Count of files checked so far.
+    BytesChecked   uint64    `json:"byteschecked"`   // Count of bytes checked so far.
+    FilesCorrupt   uint64    `json:"filescorrupt"`   // Count of corrupt files that were quarantined.
+    MerkleRepaired uint64    `json:"merklerepaired"` // Count of recreated merkle companion files.
+    FilesRepaired  uint64    `json:"filesrepaired"`  // Count of files repaired from the network.
+    FilesError     uint64    `json:"fileserror"`     // Count of files that could not be checked.
+    Unavailable    uint64    `json:"unavailable"`    // Count of files that are still unavailable after the last check and await repair.
+}
+
+/*
+apiWarehouseScrubStatus returns the status of the current or last integrity check of the warehouse.
+
+Request:    GET /warehouse/scrub/status
+Response:   200 with JSON structure apiWarehouseScrubStatus
+*/
+func (api *WebapiInstance) apiWarehouseScrubStatus(w http.ResponseWriter, r *http.Request) {
+    EncodeJSON(api.Backend, w, r, warehouseScrubStatusToAPI(api.Backend.WarehouseScrubStatus()))
+}
+
+/*
+apiWarehouseScrubStart starts an integrity check of the warehouse in the background. The read rate is limited by WarehouseScrubRate in the config, if set.
+
+Request:    GET /warehouse/scrub/start
+Response:   200 with JSON structure apiWarehouseScrubStatus
+*/
+func (api *WebapiInstance) apiWarehouseScrubStart(w http.ResponseWriter, r *http.Request) {
+    started := api.Backend.ScrubWarehouse()
+
+    result := warehouseScrubStatusToAPI(api.Backend.WarehouseScrubStatus())
+    if !started {
+        result.Status = 1
+    }
+
+    EncodeJSON(api.Backend, w, r, result)
+}
+
+func warehouseScrubStatusToAPI(status core.WarehouseScrubStatus) (result apiWarehouseScrubStatus) {
+    return apiWarehouseScrubStatus{
+        Running:        status.Running,
+        Started:        status.Started,
+        Finished:       status.Finished,
+        FilesTotal:     status.FilesTotal,
+        FilesChecked:   status.FilesChecked,
+        BytesChecked:   status.BytesChecked,
+        FilesCorrupt:   status.FilesCorrupt,
+        MerkleRepaired: status.MerkleRepaired,
+        FilesRepaired:  status.FilesRepaired,
+        FilesError:     status.FilesError,
+        Unavailable:    status.Unavailable,
+    }
+}